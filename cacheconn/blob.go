@@ -0,0 +1,57 @@
+package cacheconn
+
+import (
+	"context"
+	"os"
+
+	"github.com/domonda/go-docdb"
+)
+
+// blobFile returns the blob cache file for a content hash,
+// using the first two hash characters as sub-directory
+// to keep directory sizes manageable.
+func (c *Conn) blobFile(hash string) string {
+	return c.blobDir.Join(hash[:2], hash).LocalPath()
+}
+
+// readBlob returns the cached content of the file
+// if it exists and matches the size and hash of fileInfo.
+func (c *Conn) readBlob(ctx context.Context, fileInfo docdb.FileInfo) ([]byte, bool) {
+	if ctx.Err() != nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.blobFile(fileInfo.Hash))
+	if err != nil || int64(len(data)) != fileInfo.Size || docdb.ContentHash(data) != fileInfo.Hash {
+		return nil, false
+	}
+	return data, true
+}
+
+// writeBlob writes data to the blob cache.
+// The data is written to a temporary file first and renamed
+// so that concurrent readers never see partial content.
+// Errors are ignored because the blob cache is only an optimization.
+func (c *Conn) writeBlob(ctx context.Context, hash string, data []byte) {
+	if ctx.Err() != nil || docdb.ContentHash(data) != hash {
+		return
+	}
+	path := c.blobFile(hash)
+	dir := c.blobDir.Join(hash[:2]).LocalPath()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(dir, hash+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+}
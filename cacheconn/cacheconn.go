@@ -0,0 +1,385 @@
+// Package cacheconn provides a read-through caching docdb.Conn wrapper.
+//
+// Committed versions never change, so the VersionInfo and file content
+// of a version are cached in size-bounded LRU caches without expiration.
+// File content can additionally be cached in a local directory
+// keyed by content hash, see WithBlobCache.
+//
+// The latest version and the company of a document can change and are
+// only cached for a short TTL, see WithTTL. Writes through the wrapper
+// invalidate the cached entries of the written documents. Deleting or
+// recreating versions via another Conn is not noticed: cached content
+// of deleted versions is served until it is evicted.
+package cacheconn
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-types/uu"
+)
+
+// Default cache limits used by New.
+const (
+	DefaultMaxVersionInfos = 10_000
+	DefaultMaxFileBytes    = 64 << 20
+	DefaultTTL             = time.Second
+)
+
+// Option configures a Conn created by New.
+type Option func(*Conn)
+
+// WithMaxVersionInfos sets the maximum number of cached VersionInfos.
+func WithMaxVersionInfos(n int) Option {
+	return func(c *Conn) {
+		c.maxVersionInfos = n
+	}
+}
+
+// WithMaxFileBytes sets the maximum total size of the file content
+// cached in memory. Larger files are not cached in memory.
+func WithMaxFileBytes(n int64) Option {
+	return func(c *Conn) {
+		c.maxFileBytes = n
+	}
+}
+
+// WithTTL sets how long the latest version and the company
+// of a document are cached. Zero disables caching them.
+func WithTTL(ttl time.Duration) Option {
+	return func(c *Conn) {
+		c.ttl = ttl
+	}
+}
+
+// WithBlobCache caches file content in the local directory dir
+// with the content hash as filename. The directory is not cleaned up
+// and can be shared between Conns and process restarts
+// because content with the same hash never changes.
+func WithBlobCache(dir fs.File) Option {
+	return func(c *Conn) {
+		c.blobDir = dir
+	}
+}
+
+// Stats are the hit and miss counts of a Conn.
+type Stats struct {
+	VersionInfoHits   int64
+	VersionInfoMisses int64
+	FileHits          int64 // File content read from the memory cache
+	FileMisses        int64 // File content not in the memory cache
+	BlobHits          int64 // File content read from the blob cache directory
+	BlobMisses        int64 // File content not in the blob cache directory
+	TTLHits           int64 // Latest versions and company IDs
+	TTLMisses         int64 // Latest versions and company IDs
+}
+
+// Conn is a read-through caching docdb.Conn wrapper.
+type Conn struct {
+	conn            docdb.Conn
+	maxVersionInfos int
+	maxFileBytes    int64
+	ttl             time.Duration
+	blobDir         fs.File
+
+	versionInfos   *lru[versionKey, *docdb.VersionInfo]
+	files          *lru[fileKey, []byte]
+	latestVersions *lru[uu.ID, expiring[docdb.VersionTime]]
+	companyIDs     *lru[uu.ID, expiring[uu.ID]]
+
+	versionInfoHits, versionInfoMisses atomic.Int64
+	fileHits, fileMisses               atomic.Int64
+	blobHits, blobMisses               atomic.Int64
+	ttlHits, ttlMisses                 atomic.Int64
+}
+
+type versionKey struct {
+	docID   uu.ID
+	version docdb.VersionTime
+}
+
+type fileKey struct {
+	versionKey
+	filename string
+}
+
+type expiring[V any] struct {
+	value   V
+	expires time.Time
+}
+
+var _ docdb.Conn = (*Conn)(nil)
+
+// New returns a Conn caching the results of conn.
+func New(conn docdb.Conn, options ...Option) *Conn {
+	c := &Conn{
+		conn:            conn,
+		maxVersionInfos: DefaultMaxVersionInfos,
+		maxFileBytes:    DefaultMaxFileBytes,
+		ttl:             DefaultTTL,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	c.versionInfos = newLRU[versionKey, *docdb.VersionInfo](int64(c.maxVersionInfos))
+	c.files = newLRU[fileKey, []byte](c.maxFileBytes)
+	c.latestVersions = newLRU[uu.ID, expiring[docdb.VersionTime]](int64(c.maxVersionInfos))
+	c.companyIDs = newLRU[uu.ID, expiring[uu.ID]](int64(c.maxVersionInfos))
+	return c
+}
+
+func (c *Conn) String() string {
+	return "cacheconn.Conn"
+}
+
+// Stats returns the hit and miss counts since the Conn was created.
+func (c *Conn) Stats() Stats {
+	return Stats{
+		VersionInfoHits:   c.versionInfoHits.Load(),
+		VersionInfoMisses: c.versionInfoMisses.Load(),
+		FileHits:          c.fileHits.Load(),
+		FileMisses:        c.fileMisses.Load(),
+		BlobHits:          c.blobHits.Load(),
+		BlobMisses:        c.blobMisses.Load(),
+		TTLHits:           c.ttlHits.Load(),
+		TTLMisses:         c.ttlMisses.Load(),
+	}
+}
+
+// invalidateMutable removes the cached latest version
+// and company of the documents.
+func (c *Conn) invalidateMutable(docIDs ...uu.ID) {
+	for _, docID := range docIDs {
+		c.latestVersions.remove(docID)
+		c.companyIDs.remove(docID)
+	}
+}
+
+// invalidateDocument removes all cached entries of a document
+// whose versions were deleted or replaced.
+func (c *Conn) invalidateDocument(docID uu.ID) {
+	c.invalidateMutable(docID)
+	c.versionInfos.removeFunc(func(key versionKey) bool { return key.docID == docID })
+	c.files.removeFunc(func(key fileKey) bool { return key.docID == docID })
+}
+
+func (c *Conn) DocumentExists(ctx context.Context, docID uu.ID) (bool, error) {
+	return c.conn.DocumentExists(ctx, docID)
+}
+
+func (c *Conn) CompanyIDs(ctx context.Context) (uu.IDSlice, error) {
+	return c.conn.CompanyIDs(ctx)
+}
+
+func (c *Conn) CompanyDocumentIDs(ctx context.Context, companyID uu.ID) (uu.IDSlice, error) {
+	return c.conn.CompanyDocumentIDs(ctx, companyID)
+}
+
+func (c *Conn) DocumentCompanyID(ctx context.Context, docID uu.ID) (uu.ID, error) {
+	if cached, ok := c.companyIDs.get(docID); ok && time.Now().Before(cached.expires) {
+		c.ttlHits.Add(1)
+		return cached.value, nil
+	}
+	c.ttlMisses.Add(1)
+	companyID, err := c.conn.DocumentCompanyID(ctx, docID)
+	if err != nil {
+		return uu.IDNil, err
+	}
+	if c.ttl > 0 {
+		c.companyIDs.add(docID, expiring[uu.ID]{companyID, time.Now().Add(c.ttl)}, 1)
+	}
+	return companyID, nil
+}
+
+func (c *Conn) SetDocumentCompanyID(ctx context.Context, docID, companyID uu.ID) error {
+	defer c.invalidateMutable(docID)
+	return c.conn.SetDocumentCompanyID(ctx, docID, companyID)
+}
+
+func (c *Conn) DocumentVersions(ctx context.Context, docID uu.ID) ([]docdb.VersionTime, error) {
+	return c.conn.DocumentVersions(ctx, docID)
+}
+
+func (c *Conn) LatestDocumentVersion(ctx context.Context, docID uu.ID) (docdb.VersionTime, error) {
+	if cached, ok := c.latestVersions.get(docID); ok && time.Now().Before(cached.expires) {
+		c.ttlHits.Add(1)
+		return cached.value, nil
+	}
+	c.ttlMisses.Add(1)
+	version, err := c.conn.LatestDocumentVersion(ctx, docID)
+	if err != nil {
+		return docdb.VersionTime{}, err
+	}
+	if c.ttl > 0 {
+		c.latestVersions.add(docID, expiring[docdb.VersionTime]{version, time.Now().Add(c.ttl)}, 1)
+	}
+	return version, nil
+}
+
+func (c *Conn) DocumentVersionInfo(ctx context.Context, docID uu.ID, version docdb.VersionTime) (*docdb.VersionInfo, error) {
+	info, err := c.versionInfo(ctx, docID, version)
+	if err != nil {
+		return nil, err
+	}
+	return info.Clone(), nil
+}
+
+// versionInfo returns the cached VersionInfo
+// which must not be modified.
+func (c *Conn) versionInfo(ctx context.Context, docID uu.ID, version docdb.VersionTime) (*docdb.VersionInfo, error) {
+	key := versionKey{docID, version}
+	if info, ok := c.versionInfos.get(key); ok {
+		c.versionInfoHits.Add(1)
+		return info, nil
+	}
+	c.versionInfoMisses.Add(1)
+	info, err := c.conn.DocumentVersionInfo(ctx, docID, version)
+	if err != nil {
+		return nil, err
+	}
+	c.versionInfos.add(key, info.Clone(), 1)
+	return info, nil
+}
+
+// LatestDocumentVersionInfo combines the cached latest version
+// with the cached VersionInfo of that version.
+func (c *Conn) LatestDocumentVersionInfo(ctx context.Context, docID uu.ID) (*docdb.VersionInfo, error) {
+	version, err := c.LatestDocumentVersion(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	return c.DocumentVersionInfo(ctx, docID, version)
+}
+
+// DocumentVersionFileProvider returns a FileProvider
+// that reads files through the cache.
+func (c *Conn) DocumentVersionFileProvider(ctx context.Context, docID uu.ID, version docdb.VersionTime) (docdb.FileProvider, error) {
+	provider, err := c.conn.DocumentVersionFileProvider(ctx, docID, version)
+	if err != nil {
+		return nil, err
+	}
+	return &fileProvider{FileProvider: provider, conn: c, docID: docID, version: version}, nil
+}
+
+func (c *Conn) ReadDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) ([]byte, error) {
+	return c.readFile(ctx, docID, version, filename, func(ctx context.Context) ([]byte, error) {
+		return c.conn.ReadDocumentVersionFile(ctx, docID, version, filename)
+	})
+}
+
+// OpenDocumentVersionFile returns cached content as reader
+// but does not cache content opened for streaming.
+func (c *Conn) OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (io.ReadCloser, error) {
+	key := fileKey{versionKey{docID, version}, filename}
+	if data, ok := c.files.get(key); ok {
+		c.fileHits.Add(1)
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	c.fileMisses.Add(1)
+	return c.conn.OpenDocumentVersionFile(ctx, docID, version, filename)
+}
+
+// ReadDocumentVersionFileRange reads the range from cached content
+// but does not cache ranges read from the wrapped Conn.
+func (c *Conn) ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string, offset, length int64) ([]byte, error) {
+	key := fileKey{versionKey{docID, version}, filename}
+	if data, ok := c.files.get(key); ok {
+		c.fileHits.Add(1)
+		return docdb.ReadRange(bytes.NewReader(data), offset, length)
+	}
+	c.fileMisses.Add(1)
+	return c.conn.ReadDocumentVersionFileRange(ctx, docID, version, filename, offset, length)
+}
+
+// readFile returns the file content from the memory cache,
+// the blob cache or read.
+func (c *Conn) readFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string, read func(context.Context) ([]byte, error)) ([]byte, error) {
+	key := fileKey{versionKey{docID, version}, filename}
+	if data, ok := c.files.get(key); ok {
+		c.fileHits.Add(1)
+		return bytes.Clone(data), nil
+	}
+	c.fileMisses.Add(1)
+
+	var fileInfo docdb.FileInfo
+	if c.blobDir != "" {
+		// Errors are returned by read below
+		if info, err := c.versionInfo(ctx, docID, version); err == nil {
+			fileInfo = info.Files[filename]
+		}
+		if fileInfo.Hash != "" {
+			if data, ok := c.readBlob(ctx, fileInfo); ok {
+				c.blobHits.Add(1)
+				c.files.add(key, data, int64(len(data)))
+				return bytes.Clone(data), nil
+			}
+			c.blobMisses.Add(1)
+		}
+	}
+
+	data, err := read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.files.add(key, bytes.Clone(data), int64(len(data)))
+	if fileInfo.Hash != "" {
+		c.writeBlob(ctx, fileInfo.Hash, data)
+	}
+	return data, nil
+}
+
+func (c *Conn) DeleteDocument(ctx context.Context, docID uu.ID) error {
+	defer c.invalidateDocument(docID)
+	return c.conn.DeleteDocument(ctx, docID)
+}
+
+func (c *Conn) DeleteDocumentVersion(ctx context.Context, docID uu.ID, version docdb.VersionTime) ([]docdb.VersionTime, error) {
+	defer c.invalidateDocument(docID)
+	return c.conn.DeleteDocumentVersion(ctx, docID, version)
+}
+
+func (c *Conn) CreateDocument(ctx context.Context, companyID, docID, userID uu.ID, reason string, version docdb.VersionTime, files []fs.FileReader, onNewVersion docdb.OnNewVersionFunc) error {
+	defer c.invalidateDocument(docID)
+	return c.conn.CreateDocument(ctx, companyID, docID, userID, reason, version, files, onNewVersion)
+}
+
+func (c *Conn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+	defer c.invalidateMutable(docID)
+	return c.conn.AddDocumentVersion(ctx, docID, userID, reason, createVersion, onNewVersion)
+}
+
+func (c *Conn) AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+	defer c.invalidateMutable(docID)
+	return c.conn.AddDocumentVersionIfLatest(ctx, docID, expectedPrev, userID, reason, createVersion, onNewVersion)
+}
+
+func (c *Conn) AddMultiDocumentVersion(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+	defer c.invalidateMutable(docIDs...)
+	return c.conn.AddMultiDocumentVersion(ctx, docIDs, userID, reason, createVersion, onNewVersion)
+}
+
+func (c *Conn) RestoreDocument(ctx context.Context, doc *docdb.HashedDocument, recreate bool) error {
+	defer c.invalidateDocument(doc.ID)
+	return c.conn.RestoreDocument(ctx, doc, recreate)
+}
+
+// fileProvider reads files of a version through the cache
+// of conn and forwards all other calls.
+type fileProvider struct {
+	docdb.FileProvider
+	conn    *Conn
+	docID   uu.ID
+	version docdb.VersionTime
+}
+
+func (p *fileProvider) ReadFile(ctx context.Context, filename string) ([]byte, error) {
+	return p.conn.readFile(ctx, p.docID, p.version, filename, func(ctx context.Context) ([]byte, error) {
+		return p.FileProvider.ReadFile(ctx, filename)
+	})
+}
@@ -0,0 +1,193 @@
+package cacheconn_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/cacheconn"
+	"github.com/domonda/go-docdb/docdbtest"
+	"github.com/domonda/go-docdb/faultconn"
+	"github.com/domonda/go-docdb/memconn"
+	"github.com/domonda/go-types/uu"
+)
+
+// newConn returns a cacheconn.Conn wrapping a memconn.Conn with a document
+// and an Injector without rules counting the calls forwarded to the memconn.Conn.
+func newConn(t *testing.T, docID uu.ID, options ...cacheconn.Option) (*cacheconn.Conn, *faultconn.Injector) {
+	t.Helper()
+	inj := faultconn.NewInjector()
+	conn := cacheconn.New(faultconn.New(memconn.New(), inj), options...)
+	require.NoError(t, docdbtest.CreateDocument(t.Context(), conn, docID))
+	return conn, inj
+}
+
+func TestConnConformance(t *testing.T) {
+	docdbtest.RunConnTests(t, func(t *testing.T) docdb.Conn {
+		return cacheconn.New(memconn.New())
+	})
+}
+
+func TestDocumentVersionInfo(t *testing.T) {
+	ctx := t.Context()
+	docID := uu.IDv7()
+	conn, inj := newConn(t, docID)
+
+	info, err := conn.DocumentVersionInfo(ctx, docID, docdbtest.Version1)
+	require.NoError(t, err)
+	// Modifying a returned VersionInfo must not modify the cache
+	info.CommitReason = "modified"
+	info, err = conn.DocumentVersionInfo(ctx, docID, docdbtest.Version1)
+	require.NoError(t, err)
+	require.Equal(t, "create", info.CommitReason)
+
+	require.Equal(t, 1, inj.Calls("Conn.DocumentVersionInfo"))
+	stats := conn.Stats()
+	require.Equal(t, int64(1), stats.VersionInfoHits)
+	require.Equal(t, int64(1), stats.VersionInfoMisses)
+
+	_, err = conn.DocumentVersionInfo(ctx, docID, docdbtest.Version2)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentVersionNotFound))
+	_, err = conn.DocumentVersionInfo(ctx, docID, docdbtest.Version2)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentVersionNotFound))
+	require.Equal(t, 3, inj.Calls("Conn.DocumentVersionInfo"), "errors must not be cached")
+}
+
+func TestReadDocumentVersionFile(t *testing.T) {
+	ctx := t.Context()
+	docID := uu.IDv7()
+	conn, inj := newConn(t, docID)
+
+	for range 3 {
+		data, err := conn.ReadDocumentVersionFile(ctx, docID, docdbtest.Version1, "doc.pdf")
+		require.NoError(t, err)
+		require.Equal(t, "pdf", string(data))
+		// Modifying returned data must not modify the cache
+		data[0] = 'x'
+	}
+	reader, err := conn.OpenDocumentVersionFile(ctx, docID, docdbtest.Version1, "doc.pdf")
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, "pdf", string(data))
+
+	provider, err := conn.DocumentVersionFileProvider(ctx, docID, docdbtest.Version1)
+	require.NoError(t, err)
+	data, err = provider.ReadFile(ctx, "doc.pdf")
+	require.NoError(t, err)
+	require.Equal(t, "pdf", string(data))
+
+	require.Equal(t, 1, inj.Calls("Conn.ReadDocumentVersionFile"))
+	require.Equal(t, 0, inj.Calls("Conn.OpenDocumentVersionFile"))
+	stats := conn.Stats()
+	require.Equal(t, int64(4), stats.FileHits)
+	require.Equal(t, int64(1), stats.FileMisses)
+}
+
+func TestWithMaxFileBytes(t *testing.T) {
+	ctx := t.Context()
+	docID := uu.IDv7()
+	conn, inj := newConn(t, docID, cacheconn.WithMaxFileBytes(2))
+
+	for range 2 {
+		data, err := conn.ReadDocumentVersionFile(ctx, docID, docdbtest.Version1, "doc.pdf")
+		require.NoError(t, err)
+		require.Equal(t, "pdf", string(data))
+	}
+	require.Equal(t, 2, inj.Calls("Conn.ReadDocumentVersionFile"), "file larger than limit must not be cached")
+}
+
+func TestWithBlobCache(t *testing.T) {
+	ctx := t.Context()
+	docID := uu.IDv7()
+	blobDir := fs.File(t.TempDir())
+	conn, inj := newConn(t, docID, cacheconn.WithBlobCache(blobDir))
+
+	data, err := conn.ReadDocumentVersionFile(ctx, docID, docdbtest.Version1, "doc.pdf")
+	require.NoError(t, err)
+	require.Equal(t, "pdf", string(data))
+	hash := docdb.ContentHash([]byte("pdf"))
+	require.True(t, blobDir.Join(hash[:2], hash).Exists())
+
+	// A new Conn with the same blob cache and an empty memory cache
+	inj.Reset()
+	cached := cacheconn.New(faultconn.New(conn, inj), cacheconn.WithBlobCache(blobDir))
+	data, err = cached.ReadDocumentVersionFile(ctx, docID, docdbtest.Version1, "doc.pdf")
+	require.NoError(t, err)
+	require.Equal(t, "pdf", string(data))
+	require.Equal(t, 0, inj.Calls("Conn.ReadDocumentVersionFile"))
+	require.Equal(t, int64(1), cached.Stats().BlobHits)
+
+	// Corrupted blobs are not used
+	require.NoError(t, blobDir.Join(hash[:2], hash).WriteAllString("xyz"))
+	cached = cacheconn.New(faultconn.New(conn, inj), cacheconn.WithBlobCache(blobDir))
+	data, err = cached.ReadDocumentVersionFile(ctx, docID, docdbtest.Version1, "doc.pdf")
+	require.NoError(t, err)
+	require.Equal(t, "pdf", string(data))
+	require.Equal(t, int64(1), cached.Stats().BlobMisses)
+}
+
+func TestTTL(t *testing.T) {
+	ctx := t.Context()
+	docID := uu.IDv7()
+	conn, inj := newConn(t, docID, cacheconn.WithTTL(time.Hour))
+
+	latest, err := conn.LatestDocumentVersion(ctx, docID)
+	require.NoError(t, err)
+	require.Equal(t, docdbtest.Version1, latest)
+	_, err = conn.DocumentCompanyID(ctx, docID)
+	require.NoError(t, err)
+	info, err := conn.LatestDocumentVersionInfo(ctx, docID)
+	require.NoError(t, err)
+	require.Equal(t, docdbtest.Version1, info.Version)
+	require.Equal(t, 1, inj.Calls("Conn.LatestDocumentVersion"))
+
+	t.Run("writes through the Conn invalidate", func(t *testing.T) {
+		err := conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "add",
+			func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+				return &docdb.CreateVersionResult{Version: docdbtest.Version2, WriteFiles: []fs.FileReader{fs.NewMemFile("a.txt", []byte("a"))}}, nil
+			},
+			docdbtest.NoopOnNewVersion,
+		)
+		require.NoError(t, err)
+		latest, err := conn.LatestDocumentVersion(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, docdbtest.Version2, latest)
+
+		newCompanyID := uu.IDv7()
+		require.NoError(t, conn.SetDocumentCompanyID(ctx, docID, newCompanyID))
+		companyID, err := conn.DocumentCompanyID(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, newCompanyID, companyID)
+	})
+
+	t.Run("zero TTL disables caching", func(t *testing.T) {
+		conn, inj := newConn(t, docID, cacheconn.WithTTL(0))
+		for range 2 {
+			_, err := conn.DocumentCompanyID(ctx, docID)
+			require.NoError(t, err)
+		}
+		require.Equal(t, 2, inj.Calls("Conn.DocumentCompanyID"))
+	})
+}
+
+func TestDeleteDocumentInvalidates(t *testing.T) {
+	ctx := t.Context()
+	docID := uu.IDv7()
+	conn, _ := newConn(t, docID)
+
+	_, err := conn.ReadDocumentVersionFile(ctx, docID, docdbtest.Version1, "doc.pdf")
+	require.NoError(t, err)
+	require.NoError(t, conn.DeleteDocument(ctx, docID))
+
+	_, err = conn.ReadDocumentVersionFile(ctx, docID, docdbtest.Version1, "doc.pdf")
+	require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound))
+	_, err = conn.LatestDocumentVersion(ctx, docID)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound))
+}
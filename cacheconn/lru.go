@@ -0,0 +1,90 @@
+package cacheconn
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lru is a least recently used cache bounded by the sum
+// of the costs of its entries. It is safe for concurrent use.
+type lru[K comparable, V any] struct {
+	mtx     sync.Mutex
+	maxCost int64
+	cost    int64
+	entries map[K]*list.Element
+	order   *list.List // front is most recently used
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+	cost  int64
+}
+
+func newLRU[K comparable, V any](maxCost int64) *lru[K, V] {
+	return &lru[K, V]{
+		maxCost: maxCost,
+		entries: make(map[K]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *lru[K, V]) get(key K) (value V, ok bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return value, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry[K, V]).value, true
+}
+
+// add adds or replaces the value for key and evicts least recently
+// used entries until the cost is within the limit.
+// A value costing more than the limit is not added.
+func (c *lru[K, V]) add(key K, value V, cost int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+	if cost > c.maxCost {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value, cost: cost})
+	c.cost += cost
+	for c.cost > c.maxCost {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// remove removes the entry of key if it exists.
+func (c *lru[K, V]) remove(key K) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// removeFunc removes all entries whose key matches.
+func (c *lru[K, V]) removeFunc(match func(K) bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for key, elem := range c.entries {
+		if match(key) {
+			c.removeElement(elem)
+		}
+	}
+}
+
+func (c *lru[K, V]) removeElement(elem *list.Element) {
+	entry := c.order.Remove(elem).(*lruEntry[K, V])
+	delete(c.entries, entry.key)
+	c.cost -= entry.cost
+}
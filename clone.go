@@ -0,0 +1,93 @@
+package docdb
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+	"github.com/ungerik/go-fs"
+)
+
+// CloneDocument copies the document srcDocID to the new document newDocID
+// owned by newCompanyID.
+//
+// With history all versions are copied with their version timestamps,
+// commit users and reasons via the HashedDocument used by SyncDocument,
+// so userID and reason are not used.
+// The first version is created with CreateDocument and the other versions
+// are added with Conn.RestoreDocument. If that fails the clone is deleted.
+//
+// Without history the new document gets a single version with
+// the files of the latest version of srcDocID, the current time
+// as version timestamp, userID and reason.
+//
+// Only the Conn methods are used, so with a routerconn the source
+// and the clone can be stored by different backends.
+//
+// Returns wrapped ErrDocumentAlreadyExists if newDocID already exists.
+func CloneDocument(ctx context.Context, conn Conn, srcDocID, newDocID, newCompanyID, userID uu.ID, reason string, withHistory bool) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, conn, srcDocID, newDocID, newCompanyID, userID, reason, withHistory)
+
+	if srcDocID == newDocID {
+		return errs.Errorf("can't clone document %s into itself", srcDocID)
+	}
+	if err = newCompanyID.Validate(); err != nil {
+		return err
+	}
+	if !withHistory {
+		return cloneLatestDocumentVersion(ctx, conn, srcDocID, newDocID, newCompanyID, userID, reason)
+	}
+
+	doc, err := ReadHashedDocument(ctx, conn, srcDocID)
+	if err != nil {
+		return err
+	}
+	doc.ID = newDocID
+	doc.CompanyID = newCompanyID
+
+	// Creating the first version fails atomically for an existing newDocID,
+	// RestoreDocument would merge the versions into it
+	versions := doc.VersionTimes()
+	first := doc.Versions[versions[0]]
+	err = conn.CreateDocument(ctx, newCompanyID, newDocID, first.CommitUserID, first.CommitReason, versions[0], hashedVersionFiles(doc, first), CaptureNewVersionInfo(new(*VersionInfo)))
+	if err != nil || len(versions) == 1 {
+		return err
+	}
+	err = conn.RestoreDocument(ctx, doc, false)
+	if err != nil {
+		return errors.Join(err, conn.DeleteDocument(ctx, newDocID))
+	}
+	return nil
+}
+
+func cloneLatestDocumentVersion(ctx context.Context, conn Conn, srcDocID, newDocID, newCompanyID, userID uu.ID, reason string) error {
+	latest, err := conn.LatestDocumentVersionInfo(ctx, srcDocID)
+	if err != nil {
+		return err
+	}
+	provider, err := conn.DocumentVersionFileProvider(ctx, srcDocID, latest.Version)
+	if err != nil {
+		return err
+	}
+	files := make([]fs.FileReader, 0, len(latest.Files))
+	for _, filename := range slices.Sorted(maps.Keys(latest.Files)) {
+		file, err := ReadMemFile(ctx, provider, filename)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+	}
+	return conn.CreateDocument(ctx, newCompanyID, newDocID, userID, reason, NewVersionTime(), files, CaptureNewVersionInfo(new(*VersionInfo)))
+}
+
+// hashedVersionFiles returns the files of a version of doc.
+func hashedVersionFiles(doc *HashedDocument, version *HashedVersion) []fs.FileReader {
+	files := make([]fs.FileReader, 0, len(version.FileHashes))
+	for _, filename := range slices.Sorted(maps.Keys(version.FileHashes)) {
+		files = append(files, fs.NewMemFile(filename, doc.HashedFiles[version.FileHashes[filename]]))
+	}
+	return files
+}
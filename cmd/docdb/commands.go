@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ungerik/go-fs"
+	"github.com/ungerik/go-fs/uuiddir"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/localfsdb"
+	"github.com/domonda/go-types/uu"
+)
+
+func companiesCmd(ctx context.Context, c *cli, args []string) error {
+	if _, err := parseFlags(flag.NewFlagSet("companies", flag.ContinueOnError), args, 0, 0); err != nil {
+		return err
+	}
+	conn, err := openStore(ctx, c.store, &c.opts)
+	if err != nil {
+		return err
+	}
+	companyIDs, err := conn.CompanyIDs(ctx)
+	if err != nil {
+		return err
+	}
+	return c.output(nonNil(companyIDs), func(w io.Writer) {
+		fmt.Fprint(w, joinLines(companyIDs))
+	})
+}
+
+func docsCmd(ctx context.Context, c *cli, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("docs", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	companyID, err := parseID("company", args[0])
+	if err != nil {
+		return err
+	}
+	conn, err := openStore(ctx, c.store, &c.opts)
+	if err != nil {
+		return err
+	}
+	docIDs, err := conn.CompanyDocumentIDs(ctx, companyID)
+	if err != nil {
+		return err
+	}
+	return c.output(nonNil(docIDs), func(w io.Writer) {
+		fmt.Fprint(w, joinLines(docIDs))
+	})
+}
+
+func versionsCmd(ctx context.Context, c *cli, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("versions", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	docID, err := parseID("document", args[0])
+	if err != nil {
+		return err
+	}
+	conn, err := openStore(ctx, c.store, &c.opts)
+	if err != nil {
+		return err
+	}
+	versions, err := conn.DocumentVersions(ctx, docID)
+	if err != nil {
+		return err
+	}
+	return c.output(versions, func(w io.Writer) {
+		fmt.Fprint(w, joinLines(versions))
+	})
+}
+
+func infoCmd(ctx context.Context, c *cli, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("info", flag.ContinueOnError), args, 1, 2)
+	if err != nil {
+		return err
+	}
+	docID, err := parseID("document", args[0])
+	if err != nil {
+		return err
+	}
+	conn, err := openStore(ctx, c.store, &c.opts)
+	if err != nil {
+		return err
+	}
+	var info *docdb.VersionInfo
+	if len(args) == 1 || args[1] == "latest" {
+		info, err = conn.LatestDocumentVersionInfo(ctx, docID)
+	} else {
+		var version docdb.VersionTime
+		version, err = parseVersion(ctx, conn, docID, args[1])
+		if err != nil {
+			return err
+		}
+		info, err = conn.DocumentVersionInfo(ctx, docID, version)
+	}
+	if err != nil {
+		return err
+	}
+	return c.output(info, func(w io.Writer) {
+		printVersionInfo(w, info)
+	})
+}
+
+// printVersionInfo prints info with a line per file
+// marking added and modified files.
+func printVersionInfo(w io.Writer, info *docdb.VersionInfo) {
+	prevVersion := "-"
+	if info.PrevVersion != nil {
+		prevVersion = info.PrevVersion.String()
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Document:\t%s\n", info.DocID)
+	fmt.Fprintf(tw, "Company:\t%s\n", info.CompanyID)
+	fmt.Fprintf(tw, "Version:\t%s\n", info.Version)
+	fmt.Fprintf(tw, "Previous:\t%s\n", prevVersion)
+	fmt.Fprintf(tw, "User:\t%s\n", info.CommitUserID)
+	fmt.Fprintf(tw, "Reason:\t%q\n", info.CommitReason)
+	if info.IdempotencyKey != "" {
+		fmt.Fprintf(tw, "Idempotency key:\t%s\n", info.IdempotencyKey)
+	}
+	if len(info.RemovedFiles) > 0 {
+		fmt.Fprintf(tw, "Removed:\t%s\n", strings.Join(info.RemovedFiles, ", "))
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w, "Files:")
+	for _, filename := range slices.Sorted(maps.Keys(info.Files)) {
+		file := info.Files[filename]
+		change := ""
+		switch {
+		case slices.Contains(info.AddedFiles, filename):
+			change = "added"
+		case slices.Contains(info.ModifiedFiles, filename):
+			change = "modified"
+		}
+		fmt.Fprintf(tw, "  %s\t%d\t%s\t%s\n", file.Name, file.Size, file.Hash, change)
+	}
+	tw.Flush()
+}
+
+func catCmd(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("cat", flag.ContinueOnError)
+	offset := flags.Int64("offset", 0, "byte offset to start reading at")
+	length := flags.Int64("length", -1, "number of bytes to read, negative reads until the end of the file")
+	args, err := parseFlags(flags, args, 3, 3)
+	if err != nil {
+		return err
+	}
+	docID, err := parseID("document", args[0])
+	if err != nil {
+		return err
+	}
+	conn, err := openStore(ctx, c.store, &c.opts)
+	if err != nil {
+		return err
+	}
+	version, err := parseVersion(ctx, conn, docID, args[1])
+	if err != nil {
+		return err
+	}
+	filename := args[2]
+
+	if *offset != 0 || *length >= 0 {
+		data, err := conn.ReadDocumentVersionFileRange(ctx, docID, version, filename, *offset, *length)
+		if err != nil {
+			return err
+		}
+		_, err = c.stdout.Write(data)
+		return err
+	}
+	reader, err := conn.OpenDocumentVersionFile(ctx, docID, version, filename)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(c.stdout, reader)
+	return err
+}
+
+func historyCmd(ctx context.Context, c *cli, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("history", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	docID, err := parseID("document", args[0])
+	if err != nil {
+		return err
+	}
+	conn, err := openStore(ctx, c.store, &c.opts)
+	if err != nil {
+		return err
+	}
+	if !c.json {
+		return docdb.DebugFprintDocument(ctx, c.stdout, conn, docID, "", "  ")
+	}
+	versions, err := conn.DocumentVersions(ctx, docID)
+	if err != nil {
+		return err
+	}
+	infos := make([]*docdb.VersionInfo, len(versions))
+	for i, version := range versions {
+		infos[i], err = conn.DocumentVersionInfo(ctx, docID, version)
+		if err != nil {
+			return err
+		}
+	}
+	return c.output(infos, nil)
+}
+
+// exportCmd copies documents with docdb.CopyDocumentFiles into the
+// documents sub-directory of the export directory and completes
+// the company mapping in its companies sub-directory with
+// makeCompanyDocumentDirs, so the export directory can be used as store.
+func exportCmd(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	overwrite := flags.Bool("overwrite", false, "overwrite already exported documents")
+	args, err := parseFlags(flags, args, 1, -1)
+	if err != nil {
+		return err
+	}
+	dir := fs.File(args[0])
+	companyIDs, err := parseIDs("company", args[1:])
+	if err != nil {
+		return err
+	}
+	conn, err := openStore(ctx, c.store, &c.opts)
+	if err != nil {
+		return err
+	}
+	if len(companyIDs) == 0 {
+		companyIDs, err = conn.CompanyIDs(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	documentsDir := dir.Join("documents")
+	companiesDir := dir.Join("companies")
+	for _, d := range []fs.File{documentsDir, companiesDir} {
+		if err = d.MakeAllDirs(); err != nil {
+			return err
+		}
+	}
+
+	exported := uu.IDSlice{}
+	progress := c.progress("Exporting")
+	for _, companyID := range companyIDs {
+		docIDs, err := conn.CompanyDocumentIDs(ctx, companyID)
+		if err != nil {
+			return err
+		}
+		for i, docID := range docIDs {
+			progress(ctx, docID, i, len(docIDs))
+			_, err = docdb.CopyDocumentFiles(ctx, conn, docID, documentsDir, *overwrite)
+			if err != nil {
+				return err
+			}
+			exported = append(exported, docID)
+		}
+	}
+	err = makeCompanyDocumentDirs(ctx, localfsdb.NewConn(documentsDir, companiesDir), documentsDir, companiesDir)
+	if err != nil {
+		return err
+	}
+	return c.output(exported, func(w io.Writer) {
+		fmt.Fprintf(w, "Exported %d documents to %s\n", len(exported), dir.LocalPath())
+	})
+}
+
+// importCmd syncs all documents of an export directory into the store.
+// The company mapping is built with makeCompanyDocumentDirs
+// in a temporary directory, so the export directory is not modified
+// and can also be written by docdb.CopyDocumentFiles in other programs.
+func importCmd(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	recreate := flags.Bool("recreate", false, "replace existing documents instead of merging versions")
+	continueOnError := flags.Bool("continue-on-error", false, "continue with the next document after an error")
+	args, err := parseFlags(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	dir := fs.File(args[0])
+	documentsDir := dir.Join("documents")
+	if !documentsDir.IsDir() {
+		return fmt.Errorf("%s has no documents directory", dir.LocalPath())
+	}
+	tempDir, err := os.MkdirTemp("", "docdb-import-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+	companiesDir := fs.File(tempDir)
+	src := localfsdb.NewConn(documentsDir, companiesDir)
+	err = makeCompanyDocumentDirs(ctx, src, documentsDir, companiesDir)
+	if err != nil {
+		return err
+	}
+	dst, err := openStore(ctx, c.store, &c.opts)
+	if err != nil {
+		return err
+	}
+	return c.syncCompanies(ctx, src, dst, nil, *recreate, *continueOnError)
+}
+
+// makeCompanyDocumentDirs creates the company document directories
+// of the localfsdb conn in companiesDir for all documents in documentsDir.
+// The company of a document is read with conn.DocumentCompanyID
+// from its company.id file, which docdb.CopyDocumentFiles writes.
+// In contrast to localfsdb.Conn.Recover nothing in documentsDir is changed.
+func makeCompanyDocumentDirs(ctx context.Context, conn *localfsdb.Conn, documentsDir, companiesDir fs.File) error {
+	return uuiddir.Enum(ctx, documentsDir, func(_ fs.File, docID [16]byte) error {
+		companyID, err := conn.DocumentCompanyID(ctx, docID)
+		if err != nil {
+			return err
+		}
+		return uuiddir.Join(companiesDir.Join(companyID.String()), docID).MakeAllDirs()
+	})
+}
+
+func syncCmd(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	recreate := flags.Bool("recreate", false, "replace existing documents instead of merging versions")
+	continueOnError := flags.Bool("continue-on-error", false, "continue with the next document after an error")
+	args, err := parseFlags(flags, args, 2, -1)
+	if err != nil {
+		return err
+	}
+	if isS3Store(args[0]) && isS3Store(args[1]) {
+		// pgstore uses the global database connection, so both stores
+		// would share the metadata and dst would already contain every document
+		return usageError("src and dst can't both be s3:// stores because they share the -postgres metadata database")
+	}
+	companyIDs, err := parseIDs("company", args[2:])
+	if err != nil {
+		return err
+	}
+	src, err := openStore(ctx, args[0], &c.opts)
+	if err != nil {
+		return err
+	}
+	dst, err := openStore(ctx, args[1], &c.opts)
+	if err != nil {
+		return err
+	}
+	return c.syncCompanies(ctx, src, dst, companyIDs, *recreate, *continueOnError)
+}
+
+// syncCompanies syncs the documents of companyIDs or of all companies of src
+// to dst with docdb.SyncAllCompanyDocuments and outputs the synced document IDs.
+func (c *cli) syncCompanies(ctx context.Context, src, dst docdb.Conn, companyIDs uu.IDSlice, recreate, continueOnError bool) (err error) {
+	if len(companyIDs) == 0 {
+		companyIDs, err = src.CompanyIDs(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	synced := uu.IDSlice{}
+	var syncErrs []error
+	for _, companyID := range companyIDs {
+		docIDs, err := docdb.SyncAllCompanyDocuments(ctx, src, dst, companyID, recreate, continueOnError, c.progress("Syncing"))
+		synced = append(synced, docIDs...)
+		if err != nil {
+			if !continueOnError {
+				return err
+			}
+			syncErrs = append(syncErrs, err)
+		}
+	}
+	err = c.output(synced, func(w io.Writer) {
+		fmt.Fprintf(w, "Synced %d documents\n", len(synced))
+	})
+	return errors.Join(append(syncErrs, err)...)
+}
+
+func verifyCmd(ctx context.Context, c *cli, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("verify", flag.ContinueOnError), args, 0, -1)
+	if err != nil {
+		return err
+	}
+	companyIDs, err := parseIDs("company", args)
+	if err != nil {
+		return err
+	}
+	conn, err := openStore(ctx, c.store, &c.opts)
+	if err != nil {
+		return err
+	}
+	var reports []*docdb.VerifyReport
+	if len(companyIDs) == 0 {
+		reports, err = docdb.Verify(ctx, conn, c.progress("Verifying"))
+		if err != nil {
+			return err
+		}
+	}
+	for _, companyID := range companyIDs {
+		companyReports, err := docdb.VerifyCompany(ctx, conn, companyID, c.progress("Verifying"))
+		if err != nil {
+			return err
+		}
+		reports = append(reports, companyReports...)
+	}
+
+	failed := 0
+	for _, report := range reports {
+		if !report.OK() {
+			failed++
+		}
+	}
+	err = c.output(nonNil(reports), func(w io.Writer) {
+		for _, report := range reports {
+			if !report.OK() {
+				fmt.Fprintln(w, report)
+			}
+		}
+		fmt.Fprintf(w, "Verified %d documents, %d with problems\n", len(reports), failed)
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d documents with problems", failed)
+	}
+	return nil
+}
+
+func deleteCmd(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "confirm the deletion")
+	args, err := parseFlags(flags, args, 1, 2)
+	if err != nil {
+		return err
+	}
+	if !*yes {
+		return usageError("deleting needs confirmation with -yes")
+	}
+	docID, err := parseID("document", args[0])
+	if err != nil {
+		return err
+	}
+	conn, err := openStore(ctx, c.store, &c.opts)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		err = conn.DeleteDocument(ctx, docID)
+		if err != nil {
+			return err
+		}
+		return c.output([]docdb.VersionTime{}, func(w io.Writer) {
+			fmt.Fprintf(w, "Deleted document %s\n", docID)
+		})
+	}
+	version, err := parseVersion(ctx, conn, docID, args[1])
+	if err != nil {
+		return err
+	}
+	leftVersions, err := conn.DeleteDocumentVersion(ctx, docID, version)
+	if err != nil {
+		return err
+	}
+	return c.output(nonNil(leftVersions), func(w io.Writer) {
+		fmt.Fprintf(w, "Deleted version %s of document %s, %d versions left\n", version, docID, len(leftVersions))
+	})
+}
+
+func parseID(name, str string) (uu.ID, error) {
+	id, err := uu.IDFromString(str)
+	if err != nil {
+		return uu.IDNil, usageError(fmt.Sprintf("invalid %s ID: %s", name, err))
+	}
+	return id, nil
+}
+
+func parseIDs(name string, strs []string) (uu.IDSlice, error) {
+	ids := make(uu.IDSlice, len(strs))
+	for i, str := range strs {
+		id, err := parseID(name, str)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// parseVersion parses a version or returns
+// the latest version of the document for "latest".
+func parseVersion(ctx context.Context, conn docdb.Conn, docID uu.ID, str string) (docdb.VersionTime, error) {
+	if str == "latest" {
+		return conn.LatestDocumentVersion(ctx, docID)
+	}
+	version, err := docdb.VersionTimeFromString(str)
+	if err != nil {
+		return docdb.VersionTime{}, usageError(fmt.Sprintf("invalid version: %s", err))
+	}
+	return version, nil
+}
+
+// nonNil returns an empty slice for nil
+// so that it is encoded as JSON array instead of null.
+func nonNil[S ~[]E, E any](s S) S {
+	if s == nil {
+		return S{}
+	}
+	return s
+}
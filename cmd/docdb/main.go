@@ -0,0 +1,198 @@
+// Command docdb operates a docdb store from the command line.
+//
+// Usage:
+//
+//	docdb [flags] <command> [command flags] [arguments]
+//
+// The store is a localfsdb directory with the sub-directories
+// "documents" and "companies" or an S3 bucket used with storeconn
+// and a Postgres metadata database:
+//
+//	docdb -store /var/docdb companies
+//	docdb -store s3://my-bucket -postgres postgres://user:pw@host/db versions <doc>
+//	DOCDB_STORE='s3://my-bucket?layout=content-addressed' docdb info <doc>
+//
+// Flags:
+//
+//	-store       store to operate on, default $DOCDB_STORE
+//	-postgres    Postgres URL of the metadata database of S3 stores, default $DOCDB_POSTGRES_URL
+//	-file-locks  use file locks for localfsdb stores shared with other processes
+//	-json        write JSON instead of human-readable output
+//
+// Commands:
+//
+//	companies                               list company IDs
+//	docs <company>                          list document IDs of a company
+//	versions <doc>                          list versions of a document
+//	info <doc> [version]                    show the VersionInfo of a version, default latest
+//	cat [-offset n] [-length n] <doc> <version> <file>
+//	                                        write a file or a byte range of it to stdout
+//	history <doc>                           show all versions with their files
+//	export [-overwrite] <dir> [company...]  copy documents to a localfsdb directory
+//	import [-recreate] [-continue-on-error] <dir>
+//	                                        sync all documents of an exported directory into the store
+//	sync [-recreate] [-continue-on-error] <src> <dst> [company...]
+//	                                        sync documents from store src to store dst
+//	verify [company...]                     check stored documents, exits with 1 if problems were found
+//	delete -yes <doc> [version]             delete a document or a version
+//
+// Versions are formatted like 2024-01-31_12-00-00.000 or "latest".
+// Export, import and sync process all companies if none are passed.
+// Sync does not support two S3 stores, because all S3 stores
+// share the Postgres metadata database of the -postgres flag.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-types/uu"
+)
+
+const (
+	envStore    = "DOCDB_STORE"
+	envPostgres = "DOCDB_POSTGRES_URL"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// command is a sub-command of the CLI.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = []command{
+	{"companies", "companies", companiesCmd},
+	{"docs", "docs <company>", docsCmd},
+	{"versions", "versions <doc>", versionsCmd},
+	{"info", "info <doc> [version]", infoCmd},
+	{"cat", "cat [-offset n] [-length n] <doc> <version> <file>", catCmd},
+	{"history", "history <doc>", historyCmd},
+	{"export", "export [-overwrite] <dir> [company...]", exportCmd},
+	{"import", "import [-recreate] [-continue-on-error] <dir>", importCmd},
+	{"sync", "sync [-recreate] [-continue-on-error] <src> <dst> [company...]", syncCmd},
+	{"verify", "verify [company...]", verifyCmd},
+	{"delete", "delete -yes <doc> [version]", deleteCmd},
+}
+
+// cli holds the global flags and outputs of a run.
+type cli struct {
+	stdout io.Writer
+	stderr io.Writer
+	json   bool
+	store  string
+	opts   storeOptions
+}
+
+// usageError is returned for invalid arguments
+// and makes run exit with status 2.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// run executes the command line args and returns the exit status.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	c := &cli{stdout: stdout, stderr: stderr}
+	flags := flag.NewFlagSet("docdb", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&c.store, "store", os.Getenv(envStore), "store to operate on, a directory or s3://bucket URL")
+	flags.StringVar(&c.opts.postgresURL, "postgres", os.Getenv(envPostgres), "Postgres URL of the metadata database of S3 stores")
+	flags.BoolVar(&c.opts.fileLocks, "file-locks", false, "use file locks for localfsdb stores shared with other processes")
+	flags.BoolVar(&c.json, "json", false, "write JSON instead of human-readable output")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: docdb [flags] <command> [command flags] [arguments]")
+		fmt.Fprintln(stderr, "\nCommands:")
+		for _, cmd := range commands {
+			fmt.Fprintln(stderr, "  "+cmd.usage)
+		}
+		fmt.Fprintln(stderr, "\nFlags:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	i := slices.IndexFunc(commands, func(cmd command) bool { return cmd.name == flags.Arg(0) })
+	if i < 0 {
+		fmt.Fprintf(stderr, "docdb: unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+	cmd := commands[i]
+	err := cmd.run(ctx, c, flags.Args()[1:])
+	if err != nil {
+		fmt.Fprintf(stderr, "docdb %s: %s\n", cmd.name, err)
+		if _, ok := errors.AsType[usageError](err); ok {
+			fmt.Fprintln(stderr, "Usage: docdb "+cmd.usage)
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// parseFlags parses the flags of a command from args
+// and checks that the remaining arguments are between minArgs and maxArgs.
+// A negative maxArgs allows any number of arguments.
+func parseFlags(flags *flag.FlagSet, args []string, minArgs, maxArgs int) ([]string, error) {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		return nil, usageError(err.Error())
+	}
+	args = flags.Args()
+	if len(args) < minArgs || (maxArgs >= 0 && len(args) > maxArgs) {
+		return nil, usageError("wrong number of arguments")
+	}
+	return args, nil
+}
+
+// output writes value as JSON if the -json flag is set
+// or else calls human with the stdout writer.
+func (c *cli) output(value any, human func(w io.Writer)) error {
+	if c.json {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+	}
+	human(c.stdout)
+	return nil
+}
+
+// progress writes a progress line for a document to stderr.
+func (c *cli) progress(action string) docdb.DocProgressCallback {
+	return func(ctx context.Context, docID uu.ID, index, total int) {
+		fmt.Fprintf(c.stderr, "%s document %d/%d: %s\n", action, index+1, total, docID)
+	}
+}
+
+func joinLines[T fmt.Stringer](values []T) string {
+	var b strings.Builder
+	for _, v := range values {
+		b.WriteString(v.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	iofs "io/fs"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/docdbtest"
+	"github.com/domonda/go-docdb/localfsdb"
+	"github.com/domonda/go-types/uu"
+)
+
+var (
+	version1 = docdb.MustVersionTimeFromString("2024-01-01_00-00-00.000")
+	version2 = docdb.MustVersionTimeFromString("2024-01-02_00-00-00.000")
+)
+
+// newTestStore returns a localfsdb store directory
+// for use with the -store flag.
+func newTestStore(t *testing.T) (string, docdb.Conn) {
+	t.Helper()
+	dir := fs.File(t.TempDir())
+	require.NoError(t, dir.Join("documents").MakeDir())
+	require.NoError(t, dir.Join("companies").MakeDir())
+	return dir.LocalPath(), localfsdb.NewConn(dir.Join("documents"), dir.Join("companies"))
+}
+
+// runCLI runs the command line args and returns the exit status and outputs.
+func runCLI(t *testing.T, args ...string) (status int, stdout, stderr string) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	status = run(t.Context(), args, &out, &errOut)
+	return status, out.String(), errOut.String()
+}
+
+// runJSON runs the command line args with the -json flag
+// and decodes the output into result.
+func runJSON(t *testing.T, result any, args ...string) {
+	t.Helper()
+	status, stdout, stderr := runCLI(t, append([]string{"-json"}, args...)...)
+	require.Equal(t, 0, status, stderr)
+	require.NoError(t, json.Unmarshal([]byte(stdout), result), stdout)
+}
+
+// listTree returns the paths of all files and directories below dir.
+func listTree(t *testing.T, dir fs.File) []string {
+	t.Helper()
+	var paths []string
+	err := filepath.WalkDir(dir.LocalPath(), func(path string, _ iofs.DirEntry, err error) error {
+		paths = append(paths, path)
+		return err
+	})
+	require.NoError(t, err)
+	return paths
+}
+
+func TestCLI(t *testing.T) {
+	ctx := t.Context()
+	store, conn := newTestStore(t)
+	companyID := uu.IDv7()
+	docID := uu.IDv7()
+	err := conn.CreateDocument(ctx, companyID, docID, uu.IDv7(), "create", version1, []fs.FileReader{
+		fs.NewMemFile("doc.txt", []byte("0123456789")),
+	}, docdbtest.NoopOnNewVersion)
+	require.NoError(t, err)
+	err = conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "update",
+		func(ctx context.Context, docID uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			return &docdb.CreateVersionResult{
+				Version:    version2,
+				WriteFiles: []fs.FileReader{fs.NewMemFile("doc.json", []byte(`{}`))},
+			}, nil
+		},
+		docdbtest.NoopOnNewVersion,
+	)
+	require.NoError(t, err)
+
+	t.Run("list", func(t *testing.T) {
+		var companyIDs uu.IDSlice
+		runJSON(t, &companyIDs, "-store", store, "companies")
+		require.Equal(t, uu.IDSlice{companyID}, companyIDs)
+
+		var docIDs uu.IDSlice
+		runJSON(t, &docIDs, "-store", store, "docs", companyID.String())
+		require.Equal(t, uu.IDSlice{docID}, docIDs)
+
+		runJSON(t, &docIDs, "-store", store, "docs", uu.IDv7().String())
+		require.Empty(t, docIDs)
+
+		var versions []docdb.VersionTime
+		runJSON(t, &versions, "-store", store, "versions", docID.String())
+		require.Equal(t, []docdb.VersionTime{version1, version2}, versions)
+
+		status, stdout, _ := runCLI(t, "-store", store, "versions", docID.String())
+		require.Equal(t, 0, status)
+		require.Equal(t, version1.String()+"\n"+version2.String()+"\n", stdout)
+	})
+
+	t.Run("info", func(t *testing.T) {
+		var info docdb.VersionInfo
+		runJSON(t, &info, "-store", store, "info", docID.String())
+		require.Equal(t, version2, info.Version)
+		require.Equal(t, []string{"doc.json"}, info.AddedFiles)
+
+		runJSON(t, &info, "-store", store, "info", docID.String(), version1.String())
+		require.Equal(t, version1, info.Version)
+		require.Contains(t, info.Files, "doc.txt")
+
+		status, stdout, _ := runCLI(t, "-store", store, "info", docID.String(), "latest")
+		require.Equal(t, 0, status)
+		require.Contains(t, stdout, "Previous:  "+version1.String())
+		require.Contains(t, stdout, "doc.json")
+		require.Contains(t, stdout, "added")
+	})
+
+	t.Run("cat", func(t *testing.T) {
+		status, stdout, stderr := runCLI(t, "-store", store, "cat", docID.String(), "latest", "doc.txt")
+		require.Equal(t, 0, status, stderr)
+		require.Equal(t, "0123456789", stdout)
+
+		status, stdout, stderr = runCLI(t, "-store", store, "cat", "-offset", "3", "-length", "4", docID.String(), version1.String(), "doc.txt")
+		require.Equal(t, 0, status, stderr)
+		require.Equal(t, "3456", stdout)
+
+		status, _, stderr = runCLI(t, "-store", store, "cat", docID.String(), "latest", "missing.txt")
+		require.Equal(t, 1, status)
+		require.Contains(t, stderr, "missing.txt")
+	})
+
+	t.Run("history", func(t *testing.T) {
+		var infos []*docdb.VersionInfo
+		runJSON(t, &infos, "-store", store, "history", docID.String())
+		require.Len(t, infos, 2)
+		require.Equal(t, "create", infos[0].CommitReason)
+		require.Equal(t, "update", infos[1].CommitReason)
+
+		status, stdout, stderr := runCLI(t, "-store", store, "history", docID.String())
+		require.Equal(t, 0, status, stderr)
+		require.Contains(t, stdout, "Document: "+docID.String())
+		require.Contains(t, stdout, "  Version: "+version1.String())
+		require.Contains(t, stdout, `Reason: "update"`)
+		require.Contains(t, stdout, "    File: doc.json")
+	})
+
+	t.Run("export and import", func(t *testing.T) {
+		exportDir := t.TempDir()
+		var exported uu.IDSlice
+		runJSON(t, &exported, "-store", store, "export", exportDir)
+		require.Equal(t, uu.IDSlice{docID}, exported)
+
+		// The export directory is a store itself
+		var versions []docdb.VersionTime
+		runJSON(t, &versions, "-store", exportDir, "versions", docID.String())
+		require.Equal(t, []docdb.VersionTime{version1, version2}, versions)
+
+		status, _, _ := runCLI(t, "-store", store, "export", exportDir)
+		require.Equal(t, 1, status, "export without -overwrite")
+
+		dst, dstConn := newTestStore(t)
+		var imported uu.IDSlice
+		runJSON(t, &imported, "-store", dst, "import", exportDir)
+		require.Equal(t, uu.IDSlice{docID}, imported)
+		data, err := dstConn.ReadDocumentVersionFile(ctx, docID, version2, "doc.json")
+		require.NoError(t, err)
+		require.Equal(t, `{}`, string(data))
+	})
+
+	t.Run("import does not modify the directory", func(t *testing.T) {
+		// Like a directory written with CopyDocumentFiles by another program
+		dir := fs.File(t.TempDir())
+		_, err := docdb.CopyDocumentFiles(ctx, conn, docID, dir.Join("documents"), false)
+		require.NoError(t, err)
+		before := listTree(t, dir)
+
+		dst, _ := newTestStore(t)
+		var imported uu.IDSlice
+		runJSON(t, &imported, "-store", dst, "import", dir.LocalPath())
+		require.Equal(t, uu.IDSlice{docID}, imported)
+		require.Equal(t, before, listTree(t, dir))
+	})
+
+	t.Run("sync", func(t *testing.T) {
+		dst, dstConn := newTestStore(t)
+		var synced uu.IDSlice
+		runJSON(t, &synced, "sync", store, dst, companyID.String())
+		require.Equal(t, uu.IDSlice{docID}, synced)
+		versions, err := dstConn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, []docdb.VersionTime{version1, version2}, versions)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		var reports []*docdb.VerifyReport
+		runJSON(t, &reports, "-store", store, "verify")
+		require.Len(t, reports, 1)
+		require.Empty(t, reports[0].Problems)
+
+		runJSON(t, &reports, "-store", store, "verify", uu.IDv7().String())
+		require.Empty(t, reports)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		status, _, stderr := runCLI(t, "-store", store, "delete", docID.String())
+		require.Equal(t, 2, status)
+		require.Contains(t, stderr, "-yes")
+
+		var leftVersions []docdb.VersionTime
+		runJSON(t, &leftVersions, "-store", store, "delete", "-yes", docID.String(), version2.String())
+		require.Equal(t, []docdb.VersionTime{version1}, leftVersions)
+
+		status, _, stderr = runCLI(t, "-store", store, "delete", "-yes", docID.String())
+		require.Equal(t, 0, status, stderr)
+		_, err := conn.DocumentVersions(ctx, docID)
+		require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound))
+	})
+}
+
+func TestCLIUsage(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	status, _, stderr := runCLI(t)
+	require.Equal(t, 2, status)
+	require.Contains(t, stderr, "Usage: docdb")
+
+	status, _, _ = runCLI(t, "-h")
+	require.Equal(t, 0, status)
+
+	status, _, stderr = runCLI(t, "-store", store, "unknown")
+	require.Equal(t, 2, status)
+	require.Contains(t, stderr, `unknown command "unknown"`)
+
+	status, _, stderr = runCLI(t, "-store", store, "docs")
+	require.Equal(t, 2, status)
+	require.Contains(t, stderr, "Usage: docdb docs <company>")
+
+	status, _, stderr = runCLI(t, "-store", store, "versions", "not-an-id")
+	require.Equal(t, 2, status)
+	require.Contains(t, stderr, "invalid document ID")
+
+	status, _, stderr = runCLI(t, "-store", store, "cat", uu.IDv7().String(), "yesterday", "doc.txt")
+	require.Equal(t, 2, status)
+	require.Contains(t, stderr, "invalid version")
+
+	status, _, stderr = runCLI(t, "sync", "s3://bucket-a", "s3://bucket-b")
+	require.Equal(t, 2, status)
+	require.Contains(t, stderr, "can't both be s3:// stores")
+
+	status, _, stderr = runCLI(t, "-store", t.TempDir(), "companies")
+	require.Equal(t, 1, status)
+	require.Contains(t, stderr, "must contain the directories documents and companies")
+
+	status, _, stderr = runCLI(t, "-store", "s3://bucket", "-postgres", "", "companies")
+	require.Equal(t, 1, status)
+	require.Contains(t, stderr, envPostgres)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/domonda/go-sqldb"
+	"github.com/domonda/go-sqldb/db"
+	"github.com/domonda/go-sqldb/pqconn"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/localfsdb"
+	"github.com/domonda/go-docdb/storeconn"
+	"github.com/domonda/go-docdb/storeconn/pgstore"
+	"github.com/domonda/go-docdb/storeconn/s3store"
+)
+
+// storeOptions are the global flags used to open stores.
+type storeOptions struct {
+	postgresURL string
+	fileLocks   bool
+
+	// postgresConnected is set by connectPostgres
+	postgresConnected bool
+}
+
+// openStore opens the store described by spec:
+//
+//	/path/to/dir or file:///path/to/dir
+//	    localfsdb with the sub-directories "documents" and "companies"
+//	s3://bucket
+//	    storeconn with the S3 bucket as DocumentStore
+//	    and the Postgres database of the -postgres flag as MetadataStore
+//	s3://bucket?layout=content-addressed
+//	    storeconn.NewContentAddressed with the S3 bucket as BlobStore
+//
+// S3 stores are configured from the AWS environment like AWS_REGION
+// and AWS_ENDPOINT_URL, the query parameter path-style=true enables
+// path style addressing as needed by MinIO.
+// All S3 stores share the one Postgres database of the -postgres flag.
+func openStore(ctx context.Context, spec string, opts *storeOptions) (docdb.Conn, error) {
+	if spec == "" {
+		return nil, errors.New("no store, set -store or " + envStore)
+	}
+	scheme, rest, hasScheme := strings.Cut(spec, "://")
+	if !hasScheme {
+		return openLocalStore(fs.File(spec), opts)
+	}
+	switch scheme {
+	case "file":
+		return openLocalStore(fs.File(rest), opts)
+	case "s3":
+		return openS3Store(ctx, spec, opts)
+	}
+	return nil, fmt.Errorf("unsupported store %q, expected a directory or s3:// URL", spec)
+}
+
+// isS3Store returns if spec describes an S3 store for openStore.
+func isS3Store(spec string) bool {
+	return strings.HasPrefix(spec, "s3://")
+}
+
+func openLocalStore(dir fs.File, opts *storeOptions) (docdb.Conn, error) {
+	documentsDir := dir.Join("documents")
+	companiesDir := dir.Join("companies")
+	if !documentsDir.IsDir() || !companiesDir.IsDir() {
+		return nil, fmt.Errorf("%s must contain the directories documents and companies", dir.LocalPath())
+	}
+	var options []localfsdb.Option
+	if opts.fileLocks {
+		options = append(options, localfsdb.WithFileLocks(0))
+	}
+	return localfsdb.NewConn(documentsDir, companiesDir, options...), nil
+}
+
+func openS3Store(ctx context.Context, spec string, opts *storeOptions) (docdb.Conn, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	bucket := u.Host
+	if bucket == "" {
+		return nil, fmt.Errorf("no bucket in store %q", spec)
+	}
+	err = connectPostgres(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pathStyle := u.Query().Get("path-style") == "true"
+	client := awss3.NewFromConfig(cfg, func(o *awss3.Options) {
+		o.UsePathStyle = pathStyle
+	})
+
+	switch layout := u.Query().Get("layout"); layout {
+	case "", "document":
+		return storeconn.New(s3store.NewDocumentStore(bucket, client), pgstore.NewMetadataStore()), nil
+	case "content-addressed":
+		return storeconn.NewContentAddressed(s3store.NewBlobStore(bucket, client), pgstore.NewMetadataStore()), nil
+	default:
+		return nil, fmt.Errorf("unsupported layout %q, expected document or content-addressed", layout)
+	}
+}
+
+// connectPostgres connects the global database connection used
+// by pgstore once, so all S3 stores share one Postgres database.
+func connectPostgres(ctx context.Context, opts *storeOptions) error {
+	if opts.postgresConnected {
+		return nil
+	}
+	if opts.postgresURL == "" {
+		return errors.New("S3 stores need a Postgres metadata database, set -postgres or " + envPostgres)
+	}
+	cfg, err := sqldb.ParseConfig(opts.postgresURL)
+	if err != nil {
+		return err
+	}
+	conn, err := pqconn.Connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	db.SetConn(conn)
+	opts.postgresConnected = true
+	return nil
+}
@@ -2,6 +2,7 @@ package docdb
 
 import (
 	"context"
+	"io"
 
 	"github.com/ungerik/go-fs"
 
@@ -48,6 +49,24 @@ type Conn interface {
 	// will be returned in case of such error conditions.
 	ReadDocumentVersionFile(ctx context.Context, docID uu.ID, version VersionTime, filename string) (data []byte, err error)
 
+	// OpenDocumentVersionFile opens a file of a document version for streaming
+	// reads without loading its whole content into memory.
+	// The caller must close the returned io.ReadCloser.
+	// Wrapped ErrDocumentNotFound, ErrDocumentVersionNotFound, ErrDocumentFileNotFound
+	// will be returned in case of such error conditions.
+	OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version VersionTime, filename string) (reader io.ReadCloser, err error)
+
+	// ReadDocumentVersionFileRange returns up to length bytes
+	// of a file of a document version starting at offset.
+	// Less bytes are returned if the file ends before offset+length
+	// and a negative length reads until the end of the file.
+	// An offset at or after the end of the file returns no data.
+	// Implementations read only the requested range from storage if possible.
+	// Wrapped ErrDocumentNotFound, ErrDocumentVersionNotFound, ErrDocumentFileNotFound
+	// will be returned in case of such error conditions
+	// and an error for a negative offset.
+	ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version VersionTime, filename string, offset, length int64) (data []byte, err error)
+
 	// DeleteDocument deletes all versions and stored files of a document.
 	// Returns wrapped ErrDocumentNotFound in case the document does not exist.
 	DeleteDocument(ctx context.Context, docID uu.ID) error
@@ -77,7 +96,9 @@ type Conn interface {
 	// At least one file must be provided: a document's first version cannot be
 	// empty, so an empty files slice is rejected with an error.
 	//
-	// Returns ErrDocumentAlreadyExists if a document with docID already exists.
+	// Returns ErrDocumentAlreadyExists if a document with docID already exists
+	// unless it was created with the idempotency key of ctx,
+	// see ContextWithIdempotencyKey.
 	CreateDocument(ctx context.Context, companyID, docID, userID uu.ID, reason string, version VersionTime, files []fs.FileReader, onNewVersion OnNewVersionFunc) error
 
 	// AddDocumentVersion adds a new version to an existing document.
@@ -99,8 +120,22 @@ type Conn interface {
 	// Returns wrapped ErrDocumentNotFound if the document does not exist.
 	// Returns wrapped ErrNoChanges if the new version has identical files
 	// compared to the previous version.
+	//
+	// If the document already has a version with the idempotency key of ctx,
+	// no version is added, see ContextWithIdempotencyKey.
 	AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) error
 
+	// AddDocumentVersionIfLatest adds a new version to an existing document
+	// like AddDocumentVersion, but only if expectedPrev is still the latest
+	// version of the document. The check is atomic with committing the new version,
+	// so two writers that derived their changes from the same version
+	// can't both succeed and the later one can't silently overwrite the other.
+	//
+	// Returns wrapped ErrDocumentChanged if the latest version
+	// of the document is not expectedPrev.
+	// See AddDocumentVersion for details on the callbacks and other errors.
+	AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev VersionTime, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) error
+
 	// AddMultiDocumentVersion adds a new version to multiple existing documents as atomic operation.
 	// See AddDocumentVersion for details on the callbacks and error handling.
 	// Documents with no file changes are skipped (ErrNoChanges per-doc is not an error).
@@ -3,7 +3,9 @@ package docdb
 import (
 	"context"
 	"fmt"
+	"io"
 	"maps"
+	"os"
 	"slices"
 
 	"github.com/domonda/go-types/uu"
@@ -30,6 +32,13 @@ import (
 //
 // It returns the first error encountered while reading from conn.
 func DebugPrintDocument(ctx context.Context, conn Conn, docID uu.ID, linePrefix, indent string) error {
+	return DebugFprintDocument(ctx, os.Stdout, conn, docID, linePrefix, indent)
+}
+
+// DebugFprintDocument prints the tree of DebugPrintDocument to w.
+//
+// It returns the first error encountered while reading from conn or writing to w.
+func DebugFprintDocument(ctx context.Context, w io.Writer, conn Conn, docID uu.ID, linePrefix, indent string) error {
 	companyID, err := conn.DocumentCompanyID(ctx, docID)
 	if err != nil {
 		return err
@@ -39,7 +48,10 @@ func DebugPrintDocument(ctx context.Context, conn Conn, docID uu.ID, linePrefix,
 		return err
 	}
 
-	fmt.Printf("%sDocument: %s  Company: %s  Versions: %d\n", linePrefix, docID, companyID, len(versions))
+	_, err = fmt.Fprintf(w, "%sDocument: %s  Company: %s  Versions: %d\n", linePrefix, docID, companyID, len(versions))
+	if err != nil {
+		return err
+	}
 
 	versionPrefix := linePrefix + indent
 	filePrefix := versionPrefix + indent
@@ -48,11 +60,17 @@ func DebugPrintDocument(ctx context.Context, conn Conn, docID uu.ID, linePrefix,
 		if err != nil {
 			return err
 		}
-		fmt.Printf("%sVersion: %s  User: %s  Reason: %q\n", versionPrefix, version, versionInfo.CommitUserID, versionInfo.CommitReason)
+		_, err = fmt.Fprintf(w, "%sVersion: %s  User: %s  Reason: %q\n", versionPrefix, version, versionInfo.CommitUserID, versionInfo.CommitReason)
+		if err != nil {
+			return err
+		}
 
 		for _, filename := range slices.Sorted(maps.Keys(versionInfo.Files)) {
 			file := versionInfo.Files[filename]
-			fmt.Printf("%sFile: %s  Size: %d  Hash: %s\n", filePrefix, file.Name, file.Size, file.Hash)
+			_, err = fmt.Fprintf(w, "%sFile: %s  Size: %d  Hash: %s\n", filePrefix, file.Name, file.Size, file.Hash)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,314 @@
+package docdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// FileChange describes how a file changed between two versions.
+type FileChange string
+
+const (
+	FileUnchanged FileChange = "unchanged"
+	FileAdded     FileChange = "added"
+	FileRemoved   FileChange = "removed"
+	FileModified  FileChange = "modified"
+)
+
+// FileDiff is the change of a single file between two versions.
+type FileDiff struct {
+	Name   string
+	Change FileChange
+	From   *FileInfo `json:",omitempty"` // nil if the file was added
+	To     *FileInfo `json:",omitempty"` // nil if the file was removed
+}
+
+// VersionDiff is the result of DiffDocumentVersions.
+type VersionDiff struct {
+	DocID       uu.ID
+	FromVersion VersionTime
+	ToVersion   VersionTime
+	// Files holds the FileDiff of every file
+	// of both versions sorted by name.
+	Files []FileDiff
+}
+
+// Filenames returns the sorted names of the files with one of the changes.
+func (d *VersionDiff) Filenames(changes ...FileChange) []string {
+	var names []string
+	for _, file := range d.Files {
+		if slices.Contains(changes, file.Change) {
+			names = append(names, file.Name)
+		}
+	}
+	return names
+}
+
+// HasChanges returns true if any file was added, removed or modified.
+func (d *VersionDiff) HasChanges() bool {
+	return len(d.Filenames(FileAdded, FileRemoved, FileModified)) > 0
+}
+
+// DiffDocumentVersions compares the files of two arbitrary versions
+// of a document by the content hashes of their VersionInfo,
+// so no file content is read.
+//
+// fromVersion does not have to be before toVersion,
+// swapping them swaps added and removed files.
+//
+// Use DiffDocumentVersionFileText or DiffDocumentVersionFileJSON
+// for a content-level diff of a modified file.
+func DiffDocumentVersions(ctx context.Context, conn Conn, docID uu.ID, fromVersion, toVersion VersionTime) (diff *VersionDiff, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, conn, docID, fromVersion, toVersion)
+
+	from, err := conn.DocumentVersionInfo(ctx, docID, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := conn.DocumentVersionInfo(ctx, docID, toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	diff = &VersionDiff{
+		DocID:       docID,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+	}
+	names := slices.Sorted(maps.Keys(from.Files))
+	for name := range to.Files {
+		if _, ok := from.Files[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		file := FileDiff{Name: name}
+		if info, ok := from.Files[name]; ok {
+			file.From = &info
+		}
+		if info, ok := to.Files[name]; ok {
+			file.To = &info
+		}
+		switch {
+		case file.From == nil:
+			file.Change = FileAdded
+		case file.To == nil:
+			file.Change = FileRemoved
+		case file.From.Hash != file.To.Hash:
+			file.Change = FileModified
+		default:
+			file.Change = FileUnchanged
+		}
+		diff.Files = append(diff.Files, file)
+	}
+	return diff, nil
+}
+
+// DiffDocumentVersionFileText returns the unified diff of a text file
+// between two versions of a document with three lines of context.
+// A file missing in one of the versions is compared as empty file.
+//
+// Returns an empty string if the file content is identical
+// and an error if the file is not valid UTF-8 text.
+func DiffDocumentVersionFileText(ctx context.Context, conn Conn, docID uu.ID, fromVersion, toVersion VersionTime, filename string) (unifiedDiff string, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, conn, docID, fromVersion, toVersion, filename)
+
+	from, to, err := readFileOfVersions(ctx, conn, docID, fromVersion, toVersion, filename)
+	if err != nil || bytes.Equal(from, to) {
+		return "", err
+	}
+	for _, data := range [][]byte{from, to} {
+		if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+			return "", errs.Errorf("document %s file %q is not a text file", docID, filename)
+		}
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(string(from)),
+		B:        splitLines(string(to)),
+		FromFile: filename,
+		FromDate: fromVersion.String(),
+		ToFile:   filename,
+		ToDate:   toVersion.String(),
+		Context:  3,
+	})
+}
+
+// splitLines splits text after every newline.
+// Unlike difflib.SplitLines no empty last line is added
+// for text ending with a newline.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// JSONPatchOperation is an operation of a JSON Patch as defined by RFC 6902.
+type JSONPatchOperation struct {
+	Op    string          `json:"op"` // "add", "remove" or "replace"
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// DiffDocumentVersionFileJSON returns the JSON Patch (RFC 6902) that
+// transforms the JSON file of fromVersion into the one of toVersion.
+// Objects are compared by key and arrays by index.
+// A file missing in one of the versions is added or removed
+// as whole document with the path "".
+//
+// Returns nil if the JSON values are equal
+// and an error if a file is not valid JSON.
+func DiffDocumentVersionFileJSON(ctx context.Context, conn Conn, docID uu.ID, fromVersion, toVersion VersionTime, filename string) (patch []JSONPatchOperation, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, conn, docID, fromVersion, toVersion, filename)
+
+	from, to, err := readFileOfVersions(ctx, conn, docID, fromVersion, toVersion, filename)
+	if err != nil || bytes.Equal(from, to) {
+		return nil, err
+	}
+	return jsonPatch(from, to)
+}
+
+// readFileOfVersions reads a file of two versions of a document.
+// The data of a version is nil if the file does not exist in it.
+// Returns nil data for both versions if the content hashes are equal.
+func readFileOfVersions(ctx context.Context, conn Conn, docID uu.ID, fromVersion, toVersion VersionTime, filename string) (from, to []byte, err error) {
+	diff, err := DiffDocumentVersions(ctx, conn, docID, fromVersion, toVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	i := slices.IndexFunc(diff.Files, func(f FileDiff) bool { return f.Name == filename })
+	if i < 0 {
+		return nil, nil, NewErrDocumentFileNotFound(docID, filename)
+	}
+	file := diff.Files[i]
+	if file.Change == FileUnchanged {
+		return nil, nil, nil
+	}
+	if file.From != nil {
+		from, err = conn.ReadDocumentVersionFile(ctx, docID, fromVersion, filename)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if file.To != nil {
+		to, err = conn.ReadDocumentVersionFile(ctx, docID, toVersion, filename)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return from, to, nil
+}
+
+// jsonPatch returns the JSON Patch from the JSON document
+// from to the JSON document to, where nil means no document.
+func jsonPatch(from, to []byte) ([]JSONPatchOperation, error) {
+	var fromValue, toValue any
+	if from != nil {
+		if err := unmarshalJSONNumbers(from, &fromValue); err != nil {
+			return nil, err
+		}
+	}
+	if to != nil {
+		if err := unmarshalJSONNumbers(to, &toValue); err != nil {
+			return nil, err
+		}
+	}
+	var patch jsonPatchBuilder
+	switch {
+	case from == nil && to == nil:
+		return nil, nil
+	case from == nil:
+		patch.add("add", "", toValue)
+	case to == nil:
+		patch.add("remove", "", nil)
+	default:
+		patch.diff("", fromValue, toValue)
+	}
+	return patch.ops, patch.err
+}
+
+func unmarshalJSONNumbers(data []byte, value *any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(value); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	return nil
+}
+
+type jsonPatchBuilder struct {
+	ops []JSONPatchOperation
+	err error
+}
+
+func (b *jsonPatchBuilder) add(op, path string, value any) {
+	operation := JSONPatchOperation{Op: op, Path: path}
+	if op != "remove" {
+		operation.Value, b.err = json.Marshal(value)
+	}
+	b.ops = append(b.ops, operation)
+}
+
+func (b *jsonPatchBuilder) diff(path string, from, to any) {
+	if b.err != nil || reflect.DeepEqual(from, to) {
+		return
+	}
+	switch from := from.(type) {
+	case map[string]any:
+		to, ok := to.(map[string]any)
+		if !ok {
+			break
+		}
+		for _, key := range slices.Sorted(maps.Keys(from)) {
+			if _, ok := to[key]; !ok {
+				b.add("remove", path+"/"+escapeJSONPointer(key), nil)
+			}
+		}
+		for _, key := range slices.Sorted(maps.Keys(to)) {
+			keyPath := path + "/" + escapeJSONPointer(key)
+			if fromValue, ok := from[key]; ok {
+				b.diff(keyPath, fromValue, to[key])
+			} else {
+				b.add("add", keyPath, to[key])
+			}
+		}
+		return
+
+	case []any:
+		to, ok := to.([]any)
+		if !ok {
+			break
+		}
+		for i := range min(len(from), len(to)) {
+			b.diff(path+"/"+strconv.Itoa(i), from[i], to[i])
+		}
+		for i := len(to); i < len(from); i++ {
+			// Removing the same index shifts the following elements
+			b.add("remove", path+"/"+strconv.Itoa(len(to)), nil)
+		}
+		for i := len(from); i < len(to); i++ {
+			b.add("add", path+"/"+strconv.Itoa(i), to[i])
+		}
+		return
+	}
+	b.add("replace", path, to)
+}
+
+// escapeJSONPointer escapes a reference token of a JSON Pointer (RFC 6901).
+func escapeJSONPointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
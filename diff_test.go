@@ -0,0 +1,168 @@
+package docdb
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/domonda/go-types/uu"
+)
+
+// newDiffTestConn returns a MockConn serving the files of versions
+// of a single document and counting the file reads.
+func newDiffTestConn(docID uu.ID, versions map[VersionTime]map[string][]byte, reads *int) *MockConn {
+	return &MockConn{
+		DocumentVersionInfoMock: func(ctx context.Context, id uu.ID, version VersionTime) (*VersionInfo, error) {
+			files, ok := versions[version]
+			if id != docID || !ok {
+				return nil, NewErrDocumentVersionNotFound(id, version)
+			}
+			info := &VersionInfo{DocID: docID, Version: version, Files: make(map[string]FileInfo)}
+			for name, data := range files {
+				info.Files[name] = FileInfo{Name: name, Size: int64(len(data)), Hash: ContentHash(data)}
+			}
+			return info, nil
+		},
+		ReadDocumentVersionFileMock: func(ctx context.Context, id uu.ID, version VersionTime, filename string) ([]byte, error) {
+			*reads++
+			data, ok := versions[version][filename]
+			if !ok {
+				return nil, NewErrDocumentFileNotFound(id, filename)
+			}
+			return data, nil
+		},
+	}
+}
+
+func TestDiffDocumentVersions(t *testing.T) {
+	var (
+		ctx   = t.Context()
+		docID = uu.IDFrom("c538ac93-2cf0-49a9-8378-22cd48b5ab84")
+		v1    = MustVersionTimeFromString("2024-01-01_00-00-00.000")
+		v2    = MustVersionTimeFromString("2024-01-02_00-00-00.000")
+		reads int
+	)
+	conn := newDiffTestConn(docID, map[VersionTime]map[string][]byte{
+		v1: {
+			"a.txt":  []byte("line 1\nline 2\nline 3\n"),
+			"b.txt":  []byte("b"),
+			"d.json": []byte(`{"x":1,"list":[1,2,3],"obj":{"a/b":true,"gone":null}}`),
+			"e.bin":  {0, 1, 2},
+		},
+		v2: {
+			"a.txt":  []byte("line 1\nline two\nline 3\n"),
+			"c.txt":  []byte("c"),
+			"d.json": []byte(`{"x":1,"list":[1,4],"obj":{"a/b":false,"new":"n"}}`),
+			"e.bin":  {0, 1, 3},
+		},
+	}, &reads)
+
+	diff, err := DiffDocumentVersions(ctx, conn, docID, v1, v2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.txt", "b.txt", "c.txt", "d.json", "e.bin"}, diff.Filenames(FileUnchanged, FileAdded, FileRemoved, FileModified))
+	require.Equal(t, []string{"a.txt", "d.json", "e.bin"}, diff.Filenames(FileModified))
+	require.Equal(t, []string{"c.txt"}, diff.Filenames(FileAdded))
+	require.Equal(t, []string{"b.txt"}, diff.Filenames(FileRemoved))
+	require.Nil(t, diff.Files[1].To)
+	require.Nil(t, diff.Files[2].From)
+	require.True(t, diff.HasChanges())
+	require.Zero(t, reads, "diff must not read file content")
+
+	diff, err = DiffDocumentVersions(ctx, conn, docID, v1, v1)
+	require.NoError(t, err)
+	require.False(t, diff.HasChanges())
+	require.Len(t, diff.Filenames(FileUnchanged), 4)
+
+	_, err = DiffDocumentVersions(ctx, conn, docID, v1, MustVersionTimeFromString("2024-01-03_00-00-00.000"))
+	require.ErrorAs(t, err, new(ErrDocumentVersionNotFound))
+
+	t.Run("text", func(t *testing.T) {
+		unified, err := DiffDocumentVersionFileText(ctx, conn, docID, v1, v2, "a.txt")
+		require.NoError(t, err)
+		require.Equal(t, ""+
+			"--- a.txt\t2024-01-01_00-00-00.000\n"+
+			"+++ a.txt\t2024-01-02_00-00-00.000\n"+
+			"@@ -1,3 +1,3 @@\n"+
+			" line 1\n"+
+			"-line 2\n"+
+			"+line two\n"+
+			" line 3\n",
+			unified,
+		)
+
+		unified, err = DiffDocumentVersionFileText(ctx, conn, docID, v1, v2, "c.txt")
+		require.NoError(t, err)
+		require.Contains(t, unified, "+c")
+
+		reads = 0
+		unified, err = DiffDocumentVersionFileText(ctx, conn, docID, v1, v1, "a.txt")
+		require.NoError(t, err)
+		require.Empty(t, unified)
+		require.Zero(t, reads, "unchanged file must not be read")
+
+		_, err = DiffDocumentVersionFileText(ctx, conn, docID, v1, v2, "e.bin")
+		require.Error(t, err)
+
+		_, err = DiffDocumentVersionFileText(ctx, conn, docID, v1, v2, "missing.txt")
+		require.ErrorAs(t, err, new(ErrDocumentFileNotFound))
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		patch, err := DiffDocumentVersionFileJSON(ctx, conn, docID, v1, v2, "d.json")
+		require.NoError(t, err)
+		require.Equal(t, []JSONPatchOperation{
+			{Op: "replace", Path: "/list/1", Value: json.RawMessage(`4`)},
+			{Op: "remove", Path: "/list/2"},
+			{Op: "remove", Path: "/obj/gone"},
+			{Op: "replace", Path: "/obj/a~1b", Value: json.RawMessage(`false`)},
+			{Op: "add", Path: "/obj/new", Value: json.RawMessage(`"n"`)},
+		}, patch)
+
+		_, err = DiffDocumentVersionFileJSON(ctx, conn, docID, v1, v2, "a.txt")
+		require.Error(t, err, "not a JSON file")
+	})
+}
+
+func TestJSONPatch(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		from, to string
+		want     []JSONPatchOperation
+	}{
+		{name: "equal", from: `{"a":[1,{"b":2}]}`, to: ` { "a" : [ 1, {"b":2} ] } `},
+		{name: "numbers keep precision", from: `12345678901234567890`, to: `12345678901234567891`,
+			want: []JSONPatchOperation{{Op: "replace", Path: "", Value: json.RawMessage(`12345678901234567891`)}}},
+		{name: "type change", from: `{"a":[1]}`, to: `{"a":{"0":1}}`,
+			want: []JSONPatchOperation{{Op: "replace", Path: "/a", Value: json.RawMessage(`{"0":1}`)}}},
+		{name: "escaped key", from: `{}`, to: `{"~x/y":null}`,
+			want: []JSONPatchOperation{{Op: "add", Path: "/~0x~1y", Value: json.RawMessage(`null`)}}},
+		{name: "array append", from: `[1]`, to: `[1,2,3]`,
+			want: []JSONPatchOperation{
+				{Op: "add", Path: "/1", Value: json.RawMessage(`2`)},
+				{Op: "add", Path: "/2", Value: json.RawMessage(`3`)},
+			}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			patch, err := jsonPatch([]byte(tc.from), []byte(tc.to))
+			require.NoError(t, err)
+			require.Equal(t, tc.want, patch)
+		})
+	}
+
+	t.Run("added and removed file", func(t *testing.T) {
+		patch, err := jsonPatch(nil, []byte(`{"a":1}`))
+		require.NoError(t, err)
+		require.Equal(t, []JSONPatchOperation{{Op: "add", Path: "", Value: json.RawMessage(`{"a":1}`)}}, patch)
+
+		patch, err = jsonPatch([]byte(`{"a":1}`), nil)
+		require.NoError(t, err)
+		require.Equal(t, []JSONPatchOperation{{Op: "remove", Path: ""}}, patch)
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		_, err := jsonPatch([]byte(`{`), []byte(`{}`))
+		require.Error(t, err)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 
 	"github.com/ungerik/go-fs"
 	"github.com/ungerik/go-fs/uuiddir"
@@ -143,6 +144,45 @@ func ReadDocumentVersionFile(ctx context.Context, docID uu.ID, version VersionTi
 	return GetConn().ReadDocumentVersionFile(ctx, docID, version, filename)
 }
 
+// OpenDocumentVersionFile opens a file of a document version for streaming
+// reads without loading its whole content into memory.
+// The caller must close the returned io.ReadCloser.
+// Wrapped ErrDocumentNotFound, ErrDocumentVersionNotFound, ErrDocumentFileNotFound
+// will be returned in case of such error conditions.
+func OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version VersionTime, filename string) (reader io.ReadCloser, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version, filename)
+
+	return GetConn().OpenDocumentVersionFile(ctx, docID, version, filename)
+}
+
+// ReadDocumentVersionFileRange returns up to length bytes
+// of a file of a document version starting at offset
+// using the global connection.
+// A negative length reads until the end of the file.
+// See Conn.ReadDocumentVersionFileRange.
+func ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version VersionTime, filename string, offset, length int64) (data []byte, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version, filename, offset, length)
+
+	return GetConn().ReadDocumentVersionFileRange(ctx, docID, version, filename, offset, length)
+}
+
+// OpenLatestDocumentVersionFile opens a file from the latest version of a document
+// for streaming reads and returns the reader along with the version timestamp.
+// The caller must close the returned io.ReadCloser.
+func OpenLatestDocumentVersionFile(ctx context.Context, docID uu.ID, filename string) (reader io.ReadCloser, version VersionTime, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, filename)
+
+	version, err = GetConn().LatestDocumentVersion(ctx, docID)
+	if err != nil {
+		return nil, VersionTime{}, err
+	}
+	reader, err = GetConn().OpenDocumentVersionFile(ctx, docID, version, filename)
+	if err != nil {
+		return nil, VersionTime{}, err
+	}
+	return reader, version, nil
+}
+
 // ReadLatestDocumentVersionFile reads a file from the latest version of a document
 // and returns the file data along with the version timestamp.
 func ReadLatestDocumentVersionFile(ctx context.Context, docID uu.ID, filename string) (data []byte, version VersionTime, err error) {
@@ -162,31 +202,39 @@ func ReadLatestDocumentVersionFile(ctx context.Context, docID uu.ID, filename st
 // DocumentVersionFileReader returns a fs.FileReader for a file of a document version.
 // Wrapped ErrDocumentNotFound, ErrDocumentVersionNotFound, ErrDocumentFileNotFound
 // will be returned in case of such error conditions.
+//
+// The file content is not held in memory, every read of the returned
+// fs.FileReader streams the file with OpenDocumentVersionFile
+// using ctx if the read method has no context argument.
 func DocumentVersionFileReader(ctx context.Context, docID uu.ID, version VersionTime, filename string) (fileReader fs.FileReader, err error) {
 	defer errs.WrapWithFuncParams(&err, ctx, docID, version, filename)
 
-	data, err := GetConn().ReadDocumentVersionFile(ctx, docID, version, filename)
+	conn := GetConn()
+	versionInfo, err := conn.DocumentVersionInfo(ctx, docID, version)
 	if err != nil {
 		return nil, err
 	}
-	return fs.NewMemFile(filename, data), nil
+	return newVersionFileReader(ctx, conn, docID, versionInfo, filename)
 }
 
 // DocumentFileReader returns a fs.FileReader for a file of the latest document version.
 // Wrapped ErrDocumentNotFound, ErrDocumentFileNotFound
 // will be returned in case of such error conditions.
+//
+// The file content is streamed like with DocumentVersionFileReader.
 func DocumentFileReader(ctx context.Context, docID uu.ID, filename string) (fileReader fs.FileReader, versionInfo *VersionInfo, err error) {
 	defer errs.WrapWithFuncParams(&err, ctx, docID, filename)
 
-	versionInfo, err = GetConn().LatestDocumentVersionInfo(ctx, docID)
+	conn := GetConn()
+	versionInfo, err = conn.LatestDocumentVersionInfo(ctx, docID)
 	if err != nil {
 		return nil, nil, err
 	}
-	data, err := GetConn().ReadDocumentVersionFile(ctx, docID, versionInfo.Version, filename)
+	fileReader, err = newVersionFileReader(ctx, conn, docID, versionInfo, filename)
 	if err != nil {
 		return nil, nil, err
 	}
-	return fs.NewMemFile(filename, data), versionInfo, nil
+	return fileReader, versionInfo, nil
 }
 
 // DocumentFileExists returns if a document file with filename exists in the latest document version.
@@ -267,6 +315,18 @@ func AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reason string,
 	return GetConn().AddDocumentVersion(ctx, docID, userID, reason, createVersion, onNewVersion)
 }
 
+// AddDocumentVersionIfLatest adds a new version to an existing document
+// like AddDocumentVersion, but only if expectedPrev is still the latest
+// version of the document.
+//
+// Returns wrapped ErrDocumentChanged if the latest version
+// of the document is not expectedPrev.
+func AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev VersionTime, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, expectedPrev, userID, reason, createVersion, onNewVersion)
+
+	return GetConn().AddDocumentVersionIfLatest(ctx, docID, expectedPrev, userID, reason, createVersion, onNewVersion)
+}
+
 // AddMultiDocumentVersion adds a new version to multiple existing documents as atomic operation.
 // See AddDocumentVersion for details on the callbacks and error handling.
 func AddMultiDocumentVersion(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) (err error) {
@@ -360,13 +420,9 @@ func CopyDocumentFiles(ctx context.Context, conn Conn, docID uu.ID, backupDir fs
 			return "", err
 		}
 		for _, filename := range filenames {
-			data, err := versionFileProvider.ReadFile(ctx, filename)
-			if err != nil {
-				return "", err
-			}
 			file := versionDir.Join(filename)
 			log.Debug("Writing file").Stringer("file", file).Log()
-			err = file.WriteAllContext(ctx, data)
+			err = copyProviderFile(ctx, versionFileProvider, filename, file)
 			if err != nil {
 				return "", err
 			}
@@ -376,6 +432,29 @@ func CopyDocumentFiles(ctx context.Context, conn Conn, docID uu.ID, backupDir fs
 	return destDocDir, nil
 }
 
+// copyProviderFile streams the file with filename from provider to destFile
+// so that large files are never held in memory completely.
+func copyProviderFile(ctx context.Context, provider FileProvider, filename string, destFile fs.File) (err error) {
+	reader, err := provider.OpenFile(ctx, filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, reader.Close())
+	}()
+
+	writer, err := destFile.OpenWriter()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, writer.Close())
+	}()
+
+	_, err = io.Copy(writer, reader)
+	return err
+}
+
 // CopyAllCompanyDocumentFiles copies the files of all versions of
 // all documents of a company to a backup directory.
 //
@@ -422,6 +501,10 @@ func CopyAllCompanyDocumentFiles(ctx context.Context, conn Conn, companyID uu.ID
 // Atomicity is achieved by tracking each successfully created version and,
 // on any error, rolling back all of them via conn.DeleteDocumentVersion.
 // Any rollback errors are joined to the returned error.
+//
+// With an idempotency key in ctx (see ContextWithIdempotencyKey)
+// documents that already have a version with the key are not changed,
+// onNewVersion is called with the existing version that is never rolled back.
 func AddMultiDocumentVersionImpl(ctx context.Context, conn Conn, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) (err error) {
 	defer errs.WrapWithFuncParams(&err, ctx, docIDs, userID, reason, createVersion, onNewVersion)
 
@@ -429,7 +512,10 @@ func AddMultiDocumentVersionImpl(ctx context.Context, conn Conn, docIDs uu.IDSli
 		docID   uu.ID
 		version VersionTime
 	}
-	var created []createdVersion
+	var (
+		created  []createdVersion
+		replayed int
+	)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -447,7 +533,22 @@ func AddMultiDocumentVersionImpl(ctx context.Context, conn Conn, docIDs uu.IDSli
 		}
 	}()
 
+	idempotencyKey := IdempotencyKeyFromContext(ctx)
 	for _, docID := range docIDs {
+		var original *VersionInfo
+		original, err = IdempotentVersionInfo(ctx, conn, docID, idempotencyKey)
+		if err != nil {
+			return err
+		}
+		if original != nil {
+			replayed++
+			err = onNewVersion(ctx, original)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
 		err = conn.AddDocumentVersion(ctx, docID, userID, reason, createVersion, func(ctx context.Context, versionInfo *VersionInfo) error {
 			cbErr := onNewVersion(ctx, versionInfo)
 			if cbErr == nil {
@@ -467,7 +568,7 @@ func AddMultiDocumentVersionImpl(ctx context.Context, conn Conn, docIDs uu.IDSli
 			return err
 		}
 	}
-	if len(created) == 0 {
+	if len(created) == 0 && replayed == 0 {
 		return ErrNoChanges
 	}
 	return nil
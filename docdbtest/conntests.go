@@ -0,0 +1,1155 @@
+// Package docdbtest provides a conformance test suite for docdb.Conn
+// implementations.
+//
+// RunConnTests checks the documented contract of docdb.Conn against any
+// implementation, so backends within this module and third-party backends
+// can prove they behave the same:
+//
+//	func TestConformance(t *testing.T) {
+//		docdbtest.RunConnTests(t, func(t *testing.T) docdb.Conn {
+//			return mybackend.NewTestConn(t)
+//		})
+//	}
+//
+// FuzzConns applies random operation sequences to several Conn
+// implementations and a reference model and compares the results
+// after every step, see its documentation.
+//
+// Every test creates documents and companies with new random IDs,
+// so a Conn returned by newConn may share its storage with other tests.
+package docdbtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+)
+
+// Version times used by the tests in ascending order.
+var (
+	Version1 = docdb.MustVersionTimeFromString("2023-01-01_00-00-00.000")
+	Version2 = docdb.MustVersionTimeFromString("2023-01-02_00-00-00.000")
+	Version3 = docdb.MustVersionTimeFromString("2023-01-03_00-00-00.000")
+	Version4 = docdb.MustVersionTimeFromString("2023-01-04_00-00-00.000")
+)
+
+// NoopOnNewVersion is an OnNewVersionFunc that does nothing.
+func NoopOnNewVersion(context.Context, *docdb.VersionInfo) error { return nil }
+
+// CreateDocument creates the document docID as Version1 with the files,
+// or a file "doc.pdf" if none are passed, for a new company and user ID.
+func CreateDocument(ctx context.Context, conn docdb.Conn, docID uu.ID, files ...fs.FileReader) error {
+	if len(files) == 0 {
+		files = []fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))}
+	}
+	return conn.CreateDocument(ctx, uu.IDv7(), docID, uu.IDv7(), "create", Version1, files, NoopOnNewVersion)
+}
+
+// Option configures RunConnTests and FuzzConns.
+type Option func(*config)
+
+type config struct {
+	newContext func(t *testing.T) context.Context
+}
+
+// WithContext sets the function returning the context
+// passed to the Conn methods by every test or fuzz input.
+// The default is t.Context.
+//
+// Backends that need a per-test context, for example one
+// carrying a database transaction, can provide it here.
+func WithContext(newContext func(t *testing.T) context.Context) Option {
+	return func(c *config) {
+		c.newContext = newContext
+	}
+}
+
+func newConfig(options []Option) config {
+	cfg := config{
+		newContext: func(t *testing.T) context.Context { return t.Context() },
+	}
+	for _, option := range options {
+		option(&cfg)
+	}
+	return cfg
+}
+
+// suite holds the state of a single test of RunConnTests.
+type suite struct {
+	ctx  context.Context
+	conn docdb.Conn
+}
+
+// RunConnTests runs the conformance tests for the docdb.Conn
+// implementation returned by newConn as sub-tests of t.
+// newConn is called once per sub-test and must return a Conn
+// that is cleaned up by t.Cleanup if it needs cleanup.
+func RunConnTests(t *testing.T, newConn func(t *testing.T) docdb.Conn, options ...Option) {
+	cfg := newConfig(options)
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, s *suite)
+	}{
+		{"CreateDocument", testCreateDocument},
+		{"CreateDocument rejects empty files", testCreateDocumentRejectsEmptyFiles},
+		{"CreateDocument rejects existing document", testCreateDocumentRejectsExistingDocument},
+		{"CreateDocument rolls back if onNewVersion fails", testCreateDocumentRollsBack},
+		{"AddDocumentVersion", testAddDocumentVersion},
+		{"AddDocumentVersion rejects removal of all files", testAddDocumentVersionRejectsRemovalOfAllFiles},
+		{"AddDocumentVersion returns ErrNoChanges", testAddDocumentVersionNoChanges},
+		{"AddDocumentVersion rejects version not after previous", testAddDocumentVersionRejectsOldVersion},
+		{"AddDocumentVersion changes company", testAddDocumentVersionChangesCompany},
+		{"AddDocumentVersion after SetDocumentCompanyID", testAddDocumentVersionAfterSetDocumentCompanyID},
+		{"AddDocumentVersion rolls back if a callback fails", testAddDocumentVersionRollsBack},
+		{"AddDocumentVersion returns ErrDocumentNotFound", testAddDocumentVersionDocumentNotFound},
+		{"AddDocumentVersionIfLatest", testAddDocumentVersionIfLatest},
+		{"AddMultiDocumentVersion skips unchanged documents", testAddMultiDocumentVersionSkipsUnchanged},
+		{"AddMultiDocumentVersion returns ErrNoChanges", testAddMultiDocumentVersionNoChanges},
+		{"AddMultiDocumentVersion rolls back all documents", testAddMultiDocumentVersionRollsBack},
+		{"CreateDocument with idempotency key", testCreateDocumentIdempotencyKey},
+		{"AddDocumentVersion with idempotency key", testAddDocumentVersionIdempotencyKey},
+		{"AddMultiDocumentVersion with idempotency key", testAddMultiDocumentVersionIdempotencyKey},
+		{"RevertDocumentToVersion", testRevertDocumentToVersion},
+		{"CloneDocument", testCloneDocument},
+		{"ReadDocumentVersionFileRange", testReadDocumentVersionFileRange},
+		{"Read methods return not found errors", testReadNotFound},
+		{"CompanyIDs and CompanyDocumentIDs", testCompanyDocumentIDs},
+		{"SetDocumentCompanyID", testSetDocumentCompanyID},
+		{"DeleteDocumentVersion", testDeleteDocumentVersion},
+		{"DeleteDocument", testDeleteDocument},
+		{"RestoreDocument new document", testRestoreNewDocument},
+		{"RestoreDocument rejects invalid document", testRestoreRejectsInvalidDocument},
+		{"RestoreDocument merges missing versions", testRestoreMergesMissingVersions},
+		{"RestoreDocument merge keeps existing versions", testRestoreMergeKeepsExistingVersions},
+		{"RestoreDocument merge rejects other company", testRestoreMergeRejectsOtherCompany},
+		{"RestoreDocument recreate replaces existing document", testRestoreRecreateReplacesDocument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, &suite{
+				ctx:  cfg.newContext(t),
+				conn: newConn(t),
+			})
+		})
+	}
+}
+
+// failingOnNewVersionFuncs returns an OnNewVersionFunc
+// that returns an error and one that panics by name.
+func failingOnNewVersionFuncs() map[string]docdb.OnNewVersionFunc {
+	return map[string]docdb.OnNewVersionFunc{
+		"error": func(context.Context, *docdb.VersionInfo) error { return errors.New("onNewVersion rejected") },
+		"panic": func(context.Context, *docdb.VersionInfo) error { panic("onNewVersion panicked") },
+	}
+}
+
+// writeFiles returns a CreateVersionFunc creating version with files.
+func writeFiles(version docdb.VersionTime, files ...fs.FileReader) docdb.CreateVersionFunc {
+	return func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+		return &docdb.CreateVersionResult{Version: version, WriteFiles: files}, nil
+	}
+}
+
+// createDocument creates a document with Version1 and returns its ID.
+func (s *suite) createDocument(t *testing.T, companyID uu.ID, files ...fs.FileReader) uu.ID {
+	t.Helper()
+	docID := uu.IDv7()
+	err := s.conn.CreateDocument(s.ctx, companyID, docID, uu.IDv7(), "create", Version1, files, NoopOnNewVersion)
+	require.NoError(t, err, "CreateDocument")
+	return docID
+}
+
+func (s *suite) addVersion(t *testing.T, docID uu.ID, version docdb.VersionTime, files ...fs.FileReader) {
+	t.Helper()
+	err := s.conn.AddDocumentVersion(s.ctx, docID, uu.IDv7(), "add", writeFiles(version, files...), NoopOnNewVersion)
+	require.NoError(t, err, "AddDocumentVersion")
+}
+
+func (s *suite) requireVersions(t *testing.T, docID uu.ID, want ...docdb.VersionTime) {
+	t.Helper()
+	versions, err := s.conn.DocumentVersions(s.ctx, docID)
+	require.NoError(t, err, "DocumentVersions")
+	require.Equal(t, want, versions, "DocumentVersions")
+}
+
+func (s *suite) requireFile(t *testing.T, docID uu.ID, version docdb.VersionTime, filename string, want []byte) {
+	t.Helper()
+	data, err := s.conn.ReadDocumentVersionFile(s.ctx, docID, version, filename)
+	require.NoError(t, err, "ReadDocumentVersionFile")
+	require.Equal(t, want, data, "ReadDocumentVersionFile %s", filename)
+}
+
+func (s *suite) requireDocumentExists(t *testing.T, docID uu.ID, want bool) {
+	t.Helper()
+	exists, err := s.conn.DocumentExists(s.ctx, docID)
+	require.NoError(t, err, "DocumentExists")
+	require.Equal(t, want, exists, "DocumentExists")
+}
+
+func (s *suite) requireCompanyID(t *testing.T, docID, want uu.ID) {
+	t.Helper()
+	companyID, err := s.conn.DocumentCompanyID(s.ctx, docID)
+	require.NoError(t, err, "DocumentCompanyID")
+	require.Equal(t, want, companyID, "DocumentCompanyID")
+}
+
+func (s *suite) requireCompanyDocumentIDs(t *testing.T, companyID uu.ID, want ...uu.ID) {
+	t.Helper()
+	docIDs, err := s.conn.CompanyDocumentIDs(s.ctx, companyID)
+	require.NoError(t, err, "CompanyDocumentIDs")
+	require.ElementsMatch(t, want, docIDs, "CompanyDocumentIDs")
+}
+
+func testCreateDocument(t *testing.T, s *suite) {
+	var (
+		companyID = uu.IDv7()
+		docID     = uu.IDv7()
+		userID    = uu.IDv7()
+		files     = []fs.FileReader{
+			fs.NewMemFile("doc.pdf", []byte("pdf")),
+			fs.NewMemFile("a.txt", []byte("a")),
+		}
+		captured *docdb.VersionInfo
+	)
+	err := s.conn.CreateDocument(s.ctx, companyID, docID, userID, "create", Version1, files, docdb.CaptureNewVersionInfo(&captured))
+	require.NoError(t, err)
+
+	require.NotNil(t, captured, "onNewVersion must be called")
+	require.Equal(t, companyID, captured.CompanyID)
+	require.Equal(t, docID, captured.DocID)
+	require.Equal(t, Version1, captured.Version)
+	require.Nil(t, captured.PrevVersion)
+	require.Equal(t, userID, captured.CommitUserID)
+	require.Equal(t, "create", captured.CommitReason)
+	require.Equal(t, []string{"a.txt", "doc.pdf"}, captured.AddedFiles)
+	require.Empty(t, captured.ModifiedFiles)
+	require.Empty(t, captured.RemovedFiles)
+	require.Equal(t,
+		map[string]docdb.FileInfo{
+			"doc.pdf": {Name: "doc.pdf", Size: 3, Hash: docdb.ContentHash([]byte("pdf"))},
+			"a.txt":   {Name: "a.txt", Size: 1, Hash: docdb.ContentHash([]byte("a"))},
+		},
+		captured.Files,
+	)
+
+	info, err := s.conn.DocumentVersionInfo(s.ctx, docID, Version1)
+	require.NoError(t, err)
+	require.True(t, info.Equal(captured), "DocumentVersionInfo %s must equal VersionInfo passed to onNewVersion %s", info, captured)
+	info, err = s.conn.LatestDocumentVersionInfo(s.ctx, docID)
+	require.NoError(t, err)
+	require.True(t, info.Equal(captured), "LatestDocumentVersionInfo %s must equal VersionInfo passed to onNewVersion %s", info, captured)
+	latest, err := s.conn.LatestDocumentVersion(s.ctx, docID)
+	require.NoError(t, err)
+	require.Equal(t, Version1, latest)
+
+	s.requireDocumentExists(t, docID, true)
+	s.requireCompanyID(t, docID, companyID)
+	s.requireVersions(t, docID, Version1)
+	s.requireCompanyDocumentIDs(t, companyID, docID)
+	s.requireFile(t, docID, Version1, "doc.pdf", []byte("pdf"))
+
+	reader, err := s.conn.OpenDocumentVersionFile(s.ctx, docID, Version1, "a.txt")
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, []byte("a"), data)
+
+	provider, err := s.conn.DocumentVersionFileProvider(s.ctx, docID, Version1)
+	require.NoError(t, err)
+	filenames, err := provider.ListFiles(s.ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.txt", "doc.pdf"}, filenames)
+	data, err = provider.ReadFile(s.ctx, "doc.pdf")
+	require.NoError(t, err)
+	require.Equal(t, []byte("pdf"), data)
+}
+
+func testCreateDocumentRejectsEmptyFiles(t *testing.T, s *suite) {
+	docID := uu.IDv7()
+
+	err := s.conn.CreateDocument(s.ctx, uu.IDv7(), docID, uu.IDv7(), "create", Version1, nil, NoopOnNewVersion)
+	require.Error(t, err)
+
+	s.requireDocumentExists(t, docID, false)
+}
+
+func testCreateDocumentRejectsExistingDocument(t *testing.T, s *suite) {
+	companyID := uu.IDv7()
+	docID := s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+	err := s.conn.CreateDocument(s.ctx, companyID, docID, uu.IDv7(), "create again", Version2,
+		[]fs.FileReader{fs.NewMemFile("other.pdf", []byte("other"))},
+		NoopOnNewVersion,
+	)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentAlreadyExists))
+
+	s.requireVersions(t, docID, Version1)
+	s.requireFile(t, docID, Version1, "doc.pdf", []byte("pdf"))
+}
+
+func testCreateDocumentRollsBack(t *testing.T, s *suite) {
+	for name, onNewVersion := range failingOnNewVersionFuncs() {
+		t.Run(name, func(t *testing.T) {
+			companyID := uu.IDv7()
+			docID := uu.IDv7()
+
+			err := s.conn.CreateDocument(s.ctx, companyID, docID, uu.IDv7(), "create", Version1,
+				[]fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))},
+				onNewVersion,
+			)
+			require.Error(t, err)
+
+			s.requireDocumentExists(t, docID, false)
+			s.requireCompanyDocumentIDs(t, companyID)
+		})
+	}
+}
+
+func testAddDocumentVersion(t *testing.T, s *suite) {
+	companyID := uu.IDv7()
+	docID := s.createDocument(t, companyID,
+		fs.NewMemFile("doc.pdf", []byte("pdf")),
+		fs.NewMemFile("a.txt", []byte("a")),
+		fs.NewMemFile("b.txt", []byte("b")),
+	)
+	userID := uu.IDv7()
+
+	var captured *docdb.VersionInfo
+	err := s.conn.AddDocumentVersion(s.ctx, docID, userID, "add",
+		func(ctx context.Context, id uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			require.Equal(t, docID, id)
+			require.Equal(t, Version1, prevVersion)
+			filenames, err := prevFiles.ListFiles(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []string{"a.txt", "b.txt", "doc.pdf"}, filenames)
+			data, err := prevFiles.ReadFile(ctx, "a.txt")
+			require.NoError(t, err)
+			require.Equal(t, []byte("a"), data)
+
+			return &docdb.CreateVersionResult{
+				Version: Version2,
+				WriteFiles: []fs.FileReader{
+					fs.NewMemFile("a.txt", []byte("modified")),
+					fs.NewMemFile("b.txt", []byte("b")), // unchanged content
+					fs.NewMemFile("c.txt", []byte("c")),
+				},
+				RemoveFiles: []string{"doc.pdf"},
+			}, nil
+		},
+		docdb.CaptureNewVersionInfo(&captured),
+	)
+	require.NoError(t, err)
+
+	require.NotNil(t, captured, "onNewVersion must be called")
+	require.Equal(t, companyID, captured.CompanyID)
+	require.Equal(t, Version2, captured.Version)
+	require.NotNil(t, captured.PrevVersion)
+	require.Equal(t, Version1, *captured.PrevVersion)
+	require.Equal(t, userID, captured.CommitUserID)
+	require.Equal(t, "add", captured.CommitReason)
+	require.Equal(t, []string{"c.txt"}, captured.AddedFiles)
+	require.Equal(t, []string{"a.txt"}, captured.ModifiedFiles)
+	require.Equal(t, []string{"doc.pdf"}, captured.RemovedFiles)
+	require.Equal(t, []string{"a.txt", "b.txt", "c.txt"}, slices.Sorted(maps.Keys(captured.Files)))
+
+	info, err := s.conn.LatestDocumentVersionInfo(s.ctx, docID)
+	require.NoError(t, err)
+	require.True(t, info.Equal(captured), "LatestDocumentVersionInfo %s must equal VersionInfo passed to onNewVersion %s", info, captured)
+
+	s.requireVersions(t, docID, Version1, Version2)
+	s.requireFile(t, docID, Version2, "a.txt", []byte("modified"))
+	s.requireFile(t, docID, Version2, "b.txt", []byte("b"))
+	s.requireFile(t, docID, Version2, "c.txt", []byte("c"))
+	_, err = s.conn.ReadDocumentVersionFile(s.ctx, docID, Version2, "doc.pdf")
+	require.ErrorAs(t, err, new(docdb.ErrDocumentFileNotFound))
+
+	// The previous version is unchanged
+	s.requireFile(t, docID, Version1, "a.txt", []byte("a"))
+	s.requireFile(t, docID, Version1, "doc.pdf", []byte("pdf"))
+}
+
+func testAddDocumentVersionRejectsRemovalOfAllFiles(t *testing.T, s *suite) {
+	docID := s.createDocument(t, uu.IDv7(),
+		fs.NewMemFile("doc.pdf", []byte("pdf")),
+		fs.NewMemFile("a.txt", []byte("a")),
+	)
+
+	err := s.conn.AddDocumentVersion(s.ctx, docID, uu.IDv7(), "remove all",
+		func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			return &docdb.CreateVersionResult{Version: Version2, RemoveFiles: []string{"doc.pdf", "a.txt"}}, nil
+		},
+		NoopOnNewVersion,
+	)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, docdb.ErrNoChanges)
+
+	s.requireVersions(t, docID, Version1)
+}
+
+func testAddDocumentVersionNoChanges(t *testing.T, s *suite) {
+	docID := s.createDocument(t, uu.IDv7(), fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+	t.Run("no files written", func(t *testing.T) {
+		err := s.conn.AddDocumentVersion(s.ctx, docID, uu.IDv7(), "nothing", writeFiles(Version2), NoopOnNewVersion)
+		require.ErrorIs(t, err, docdb.ErrNoChanges)
+	})
+
+	t.Run("same content written", func(t *testing.T) {
+		err := s.conn.AddDocumentVersion(s.ctx, docID, uu.IDv7(), "same",
+			writeFiles(Version2, fs.NewMemFile("doc.pdf", []byte("pdf"))),
+			func(context.Context, *docdb.VersionInfo) error {
+				t.Error("onNewVersion must not be called without changes")
+				return nil
+			},
+		)
+		require.ErrorIs(t, err, docdb.ErrNoChanges)
+	})
+
+	t.Run("only missing files removed", func(t *testing.T) {
+		err := s.conn.AddDocumentVersion(s.ctx, docID, uu.IDv7(), "remove missing",
+			func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+				return &docdb.CreateVersionResult{Version: Version2, RemoveFiles: []string{"missing.txt"}}, nil
+			},
+			NoopOnNewVersion,
+		)
+		require.ErrorIs(t, err, docdb.ErrNoChanges)
+	})
+
+	s.requireVersions(t, docID, Version1)
+}
+
+func testAddDocumentVersionRejectsOldVersion(t *testing.T, s *suite) {
+	docID := s.createDocument(t, uu.IDv7(), fs.NewMemFile("doc.pdf", []byte("pdf")))
+	s.addVersion(t, docID, Version3, fs.NewMemFile("a.txt", []byte("a")))
+
+	for _, version := range []docdb.VersionTime{Version2, Version3} {
+		err := s.conn.AddDocumentVersion(s.ctx, docID, uu.IDv7(), "old", writeFiles(version, fs.NewMemFile("b.txt", []byte("b"))), NoopOnNewVersion)
+		require.Error(t, err, "version %s", version)
+	}
+
+	s.requireVersions(t, docID, Version1, Version3)
+}
+
+func testAddDocumentVersionChangesCompany(t *testing.T, s *suite) {
+	companyID := uu.IDv7()
+	newCompanyID := uu.IDv7()
+	docID := s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+	var captured *docdb.VersionInfo
+	err := s.conn.AddDocumentVersion(s.ctx, docID, uu.IDv7(), "move",
+		func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			return &docdb.CreateVersionResult{
+				Version:      Version2,
+				WriteFiles:   []fs.FileReader{fs.NewMemFile("a.txt", []byte("a"))},
+				NewCompanyID: uu.NullableID(newCompanyID),
+			}, nil
+		},
+		docdb.CaptureNewVersionInfo(&captured),
+	)
+	require.NoError(t, err)
+	require.Equal(t, newCompanyID, captured.CompanyID)
+
+	s.requireCompanyID(t, docID, newCompanyID)
+	s.requireCompanyDocumentIDs(t, companyID)
+	s.requireCompanyDocumentIDs(t, newCompanyID, docID)
+}
+
+func testAddDocumentVersionAfterSetDocumentCompanyID(t *testing.T, s *suite) {
+	companyID := uu.IDv7()
+	otherCompanyID := uu.IDv7()
+	docID := s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+	require.NoError(t, s.conn.SetDocumentCompanyID(s.ctx, docID, otherCompanyID))
+
+	// The new version belongs to the company set by SetDocumentCompanyID
+	var captured *docdb.VersionInfo
+	err := s.conn.AddDocumentVersion(s.ctx, docID, uu.IDv7(), "add",
+		writeFiles(Version2, fs.NewMemFile("a.txt", []byte("a"))),
+		docdb.CaptureNewVersionInfo(&captured),
+	)
+	require.NoError(t, err)
+	require.Equal(t, otherCompanyID, captured.CompanyID)
+	s.requireCompanyID(t, docID, otherCompanyID)
+
+	// Moving the document back to the company of its first version
+	err = s.conn.AddDocumentVersion(s.ctx, docID, uu.IDv7(), "move back",
+		func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			return &docdb.CreateVersionResult{
+				Version:      Version3,
+				WriteFiles:   []fs.FileReader{fs.NewMemFile("b.txt", []byte("b"))},
+				NewCompanyID: uu.NullableID(companyID),
+			}, nil
+		},
+		docdb.CaptureNewVersionInfo(&captured),
+	)
+	require.NoError(t, err)
+	require.Equal(t, companyID, captured.CompanyID)
+
+	s.requireCompanyID(t, docID, companyID)
+	s.requireCompanyDocumentIDs(t, companyID, docID)
+	s.requireCompanyDocumentIDs(t, otherCompanyID)
+}
+
+func testAddDocumentVersionRollsBack(t *testing.T, s *suite) {
+	for name, onNewVersion := range failingOnNewVersionFuncs() {
+		t.Run("onNewVersion "+name, func(t *testing.T) {
+			companyID := uu.IDv7()
+			docID := s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+			err := s.conn.AddDocumentVersion(s.ctx, docID, uu.IDv7(), "add",
+				func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+					return &docdb.CreateVersionResult{
+						Version: Version2,
+						WriteFiles: []fs.FileReader{
+							fs.NewMemFile("doc.pdf", []byte("modified")),
+							fs.NewMemFile("a.txt", []byte("a")),
+						},
+						NewCompanyID: uu.NullableID(uu.IDv7()),
+					}, nil
+				},
+				onNewVersion,
+			)
+			require.Error(t, err)
+
+			s.requireVersions(t, docID, Version1)
+			s.requireCompanyID(t, docID, companyID)
+			s.requireCompanyDocumentIDs(t, companyID, docID)
+			s.requireFile(t, docID, Version1, "doc.pdf", []byte("pdf"))
+		})
+	}
+
+	createVersionFuncs := map[string]docdb.CreateVersionFunc{
+		"error": func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			return nil, errors.New("createVersion failed")
+		},
+		"panic": func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			panic("createVersion panicked")
+		},
+	}
+	for name, createVersion := range createVersionFuncs {
+		t.Run("createVersion "+name, func(t *testing.T) {
+			docID := s.createDocument(t, uu.IDv7(), fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+			err := s.conn.AddDocumentVersion(s.ctx, docID, uu.IDv7(), "add", createVersion,
+				func(context.Context, *docdb.VersionInfo) error {
+					t.Error("onNewVersion must not be called")
+					return nil
+				},
+			)
+			require.Error(t, err)
+
+			s.requireVersions(t, docID, Version1)
+		})
+	}
+}
+
+func testAddDocumentVersionDocumentNotFound(t *testing.T, s *suite) {
+	err := s.conn.AddDocumentVersion(s.ctx, uu.IDv7(), uu.IDv7(), "add", writeFiles(Version2, fs.NewMemFile("a.txt", []byte("a"))), NoopOnNewVersion)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound))
+}
+
+func testAddDocumentVersionIfLatest(t *testing.T, s *suite) {
+	docID := s.createDocument(t, uu.IDv7(), fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+	err := s.conn.AddDocumentVersionIfLatest(s.ctx, docID, Version1, uu.IDv7(), "add",
+		writeFiles(Version2, fs.NewMemFile("a.txt", []byte("a"))),
+		NoopOnNewVersion,
+	)
+	require.NoError(t, err)
+
+	err = s.conn.AddDocumentVersionIfLatest(s.ctx, docID, Version1, uu.IDv7(), "stale",
+		writeFiles(Version3, fs.NewMemFile("b.txt", []byte("b"))),
+		NoopOnNewVersion,
+	)
+	var changed docdb.ErrDocumentChanged
+	require.ErrorAs(t, err, &changed)
+	require.Equal(t, docID, changed.DocID())
+	require.Equal(t, Version1, changed.BaseVersion())
+
+	s.requireVersions(t, docID, Version1, Version2)
+}
+
+func testAddMultiDocumentVersionSkipsUnchanged(t *testing.T, s *suite) {
+	var (
+		companyID = uu.IDv7()
+		docID1    = s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+		docID2    = s.createDocument(t, companyID, fs.NewMemFile("a.txt", []byte("a")))
+		called    uu.IDSlice
+	)
+
+	// a.txt is unchanged for docID2
+	err := s.conn.AddMultiDocumentVersion(s.ctx, uu.IDSlice{docID1, docID2}, uu.IDv7(), "multi",
+		writeFiles(Version2, fs.NewMemFile("a.txt", []byte("a"))),
+		func(_ context.Context, versionInfo *docdb.VersionInfo) error {
+			called = append(called, versionInfo.DocID)
+			return nil
+		},
+	)
+	require.NoError(t, err)
+	require.Equal(t, uu.IDSlice{docID1}, called)
+
+	s.requireVersions(t, docID1, Version1, Version2)
+	s.requireVersions(t, docID2, Version1)
+}
+
+func testAddMultiDocumentVersionNoChanges(t *testing.T, s *suite) {
+	var (
+		companyID = uu.IDv7()
+		docID1    = s.createDocument(t, companyID, fs.NewMemFile("a.txt", []byte("a")))
+		docID2    = s.createDocument(t, companyID, fs.NewMemFile("a.txt", []byte("a")))
+	)
+
+	err := s.conn.AddMultiDocumentVersion(s.ctx, uu.IDSlice{docID1, docID2}, uu.IDv7(), "multi",
+		writeFiles(Version2, fs.NewMemFile("a.txt", []byte("a"))),
+		NoopOnNewVersion,
+	)
+	require.ErrorIs(t, err, docdb.ErrNoChanges)
+
+	s.requireVersions(t, docID1, Version1)
+	s.requireVersions(t, docID2, Version1)
+}
+
+func testAddMultiDocumentVersionRollsBack(t *testing.T, s *suite) {
+	var (
+		companyID = uu.IDv7()
+		docID1    = s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+		docID2    = s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+	)
+
+	err := s.conn.AddMultiDocumentVersion(s.ctx, uu.IDSlice{docID1, docID2}, uu.IDv7(), "multi",
+		writeFiles(Version2, fs.NewMemFile("a.txt", []byte("a"))),
+		func(_ context.Context, versionInfo *docdb.VersionInfo) error {
+			if versionInfo.DocID == docID2 {
+				panic("onNewVersion panicked")
+			}
+			return nil
+		},
+	)
+	require.Error(t, err)
+
+	s.requireVersions(t, docID1, Version1)
+	s.requireVersions(t, docID2, Version1)
+}
+
+func testCreateDocumentIdempotencyKey(t *testing.T, s *suite) {
+	var (
+		ctx       = docdb.ContextWithIdempotencyKey(s.ctx, "create-key")
+		companyID = uu.IDv7()
+		docID     = uu.IDv7()
+		original  *docdb.VersionInfo
+		repeated  *docdb.VersionInfo
+	)
+	err := s.conn.CreateDocument(ctx, companyID, docID, uu.IDv7(), "create", Version1,
+		[]fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))},
+		docdb.CaptureNewVersionInfo(&original),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "create-key", original.IdempotencyKey)
+	info, err := s.conn.DocumentVersionInfo(s.ctx, docID, Version1)
+	require.NoError(t, err)
+	require.True(t, info.Equal(original), "stored VersionInfo %s must equal VersionInfo passed to onNewVersion %s", info, original)
+
+	// A repeated call returns the original version
+	// even if its arguments differ
+	err = s.conn.CreateDocument(ctx, companyID, docID, uu.IDv7(), "repeated", Version2,
+		[]fs.FileReader{fs.NewMemFile("other.pdf", []byte("other"))},
+		docdb.CaptureNewVersionInfo(&repeated),
+	)
+	require.NoError(t, err)
+	require.True(t, repeated.Equal(original), "repeated VersionInfo %s must equal original %s", repeated, original)
+	s.requireVersions(t, docID, Version1)
+	s.requireFile(t, docID, Version1, "doc.pdf", []byte("pdf"))
+
+	for name, ctx := range map[string]context.Context{
+		"without key": s.ctx,
+		"other key":   docdb.ContextWithIdempotencyKey(s.ctx, "other-key"),
+	} {
+		err = s.conn.CreateDocument(ctx, companyID, docID, uu.IDv7(), "create", Version1,
+			[]fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))},
+			NoopOnNewVersion,
+		)
+		require.ErrorAs(t, err, new(docdb.ErrDocumentAlreadyExists), name)
+	}
+}
+
+func testAddDocumentVersionIdempotencyKey(t *testing.T, s *suite) {
+	var (
+		ctx      = docdb.ContextWithIdempotencyKey(s.ctx, "add-key")
+		docID    = s.createDocument(t, uu.IDv7(), fs.NewMemFile("doc.pdf", []byte("pdf")))
+		original *docdb.VersionInfo
+		repeated *docdb.VersionInfo
+	)
+	err := s.conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "add",
+		writeFiles(Version2, fs.NewMemFile("a.txt", []byte("a"))),
+		docdb.CaptureNewVersionInfo(&original),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "add-key", original.IdempotencyKey)
+	info, err := s.conn.DocumentVersionInfo(s.ctx, docID, Version2)
+	require.NoError(t, err)
+	require.True(t, info.Equal(original), "stored VersionInfo %s must equal VersionInfo passed to onNewVersion %s", info, original)
+
+	// A version without key in between must not hide the original one
+	s.addVersion(t, docID, Version3, fs.NewMemFile("b.txt", []byte("b")))
+
+	createVersionCalled := false
+	err = s.conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "repeated",
+		func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			createVersionCalled = true
+			return &docdb.CreateVersionResult{Version: Version4, WriteFiles: []fs.FileReader{fs.NewMemFile("c.txt", []byte("c"))}}, nil
+		},
+		docdb.CaptureNewVersionInfo(&repeated),
+	)
+	require.NoError(t, err)
+	require.False(t, createVersionCalled, "createVersion must not be called for a repeated idempotency key")
+	require.True(t, repeated.Equal(original), "repeated VersionInfo %s must equal original %s", repeated, original)
+
+	// The key is checked before the expected previous version
+	repeated = nil
+	err = s.conn.AddDocumentVersionIfLatest(ctx, docID, Version1, uu.IDv7(), "repeated",
+		writeFiles(Version4, fs.NewMemFile("c.txt", []byte("c"))),
+		docdb.CaptureNewVersionInfo(&repeated),
+	)
+	require.NoError(t, err)
+	require.True(t, repeated.Equal(original), "repeated VersionInfo %s must equal original %s", repeated, original)
+
+	s.requireVersions(t, docID, Version1, Version2, Version3)
+
+	err = s.conn.AddDocumentVersion(docdb.ContextWithIdempotencyKey(s.ctx, "other-key"), docID, uu.IDv7(), "add",
+		writeFiles(Version4, fs.NewMemFile("c.txt", []byte("c"))),
+		NoopOnNewVersion,
+	)
+	require.NoError(t, err)
+	s.requireVersions(t, docID, Version1, Version2, Version3, Version4)
+}
+
+func testAddMultiDocumentVersionIdempotencyKey(t *testing.T, s *suite) {
+	var (
+		ctx       = docdb.ContextWithIdempotencyKey(s.ctx, "multi-key")
+		companyID = uu.IDv7()
+		docID1    = s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+		docID2    = s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+		original  = make(map[uu.ID]*docdb.VersionInfo)
+	)
+	err := s.conn.AddMultiDocumentVersion(ctx, uu.IDSlice{docID1, docID2}, uu.IDv7(), "multi",
+		writeFiles(Version2, fs.NewMemFile("a.txt", []byte("a"))),
+		func(_ context.Context, versionInfo *docdb.VersionInfo) error {
+			original[versionInfo.DocID] = versionInfo
+			return nil
+		},
+	)
+	require.NoError(t, err)
+
+	repeated := make(map[uu.ID]*docdb.VersionInfo)
+	err = s.conn.AddMultiDocumentVersion(ctx, uu.IDSlice{docID1, docID2}, uu.IDv7(), "repeated",
+		writeFiles(Version3, fs.NewMemFile("b.txt", []byte("b"))),
+		func(_ context.Context, versionInfo *docdb.VersionInfo) error {
+			repeated[versionInfo.DocID] = versionInfo
+			return nil
+		},
+	)
+	require.NoError(t, err, "repeated call must not return ErrNoChanges")
+	for _, docID := range []uu.ID{docID1, docID2} {
+		require.True(t, repeated[docID].Equal(original[docID]), "repeated VersionInfo %s must equal original %s", repeated[docID], original[docID])
+		s.requireVersions(t, docID, Version1, Version2)
+	}
+}
+
+func testRevertDocumentToVersion(t *testing.T, s *suite) {
+	var (
+		userID = uu.IDv7()
+		docID  = s.createDocument(t, uu.IDv7(),
+			fs.NewMemFile("doc.pdf", []byte("pdf")),
+			fs.NewMemFile("a.txt", []byte("a")),
+		)
+	)
+	err := s.conn.AddDocumentVersion(s.ctx, docID, uu.IDv7(), "bad edit",
+		func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			return &docdb.CreateVersionResult{
+				Version:     Version2,
+				WriteFiles:  []fs.FileReader{fs.NewMemFile("a.txt", []byte("bad")), fs.NewMemFile("b.txt", []byte("b"))},
+				RemoveFiles: []string{"doc.pdf"},
+			}, nil
+		},
+		NoopOnNewVersion,
+	)
+	require.NoError(t, err)
+
+	reverted, err := docdb.RevertDocumentToVersion(s.ctx, s.conn, docID, Version1, userID, "undo bad edit")
+	require.NoError(t, err)
+	require.True(t, reverted.Version.After(Version2), "reverted version %s must be after %s", reverted.Version, Version2)
+	require.Equal(t, Version2, *reverted.PrevVersion)
+	require.Equal(t, userID, reverted.CommitUserID)
+	revertedTo, ok := docdb.RevertedToVersion(reverted)
+	require.True(t, ok, "RevertedToVersion of %q", reverted.CommitReason)
+	require.Equal(t, Version1, revertedTo)
+	require.Equal(t, []string{"doc.pdf"}, reverted.AddedFiles)
+	require.Equal(t, []string{"a.txt"}, reverted.ModifiedFiles)
+	require.Equal(t, []string{"b.txt"}, reverted.RemovedFiles)
+
+	target, err := s.conn.DocumentVersionInfo(s.ctx, docID, Version1)
+	require.NoError(t, err)
+	require.True(t, reverted.EqualFiles(target), "files of %s must equal files of %s", reverted, target)
+	s.requireVersions(t, docID, Version1, Version2, reverted.Version)
+	s.requireFile(t, docID, reverted.Version, "doc.pdf", []byte("pdf"))
+	s.requireFile(t, docID, reverted.Version, "a.txt", []byte("a"))
+
+	_, err = docdb.RevertDocumentToVersion(s.ctx, s.conn, docID, Version1, userID, "again")
+	require.ErrorIs(t, err, docdb.ErrNoChanges, "latest version already has the files")
+	_, err = docdb.RevertDocumentToVersion(s.ctx, s.conn, docID, Version3, userID, "missing")
+	require.ErrorIs(t, err, errs.ErrNotFound, "missing version")
+}
+
+func testCloneDocument(t *testing.T, s *suite) {
+	var (
+		companyID    = uu.IDv7()
+		newCompanyID = uu.IDv7()
+		userID       = uu.IDv7()
+		srcDocID     = s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+	)
+	s.addVersion(t, srcDocID, Version2, fs.NewMemFile("a.txt", []byte("a")))
+
+	t.Run("with history", func(t *testing.T) {
+		newDocID := uu.IDv7()
+		err := docdb.CloneDocument(s.ctx, s.conn, srcDocID, newDocID, newCompanyID, userID, "clone", true)
+		require.NoError(t, err)
+
+		s.requireVersions(t, newDocID, Version1, Version2)
+		s.requireCompanyID(t, newDocID, newCompanyID)
+		for _, version := range []docdb.VersionTime{Version1, Version2} {
+			src, err := s.conn.DocumentVersionInfo(s.ctx, srcDocID, version)
+			require.NoError(t, err)
+			clone, err := s.conn.DocumentVersionInfo(s.ctx, newDocID, version)
+			require.NoError(t, err)
+			require.Equal(t, newDocID, clone.DocID)
+			require.Equal(t, newCompanyID, clone.CompanyID)
+			require.Equal(t, src.CommitUserID, clone.CommitUserID)
+			require.Equal(t, src.CommitReason, clone.CommitReason)
+			require.True(t, clone.EqualFiles(src), "files of %s must equal files of %s", clone, src)
+		}
+		s.requireFile(t, newDocID, Version2, "a.txt", []byte("a"))
+	})
+
+	t.Run("without history", func(t *testing.T) {
+		var (
+			newDocID = uu.IDv7()
+			latest   *docdb.VersionInfo
+		)
+		err := docdb.CloneDocument(s.ctx, s.conn, srcDocID, newDocID, newCompanyID, userID, "clone", false)
+		require.NoError(t, err)
+
+		latest, err = s.conn.LatestDocumentVersionInfo(s.ctx, newDocID)
+		require.NoError(t, err)
+		s.requireVersions(t, newDocID, latest.Version)
+		s.requireCompanyID(t, newDocID, newCompanyID)
+		require.Equal(t, userID, latest.CommitUserID)
+		require.Equal(t, "clone", latest.CommitReason)
+		require.Equal(t, []string{"a.txt", "doc.pdf"}, latest.AddedFiles)
+		s.requireFile(t, newDocID, latest.Version, "doc.pdf", []byte("pdf"))
+	})
+
+	s.requireVersions(t, srcDocID, Version1, Version2)
+	s.requireCompanyID(t, srcDocID, companyID)
+
+	existingDocID := s.createDocument(t, newCompanyID, fs.NewMemFile("doc.pdf", []byte("other")))
+	for _, withHistory := range []bool{true, false} {
+		err := docdb.CloneDocument(s.ctx, s.conn, srcDocID, existingDocID, newCompanyID, userID, "clone", withHistory)
+		require.ErrorAs(t, err, new(docdb.ErrDocumentAlreadyExists), "withHistory: %t", withHistory)
+	}
+	s.requireVersions(t, existingDocID, Version1)
+	s.requireFile(t, existingDocID, Version1, "doc.pdf", []byte("other"))
+}
+
+func testReadDocumentVersionFileRange(t *testing.T, s *suite) {
+	docID := s.createDocument(t, uu.IDv7(), fs.NewMemFile("doc.txt", []byte("0123456789")))
+
+	for _, tt := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 4, "0123"},
+		{3, 4, "3456"},
+		{6, 10, "6789"},
+		{7, -1, "789"},
+		{0, -1, "0123456789"},
+		{5, 0, ""},
+		{10, 4, ""},
+		{20, -1, ""},
+	} {
+		data, err := s.conn.ReadDocumentVersionFileRange(s.ctx, docID, Version1, "doc.txt", tt.offset, tt.length)
+		require.NoError(t, err, "offset %d length %d", tt.offset, tt.length)
+		require.Equal(t, tt.want, string(data), "offset %d length %d", tt.offset, tt.length)
+	}
+
+	_, err := s.conn.ReadDocumentVersionFileRange(s.ctx, docID, Version1, "doc.txt", -1, 4)
+	require.ErrorIs(t, err, docdb.ErrNegativeOffset)
+}
+
+func testReadNotFound(t *testing.T, s *suite) {
+	missingID := uu.IDv7()
+	docID := s.createDocument(t, uu.IDv7(), fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+	s.requireDocumentExists(t, missingID, false)
+
+	_, err := s.conn.DocumentCompanyID(s.ctx, missingID)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound), "DocumentCompanyID")
+	_, err = s.conn.DocumentVersions(s.ctx, missingID)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound), "DocumentVersions")
+	_, err = s.conn.LatestDocumentVersion(s.ctx, missingID)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound), "LatestDocumentVersion")
+	_, err = s.conn.LatestDocumentVersionInfo(s.ctx, missingID)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound), "LatestDocumentVersionInfo")
+	_, err = s.conn.ReadDocumentVersionFile(s.ctx, missingID, Version1, "doc.pdf")
+	require.ErrorIs(t, err, errs.ErrNotFound, "ReadDocumentVersionFile of missing document")
+
+	_, err = s.conn.DocumentVersionInfo(s.ctx, docID, Version2)
+	require.ErrorIs(t, err, errs.ErrNotFound, "DocumentVersionInfo of missing version")
+	_, err = s.conn.ReadDocumentVersionFile(s.ctx, docID, Version2, "doc.pdf")
+	require.ErrorIs(t, err, errs.ErrNotFound, "ReadDocumentVersionFile of missing version")
+
+	_, err = s.conn.ReadDocumentVersionFile(s.ctx, docID, Version1, "missing.pdf")
+	require.ErrorAs(t, err, new(docdb.ErrDocumentFileNotFound), "ReadDocumentVersionFile of missing file")
+	_, err = s.conn.OpenDocumentVersionFile(s.ctx, docID, Version1, "missing.pdf")
+	require.ErrorAs(t, err, new(docdb.ErrDocumentFileNotFound), "OpenDocumentVersionFile of missing file")
+	_, err = s.conn.ReadDocumentVersionFileRange(s.ctx, docID, Version1, "missing.pdf", 0, 1)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentFileNotFound), "ReadDocumentVersionFileRange of missing file")
+	_, err = s.conn.ReadDocumentVersionFileRange(s.ctx, missingID, Version1, "doc.pdf", 0, 1)
+	require.ErrorIs(t, err, errs.ErrNotFound, "ReadDocumentVersionFileRange of missing document")
+}
+
+func testCompanyDocumentIDs(t *testing.T, s *suite) {
+	var (
+		companyID1 = uu.IDv7()
+		companyID2 = uu.IDv7()
+		docIDs1    uu.IDSlice
+	)
+	for range 3 {
+		docIDs1 = append(docIDs1, s.createDocument(t, companyID1, fs.NewMemFile("doc.pdf", []byte("pdf"))))
+	}
+	docID2 := s.createDocument(t, companyID2, fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+	docIDs, err := s.conn.CompanyDocumentIDs(s.ctx, companyID1)
+	require.NoError(t, err)
+	docIDs1.Sort()
+	require.Equal(t, docIDs1, docIDs, "CompanyDocumentIDs must be sorted by ID")
+	s.requireCompanyDocumentIDs(t, companyID2, docID2)
+	s.requireCompanyDocumentIDs(t, uu.IDv7())
+
+	// The Conn may hold companies of other tests
+	companyIDs, err := s.conn.CompanyIDs(s.ctx)
+	require.NoError(t, err)
+	require.Contains(t, companyIDs, companyID1)
+	require.Contains(t, companyIDs, companyID2)
+	require.True(t, sort.IsSorted(companyIDs), "CompanyIDs must be sorted by ID")
+	require.Len(t, companyIDs, len(companyIDs.AsSet()), "CompanyIDs must be unique")
+}
+
+func testSetDocumentCompanyID(t *testing.T, s *suite) {
+	companyID := uu.IDv7()
+	newCompanyID := uu.IDv7()
+	docID := s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+	require.NoError(t, s.conn.SetDocumentCompanyID(s.ctx, docID, newCompanyID))
+
+	s.requireCompanyID(t, docID, newCompanyID)
+	s.requireCompanyDocumentIDs(t, companyID)
+	s.requireCompanyDocumentIDs(t, newCompanyID, docID)
+
+	err := s.conn.SetDocumentCompanyID(s.ctx, uu.IDv7(), newCompanyID)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound))
+}
+
+func testDeleteDocumentVersion(t *testing.T, s *suite) {
+	companyID := uu.IDv7()
+	docID := s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+	s.addVersion(t, docID, Version2, fs.NewMemFile("a.txt", []byte("a")))
+	s.addVersion(t, docID, Version3, fs.NewMemFile("b.txt", []byte("b")))
+
+	_, err := s.conn.DeleteDocumentVersion(s.ctx, docID, Version4)
+	require.ErrorIs(t, err, errs.ErrNotFound)
+	_, err = s.conn.DeleteDocumentVersion(s.ctx, uu.IDv7(), Version1)
+	require.ErrorIs(t, err, errs.ErrNotFound)
+
+	leftVersions, err := s.conn.DeleteDocumentVersion(s.ctx, docID, Version2)
+	require.NoError(t, err)
+	require.Equal(t, []docdb.VersionTime{Version1, Version3}, leftVersions)
+	s.requireVersions(t, docID, Version1, Version3)
+	s.requireFile(t, docID, Version3, "a.txt", []byte("a"))
+
+	leftVersions, err = s.conn.DeleteDocumentVersion(s.ctx, docID, Version3)
+	require.NoError(t, err)
+	require.Equal(t, []docdb.VersionTime{Version1}, leftVersions)
+
+	// Deleting the only version deletes the document
+	leftVersions, err = s.conn.DeleteDocumentVersion(s.ctx, docID, Version1)
+	require.NoError(t, err)
+	require.Empty(t, leftVersions)
+	s.requireDocumentExists(t, docID, false)
+	s.requireCompanyDocumentIDs(t, companyID)
+}
+
+func testDeleteDocument(t *testing.T, s *suite) {
+	companyID := uu.IDv7()
+	docID := s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+	s.addVersion(t, docID, Version2, fs.NewMemFile("a.txt", []byte("a")))
+	otherDocID := s.createDocument(t, companyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+	require.NoError(t, s.conn.DeleteDocument(s.ctx, docID))
+
+	s.requireDocumentExists(t, docID, false)
+	s.requireCompanyDocumentIDs(t, companyID, otherDocID)
+	s.requireFile(t, otherDocID, Version1, "doc.pdf", []byte("pdf"))
+
+	err := s.conn.DeleteDocument(s.ctx, docID)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound))
+}
+
+// newHashedDocument returns a HashedDocument with three versions:
+// Version1 with doc.pdf, Version2 adding a.txt and Version3 modifying a.txt.
+func newHashedDocument(companyID uu.ID) *docdb.HashedDocument {
+	var (
+		pdfHash = docdb.ContentHash([]byte("pdf"))
+		aHash   = docdb.ContentHash([]byte("a"))
+		a2Hash  = docdb.ContentHash([]byte("a2"))
+	)
+	return &docdb.HashedDocument{
+		ID:        uu.IDv7(),
+		CompanyID: companyID,
+		Versions: map[docdb.VersionTime]*docdb.HashedVersion{
+			Version1: {CommitUserID: uu.IDv7(), CommitReason: "v1", FileHashes: map[string]string{"doc.pdf": pdfHash}},
+			Version2: {CommitUserID: uu.IDv7(), CommitReason: "v2", FileHashes: map[string]string{"doc.pdf": pdfHash, "a.txt": aHash}},
+			Version3: {CommitUserID: uu.IDv7(), CommitReason: "v3", FileHashes: map[string]string{"doc.pdf": pdfHash, "a.txt": a2Hash}},
+		},
+		HashedFiles: map[string][]byte{
+			pdfHash: []byte("pdf"),
+			aHash:   []byte("a"),
+			a2Hash:  []byte("a2"),
+		},
+	}
+}
+
+func (s *suite) requireHashedDocument(t *testing.T, want *docdb.HashedDocument) {
+	t.Helper()
+	got, err := docdb.ReadHashedDocument(s.ctx, s.conn, want.ID)
+	require.NoError(t, err, "ReadHashedDocument")
+	require.Equal(t, want, got, "ReadHashedDocument")
+	s.requireCompanyID(t, want.ID, want.CompanyID)
+	s.requireCompanyDocumentIDs(t, want.CompanyID, want.ID)
+	for _, version := range want.VersionTimes() {
+		wantInfo, err := want.VersionInfo(version)
+		require.NoError(t, err)
+		info, err := s.conn.DocumentVersionInfo(s.ctx, want.ID, version)
+		require.NoError(t, err)
+		require.Equal(t, wantInfo.PrevVersion, info.PrevVersion, "version %s PrevVersion", version)
+		require.ElementsMatch(t, wantInfo.AddedFiles, info.AddedFiles, "version %s AddedFiles", version)
+		require.ElementsMatch(t, wantInfo.ModifiedFiles, info.ModifiedFiles, "version %s ModifiedFiles", version)
+		require.ElementsMatch(t, wantInfo.RemovedFiles, info.RemovedFiles, "version %s RemovedFiles", version)
+	}
+}
+
+func testRestoreNewDocument(t *testing.T, s *suite) {
+	for _, recreate := range []bool{false, true} {
+		doc := newHashedDocument(uu.IDv7())
+
+		require.NoError(t, s.conn.RestoreDocument(s.ctx, doc, recreate), "recreate=%t", recreate)
+
+		s.requireHashedDocument(t, doc)
+	}
+}
+
+func testRestoreRejectsInvalidDocument(t *testing.T, s *suite) {
+	doc := newHashedDocument(uu.IDv7())
+	docID := s.createDocument(t, doc.CompanyID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+	doc.ID = docID
+	// Identical to Version1, versions must change files
+	doc.Versions[Version2].FileHashes = doc.Versions[Version1].FileHashes
+
+	for _, recreate := range []bool{false, true} {
+		require.Error(t, s.conn.RestoreDocument(s.ctx, doc, recreate), "recreate=%t", recreate)
+	}
+
+	s.requireVersions(t, docID, Version1)
+}
+
+func testRestoreMergesMissingVersions(t *testing.T, s *suite) {
+	for _, missing := range []docdb.VersionTime{Version1, Version2, Version3} {
+		t.Run("missing "+missing.String(), func(t *testing.T) {
+			doc := newHashedDocument(uu.IDv7())
+			require.NoError(t, s.conn.RestoreDocument(s.ctx, doc, false))
+			_, err := s.conn.DeleteDocumentVersion(s.ctx, doc.ID, missing)
+			require.NoError(t, err)
+
+			require.NoError(t, s.conn.RestoreDocument(s.ctx, doc, false))
+
+			s.requireVersions(t, doc.ID, Version1, Version2, Version3)
+			got, err := docdb.ReadHashedDocument(s.ctx, s.conn, doc.ID)
+			require.NoError(t, err)
+			require.Equal(t, doc, got)
+		})
+	}
+}
+
+func testRestoreMergeKeepsExistingVersions(t *testing.T, s *suite) {
+	companyID := uu.IDv7()
+	docID := s.createDocument(t, companyID, fs.NewMemFile("existing.pdf", []byte("existing")))
+	s.addVersion(t, docID, Version4, fs.NewMemFile("v4.txt", []byte("v4")))
+	existing, err := docdb.ReadHashedDocument(s.ctx, s.conn, docID)
+	require.NoError(t, err)
+
+	doc := newHashedDocument(companyID)
+	doc.ID = docID
+
+	require.NoError(t, s.conn.RestoreDocument(s.ctx, doc, false))
+
+	// Version1 is kept as-is, Version4 is not in doc and kept as well
+	s.requireVersions(t, docID, Version1, Version2, Version3, Version4)
+	for _, version := range []docdb.VersionTime{Version1, Version4} {
+		provider, err := s.conn.DocumentVersionFileProvider(s.ctx, docID, version)
+		require.NoError(t, err)
+		filenames, err := provider.ListFiles(s.ctx)
+		require.NoError(t, err)
+		wantFilenames := slices.Sorted(maps.Keys(existing.Versions[version].FileHashes))
+		require.Equal(t, wantFilenames, filenames, "files of kept version %s", version)
+	}
+	s.requireFile(t, docID, Version1, "existing.pdf", []byte("existing"))
+	s.requireFile(t, docID, Version3, "a.txt", []byte("a2"))
+}
+
+func testRestoreMergeRejectsOtherCompany(t *testing.T, s *suite) {
+	companyID := uu.IDv7()
+	docID := s.createDocument(t, companyID, fs.NewMemFile("existing.pdf", []byte("existing")))
+	doc := newHashedDocument(uu.IDv7())
+	doc.ID = docID
+
+	require.Error(t, s.conn.RestoreDocument(s.ctx, doc, false))
+
+	s.requireVersions(t, docID, Version1)
+	s.requireCompanyID(t, docID, companyID)
+	s.requireFile(t, docID, Version1, "existing.pdf", []byte("existing"))
+	s.requireCompanyDocumentIDs(t, doc.CompanyID)
+}
+
+func testRestoreRecreateReplacesDocument(t *testing.T, s *suite) {
+	oldCompanyID := uu.IDv7()
+	docID := s.createDocument(t, oldCompanyID, fs.NewMemFile("existing.pdf", []byte("existing")))
+	s.addVersion(t, docID, Version4, fs.NewMemFile("v4.txt", []byte("v4")))
+	doc := newHashedDocument(uu.IDv7())
+	doc.ID = docID
+
+	require.NoError(t, s.conn.RestoreDocument(s.ctx, doc, true))
+
+	s.requireHashedDocument(t, doc)
+	s.requireCompanyDocumentIDs(t, oldCompanyID)
+}
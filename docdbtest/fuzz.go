@@ -0,0 +1,623 @@
+package docdbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+)
+
+// NamedConn is a docdb.Conn compared by FuzzConns
+// with Name used in failure messages.
+type NamedConn struct {
+	Name string
+	Conn docdb.Conn
+}
+
+// Pools the fuzz operations pick their arguments from.
+// They are small so that operations hit the same documents,
+// companies and files often.
+const (
+	fuzzNumDocs      = 3
+	fuzzNumCompanies = 3
+	fuzzMaxSteps     = 64
+)
+
+var (
+	fuzzFilenames = []string{"doc.pdf", "a.txt", "b.txt", "c.json"}
+	fuzzContents  = []string{"a", "b", "c"}
+)
+
+// Fuzz operations, the first byte of every step modulo fuzzNumOps.
+const (
+	fuzzOpCreate = iota
+	fuzzOpAddVersion
+	fuzzOpDeleteVersion
+	fuzzOpDeleteDocument
+	fuzzOpSetCompanyID
+	fuzzOpRestore
+	fuzzNumOps
+)
+
+// FuzzConns is a differential fuzz test for docdb.Conn implementations.
+//
+// Every fuzz input is decoded into a sequence of CreateDocument,
+// AddDocumentVersion, DeleteDocumentVersion, DeleteDocument,
+// SetDocumentCompanyID and RestoreDocument operations on a small pool
+// of documents, companies and files. Each operation is applied to all
+// Conns returned by newConns and to a reference model. After every step
+// the class of the returned errors, the documents read with
+// docdb.ReadHashedDocument, the VersionInfo of every version
+// and CompanyDocumentIDs must be the same for all Conns and the model.
+//
+// newConns is called once per fuzz input and must return new,
+// empty Conns. At least one Conn is required, in which case it is
+// only compared with the model.
+//
+// Use it from a fuzz test and run it with go test -fuzz:
+//
+//	func FuzzConns(f *testing.F) {
+//		docdbtest.FuzzConns(f, func(t *testing.T) []docdbtest.NamedConn {
+//			return []docdbtest.NamedConn{
+//				{Name: "memconn", Conn: memconn.New()},
+//				{Name: "mybackend", Conn: mybackend.NewTestConn(t)},
+//			}
+//		})
+//	}
+//
+// The fuzzing engine minimizes failing inputs and stores them
+// in the testdata/fuzz directory of the package. The steps of an input
+// are logged, so a failing test shows the minimized operation sequence.
+func FuzzConns(f *testing.F, newConns func(t *testing.T) []NamedConn, options ...Option) {
+	cfg := newConfig(options)
+
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		conns := newConns(t)
+		require.NotEmpty(t, conns, "newConns returned no Conns")
+		h := &fuzzHarness{
+			ctx:   cfg.newContext(t),
+			conns: conns,
+			model: make(map[uu.ID]*modelDocument),
+		}
+		for i := range h.docIDs {
+			h.docIDs[i] = uu.IDv7()
+		}
+		for i := range h.companyIDs {
+			h.companyIDs[i] = uu.IDv7()
+		}
+
+		r := fuzzReader(ops)
+		for step := 0; step < fuzzMaxSteps && len(r) > 0; step++ {
+			h.step(t, step, &r)
+			h.check(t)
+		}
+	})
+}
+
+// fuzzSeeds is the seed corpus of FuzzConns
+// covering every operation at least once.
+var fuzzSeeds = [][]byte{
+	// Create doc0 with doc.pdf and a.txt, add a version modifying a.txt,
+	// removing doc.pdf and adding b.txt, then delete the first version
+	{
+		fuzzOpCreate, 0, 0, 1, 1, 0, 0,
+		fuzzOpAddVersion, 0, 2, 4, 3, 0, 3,
+		fuzzOpDeleteVersion, 0, 0,
+	},
+	// Create doc0 and doc1, move doc1 to company 0 and restore doc0 over doc1
+	{
+		fuzzOpCreate, 0, 0, 1, 0, 0, 0,
+		fuzzOpCreate, 1, 1, 0, 2, 0, 0,
+		fuzzOpSetCompanyID, 1, 0,
+		fuzzOpRestore, 1, 0, 3, 0,
+		fuzzOpRestore, 2, 0, 1, 1,
+	},
+	// Create doc0, write unchanged content, remove all files,
+	// create it again and delete it
+	{
+		fuzzOpCreate, 0, 2, 1, 0, 0, 0,
+		fuzzOpAddVersion, 0, 3, 0, 0, 0, 3,
+		fuzzOpAddVersion, 0, 2, 0, 0, 0, 1,
+		fuzzOpCreate, 0, 2, 1, 0, 0, 0,
+		fuzzOpDeleteDocument, 0,
+		fuzzOpDeleteVersion, 0, 0,
+	},
+	// Build up versions and delete one in the middle
+	// so that its neighbours have identical files
+	{
+		fuzzOpCreate, 2, 0, 1, 0, 0, 0,
+		fuzzOpAddVersion, 2, 0, 0, 3, 0, 3,
+		fuzzOpAddVersion, 2, 0, 0, 2, 0, 1,
+		fuzzOpDeleteVersion, 2, 1,
+		fuzzOpRestore, 0, 2, 3, 1,
+	},
+}
+
+// fuzzReader decodes the bytes of a fuzz input.
+// Reading past the end returns zeros so that
+// every input decodes into a valid operation sequence.
+type fuzzReader []byte
+
+func (r *fuzzReader) next(n int) int {
+	if len(*r) == 0 {
+		return 0
+	}
+	b := (*r)[0]
+	*r = (*r)[1:]
+	return int(b) % n
+}
+
+// errClass is the class of an error that must be
+// the same for all Conns and the model.
+type errClass string
+
+const (
+	errClassNone          errClass = "no error"
+	errClassNoChanges     errClass = "ErrNoChanges"
+	errClassAlreadyExists errClass = "ErrDocumentAlreadyExists"
+	errClassNotFound      errClass = "not found error"
+	errClassOther         errClass = "other error"
+)
+
+func classifyError(err error) errClass {
+	switch {
+	case err == nil:
+		return errClassNone
+	case errors.Is(err, docdb.ErrNoChanges):
+		return errClassNoChanges
+	case errors.As(err, new(docdb.ErrDocumentAlreadyExists)):
+		return errClassAlreadyExists
+	case errors.Is(err, errs.ErrNotFound):
+		return errClassNotFound
+	default:
+		return errClassOther
+	}
+}
+
+// modelDocument is the reference model of a document.
+type modelDocument struct {
+	companyID uu.ID
+	versions  map[docdb.VersionTime]*modelVersion
+}
+
+type modelVersion struct {
+	userID uu.ID
+	reason string
+	files  map[string]string // filename -> content
+}
+
+func (d *modelDocument) versionTimes() []docdb.VersionTime {
+	return slices.SortedFunc(maps.Keys(d.versions), docdb.VersionTime.Compare)
+}
+
+func (d *modelDocument) latest() *modelVersion {
+	versions := d.versionTimes()
+	return d.versions[versions[len(versions)-1]]
+}
+
+func (d *modelDocument) clone() *modelDocument {
+	clone := &modelDocument{
+		companyID: d.companyID,
+		versions:  make(map[docdb.VersionTime]*modelVersion, len(d.versions)),
+	}
+	for version, v := range d.versions {
+		clone.versions[version] = &modelVersion{userID: v.userID, reason: v.reason, files: maps.Clone(v.files)}
+	}
+	return clone
+}
+
+func (d *modelDocument) hashedDocument(docID uu.ID) *docdb.HashedDocument {
+	doc := &docdb.HashedDocument{
+		ID:          docID,
+		CompanyID:   d.companyID,
+		HashedFiles: make(map[string][]byte),
+		Versions:    make(map[docdb.VersionTime]*docdb.HashedVersion, len(d.versions)),
+	}
+	for version, v := range d.versions {
+		hv := &docdb.HashedVersion{
+			CommitUserID: v.userID,
+			CommitReason: v.reason,
+			FileHashes:   make(map[string]string, len(v.files)),
+		}
+		for filename, content := range v.files {
+			hash := docdb.ContentHash([]byte(content))
+			hv.FileHashes[filename] = hash
+			doc.HashedFiles[hash] = []byte(content)
+		}
+		doc.Versions[version] = hv
+	}
+	return doc
+}
+
+// fuzzHarness holds the state of a single FuzzConns input.
+type fuzzHarness struct {
+	ctx        context.Context
+	conns      []NamedConn
+	docIDs     [fuzzNumDocs]uu.ID
+	companyIDs [fuzzNumCompanies]uu.ID
+	model      map[uu.ID]*modelDocument
+}
+
+// fuzzOp is a decoded operation.
+type fuzzOp struct {
+	desc string
+	// want is the error class predicted by the model
+	want errClass
+	// apply applies the operation to a Conn
+	apply func(t *testing.T, c NamedConn) error
+	// commit applies the operation to the model if want is errClassNone
+	commit func()
+}
+
+// step decodes the next operation from r,
+// applies it to all Conns and the model
+// and compares the returned errors.
+func (h *fuzzHarness) step(t *testing.T, step int, r *fuzzReader) {
+	t.Helper()
+
+	// Every step gets its own version time after all previous ones
+	version := docdb.VersionTimeFrom(Version1.Time.Add(time.Duration(step) * time.Minute))
+	userID := uu.IDv7()
+	reason := fmt.Sprintf("step %d", step)
+
+	var op *fuzzOp
+	switch r.next(fuzzNumOps) {
+	case fuzzOpCreate:
+		op = h.createOp(r, version, userID, reason)
+	case fuzzOpAddVersion:
+		op = h.addVersionOp(r, version, userID, reason)
+	case fuzzOpDeleteVersion:
+		op = h.deleteVersionOp(r, version)
+	case fuzzOpDeleteDocument:
+		op = h.deleteDocumentOp(r)
+	case fuzzOpSetCompanyID:
+		op = h.setCompanyIDOp(r)
+	case fuzzOpRestore:
+		op = h.restoreOp(r)
+	}
+	t.Logf("step %d: %s, want %s", step, op.desc, op.want)
+
+	for _, c := range h.conns {
+		err := op.apply(t, c)
+		require.Equal(t, op.want, classifyError(err), "%s: step %d: %s: %v", c.Name, step, op.desc, err)
+	}
+	if op.want == errClassNone && op.commit != nil {
+		op.commit()
+	}
+}
+
+func (h *fuzzHarness) createOp(r *fuzzReader, version docdb.VersionTime, userID uu.ID, reason string) *fuzzOp {
+	docIndex := r.next(fuzzNumDocs)
+	companyIndex := r.next(fuzzNumCompanies)
+	docID, companyID := h.docIDs[docIndex], h.companyIDs[companyIndex]
+	files := make(map[string]string)
+	for _, filename := range fuzzFilenames {
+		if i := r.next(len(fuzzContents) + 1); i > 0 {
+			files[filename] = fuzzContents[i-1]
+		}
+	}
+
+	op := &fuzzOp{
+		desc: fmt.Sprintf("CreateDocument doc%d company%d %s files %s", docIndex, companyIndex, version, formatFiles(files)),
+		apply: func(t *testing.T, c NamedConn) error {
+			return c.Conn.CreateDocument(h.ctx, companyID, docID, userID, reason, version, memFiles(files), NoopOnNewVersion)
+		},
+		commit: func() {
+			h.model[docID] = &modelDocument{
+				companyID: companyID,
+				versions:  map[docdb.VersionTime]*modelVersion{version: {userID: userID, reason: reason, files: files}},
+			}
+		},
+	}
+	switch {
+	case len(files) == 0:
+		op.want = errClassOther
+	case h.model[docID] != nil:
+		op.want = errClassAlreadyExists
+	default:
+		op.want = errClassNone
+	}
+	return op
+}
+
+func (h *fuzzHarness) addVersionOp(r *fuzzReader, version docdb.VersionTime, userID uu.ID, reason string) *fuzzOp {
+	docIndex := r.next(fuzzNumDocs)
+	docID := h.docIDs[docIndex]
+	var (
+		writeFiles  = make(map[string]string)
+		removeFiles []string
+		actions     []string
+	)
+	for _, filename := range fuzzFilenames {
+		// 0 and 1 keep the file, 2 removes it, 3... write fuzzContents
+		switch i := r.next(len(fuzzContents) + 3); {
+		case i == 2:
+			removeFiles = append(removeFiles, filename)
+			actions = append(actions, "-"+filename)
+		case i > 2:
+			writeFiles[filename] = fuzzContents[i-3]
+			actions = append(actions, fmt.Sprintf("+%s=%q", filename, fuzzContents[i-3]))
+		}
+	}
+	newCompanyIndex := r.next(fuzzNumCompanies + 1)
+	var newCompanyID uu.NullableID
+	if newCompanyIndex < fuzzNumCompanies {
+		newCompanyID = h.companyIDs[newCompanyIndex].Nullable()
+		actions = append(actions, fmt.Sprintf("company%d", newCompanyIndex))
+	}
+
+	op := &fuzzOp{
+		desc: fmt.Sprintf("AddDocumentVersion doc%d %s [%s]", docIndex, version, strings.Join(actions, " ")),
+		apply: func(t *testing.T, c NamedConn) error {
+			createVersion := func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+				return &docdb.CreateVersionResult{
+					Version:      version,
+					WriteFiles:   memFiles(writeFiles),
+					RemoveFiles:  removeFiles,
+					NewCompanyID: newCompanyID,
+				}, nil
+			}
+			return c.Conn.AddDocumentVersion(h.ctx, docID, userID, reason, createVersion, NoopOnNewVersion)
+		},
+	}
+	doc := h.model[docID]
+	if doc == nil {
+		op.want = errClassNotFound
+		return op
+	}
+	prevFiles := doc.latest().files
+	files := maps.Clone(prevFiles)
+	for _, filename := range removeFiles {
+		delete(files, filename)
+	}
+	maps.Copy(files, writeFiles)
+	switch {
+	case len(files) == 0:
+		op.want = errClassOther
+	case maps.Equal(files, prevFiles):
+		op.want = errClassNoChanges
+	default:
+		op.want = errClassNone
+		op.commit = func() {
+			doc.versions[version] = &modelVersion{userID: userID, reason: reason, files: files}
+			if newCompanyID.IsNotNull() {
+				doc.companyID = newCompanyID.Get()
+			}
+		}
+	}
+	return op
+}
+
+func (h *fuzzHarness) deleteVersionOp(r *fuzzReader, notExistingVersion docdb.VersionTime) *fuzzOp {
+	docIndex := r.next(fuzzNumDocs)
+	docID := h.docIDs[docIndex]
+	doc := h.model[docID]
+	var versions []docdb.VersionTime
+	if doc != nil {
+		versions = doc.versionTimes()
+	}
+	// The index after the last version selects a version that does not exist
+	versionIndex := r.next(len(versions) + 1)
+	version := notExistingVersion
+	if versionIndex < len(versions) {
+		version = versions[versionIndex]
+	}
+
+	desc := fmt.Sprintf("DeleteDocumentVersion doc%d %s", docIndex, version)
+	if versionIndex == len(versions) {
+		return &fuzzOp{
+			desc: desc,
+			want: errClassNotFound,
+			apply: func(t *testing.T, c NamedConn) error {
+				_, err := c.Conn.DeleteDocumentVersion(h.ctx, docID, version)
+				return err
+			},
+		}
+	}
+	leftVersions := slices.Delete(slices.Clone(versions), versionIndex, versionIndex+1)
+	return &fuzzOp{
+		desc: desc,
+		want: errClassNone,
+		apply: func(t *testing.T, c NamedConn) error {
+			got, err := c.Conn.DeleteDocumentVersion(h.ctx, docID, version)
+			if err == nil && len(leftVersions) == 0 {
+				require.Empty(t, got, "%s: %s: leftVersions", c.Name, desc)
+			} else if err == nil {
+				require.Equal(t, leftVersions, got, "%s: %s: leftVersions", c.Name, desc)
+			}
+			return err
+		},
+		commit: func() {
+			delete(doc.versions, version)
+			if len(doc.versions) == 0 {
+				delete(h.model, docID)
+			}
+		},
+	}
+}
+
+func (h *fuzzHarness) deleteDocumentOp(r *fuzzReader) *fuzzOp {
+	docIndex := r.next(fuzzNumDocs)
+	docID := h.docIDs[docIndex]
+	op := &fuzzOp{
+		desc: fmt.Sprintf("DeleteDocument doc%d", docIndex),
+		apply: func(t *testing.T, c NamedConn) error {
+			return c.Conn.DeleteDocument(h.ctx, docID)
+		},
+		commit: func() {
+			delete(h.model, docID)
+		},
+	}
+	if h.model[docID] == nil {
+		op.want = errClassNotFound
+	} else {
+		op.want = errClassNone
+	}
+	return op
+}
+
+func (h *fuzzHarness) setCompanyIDOp(r *fuzzReader) *fuzzOp {
+	docIndex := r.next(fuzzNumDocs)
+	companyIndex := r.next(fuzzNumCompanies)
+	docID, companyID := h.docIDs[docIndex], h.companyIDs[companyIndex]
+	op := &fuzzOp{
+		desc: fmt.Sprintf("SetDocumentCompanyID doc%d company%d", docIndex, companyIndex),
+		apply: func(t *testing.T, c NamedConn) error {
+			return c.Conn.SetDocumentCompanyID(h.ctx, docID, companyID)
+		},
+		commit: func() {
+			h.model[docID].companyID = companyID
+		},
+	}
+	if h.model[docID] == nil {
+		op.want = errClassNotFound
+	} else {
+		op.want = errClassNone
+	}
+	return op
+}
+
+// restoreOp restores a backup of the source document,
+// which may be the document itself, to a document.
+func (h *fuzzHarness) restoreOp(r *fuzzReader) *fuzzOp {
+	docIndex := r.next(fuzzNumDocs)
+	srcIndex := r.next(fuzzNumDocs)
+	// The index after the last company selects the company of the
+	// restored document if it exists, else the company of the source
+	companyIndex := r.next(fuzzNumCompanies + 1)
+	recreate := r.next(2) == 1
+	docID := h.docIDs[docIndex]
+
+	backup := &modelDocument{versions: make(map[docdb.VersionTime]*modelVersion)}
+	if src := h.model[h.docIDs[srcIndex]]; src != nil {
+		backup = src.clone()
+	}
+	existing := h.model[docID]
+	company := "unchanged company"
+	switch {
+	case companyIndex < fuzzNumCompanies:
+		backup.companyID = h.companyIDs[companyIndex]
+		company = fmt.Sprintf("company%d", companyIndex)
+	case existing != nil:
+		backup.companyID = existing.companyID
+	}
+	// A missing source document results in an invalid backup
+	// without versions and company
+	doc := backup.hashedDocument(docID)
+
+	op := &fuzzOp{
+		desc: fmt.Sprintf("RestoreDocument doc%d from doc%d %s recreate=%t", docIndex, srcIndex, company, recreate),
+		apply: func(t *testing.T, c NamedConn) error {
+			return c.Conn.RestoreDocument(h.ctx, doc, recreate)
+		},
+	}
+	switch {
+	case doc.Validate() != nil:
+		op.want = errClassOther
+	case recreate || existing == nil:
+		op.want = errClassNone
+		op.commit = func() {
+			h.model[docID] = backup
+		}
+	case existing.companyID != backup.companyID:
+		op.want = errClassOther
+	default:
+		op.want = errClassNone
+		op.commit = func() {
+			for version, v := range backup.versions {
+				if existing.versions[version] == nil {
+					existing.versions[version] = v
+				}
+			}
+		}
+	}
+	return op
+}
+
+// check compares the state of all Conns with the model.
+func (h *fuzzHarness) check(t *testing.T) {
+	t.Helper()
+
+	for docIndex, docID := range h.docIDs {
+		var want *docdb.HashedDocument
+		if doc := h.model[docID]; doc != nil {
+			want = doc.hashedDocument(docID)
+		}
+		for _, c := range h.conns {
+			exists, err := c.Conn.DocumentExists(h.ctx, docID)
+			require.NoError(t, err, "%s: doc%d DocumentExists", c.Name, docIndex)
+			require.Equal(t, want != nil, exists, "%s: doc%d DocumentExists", c.Name, docIndex)
+
+			got, err := docdb.ReadHashedDocument(h.ctx, c.Conn, docID)
+			if want == nil {
+				require.ErrorIs(t, err, errs.ErrNotFound, "%s: doc%d ReadHashedDocument", c.Name, docIndex)
+				continue
+			}
+			require.NoError(t, err, "%s: doc%d ReadHashedDocument", c.Name, docIndex)
+			require.Equal(t, want, got, "%s: doc%d ReadHashedDocument", c.Name, docIndex)
+		}
+		if want == nil {
+			continue
+		}
+		// The model does not track the VersionInfo change lists,
+		// they are only compared between the Conns.
+		// The CompanyID of versions is not compared because
+		// storeconn changes it for all versions in SetDocumentCompanyID
+		// while localfsdb and memconn keep the company of the commit.
+		for _, version := range want.VersionTimes() {
+			first, err := h.conns[0].Conn.DocumentVersionInfo(h.ctx, docID, version)
+			require.NoError(t, err, "%s: doc%d DocumentVersionInfo %s", h.conns[0].Name, docIndex, version)
+			for _, c := range h.conns[1:] {
+				info, err := c.Conn.DocumentVersionInfo(h.ctx, docID, version)
+				require.NoError(t, err, "%s: doc%d DocumentVersionInfo %s", c.Name, docIndex, version)
+				other := *info
+				other.CompanyID = first.CompanyID
+				require.True(t, first.Equal(&other), "doc%d DocumentVersionInfo %s differs:\n%s: %#v\n%s: %#v", docIndex, version, h.conns[0].Name, first, c.Name, info)
+			}
+		}
+	}
+
+	for companyIndex, companyID := range h.companyIDs {
+		var want uu.IDSlice
+		for docID, doc := range h.model {
+			if doc.companyID == companyID {
+				want = append(want, docID)
+			}
+		}
+		for _, c := range h.conns {
+			got, err := c.Conn.CompanyDocumentIDs(h.ctx, companyID)
+			require.NoError(t, err, "%s: company%d CompanyDocumentIDs", c.Name, companyIndex)
+			require.ElementsMatch(t, want, got, "%s: company%d CompanyDocumentIDs", c.Name, companyIndex)
+		}
+	}
+}
+
+func memFiles(files map[string]string) []fs.FileReader {
+	readers := make([]fs.FileReader, 0, len(files))
+	for _, filename := range slices.Sorted(maps.Keys(files)) {
+		readers = append(readers, fs.NewMemFile(filename, []byte(files[filename])))
+	}
+	return readers
+}
+
+func formatFiles(files map[string]string) string {
+	entries := make([]string, 0, len(files))
+	for _, filename := range slices.Sorted(maps.Keys(files)) {
+		entries = append(entries, fmt.Sprintf("%s=%q", filename, files[filename]))
+	}
+	return "[" + strings.Join(entries, " ") + "]"
+}
@@ -2,6 +2,7 @@ package docdb
 
 import (
 	"context"
+	"io"
 
 	"github.com/ungerik/go-fs"
 
@@ -64,6 +65,14 @@ func (c errConn) ReadDocumentVersionFile(ctx context.Context, docID uu.ID, versi
 	return nil, c.err
 }
 
+func (c errConn) OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version VersionTime, filename string) (io.ReadCloser, error) {
+	return nil, c.err
+}
+
+func (c errConn) ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version VersionTime, filename string, offset, length int64) ([]byte, error) {
+	return nil, c.err
+}
+
 func (c errConn) DeleteDocument(context.Context, uu.ID) error {
 	return c.err
 }
@@ -80,6 +89,10 @@ func (c errConn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, re
 	return c.err
 }
 
+func (c errConn) AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev VersionTime, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) error {
+	return c.err
+}
+
 func (c errConn) AddMultiDocumentVersion(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) error {
 	return c.err
 }
@@ -20,6 +20,9 @@ const (
 	// ErrReadonly is returned by the write methods of a read-only Conn,
 	// such as one created with ReadonlyConn.
 	ErrReadonly errs.Sentinel = "connection is read-only"
+	// ErrNegativeOffset is returned for a negative offset
+	// passed to Conn.ReadDocumentVersionFileRange.
+	ErrNegativeOffset errs.Sentinel = "negative file offset"
 )
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -118,6 +121,8 @@ func (e ErrDocumentAlreadyExists) Error() string {
 	return fmt.Sprintf("document %s already exists", e.docID)
 }
 
+func (e ErrDocumentAlreadyExists) DocID() uu.ID { return e.docID }
+
 ///////////////////////////////////////////////////////////////////////////////
 // ErrVersionAlreadyExists
 
@@ -138,6 +143,9 @@ func (e ErrVersionAlreadyExists) Error() string {
 	return fmt.Sprintf("document %s version %s already exists", e.docID, e.version)
 }
 
+func (e ErrVersionAlreadyExists) DocID() uu.ID         { return e.docID }
+func (e ErrVersionAlreadyExists) Version() VersionTime { return e.version }
+
 ///////////////////////////////////////////////////////////////////////////////
 // ErrPathConflict
 
@@ -214,3 +222,11 @@ func NewErrDocumentChanged(docID uu.ID, version VersionTime) ErrDocumentChanged
 func (e ErrDocumentChanged) Error() string {
 	return fmt.Sprintf("document %s has changed since version %s", e.docID, e.baseVersion)
 }
+
+func (e ErrDocumentChanged) DocID() uu.ID {
+	return e.docID
+}
+
+func (e ErrDocumentChanged) BaseVersion() VersionTime {
+	return e.baseVersion
+}
@@ -0,0 +1,185 @@
+// Package faultconn provides wrappers for docdb.Conn and the storeconn
+// stores that inject faults for chaos testing.
+//
+// An Injector holds Rules that fail the Nth call or calls with a
+// probability, optionally only for some methods or documents,
+// with an error, a panic or added latency:
+//
+//	inj := faultconn.NewInjector(faultconn.Rule{
+//		Methods: []string{"MetadataStore.CreateDocumentVersion"},
+//		Err:     faultconn.ErrInjected,
+//	})
+//	conn := storeconn.New(
+//		faultconn.NewDocumentStore(documentStore, inj),
+//		faultconn.NewMetadataStore(metadataStore, inj),
+//	)
+//
+// Calls that are not failed are forwarded unchanged to the wrapped
+// implementation. Callbacks like docdb.CreateVersionFunc
+// and docdb.OnNewVersionFunc are not wrapped.
+package faultconn
+
+import (
+	"context"
+	"io"
+
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-types/uu"
+)
+
+// New returns a docdb.Conn that wraps conn
+// and fails calls according to the rules of inj.
+// Methods are named like "Conn.CreateDocument" in rules.
+func New(conn docdb.Conn, inj *Injector) docdb.Conn {
+	return &faultConn{conn: conn, inj: inj}
+}
+
+type faultConn struct {
+	conn docdb.Conn
+	inj  *Injector
+}
+
+var _ docdb.Conn = (*faultConn)(nil)
+
+func (c *faultConn) DocumentExists(ctx context.Context, docID uu.ID) (bool, error) {
+	if err := c.inj.inject(ctx, "Conn.DocumentExists", docID); err != nil {
+		return false, err
+	}
+	return c.conn.DocumentExists(ctx, docID)
+}
+
+func (c *faultConn) CompanyIDs(ctx context.Context) (uu.IDSlice, error) {
+	if err := c.inj.inject(ctx, "Conn.CompanyIDs"); err != nil {
+		return nil, err
+	}
+	return c.conn.CompanyIDs(ctx)
+}
+
+func (c *faultConn) CompanyDocumentIDs(ctx context.Context, companyID uu.ID) (uu.IDSlice, error) {
+	if err := c.inj.inject(ctx, "Conn.CompanyDocumentIDs"); err != nil {
+		return nil, err
+	}
+	return c.conn.CompanyDocumentIDs(ctx, companyID)
+}
+
+func (c *faultConn) DocumentCompanyID(ctx context.Context, docID uu.ID) (uu.ID, error) {
+	if err := c.inj.inject(ctx, "Conn.DocumentCompanyID", docID); err != nil {
+		return uu.IDNil, err
+	}
+	return c.conn.DocumentCompanyID(ctx, docID)
+}
+
+func (c *faultConn) SetDocumentCompanyID(ctx context.Context, docID, companyID uu.ID) error {
+	if err := c.inj.inject(ctx, "Conn.SetDocumentCompanyID", docID); err != nil {
+		return err
+	}
+	return c.conn.SetDocumentCompanyID(ctx, docID, companyID)
+}
+
+func (c *faultConn) DocumentVersions(ctx context.Context, docID uu.ID) ([]docdb.VersionTime, error) {
+	if err := c.inj.inject(ctx, "Conn.DocumentVersions", docID); err != nil {
+		return nil, err
+	}
+	return c.conn.DocumentVersions(ctx, docID)
+}
+
+func (c *faultConn) LatestDocumentVersion(ctx context.Context, docID uu.ID) (docdb.VersionTime, error) {
+	if err := c.inj.inject(ctx, "Conn.LatestDocumentVersion", docID); err != nil {
+		return docdb.VersionTime{}, err
+	}
+	return c.conn.LatestDocumentVersion(ctx, docID)
+}
+
+func (c *faultConn) DocumentVersionInfo(ctx context.Context, docID uu.ID, version docdb.VersionTime) (*docdb.VersionInfo, error) {
+	if err := c.inj.inject(ctx, "Conn.DocumentVersionInfo", docID); err != nil {
+		return nil, err
+	}
+	return c.conn.DocumentVersionInfo(ctx, docID, version)
+}
+
+func (c *faultConn) LatestDocumentVersionInfo(ctx context.Context, docID uu.ID) (*docdb.VersionInfo, error) {
+	if err := c.inj.inject(ctx, "Conn.LatestDocumentVersionInfo", docID); err != nil {
+		return nil, err
+	}
+	return c.conn.LatestDocumentVersionInfo(ctx, docID)
+}
+
+func (c *faultConn) DocumentVersionFileProvider(ctx context.Context, docID uu.ID, version docdb.VersionTime) (docdb.FileProvider, error) {
+	if err := c.inj.inject(ctx, "Conn.DocumentVersionFileProvider", docID); err != nil {
+		return nil, err
+	}
+	return c.conn.DocumentVersionFileProvider(ctx, docID, version)
+}
+
+func (c *faultConn) ReadDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) ([]byte, error) {
+	if err := c.inj.inject(ctx, "Conn.ReadDocumentVersionFile", docID); err != nil {
+		return nil, err
+	}
+	return c.conn.ReadDocumentVersionFile(ctx, docID, version, filename)
+}
+
+func (c *faultConn) OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (io.ReadCloser, error) {
+	if err := c.inj.inject(ctx, "Conn.OpenDocumentVersionFile", docID); err != nil {
+		return nil, err
+	}
+	return c.conn.OpenDocumentVersionFile(ctx, docID, version, filename)
+}
+
+func (c *faultConn) ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string, offset, length int64) ([]byte, error) {
+	if err := c.inj.inject(ctx, "Conn.ReadDocumentVersionFileRange", docID); err != nil {
+		return nil, err
+	}
+	return c.conn.ReadDocumentVersionFileRange(ctx, docID, version, filename, offset, length)
+}
+
+func (c *faultConn) DeleteDocument(ctx context.Context, docID uu.ID) error {
+	if err := c.inj.inject(ctx, "Conn.DeleteDocument", docID); err != nil {
+		return err
+	}
+	return c.conn.DeleteDocument(ctx, docID)
+}
+
+func (c *faultConn) DeleteDocumentVersion(ctx context.Context, docID uu.ID, version docdb.VersionTime) ([]docdb.VersionTime, error) {
+	if err := c.inj.inject(ctx, "Conn.DeleteDocumentVersion", docID); err != nil {
+		return nil, err
+	}
+	return c.conn.DeleteDocumentVersion(ctx, docID, version)
+}
+
+func (c *faultConn) CreateDocument(ctx context.Context, companyID, docID, userID uu.ID, reason string, version docdb.VersionTime, files []fs.FileReader, onNewVersion docdb.OnNewVersionFunc) error {
+	if err := c.inj.inject(ctx, "Conn.CreateDocument", docID); err != nil {
+		return err
+	}
+	return c.conn.CreateDocument(ctx, companyID, docID, userID, reason, version, files, onNewVersion)
+}
+
+func (c *faultConn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+	if err := c.inj.inject(ctx, "Conn.AddDocumentVersion", docID); err != nil {
+		return err
+	}
+	return c.conn.AddDocumentVersion(ctx, docID, userID, reason, createVersion, onNewVersion)
+}
+
+func (c *faultConn) AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+	if err := c.inj.inject(ctx, "Conn.AddDocumentVersionIfLatest", docID); err != nil {
+		return err
+	}
+	return c.conn.AddDocumentVersionIfLatest(ctx, docID, expectedPrev, userID, reason, createVersion, onNewVersion)
+}
+
+// AddMultiDocumentVersion matches rules for any of docIDs.
+func (c *faultConn) AddMultiDocumentVersion(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+	if err := c.inj.inject(ctx, "Conn.AddMultiDocumentVersion", docIDs...); err != nil {
+		return err
+	}
+	return c.conn.AddMultiDocumentVersion(ctx, docIDs, userID, reason, createVersion, onNewVersion)
+}
+
+func (c *faultConn) RestoreDocument(ctx context.Context, doc *docdb.HashedDocument, recreate bool) error {
+	if err := c.inj.inject(ctx, "Conn.RestoreDocument", doc.ID); err != nil {
+		return err
+	}
+	return c.conn.RestoreDocument(ctx, doc, recreate)
+}
@@ -0,0 +1,187 @@
+package faultconn_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/docdbtest"
+	"github.com/domonda/go-docdb/faultconn"
+	"github.com/domonda/go-docdb/memconn"
+	"github.com/domonda/go-docdb/storeconn"
+	"github.com/domonda/go-types/uu"
+)
+
+func TestNth(t *testing.T) {
+	ctx := t.Context()
+	inj := faultconn.NewInjector(faultconn.Rule{
+		Methods: []string{"Conn.CreateDocument"},
+		Nth:     2,
+		Err:     faultconn.ErrInjected,
+	})
+	conn := faultconn.New(memconn.New(), inj)
+
+	require.NoError(t, docdbtest.CreateDocument(ctx, conn, uu.IDv7()))
+	// Other methods don't count as matching calls
+	_, err := conn.CompanyIDs(ctx)
+	require.NoError(t, err)
+
+	failedDocID := uu.IDv7()
+	require.ErrorIs(t, docdbtest.CreateDocument(ctx, conn, failedDocID), faultconn.ErrInjected)
+	exists, err := conn.DocumentExists(ctx, failedDocID)
+	require.NoError(t, err)
+	require.False(t, exists, "failed call must not be forwarded")
+
+	require.NoError(t, docdbtest.CreateDocument(ctx, conn, uu.IDv7()))
+	require.Equal(t, 3, inj.Calls("Conn.CreateDocument"))
+}
+
+func TestDocIDs(t *testing.T) {
+	ctx := t.Context()
+	failingDocID := uu.IDv7()
+	inj := faultconn.NewInjector(faultconn.Rule{
+		DocIDs: []uu.ID{failingDocID},
+		Err:    docdb.NewErrDocumentNotFound(failingDocID),
+	})
+	conn := faultconn.New(memconn.New(), inj)
+
+	require.ErrorAs(t, docdbtest.CreateDocument(ctx, conn, failingDocID), new(docdb.ErrDocumentNotFound))
+	_, err := conn.DocumentVersions(ctx, failingDocID)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound))
+	// Calls without document ID don't match
+	_, err = conn.CompanyIDs(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, docdbtest.CreateDocument(ctx, conn, uu.IDv7()))
+	err = conn.AddMultiDocumentVersion(ctx, uu.IDSlice{uu.IDv7(), failingDocID}, uu.IDv7(), "add",
+		docdb.CreateVersionWriteFiles(fs.NewMemFile("a.txt", []byte("a"))),
+		docdbtest.NoopOnNewVersion,
+	)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound))
+}
+
+func TestProbability(t *testing.T) {
+	ctx := t.Context()
+	countFailures := func(seed uint64) (failures int) {
+		inj := faultconn.NewInjector(faultconn.Rule{Probability: 0.5, Err: faultconn.ErrInjected})
+		inj.SetSeed(seed)
+		conn := faultconn.New(memconn.New(), inj)
+		for range 100 {
+			if _, err := conn.CompanyIDs(ctx); err != nil {
+				failures++
+			}
+		}
+		return failures
+	}
+
+	failures := countFailures(1)
+	require.Greater(t, failures, 0)
+	require.Less(t, failures, 100)
+	require.Equal(t, failures, countFailures(1), "same seed must fail the same calls")
+}
+
+func TestLatency(t *testing.T) {
+	t.Run("delays forwarded call", func(t *testing.T) {
+		inj := faultconn.NewInjector(faultconn.Rule{Latency: 20 * time.Millisecond})
+		conn := faultconn.New(memconn.New(), inj)
+
+		start := time.Now()
+		_, err := conn.CompanyIDs(t.Context())
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	})
+
+	t.Run("returns context error", func(t *testing.T) {
+		inj := faultconn.NewInjector(faultconn.Rule{Latency: time.Hour})
+		conn := faultconn.New(memconn.New(), inj)
+
+		ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+		defer cancel()
+		_, err := conn.CompanyIDs(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestPanic(t *testing.T) {
+	inj := faultconn.NewInjector(faultconn.Rule{
+		Methods: []string{"Conn.DeleteDocument"},
+		Panic:   "injected panic",
+	})
+	conn := faultconn.New(memconn.New(), inj)
+
+	require.PanicsWithValue(t, "injected panic", func() {
+		_ = conn.DeleteDocument(t.Context(), uu.IDv7())
+	})
+
+	inj.Reset()
+	require.ErrorAs(t, conn.DeleteDocument(t.Context(), uu.IDv7()), new(docdb.ErrDocumentNotFound))
+}
+
+// blobDocumentStore is a storeconn.DocumentStore for
+// a single document that records deleted documents.
+type blobDocumentStore struct {
+	storeconn.DocumentStore
+
+	exists  bool
+	deleted []uu.ID
+}
+
+func (s *blobDocumentStore) DocumentExists(context.Context, uu.ID) (bool, error) {
+	return s.exists, nil
+}
+
+func (s *blobDocumentStore) CreateDocumentVersion(ctx context.Context, _ uu.ID, _ docdb.VersionTime, files []fs.FileReader) ([]*docdb.FileInfo, error) {
+	s.exists = true
+	infos := make([]*docdb.FileInfo, len(files))
+	for i, file := range files {
+		info, err := docdb.ReadFileInfo(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		infos[i] = &info
+	}
+	return infos, nil
+}
+
+func (s *blobDocumentStore) DeleteDocument(_ context.Context, docID uu.ID) error {
+	s.exists = false
+	s.deleted = append(s.deleted, docID)
+	return nil
+}
+
+func TestMetadataStore_CreateDocumentVersionAfterBlobUpload(t *testing.T) {
+	var (
+		documentStore = &blobDocumentStore{}
+		inj           = faultconn.NewInjector(faultconn.Rule{
+			Methods: []string{"MetadataStore.CreateDocumentVersion"},
+			Err:     faultconn.ErrInjected,
+		})
+		// The rule fails every call of the only method used
+		// of the otherwise unimplemented MetadataStore
+		conn  = storeconn.New(documentStore, faultconn.NewMetadataStore(struct{ storeconn.MetadataStore }{}, inj))
+		docID = uu.IDv7()
+	)
+
+	err := docdbtest.CreateDocument(t.Context(), conn, docID)
+	require.ErrorIs(t, err, faultconn.ErrInjected)
+	require.Equal(t, []uu.ID{docID}, documentStore.deleted, "uploaded blobs must be deleted")
+	require.False(t, documentStore.exists)
+}
+
+func TestDocumentStore(t *testing.T) {
+	inj := faultconn.NewInjector(faultconn.Rule{
+		Methods: []string{"DocumentStore.DocumentExists"},
+		Err:     errors.New("connection reset"),
+	})
+	store := faultconn.NewDocumentStore(&blobDocumentStore{}, inj)
+
+	_, err := store.DocumentExists(t.Context(), uu.IDv7())
+	require.EqualError(t, err, "connection reset")
+	require.NoError(t, store.DeleteDocument(t.Context(), uu.IDv7()))
+	require.Equal(t, 1, inj.Calls("DocumentStore.DeleteDocument"))
+}
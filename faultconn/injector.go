@@ -0,0 +1,178 @@
+package faultconn
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/domonda/go-types/uu"
+)
+
+// ErrInjected can be used as Rule.Err for a generic injected failure.
+var ErrInjected = errors.New("injected fault")
+
+// Rule describes which calls an Injector fails and how.
+//
+// A call matches the rule if its method is one of Methods
+// and one of its document IDs is one of DocIDs.
+// Of the matching calls only the Nth fails if Nth is greater than zero,
+// and a call only fails with Probability if it is greater than zero.
+// Without Nth and Probability every matching call fails.
+//
+// A failing call is first delayed by Latency, then panics with Panic
+// if it is not nil, else returns Err. If Err and Panic are nil
+// the call is only delayed and then forwarded.
+type Rule struct {
+	// Methods the rule applies to, formatted as interface and method name
+	// like "Conn.CreateDocument", "DocumentStore.CreateDocumentVersion",
+	// "MetadataStore.CreateDocumentVersion" or "BlobStore.WriteBlob".
+	// Empty matches all methods.
+	Methods []string
+	// DocIDs the rule applies to. Empty matches all calls,
+	// else calls without a document ID never match.
+	DocIDs []uu.ID
+	// Nth is the 1-based number of the matching call that fails.
+	// Zero fails every matching call.
+	Nth int
+	// Probability is the probability between 0 and 1 that a matching call fails.
+	// Zero fails every matching call.
+	Probability float64
+	// Latency delays a failing call.
+	// The delay ends early with the context error if the context is done.
+	Latency time.Duration
+	// Err is returned by a failing call.
+	Err error
+	// Panic is used to panic in a failing call if not nil.
+	Panic any
+}
+
+func (r *Rule) matches(method string, docIDs []uu.ID) bool {
+	if len(r.Methods) > 0 && !slices.Contains(r.Methods, method) {
+		return false
+	}
+	if len(r.DocIDs) > 0 && !slices.ContainsFunc(docIDs, func(id uu.ID) bool { return slices.Contains(r.DocIDs, id) }) {
+		return false
+	}
+	return true
+}
+
+// Injector decides which calls of the wrappers
+// of this package fail according to its rules.
+//
+// One Injector can be shared by several wrappers, for example
+// by the DocumentStore and MetadataStore of a storeconn.Conn.
+// It is safe for concurrent use.
+type Injector struct {
+	mtx   sync.Mutex
+	rules []*ruleState
+	rand  *rand.Rand
+	calls map[string]int
+}
+
+type ruleState struct {
+	Rule
+	matched int
+}
+
+// NewInjector returns an Injector with the passed rules.
+// Probabilities use a random seed, see SetSeed.
+func NewInjector(rules ...Rule) *Injector {
+	inj := &Injector{
+		rand:  rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		calls: make(map[string]int),
+	}
+	for _, rule := range rules {
+		inj.AddRule(rule)
+	}
+	return inj
+}
+
+// SetSeed seeds the random number generator
+// used for Rule.Probability to make failures reproducible.
+func (inj *Injector) SetSeed(seed uint64) {
+	inj.mtx.Lock()
+	defer inj.mtx.Unlock()
+
+	inj.rand = rand.New(rand.NewPCG(seed, seed))
+}
+
+// AddRule adds a rule. Rules are checked in the order
+// they were added and the first failing rule is applied.
+func (inj *Injector) AddRule(rule Rule) {
+	inj.mtx.Lock()
+	defer inj.mtx.Unlock()
+
+	inj.rules = append(inj.rules, &ruleState{Rule: rule})
+}
+
+// Reset removes all rules and call counts.
+func (inj *Injector) Reset() {
+	inj.mtx.Lock()
+	defer inj.mtx.Unlock()
+
+	inj.rules = nil
+	clear(inj.calls)
+}
+
+// Calls returns the number of calls of method,
+// formatted like Rule.Methods, including failed calls.
+func (inj *Injector) Calls(method string) int {
+	inj.mtx.Lock()
+	defer inj.mtx.Unlock()
+
+	return inj.calls[method]
+}
+
+// failingRule counts the call and returns
+// the first rule that fails it or nil.
+func (inj *Injector) failingRule(method string, docIDs []uu.ID) *Rule {
+	inj.mtx.Lock()
+	defer inj.mtx.Unlock()
+
+	inj.calls[method]++
+	var failing *Rule
+	for _, rule := range inj.rules {
+		if !rule.matches(method, docIDs) {
+			continue
+		}
+		// Every matching rule counts the call,
+		// independent of an earlier rule failing it
+		rule.matched++
+		if failing != nil {
+			continue
+		}
+		if rule.Nth > 0 && rule.matched != rule.Nth {
+			continue
+		}
+		if rule.Probability > 0 && inj.rand.Float64() >= rule.Probability {
+			continue
+		}
+		failing = &rule.Rule
+	}
+	return failing
+}
+
+// inject is called by the wrappers before forwarding a call.
+// It returns an error or panics if a rule fails the call.
+func (inj *Injector) inject(ctx context.Context, method string, docIDs ...uu.ID) error {
+	rule := inj.failingRule(method, docIDs)
+	if rule == nil {
+		return nil
+	}
+	if rule.Latency > 0 {
+		timer := time.NewTimer(rule.Latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if rule.Panic != nil {
+		panic(rule.Panic)
+	}
+	return rule.Err
+}
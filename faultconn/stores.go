@@ -0,0 +1,251 @@
+package faultconn
+
+import (
+	"context"
+	"io"
+
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/storeconn"
+	"github.com/domonda/go-types/uu"
+)
+
+// NewDocumentStore returns a storeconn.DocumentStore that wraps store
+// and fails calls according to the rules of inj.
+// Methods are named like "DocumentStore.CreateDocumentVersion" in rules.
+func NewDocumentStore(store storeconn.DocumentStore, inj *Injector) storeconn.DocumentStore {
+	return &faultDocumentStore{store: store, inj: inj}
+}
+
+type faultDocumentStore struct {
+	store storeconn.DocumentStore
+	inj   *Injector
+}
+
+var _ storeconn.DocumentStore = (*faultDocumentStore)(nil)
+
+func (s *faultDocumentStore) CreateDocumentVersion(ctx context.Context, docID uu.ID, version docdb.VersionTime, files []fs.FileReader) ([]*docdb.FileInfo, error) {
+	if err := s.inj.inject(ctx, "DocumentStore.CreateDocumentVersion", docID); err != nil {
+		return nil, err
+	}
+	return s.store.CreateDocumentVersion(ctx, docID, version, files)
+}
+
+func (s *faultDocumentStore) DocumentExists(ctx context.Context, docID uu.ID) (bool, error) {
+	if err := s.inj.inject(ctx, "DocumentStore.DocumentExists", docID); err != nil {
+		return false, err
+	}
+	return s.store.DocumentExists(ctx, docID)
+}
+
+func (s *faultDocumentStore) DocumentHashFileProvider(ctx context.Context, docID uu.ID, fileHashes []string) (docdb.FileProvider, error) {
+	if err := s.inj.inject(ctx, "DocumentStore.DocumentHashFileProvider", docID); err != nil {
+		return nil, err
+	}
+	return s.store.DocumentHashFileProvider(ctx, docID, fileHashes)
+}
+
+func (s *faultDocumentStore) ReadDocumentHashFile(ctx context.Context, docID uu.ID, filename, hash string) ([]byte, error) {
+	if err := s.inj.inject(ctx, "DocumentStore.ReadDocumentHashFile", docID); err != nil {
+		return nil, err
+	}
+	return s.store.ReadDocumentHashFile(ctx, docID, filename, hash)
+}
+
+func (s *faultDocumentStore) OpenDocumentHashFile(ctx context.Context, docID uu.ID, filename, hash string) (io.ReadCloser, error) {
+	if err := s.inj.inject(ctx, "DocumentStore.OpenDocumentHashFile", docID); err != nil {
+		return nil, err
+	}
+	return s.store.OpenDocumentHashFile(ctx, docID, filename, hash)
+}
+
+func (s *faultDocumentStore) ReadDocumentHashFileRange(ctx context.Context, docID uu.ID, filename, hash string, offset, length int64) ([]byte, error) {
+	if err := s.inj.inject(ctx, "DocumentStore.ReadDocumentHashFileRange", docID); err != nil {
+		return nil, err
+	}
+	return s.store.ReadDocumentHashFileRange(ctx, docID, filename, hash, offset, length)
+}
+
+func (s *faultDocumentStore) DeleteDocument(ctx context.Context, docID uu.ID) error {
+	if err := s.inj.inject(ctx, "DocumentStore.DeleteDocument", docID); err != nil {
+		return err
+	}
+	return s.store.DeleteDocument(ctx, docID)
+}
+
+func (s *faultDocumentStore) DeleteDocumentHashes(ctx context.Context, docID uu.ID, hashes []string) error {
+	if err := s.inj.inject(ctx, "DocumentStore.DeleteDocumentHashes", docID); err != nil {
+		return err
+	}
+	return s.store.DeleteDocumentHashes(ctx, docID, hashes)
+}
+
+// NewMetadataStore returns a storeconn.MetadataStore that wraps store
+// and fails calls according to the rules of inj.
+// Methods are named like "MetadataStore.CreateDocumentVersion" in rules.
+func NewMetadataStore(store storeconn.MetadataStore, inj *Injector) storeconn.MetadataStore {
+	return &faultMetadataStore{store: store, inj: inj}
+}
+
+type faultMetadataStore struct {
+	store storeconn.MetadataStore
+	inj   *Injector
+}
+
+var _ storeconn.MetadataStore = (*faultMetadataStore)(nil)
+
+func (s *faultMetadataStore) CreateDocumentVersion(ctx context.Context, in storeconn.CreateDocumentVersionInput) (*docdb.VersionInfo, error) {
+	if err := s.inj.inject(ctx, "MetadataStore.CreateDocumentVersion", in.DocID); err != nil {
+		return nil, err
+	}
+	return s.store.CreateDocumentVersion(ctx, in)
+}
+
+func (s *faultMetadataStore) DocumentCompanyID(ctx context.Context, docID uu.ID) (uu.ID, error) {
+	if err := s.inj.inject(ctx, "MetadataStore.DocumentCompanyID", docID); err != nil {
+		return uu.IDNil, err
+	}
+	return s.store.DocumentCompanyID(ctx, docID)
+}
+
+func (s *faultMetadataStore) SetDocumentCompanyID(ctx context.Context, docID, companyID uu.ID) error {
+	if err := s.inj.inject(ctx, "MetadataStore.SetDocumentCompanyID", docID); err != nil {
+		return err
+	}
+	return s.store.SetDocumentCompanyID(ctx, docID, companyID)
+}
+
+func (s *faultMetadataStore) DocumentVersions(ctx context.Context, docID uu.ID) ([]docdb.VersionTime, error) {
+	if err := s.inj.inject(ctx, "MetadataStore.DocumentVersions", docID); err != nil {
+		return nil, err
+	}
+	return s.store.DocumentVersions(ctx, docID)
+}
+
+func (s *faultMetadataStore) LatestDocumentVersion(ctx context.Context, docID uu.ID) (docdb.VersionTime, error) {
+	if err := s.inj.inject(ctx, "MetadataStore.LatestDocumentVersion", docID); err != nil {
+		return docdb.VersionTime{}, err
+	}
+	return s.store.LatestDocumentVersion(ctx, docID)
+}
+
+func (s *faultMetadataStore) CompanyIDs(ctx context.Context) (uu.IDSlice, error) {
+	if err := s.inj.inject(ctx, "MetadataStore.CompanyIDs"); err != nil {
+		return nil, err
+	}
+	return s.store.CompanyIDs(ctx)
+}
+
+func (s *faultMetadataStore) CompanyDocumentIDs(ctx context.Context, companyID uu.ID) (uu.IDSlice, error) {
+	if err := s.inj.inject(ctx, "MetadataStore.CompanyDocumentIDs"); err != nil {
+		return nil, err
+	}
+	return s.store.CompanyDocumentIDs(ctx, companyID)
+}
+
+func (s *faultMetadataStore) DocumentVersionInfo(ctx context.Context, docID uu.ID, version docdb.VersionTime) (*docdb.VersionInfo, error) {
+	if err := s.inj.inject(ctx, "MetadataStore.DocumentVersionInfo", docID); err != nil {
+		return nil, err
+	}
+	return s.store.DocumentVersionInfo(ctx, docID, version)
+}
+
+func (s *faultMetadataStore) LatestDocumentVersionInfo(ctx context.Context, docID uu.ID) (*docdb.VersionInfo, error) {
+	if err := s.inj.inject(ctx, "MetadataStore.LatestDocumentVersionInfo", docID); err != nil {
+		return nil, err
+	}
+	return s.store.LatestDocumentVersionInfo(ctx, docID)
+}
+
+func (s *faultMetadataStore) IdempotentVersionInfo(ctx context.Context, docID uu.ID, key string) (*docdb.VersionInfo, error) {
+	if err := s.inj.inject(ctx, "MetadataStore.IdempotentVersionInfo", docID); err != nil {
+		return nil, err
+	}
+	return s.store.IdempotentVersionInfo(ctx, docID, key)
+}
+
+func (s *faultMetadataStore) DocumentHashes(ctx context.Context, docID uu.ID) ([]string, error) {
+	if err := s.inj.inject(ctx, "MetadataStore.DocumentHashes", docID); err != nil {
+		return nil, err
+	}
+	return s.store.DocumentHashes(ctx, docID)
+}
+
+func (s *faultMetadataStore) DeleteUnreferencedHashes(ctx context.Context, hashes []string, deleteContent func(ctx context.Context, hashes []string) error) error {
+	if err := s.inj.inject(ctx, "MetadataStore.DeleteUnreferencedHashes"); err != nil {
+		return err
+	}
+	return s.store.DeleteUnreferencedHashes(ctx, hashes, deleteContent)
+}
+
+func (s *faultMetadataStore) UnreferencedHashes(ctx context.Context, hashes []string) ([]string, error) {
+	if err := s.inj.inject(ctx, "MetadataStore.UnreferencedHashes"); err != nil {
+		return nil, err
+	}
+	return s.store.UnreferencedHashes(ctx, hashes)
+}
+
+func (s *faultMetadataStore) DeleteDocument(ctx context.Context, docID uu.ID) error {
+	if err := s.inj.inject(ctx, "MetadataStore.DeleteDocument", docID); err != nil {
+		return err
+	}
+	return s.store.DeleteDocument(ctx, docID)
+}
+
+func (s *faultMetadataStore) DeleteDocumentVersion(ctx context.Context, docID uu.ID, version docdb.VersionTime) ([]docdb.VersionTime, []string, error) {
+	if err := s.inj.inject(ctx, "MetadataStore.DeleteDocumentVersion", docID); err != nil {
+		return nil, nil, err
+	}
+	return s.store.DeleteDocumentVersion(ctx, docID, version)
+}
+
+// NewBlobStore returns a storeconn.BlobStore that wraps store
+// and fails calls according to the rules of inj.
+// Methods are named like "BlobStore.WriteBlob" in rules.
+// Blob calls have no document ID, so rules with DocIDs never match them.
+func NewBlobStore(store storeconn.BlobStore, inj *Injector) storeconn.BlobStore {
+	return &faultBlobStore{store: store, inj: inj}
+}
+
+type faultBlobStore struct {
+	store storeconn.BlobStore
+	inj   *Injector
+}
+
+var _ storeconn.BlobStore = (*faultBlobStore)(nil)
+
+func (s *faultBlobStore) WriteBlob(ctx context.Context, hash string, file fs.FileReader) error {
+	if err := s.inj.inject(ctx, "BlobStore.WriteBlob"); err != nil {
+		return err
+	}
+	return s.store.WriteBlob(ctx, hash, file)
+}
+
+func (s *faultBlobStore) OpenBlob(ctx context.Context, hash string) (io.ReadCloser, error) {
+	if err := s.inj.inject(ctx, "BlobStore.OpenBlob"); err != nil {
+		return nil, err
+	}
+	return s.store.OpenBlob(ctx, hash)
+}
+
+func (s *faultBlobStore) ReadBlobRange(ctx context.Context, hash string, offset, length int64) ([]byte, error) {
+	if err := s.inj.inject(ctx, "BlobStore.ReadBlobRange"); err != nil {
+		return nil, err
+	}
+	return s.store.ReadBlobRange(ctx, hash, offset, length)
+}
+
+func (s *faultBlobStore) ListBlobs(ctx context.Context, onHashes func(ctx context.Context, hashes []string) error) error {
+	if err := s.inj.inject(ctx, "BlobStore.ListBlobs"); err != nil {
+		return err
+	}
+	return s.store.ListBlobs(ctx, onHashes)
+}
+
+func (s *faultBlobStore) DeleteBlobs(ctx context.Context, hashes []string) error {
+	if err := s.inj.inject(ctx, "BlobStore.DeleteBlobs"); err != nil {
+		return err
+	}
+	return s.store.DeleteBlobs(ctx, hashes)
+}
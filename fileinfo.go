@@ -3,6 +3,10 @@ package docdb
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
 
 	"github.com/ungerik/go-fs"
 	"github.com/ungerik/go-fs/fsimpl"
@@ -30,19 +34,105 @@ func ContentHash(data []byte) string {
 	return hash
 }
 
-// ReadFileInfo reads the file content from file and returns a FileInfo with the file name, size and hash.
+// ReadFileInfo streams the file content from file and returns a FileInfo
+// with the file name, size and hash without loading the whole file into memory.
 func ReadFileInfo(ctx context.Context, file fs.FileReader) (info FileInfo, err error) {
 	defer errs.WrapWithFuncParams(&err, ctx, file)
 
-	data, err := file.ReadAllContext(ctx)
+	reader, err := file.OpenReader()
 	if err != nil {
 		return FileInfo{}, err
 	}
+	defer reader.Close()
+
 	info.Name = file.Name()
-	info.Size = int64(len(data))
-	info.Hash, err = fsimpl.DropboxContentHash(ctx, bytes.NewReader(data))
+	info.Hash, info.Size, err = ReadContentHash(ctx, reader)
 	if err != nil {
 		return FileInfo{}, err
 	}
 	return info, nil
 }
+
+// ReadContentHash reads reader until io.EOF and returns the Dropbox compatible
+// content hash and the size of the read data.
+// Memory use is bounded independent of the data size.
+func ReadContentHash(ctx context.Context, reader io.Reader) (hash string, size int64, err error) {
+	hasher := NewContentHasher()
+	buf := make([]byte, 64*1024)
+	for {
+		if err = ctx.Err(); err != nil {
+			return "", 0, err
+		}
+		n, err := reader.Read(buf)
+		hasher.Write(buf[:n]) //#nosec G104 -- never returns an error
+		if err == io.EOF {
+			return hasher.Sum(), hasher.Size(), nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+	}
+}
+
+// contentHashBlockSize is the block size of the Dropbox content hash.
+const contentHashBlockSize = 4 * 1024 * 1024
+
+// ContentHasher is an io.Writer that calculates the Dropbox compatible
+// content hash of all data written to it, see ContentHash.
+//
+// In contrast to fsimpl.DropboxContentHash it does not rely on every Read
+// call filling a complete block, so it can be fed from any stream
+// in chunks of arbitrary size.
+type ContentHasher struct {
+	block     hash.Hash // SHA-256 of the current block
+	blockSize int       // Bytes written to the current block
+	result    hash.Hash // SHA-256 of the concatenated block hashes
+	size      int64
+}
+
+// NewContentHasher returns a new ContentHasher.
+func NewContentHasher() *ContentHasher {
+	return &ContentHasher{
+		block:  sha256.New(),
+		result: sha256.New(),
+	}
+}
+
+// Write implements io.Writer and never returns an error.
+func (h *ContentHasher) Write(p []byte) (n int, err error) {
+	n = len(p)
+	h.size += int64(n)
+	for len(p) > 0 {
+		chunk := min(len(p), contentHashBlockSize-h.blockSize)
+		h.block.Write(p[:chunk])
+		h.blockSize += chunk
+		p = p[chunk:]
+		if h.blockSize == contentHashBlockSize {
+			h.result.Write(h.block.Sum(nil))
+			h.block.Reset()
+			h.blockSize = 0
+		}
+	}
+	return n, nil
+}
+
+// Size returns the number of bytes written to the hasher.
+func (h *ContentHasher) Size() int64 {
+	return h.size
+}
+
+// Sum returns the 64 hex character content hash of all data written so far.
+// It does not change the state of the hasher.
+func (h *ContentHasher) Sum() string {
+	if h.blockSize == 0 {
+		return hex.EncodeToString(h.result.Sum(nil))
+	}
+	// Add the hash of the incomplete last block to a clone
+	// to keep the hasher usable for further writes
+	clone, err := h.result.(hash.Cloner).Clone()
+	if err != nil {
+		panic(errs.Errorf("should never happen: %w", err))
+	}
+	clone.Write(h.block.Sum(nil))
+	return hex.EncodeToString(clone.Sum(nil))
+}
@@ -0,0 +1,71 @@
+package docdb
+
+import (
+	"bytes"
+	"context"
+	"math/rand/v2"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+)
+
+func TestContentHasher(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for _, size := range []int{
+		0,
+		1,
+		contentHashBlockSize - 1,
+		contentHashBlockSize,
+		contentHashBlockSize + 1,
+		2*contentHashBlockSize + 12345,
+	} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(rnd.Uint32())
+		}
+		want := ContentHash(data)
+
+		hasher := NewContentHasher()
+		// Write in chunks that don't align with the hash block size
+		for p := data; len(p) > 0; {
+			chunk := min(len(p), 1+rnd.IntN(1024*1024))
+			n, err := hasher.Write(p[:chunk])
+			require.NoError(t, err)
+			require.Equal(t, chunk, n)
+			p = p[chunk:]
+		}
+		assert.Equal(t, want, hasher.Sum(), "size %d", size)
+		assert.Equal(t, want, hasher.Sum(), "Sum must not change the hasher state")
+		assert.Equal(t, int64(size), hasher.Size())
+	}
+}
+
+func TestReadContentHash(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), contentHashBlockSize/10+1)
+
+	// fsimpl.DropboxContentHash would return a wrong hash
+	// for a reader that returns fewer bytes than a block per Read call
+	hash, size, err := ReadContentHash(context.Background(), iotest.HalfReader(bytes.NewReader(data)))
+	require.NoError(t, err)
+	assert.Equal(t, ContentHash(data), hash)
+	assert.Equal(t, int64(len(data)), size)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = ReadContentHash(ctx, bytes.NewReader(data))
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, _, err = ReadContentHash(context.Background(), iotest.ErrReader(iotest.ErrTimeout))
+	assert.ErrorIs(t, err, iotest.ErrTimeout)
+}
+
+func TestReadFileInfo(t *testing.T) {
+	file := fs.NewMemFile("test.txt", []byte("hello world"))
+
+	info, err := ReadFileInfo(context.Background(), file)
+	require.NoError(t, err)
+	assert.Equal(t, FileInfo{Name: "test.txt", Size: 11, Hash: ContentHash([]byte("hello world"))}, info)
+}
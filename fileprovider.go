@@ -1,7 +1,10 @@
 package docdb
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
 	"path"
 	"slices"
 
@@ -13,6 +16,11 @@ type FileProvider interface {
 	HasFile(filename string) (bool, error)
 	ListFiles(ctx context.Context) (filenames []string, err error)
 	ReadFile(ctx context.Context, filename string) ([]byte, error)
+
+	// OpenFile opens a file for streaming reads without loading
+	// its whole content into memory.
+	// The caller must close the returned io.ReadCloser.
+	OpenFile(ctx context.Context, filename string) (io.ReadCloser, error)
 }
 
 func NewFileProvider(files ...fs.FileReader) FileProvider {
@@ -45,6 +53,18 @@ func (p fileReaderProvider) ReadFile(ctx context.Context, filename string) ([]by
 	return nil, fs.NewErrPathDoesNotExist(filename)
 }
 
+func (p fileReaderProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, f := range p {
+		if f.Name() == filename {
+			return f.OpenReader()
+		}
+	}
+	return nil, fs.NewErrPathDoesNotExist(filename)
+}
+
 // ReadMemFile reads a file from a FileProvider and returns it as an fs.MemFile.
 func ReadMemFile(ctx context.Context, provider FileProvider, filename string) (fs.MemFile, error) {
 	data, err := provider.ReadFile(ctx, filename)
@@ -54,15 +74,22 @@ func ReadMemFile(ctx context.Context, provider FileProvider, filename string) (f
 	return fs.NewMemFile(filename, data), nil
 }
 
-// TempFileCopy reads a file from a FileProvider and writes it to a temporary file
+// TempFileCopy streams a file from a FileProvider to a temporary file
 // with a random basename and the same extension as the original filename.
 func TempFileCopy(ctx context.Context, provider FileProvider, filename string) (fs.File, error) {
-	data, err := provider.ReadFile(ctx, filename)
+	reader, err := provider.OpenFile(ctx, filename)
 	if err != nil {
 		return fs.InvalidFile, err
 	}
+	defer reader.Close()
+
 	f := fs.TempFile(path.Ext(filename))
-	return f, f.WriteAllContext(ctx, data)
+	writer, err := f.OpenWriter()
+	if err != nil {
+		return fs.InvalidFile, err
+	}
+	_, err = io.Copy(writer, reader)
+	return f, errors.Join(err, writer.Close())
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -97,6 +124,13 @@ func (p dirFileProvider) ReadFile(ctx context.Context, filename string) ([]byte,
 	return p.dir.Join(filename).ReadAllContext(ctx)
 }
 
+func (p dirFileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return p.dir.Join(filename).OpenReader()
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 // ExtFileProvider
 
@@ -151,6 +185,21 @@ func (p extFileProvider) ReadFile(ctx context.Context, filename string) ([]byte,
 	return p.base.ReadFile(ctx, filename)
 }
 
+func (p extFileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	for _, f := range p.extFiles {
+		if f.Name() == filename {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return f.OpenReader()
+		}
+	}
+	if p.base == nil {
+		return nil, fs.NewErrPathDoesNotExist(filename)
+	}
+	return p.base.OpenFile(ctx, filename)
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 // RemoveFileProvider
 
@@ -190,12 +239,20 @@ func (p removeFileProvider) ReadFile(ctx context.Context, filename string) ([]by
 	return p.base.ReadFile(ctx, filename)
 }
 
+func (p removeFileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	if slices.Contains(p.remove, filename) {
+		return nil, fs.NewErrPathDoesNotExist(filename)
+	}
+	return p.base.OpenFile(ctx, filename)
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 
 type MockFileProvider struct {
 	HasFileMock   func(filename string) (bool, error)
 	ListFilesMock func(ctx context.Context) (filenames []string, err error)
 	ReadFileMock  func(ctx context.Context, filename string) ([]byte, error)
+	OpenFileMock  func(ctx context.Context, filename string) (io.ReadCloser, error)
 }
 
 func (fp *MockFileProvider) HasFile(filename string) (bool, error) {
@@ -210,6 +267,10 @@ func (fp *MockFileProvider) ReadFile(ctx context.Context, filename string) ([]by
 	return fp.ReadFileMock(ctx, filename)
 }
 
+func (fp *MockFileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return fp.OpenFileMock(ctx, filename)
+}
+
 var _ FileProvider = &MockFileProvider{}
 
 var _ FileProvider = memFileProvider{}
@@ -237,3 +298,10 @@ func (mem memFileProvider) ReadFile(ctx context.Context, filename string) ([]byt
 	}
 	return mem.file.FileData, nil
 }
+
+func (mem memFileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	if filename != mem.file.FileName {
+		return nil, fs.NewErrPathDoesNotExist(filename)
+	}
+	return io.NopCloser(bytes.NewReader(mem.file.FileData)), nil
+}
@@ -3,6 +3,7 @@ package docdb
 import (
 	"context"
 	"errors"
+	"io"
 	"os"
 	"testing"
 
@@ -190,6 +191,30 @@ func TestFileReaderProvider_ReadFile(t *testing.T) {
 	}
 }
 
+func TestFileReaderProvider_OpenFile(t *testing.T) {
+	provider := NewFileProvider(
+		fs.NewMemFile("first.txt", []byte("first content")),
+		fs.NewMemFile("second.txt", []byte("second content")),
+	)
+	ctx := context.Background()
+
+	reader, err := provider.OpenFile(ctx, "second.txt")
+	require.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, []byte("second content"), content)
+
+	_, err = provider.OpenFile(ctx, "missing.txt")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = provider.OpenFile(cancelledCtx, "first.txt")
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
 func TestFileReaderProvider_ContextCancellation(t *testing.T) {
 	provider := NewFileProvider(
 		fs.NewMemFile("test.txt", []byte("content")),
@@ -900,6 +925,29 @@ func TestExtFileProvider_ReadFile_NilBase(t *testing.T) {
 	assert.True(t, errors.Is(err, os.ErrNotExist))
 }
 
+func TestExtFileProvider_OpenFile(t *testing.T) {
+	baseProvider := NewFileProvider(
+		fs.NewMemFile("base.txt", []byte("base content")),
+		fs.NewMemFile("override.txt", []byte("original content")),
+	)
+	provider := RemoveFileProvider(
+		ExtFileProvider(baseProvider, fs.NewMemFile("override.txt", []byte("overridden content"))),
+		"base.txt",
+	)
+	ctx := context.Background()
+
+	reader, err := provider.OpenFile(ctx, "override.txt")
+	require.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	assert.Equal(t, []byte("overridden content"), content)
+
+	_, err = provider.OpenFile(ctx, "base.txt")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
 func TestExtFileProvider_Integration(t *testing.T) {
 	// Create a base provider with some files
 	dir := fs.TempDir().Join("TestExtFileProvider_Integration")
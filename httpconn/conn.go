@@ -0,0 +1,607 @@
+package httpconn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+)
+
+// Compiler check if *Conn implements docdb.Conn
+var _ docdb.Conn = new(Conn)
+
+// Conn is a docdb.Conn calling the REST API of a Handler.
+//
+// Errors of the docdb error types are returned as such,
+// so for example errs.Has[docdb.ErrDocumentNotFound] works
+// as with a local Conn.
+//
+// The callbacks of the writes are called on the client:
+// AddDocumentVersion reads the latest version, calls createVersion
+// with a FileProvider reading the files of that version from the server
+// and submits the result with the latest version as "expectedPrev".
+// If another version was added in the meantime, this is repeated
+// with the new latest version, AddDocumentVersionIfLatest
+// returns docdb.ErrDocumentChanged instead.
+//
+// The server commits a version before the client calls onNewVersion,
+// so an error returned by onNewVersion is rolled back by deleting
+// the new document or version again. Concurrent readers can see
+// a version that gets rolled back this way.
+//
+// AddMultiDocumentVersion is implemented with docdb.AddMultiDocumentVersionImpl
+// and not atomic on the server.
+type Conn struct {
+	baseURL string
+	client  *http.Client
+}
+
+// Option configures a Conn.
+type Option func(*Conn)
+
+// WithHTTPClient sets the http.Client used for requests,
+// for example with a Transport authenticating requests.
+// The default is http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Conn) {
+		c.client = client
+	}
+}
+
+// New returns a Conn for the Handler served at baseURL.
+func New(baseURL string, options ...Option) *Conn {
+	c := &Conn{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  http.DefaultClient,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+func (c *Conn) String() string {
+	return fmt.Sprintf("httpconn.Conn{%s}", c.baseURL)
+}
+
+func (c *Conn) DocumentExists(ctx context.Context, docID uu.ID) (exists bool, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID)
+
+	req, err := c.newRequest(ctx, http.MethodHead, documentPath(docID), nil)
+	if err != nil {
+		return false, err
+	}
+	response, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, errs.Errorf("%s %s responded %s", req.Method, req.URL, response.Status)
+}
+
+func (c *Conn) CompanyIDs(ctx context.Context) (companyIDs uu.IDSlice, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx)
+
+	err = c.getJSON(ctx, "/companies", &companyIDs)
+	return companyIDs, err
+}
+
+func (c *Conn) CompanyDocumentIDs(ctx context.Context, companyID uu.ID) (docIDs uu.IDSlice, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, companyID)
+
+	err = c.getJSON(ctx, "/companies/"+companyID.String()+"/documents", &docIDs)
+	return docIDs, err
+}
+
+func (c *Conn) DocumentCompanyID(ctx context.Context, docID uu.ID) (companyID uu.ID, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID)
+
+	err = c.getJSON(ctx, documentPath(docID)+"/company", &companyID)
+	return companyID, err
+}
+
+func (c *Conn) SetDocumentCompanyID(ctx context.Context, docID, companyID uu.ID) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, companyID)
+
+	body, err := json.Marshal(companyID)
+	if err != nil {
+		return err
+	}
+	response, err := c.do(ctx, http.MethodPut, documentPath(docID)+"/company", bytes.NewReader(body), "application/json")
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (c *Conn) DocumentVersions(ctx context.Context, docID uu.ID) (versions []docdb.VersionTime, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID)
+
+	err = c.getJSON(ctx, documentPath(docID)+"/versions", &versions)
+	return versions, err
+}
+
+func (c *Conn) LatestDocumentVersion(ctx context.Context, docID uu.ID) (version docdb.VersionTime, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID)
+
+	info, err := c.LatestDocumentVersionInfo(ctx, docID)
+	if err != nil {
+		return docdb.VersionTime{}, err
+	}
+	return info.Version, nil
+}
+
+func (c *Conn) DocumentVersionInfo(ctx context.Context, docID uu.ID, version docdb.VersionTime) (versionInfo *docdb.VersionInfo, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version)
+
+	err = c.getJSON(ctx, versionPath(docID, version.String()), &versionInfo)
+	if err != nil {
+		return nil, err
+	}
+	return versionInfo, nil
+}
+
+func (c *Conn) LatestDocumentVersionInfo(ctx context.Context, docID uu.ID) (versionInfo *docdb.VersionInfo, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID)
+
+	err = c.getJSON(ctx, versionPath(docID, LatestVersion), &versionInfo)
+	if err != nil {
+		return nil, err
+	}
+	return versionInfo, nil
+}
+
+func (c *Conn) DocumentVersionFileProvider(ctx context.Context, docID uu.ID, version docdb.VersionTime) (p docdb.FileProvider, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version)
+
+	info, err := c.DocumentVersionInfo(ctx, docID, version)
+	if err != nil {
+		return nil, err
+	}
+	return &fileProvider{conn: c, info: info}, nil
+}
+
+func (c *Conn) ReadDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (data []byte, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version, filename)
+
+	reader, err := c.OpenDocumentVersionFile(ctx, docID, version, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+func (c *Conn) OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (reader io.ReadCloser, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version, filename)
+
+	response, err := c.do(ctx, http.MethodGet, filePath(docID, version, filename), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+// ReadDocumentVersionFileRange requests the file with a Range header
+// so that only the range is transferred.
+func (c *Conn) ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string, offset, length int64) (data []byte, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version, filename, offset, length)
+
+	if offset < 0 {
+		return nil, docdb.ErrNegativeOffset
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, filePath(docID, version, filename), nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case length < 0 || length > math.MaxInt64-offset:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	case length == 0:
+		// A range can't be empty, request one byte
+		// to check that the file exists and discard it
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset))
+	default:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	}
+	response, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The file exists but ends before offset
+		return []byte{}, nil
+	case response.StatusCode >= 300:
+		return nil, responseError(req, response)
+	case length == 0:
+		return []byte{}, nil
+	case response.StatusCode == http.StatusOK:
+		// The server ignored the Range header
+		return docdb.ReadRange(response.Body, offset, length)
+	}
+	return io.ReadAll(response.Body)
+}
+
+func (c *Conn) DeleteDocument(ctx context.Context, docID uu.ID) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID)
+
+	response, err := c.do(ctx, http.MethodDelete, documentPath(docID), nil, "")
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (c *Conn) DeleteDocumentVersion(ctx context.Context, docID uu.ID, version docdb.VersionTime) (leftVersions []docdb.VersionTime, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version)
+
+	response, err := c.do(ctx, http.MethodDelete, versionPath(docID, version.String()), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	err = decodeJSON(response, &leftVersions)
+	if err != nil || len(leftVersions) == 0 {
+		return nil, err
+	}
+	return leftVersions, nil
+}
+
+func (c *Conn) CreateDocument(ctx context.Context, companyID, docID, userID uu.ID, reason string, version docdb.VersionTime, files []fs.FileReader, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, companyID, docID, userID, reason, version, files, onNewVersion)
+
+	if err = errors.Join(companyID.Validate(), docID.Validate(), userID.Validate(), version.Validate()); err != nil {
+		return err
+	}
+	if onNewVersion == nil {
+		return errs.New("nil onNewVersion func passed to CreateDocument")
+	}
+	if len(files) == 0 {
+		return errs.Errorf("cannot create document %s without files", docID)
+	}
+
+	fields := url.Values{
+		"companyID": {companyID.String()},
+		"userID":    {userID.String()},
+		"reason":    {reason},
+		"version":   {version.String()},
+	}
+	info, replayed, err := c.postVersion(ctx, documentPath(docID), fields, files)
+	if err != nil {
+		return err
+	}
+	err = safelyCallOnNewVersionFunc(ctx, info, onNewVersion)
+	if err != nil && !replayed {
+		return errors.Join(err, c.DeleteDocument(ctx, docID))
+	}
+	return err
+}
+
+func (c *Conn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, userID, reason, createVersion, onNewVersion)
+
+	return c.addDocumentVersion(ctx, docID, nil, userID, reason, createVersion, onNewVersion)
+}
+
+func (c *Conn) AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, expectedPrev, userID, reason, createVersion, onNewVersion)
+
+	return c.addDocumentVersion(ctx, docID, &expectedPrev, userID, reason, createVersion, onNewVersion)
+}
+
+func (c *Conn) addDocumentVersion(ctx context.Context, docID uu.ID, expectedPrev *docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+	if err := errors.Join(docID.Validate(), userID.Validate()); err != nil {
+		return err
+	}
+	if createVersion == nil {
+		return errs.New("nil createVersion func passed to AddDocumentVersion")
+	}
+	if onNewVersion == nil {
+		return errs.New("nil onNewVersion func passed to AddDocumentVersion")
+	}
+
+	// Check the key before calling createVersion
+	// that must not be called for a replayed version
+	if key := docdb.IdempotencyKeyFromContext(ctx); key != "" {
+		original, err := c.idempotentVersionInfo(ctx, docID, key)
+		if err != nil {
+			return err
+		}
+		if original != nil {
+			return safelyCallOnNewVersionFunc(ctx, original, onNewVersion)
+		}
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		prev, err := c.LatestDocumentVersionInfo(ctx, docID)
+		if err != nil {
+			return err
+		}
+		if expectedPrev != nil && !prev.Version.Equal(*expectedPrev) {
+			return docdb.NewErrDocumentChanged(docID, *expectedPrev)
+		}
+		result, err := safelyCallCreateVersionFunc(ctx, docID, prev.Version, &fileProvider{conn: c, info: prev}, createVersion)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			return errs.New("createVersion returned nil CreateVersionResult")
+		}
+		if err = result.Validate(); err != nil {
+			return err
+		}
+
+		fields := url.Values{
+			"userID":       {userID.String()},
+			"reason":       {reason},
+			"version":      {result.Version.String()},
+			"expectedPrev": {prev.Version.String()},
+			"remove":       result.RemoveFiles,
+		}
+		if result.NewCompanyID.IsNotNull() {
+			fields.Set("newCompanyID", result.NewCompanyID.String())
+		}
+		info, replayed, err := c.postVersion(ctx, documentPath(docID)+"/versions", fields, result.WriteFiles)
+		if errors.As(err, new(docdb.ErrDocumentChanged)) && expectedPrev == nil {
+			// Another version was added after reading prev
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = safelyCallOnNewVersionFunc(ctx, info, onNewVersion)
+		if err != nil && !replayed {
+			_, e := c.DeleteDocumentVersion(ctx, docID, info.Version)
+			if e == nil && info.CompanyID != prev.CompanyID {
+				e = c.SetDocumentCompanyID(ctx, docID, prev.CompanyID)
+			}
+			return errors.Join(err, e)
+		}
+		return err
+	}
+}
+
+func (c *Conn) AddMultiDocumentVersion(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docIDs, userID, reason, createVersion, onNewVersion)
+
+	return docdb.AddMultiDocumentVersionImpl(ctx, c, docIDs, userID, reason, createVersion, onNewVersion)
+}
+
+func (c *Conn) RestoreDocument(ctx context.Context, doc *docdb.HashedDocument, recreate bool) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, doc, recreate)
+
+	if err = doc.Validate(); err != nil {
+		return err
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	path := documentPath(doc.ID)
+	if recreate {
+		path += "?recreate=true"
+	}
+	response, err := c.do(ctx, http.MethodPut, path, bytes.NewReader(body), "application/json")
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// idempotentVersionInfo returns the VersionInfo of the version
+// of a document committed with key or nil.
+func (c *Conn) idempotentVersionInfo(ctx context.Context, docID uu.ID, key string) (*docdb.VersionInfo, error) {
+	response, err := c.do(ctx, http.MethodGet, documentPath(docID)+"/idempotency-keys/"+url.PathEscape(key), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusNoContent {
+		return nil, response.Body.Close()
+	}
+	var info *docdb.VersionInfo
+	return info, decodeJSON(response, &info)
+}
+
+// postVersion posts a multipart form with fields and files
+// and returns the VersionInfo of the response
+// and if it is the replayed version of an idempotency key.
+func (c *Conn) postVersion(ctx context.Context, path string, fields url.Values, files []fs.FileReader) (info *docdb.VersionInfo, replayed bool, err error) {
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeForm(form, fields, files))
+	}()
+	defer body.Close()
+
+	response, err := c.do(ctx, http.MethodPost, path, body, form.FormDataContentType())
+	if err != nil {
+		return nil, false, err
+	}
+	replayed = response.StatusCode == http.StatusOK
+	err = decodeJSON(response, &info)
+	if err != nil {
+		return nil, false, err
+	}
+	return info, replayed, nil
+}
+
+// writeForm writes fields and files as multipart form
+// and streams the content of the files.
+func writeForm(form *multipart.Writer, fields url.Values, files []fs.FileReader) error {
+	for name, values := range fields {
+		for _, value := range values {
+			if err := form.WriteField(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	for _, file := range files {
+		part, err := form.CreateFormFile("file", file.Name())
+		if err != nil {
+			return err
+		}
+		reader, err := file.OpenReader()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return form.Close()
+}
+
+func (c *Conn) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if key := docdb.IdempotencyKeyFromContext(ctx); key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	return req, nil
+}
+
+// do sends a request and returns the response
+// or the error of an ErrorResponse.
+// The caller must close the body of the returned response.
+func (c *Conn) do(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	response, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		return nil, responseError(req, response)
+	}
+	return response, nil
+}
+
+func (c *Conn) getJSON(ctx context.Context, path string, value any) error {
+	response, err := c.do(ctx, http.MethodGet, path, nil, "")
+	if err != nil {
+		return err
+	}
+	return decodeJSON(response, value)
+}
+
+func decodeJSON(response *http.Response, value any) error {
+	defer response.Body.Close()
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// responseError returns the error of an error response.
+// An ErrorResponse with a docdb error type
+// is returned as error of that type.
+func responseError(req *http.Request, response *http.Response) error {
+	var e ErrorResponse
+	if json.NewDecoder(response.Body).Decode(&e) != nil || e.Error == "" {
+		return errs.Errorf("%s %s responded %s", req.Method, req.URL, response.Status)
+	}
+	switch e.Type {
+	case ErrorTypeDocumentNotFound:
+		return docdb.NewErrDocumentNotFound(e.DocID)
+	case ErrorTypeDocumentVersionNotFound:
+		return docdb.NewErrDocumentVersionNotFound(e.DocID, e.Version)
+	case ErrorTypeDocumentFileNotFound:
+		return docdb.NewErrDocumentFileNotFound(e.DocID, e.Filename)
+	case ErrorTypeDocumentAlreadyExists:
+		return docdb.NewErrDocumentAlreadyExists(e.DocID)
+	case ErrorTypeVersionAlreadyExists:
+		return docdb.NewErrVersionAlreadyExists(e.DocID, e.Version)
+	case ErrorTypeDocumentChanged:
+		return docdb.NewErrDocumentChanged(e.DocID, e.Version)
+	case ErrorTypeNoChanges:
+		return docdb.ErrNoChanges
+	case ErrorTypeReadonly:
+		return docdb.ErrReadonly
+	case ErrorTypeNotImplemented:
+		return docdb.ErrNotImplemented
+	}
+	return errs.Errorf("%s %s responded %s: %s", req.Method, req.URL, response.Status, e.Error)
+}
+
+func documentPath(docID uu.ID) string {
+	return "/documents/" + docID.String()
+}
+
+func versionPath(docID uu.ID, version string) string {
+	return documentPath(docID) + "/versions/" + version
+}
+
+func filePath(docID uu.ID, version docdb.VersionTime, filename string) string {
+	return versionPath(docID, version.String()) + "/files/" + url.PathEscape(filename)
+}
+
+func safelyCallCreateVersionFunc(ctx context.Context, docID uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider, createVersion docdb.CreateVersionFunc) (result *docdb.CreateVersionResult, err error) {
+	defer errs.RecoverPanicAsError(&err)
+
+	return createVersion(ctx, docID, prevVersion, prevFiles)
+}
+
+func safelyCallOnNewVersionFunc(ctx context.Context, versionInfo *docdb.VersionInfo, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	defer errs.RecoverPanicAsError(&err)
+
+	return onNewVersion(ctx, versionInfo)
+}
+
+// fileProvider reads the files of a version from the server.
+type fileProvider struct {
+	conn *Conn
+	info *docdb.VersionInfo
+}
+
+func (p *fileProvider) HasFile(filename string) (bool, error) {
+	_, ok := p.info.Files[filename]
+	return ok, nil
+}
+
+func (p *fileProvider) ListFiles(ctx context.Context) (filenames []string, err error) {
+	return slices.Sorted(maps.Keys(p.info.Files)), nil
+}
+
+func (p *fileProvider) ReadFile(ctx context.Context, filename string) ([]byte, error) {
+	return p.conn.ReadDocumentVersionFile(ctx, p.info.DocID, p.info.Version, filename)
+}
+
+func (p *fileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return p.conn.OpenDocumentVersionFile(ctx, p.info.DocID, p.info.Version, filename)
+}
@@ -0,0 +1,118 @@
+package httpconn_test
+
+import (
+	"context"
+	"maps"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/domonda/go-errs"
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/docdbtest"
+	"github.com/domonda/go-docdb/httpconn"
+	"github.com/domonda/go-docdb/memconn"
+	"github.com/domonda/go-types/uu"
+)
+
+// newConn returns a Conn for a Handler serving backend.
+func newConn(t *testing.T, backend docdb.Conn) *httpconn.Conn {
+	server := httptest.NewServer(httpconn.NewHandler(backend))
+	t.Cleanup(server.Close)
+	return httpconn.New(server.URL + "/")
+}
+
+func TestConnConformance(t *testing.T) {
+	docdbtest.RunConnTests(t, func(t *testing.T) docdb.Conn {
+		return newConn(t, memconn.New())
+	})
+}
+
+func TestConnErrors(t *testing.T) {
+	ctx := t.Context()
+	conn := newConn(t, memconn.New())
+	docID := uu.IDv7()
+
+	_, err := conn.DocumentVersions(ctx, docID)
+	require.True(t, errs.Has[docdb.ErrDocumentNotFound](err))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	var notFound docdb.ErrDocumentNotFound
+	require.ErrorAs(t, err, &notFound)
+	require.Equal(t, docID, notFound.DocID())
+
+	err = conn.CreateDocument(ctx, uu.IDv7(), docID, uu.IDv7(), "create", version1, []fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))}, docdbtest.NoopOnNewVersion)
+	require.NoError(t, err)
+
+	_, err = conn.ReadDocumentVersionFile(ctx, docID, version1, "file with spaces.txt")
+	var fileNotFound docdb.ErrDocumentFileNotFound
+	require.ErrorAs(t, err, &fileNotFound)
+	require.Equal(t, "file with spaces.txt", fileNotFound.Filename())
+
+	_, err = conn.DocumentVersionInfo(ctx, docID, version2)
+	var versionNotFound docdb.ErrDocumentVersionNotFound
+	require.ErrorAs(t, err, &versionNotFound)
+	require.Equal(t, version2, versionNotFound.Version())
+
+	err = conn.CreateDocument(ctx, uu.IDv7(), docID, uu.IDv7(), "create", version1, []fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))}, docdbtest.NoopOnNewVersion)
+	require.True(t, errs.Has[docdb.ErrDocumentAlreadyExists](err))
+
+	readonly := newConn(t, docdb.ReadonlyConn(memconn.New()))
+	require.ErrorIs(t, readonly.DeleteDocument(ctx, docID), docdb.ErrReadonly)
+}
+
+// addVersionBeforeFirstSubmit adds a version with backend
+// on the first call of the returned CreateVersionFunc
+// as if another client did so concurrently.
+func addVersionBeforeFirstSubmit(t *testing.T, backend docdb.Conn, calls *int) docdb.CreateVersionFunc {
+	return func(ctx context.Context, docID uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+		*calls++
+		if *calls == 1 {
+			err := backend.AddDocumentVersion(ctx, docID, uu.IDv7(), "concurrent", func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+				return &docdb.CreateVersionResult{Version: version2, WriteFiles: []fs.FileReader{fs.NewMemFile("concurrent.txt", []byte("c"))}}, nil
+			}, docdbtest.NoopOnNewVersion)
+			require.NoError(t, err)
+		}
+		return &docdb.CreateVersionResult{
+			Version:    docdb.VersionTimeFrom(prevVersion.Time.AddDate(0, 0, 1)),
+			WriteFiles: []fs.FileReader{fs.NewMemFile("client.txt", []byte("client"))},
+		}, nil
+	}
+}
+
+func TestConnAddDocumentVersionConflict(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("AddDocumentVersion retries with new latest version", func(t *testing.T) {
+		backend := memconn.New()
+		conn := newConn(t, backend)
+		docID := uu.IDv7()
+		require.NoError(t, conn.CreateDocument(ctx, uu.IDv7(), docID, uu.IDv7(), "create", version1, []fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))}, docdbtest.NoopOnNewVersion))
+
+		calls := 0
+		var info *docdb.VersionInfo
+		err := conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "client", addVersionBeforeFirstSubmit(t, backend, &calls), docdb.CaptureNewVersionInfo(&info))
+		require.NoError(t, err)
+		require.Equal(t, 2, calls)
+		require.Equal(t, version2, *info.PrevVersion)
+		require.Equal(t, []string{"client.txt", "concurrent.txt", "doc.pdf"}, slices.Sorted(maps.Keys(info.Files)))
+	})
+
+	t.Run("AddDocumentVersionIfLatest fails", func(t *testing.T) {
+		backend := memconn.New()
+		conn := newConn(t, backend)
+		docID := uu.IDv7()
+		require.NoError(t, conn.CreateDocument(ctx, uu.IDv7(), docID, uu.IDv7(), "create", version1, []fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))}, docdbtest.NoopOnNewVersion))
+
+		calls := 0
+		err := conn.AddDocumentVersionIfLatest(ctx, docID, version1, uu.IDv7(), "client", addVersionBeforeFirstSubmit(t, backend, &calls), docdbtest.NoopOnNewVersion)
+		require.True(t, errs.Has[docdb.ErrDocumentChanged](err))
+		require.Equal(t, 1, calls)
+		versions, err := conn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, []docdb.VersionTime{version1, version2}, versions)
+	})
+}
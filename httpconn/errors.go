@@ -0,0 +1,115 @@
+package httpconn
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-types/uu"
+)
+
+// Error types of ErrorResponse.Type
+const (
+	ErrorTypeDocumentNotFound        = "DocumentNotFound"
+	ErrorTypeDocumentVersionNotFound = "DocumentVersionNotFound"
+	ErrorTypeDocumentFileNotFound    = "DocumentFileNotFound"
+	ErrorTypeDocumentAlreadyExists   = "DocumentAlreadyExists"
+	ErrorTypeVersionAlreadyExists    = "VersionAlreadyExists"
+	ErrorTypeDocumentChanged         = "DocumentChanged"
+	ErrorTypeNoChanges               = "NoChanges"
+	ErrorTypeReadonly                = "Readonly"
+	ErrorTypeNotImplemented          = "NotImplemented"
+	ErrorTypeBadRequest              = "BadRequest"
+	ErrorTypeRequestTooLarge         = "RequestTooLarge"
+)
+
+// ErrorResponse is the JSON body of an error response.
+// Type is empty for errors without a docdb error type.
+// DocID, Version and Filename are set as far as
+// the docdb error type provides them.
+type ErrorResponse struct {
+	Error    string            `json:"error"`
+	Type     string            `json:"type,omitempty"`
+	DocID    uu.ID             `json:"docID,omitzero"`
+	Version  docdb.VersionTime `json:"version,omitzero"`
+	Filename string            `json:"filename,omitempty"`
+}
+
+// errBadRequest wraps errors caused by an invalid request.
+type errBadRequest struct {
+	err error
+}
+
+func (e errBadRequest) Error() string { return e.err.Error() }
+func (e errBadRequest) Unwrap() error { return e.err }
+
+// NewErrorResponse returns the HTTP status code and ErrorResponse for err.
+func NewErrorResponse(err error) (status int, response *ErrorResponse) {
+	response = &ErrorResponse{Error: err.Error()}
+	var (
+		docNotFound     docdb.ErrDocumentNotFound
+		versionNotFound docdb.ErrDocumentVersionNotFound
+		fileNotFound    docdb.ErrDocumentFileNotFound
+		docExists       docdb.ErrDocumentAlreadyExists
+		versionExists   docdb.ErrVersionAlreadyExists
+		docChanged      docdb.ErrDocumentChanged
+	)
+	switch {
+	case errors.As(err, &docNotFound):
+		response.Type = ErrorTypeDocumentNotFound
+		response.DocID = docNotFound.DocID()
+		return http.StatusNotFound, response
+	case errors.As(err, &versionNotFound):
+		response.Type = ErrorTypeDocumentVersionNotFound
+		response.DocID = versionNotFound.DocID()
+		response.Version = versionNotFound.Version()
+		return http.StatusNotFound, response
+	case errors.As(err, &fileNotFound):
+		response.Type = ErrorTypeDocumentFileNotFound
+		response.DocID = fileNotFound.DocID()
+		response.Filename = fileNotFound.Filename()
+		return http.StatusNotFound, response
+	case errors.As(err, &docExists):
+		response.Type = ErrorTypeDocumentAlreadyExists
+		response.DocID = docExists.DocID()
+		return http.StatusConflict, response
+	case errors.As(err, &versionExists):
+		response.Type = ErrorTypeVersionAlreadyExists
+		response.DocID = versionExists.DocID()
+		response.Version = versionExists.Version()
+		return http.StatusConflict, response
+	case errors.As(err, &docChanged):
+		response.Type = ErrorTypeDocumentChanged
+		response.DocID = docChanged.DocID()
+		response.Version = docChanged.BaseVersion()
+		return http.StatusPreconditionFailed, response
+	case errors.Is(err, docdb.ErrNoChanges):
+		response.Type = ErrorTypeNoChanges
+		return http.StatusUnprocessableEntity, response
+	case errors.Is(err, docdb.ErrReadonly):
+		response.Type = ErrorTypeReadonly
+		return http.StatusForbidden, response
+	case errors.Is(err, docdb.ErrNotImplemented):
+		response.Type = ErrorTypeNotImplemented
+		return http.StatusNotImplemented, response
+	case errors.As(err, new(*http.MaxBytesError)):
+		response.Type = ErrorTypeRequestTooLarge
+		return http.StatusRequestEntityTooLarge, response
+	case errors.As(err, new(errBadRequest)):
+		response.Type = ErrorTypeBadRequest
+		return http.StatusBadRequest, response
+	}
+	return http.StatusInternalServerError, response
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status, response := NewErrorResponse(err)
+	writeJSON(w, status, response)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,560 @@
+// Package httpconn serves a docdb.Conn over HTTP
+// and provides the Conn client for it.
+//
+// NewHandler returns an http.Handler with the REST API:
+//
+//	GET    /companies                                   company IDs
+//	GET    /companies/{companyID}/documents             document IDs of a company
+//	HEAD   /documents/{docID}                           200 if the document exists, else 404
+//	POST   /documents/{docID}                           create a document from a multipart form
+//	PUT    /documents/{docID}                           restore a docdb.HashedDocument from a JSON body
+//	DELETE /documents/{docID}                           delete a document
+//	GET    /documents/{docID}/company                   company ID of a document
+//	PUT    /documents/{docID}/company                   set the company ID from a JSON body
+//	GET    /documents/{docID}/versions                  version timestamps
+//	POST   /documents/{docID}/versions                  add a version from a multipart form
+//	GET    /documents/{docID}/versions/{version}        VersionInfo
+//	DELETE /documents/{docID}/versions/{version}        delete a version, returns the left versions
+//	GET    /documents/{docID}/versions/{version}/files/{filename}   file content, see ServeDocumentVersionFile
+//	GET    /documents/{docID}/idempotency-keys/{key}    VersionInfo committed with the key, 204 if none
+//
+// Versions are formatted like docdb.VersionTime.String,
+// GET requests also accept "latest" as version.
+// Responses are JSON except for file content.
+//
+// The multipart forms for creating documents and adding versions
+// have the fields "companyID" (create only), "userID", "reason",
+// and optionally "version" with the timestamp of the new version
+// that defaults to the current time.
+// Every part with a filename is a file of the new version.
+// Adding a version additionally accepts "remove" fields with filenames
+// to remove, an optional "newCompanyID" and an optional "expectedPrev"
+// version for docdb.Conn.AddDocumentVersionIfLatest.
+// Successful writes respond with the VersionInfo of the new version.
+//
+// The value of an Idempotency-Key request header is passed
+// to the Conn with docdb.ContextWithIdempotencyKey.
+// A write replayed because of the key responds with status 200
+// and the VersionInfo of the original version instead of 201.
+//
+// Restoring a document recreates it with the query parameter recreate=true,
+// see docdb.Conn.RestoreDocument.
+//
+// Request bodies larger than the limit set with WithMaxRequestBodySize
+// are rejected with status 413 and form fields without a filename
+// larger than 64 KiB with status 400.
+//
+// Errors are responded as ErrorResponse with a status code
+// depending on the docdb error type, see NewErrorResponse.
+//
+// The handler does not authenticate requests,
+// wrap it with a middleware for that.
+package httpconn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-types/uu"
+)
+
+// IdempotencyKeyHeader is the request header
+// passed with docdb.ContextWithIdempotencyKey.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// LatestVersion can be used instead of a version timestamp in GET requests.
+const LatestVersion = "latest"
+
+// DefaultMaxRequestBodySize is the default limit
+// of the size of request bodies, see WithMaxRequestBodySize.
+const DefaultMaxRequestBodySize = 1 << 30
+
+// maxFormValueSize limits the size of non-file multipart form fields.
+const maxFormValueSize = 64 << 10
+
+// Handler is an http.Handler serving a docdb.Conn,
+// see the package documentation for the API.
+type Handler struct {
+	conn               docdb.Conn
+	mux                *http.ServeMux
+	maxRequestBodySize int64
+}
+
+// HandlerOption configures a Handler.
+type HandlerOption func(*Handler)
+
+// WithMaxRequestBodySize limits the size of request bodies in bytes,
+// larger requests are responded with status 413.
+// The default is DefaultMaxRequestBodySize,
+// a size of zero or less disables the limit.
+func WithMaxRequestBodySize(size int64) HandlerOption {
+	return func(h *Handler) {
+		h.maxRequestBodySize = size
+	}
+}
+
+// NewHandler returns a Handler serving conn.
+// Use http.StripPrefix to serve it under a path prefix.
+func NewHandler(conn docdb.Conn, options ...HandlerOption) *Handler {
+	h := &Handler{
+		conn:               conn,
+		mux:                http.NewServeMux(),
+		maxRequestBodySize: DefaultMaxRequestBodySize,
+	}
+	for _, option := range options {
+		option(h)
+	}
+	h.mux.HandleFunc("GET /companies", h.companyIDs)
+	h.mux.HandleFunc("GET /companies/{companyID}/documents", h.companyDocumentIDs)
+	h.mux.HandleFunc("HEAD /documents/{docID}", h.documentExists)
+	h.mux.HandleFunc("POST /documents/{docID}", h.createDocument)
+	h.mux.HandleFunc("PUT /documents/{docID}", h.restoreDocument)
+	h.mux.HandleFunc("DELETE /documents/{docID}", h.deleteDocument)
+	h.mux.HandleFunc("GET /documents/{docID}/company", h.documentCompanyID)
+	h.mux.HandleFunc("PUT /documents/{docID}/company", h.setDocumentCompanyID)
+	h.mux.HandleFunc("GET /documents/{docID}/versions", h.documentVersions)
+	h.mux.HandleFunc("POST /documents/{docID}/versions", h.addDocumentVersion)
+	h.mux.HandleFunc("GET /documents/{docID}/versions/{version}", h.documentVersionInfo)
+	h.mux.HandleFunc("DELETE /documents/{docID}/versions/{version}", h.deleteDocumentVersion)
+	h.mux.HandleFunc("GET /documents/{docID}/versions/{version}/files/{filename}", h.documentVersionFile)
+	h.mux.HandleFunc("GET /documents/{docID}/idempotency-keys/{key}", h.idempotentVersionInfo)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+		r = r.WithContext(docdb.ContextWithIdempotencyKey(r.Context(), key))
+	}
+	if h.maxRequestBodySize > 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxRequestBodySize)
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) companyIDs(w http.ResponseWriter, r *http.Request) {
+	companyIDs, err := h.conn.CompanyIDs(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(companyIDs))
+}
+
+func (h *Handler) companyDocumentIDs(w http.ResponseWriter, r *http.Request) {
+	companyID, err := pathID(r, "companyID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	docIDs, err := h.conn.CompanyDocumentIDs(r.Context(), companyID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(docIDs))
+}
+
+func (h *Handler) documentExists(w http.ResponseWriter, r *http.Request) {
+	docID, err := pathID(r, "docID")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	exists, err := h.conn.DocumentExists(r.Context(), docID)
+	switch {
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+	case !exists:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (h *Handler) documentCompanyID(w http.ResponseWriter, r *http.Request) {
+	docID, err := pathID(r, "docID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	companyID, err := h.conn.DocumentCompanyID(r.Context(), docID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, companyID)
+}
+
+func (h *Handler) setDocumentCompanyID(w http.ResponseWriter, r *http.Request) {
+	docID, err := pathID(r, "docID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var companyID uu.ID
+	err = json.NewDecoder(io.LimitReader(r.Body, maxFormValueSize)).Decode(&companyID)
+	if err == nil {
+		err = companyID.Validate()
+	}
+	if err != nil {
+		writeError(w, errBadRequest{fmt.Errorf("invalid company ID: %w", err)})
+		return
+	}
+	err = h.conn.SetDocumentCompanyID(r.Context(), docID, companyID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) documentVersions(w http.ResponseWriter, r *http.Request) {
+	docID, err := pathID(r, "docID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	versions, err := h.conn.DocumentVersions(r.Context(), docID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(versions))
+}
+
+func (h *Handler) documentVersionInfo(w http.ResponseWriter, r *http.Request) {
+	docID, version, err := h.pathDocVersion(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	info, err := h.conn.DocumentVersionInfo(r.Context(), docID, version)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (h *Handler) documentVersionFile(w http.ResponseWriter, r *http.Request) {
+	docID, version, err := h.pathDocVersion(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	ServeDocumentVersionFile(w, r, h.conn, docID, version, r.PathValue("filename"))
+}
+
+func (h *Handler) deleteDocument(w http.ResponseWriter, r *http.Request) {
+	docID, err := pathID(r, "docID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	err = h.conn.DeleteDocument(r.Context(), docID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) deleteDocumentVersion(w http.ResponseWriter, r *http.Request) {
+	docID, err := pathID(r, "docID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version, err := docdb.VersionTimeFromString(r.PathValue("version"))
+	if err != nil {
+		writeError(w, errBadRequest{err})
+		return
+	}
+	leftVersions, err := h.conn.DeleteDocumentVersion(r.Context(), docID, version)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(leftVersions))
+}
+
+func (h *Handler) createDocument(w http.ResponseWriter, r *http.Request) {
+	docID, err := pathID(r, "docID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	form, err := readForm(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	companyID, err := form.id("companyID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	userID, err := form.id("userID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version := docdb.NewVersionTime()
+	if form.has("version") {
+		version, err = form.version("version")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	replayed, err := docdb.IdempotentVersionInfo(r.Context(), h.conn, docID, docdb.IdempotencyKeyFromContext(r.Context()))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if replayed != nil {
+		writeJSON(w, http.StatusOK, replayed)
+		return
+	}
+	var info *docdb.VersionInfo
+	err = h.conn.CreateDocument(r.Context(), companyID, docID, userID, form.value("reason"), version, form.files, docdb.CaptureNewVersionInfo(&info))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, info)
+}
+
+func (h *Handler) addDocumentVersion(w http.ResponseWriter, r *http.Request) {
+	docID, err := pathID(r, "docID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	form, err := readForm(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	userID, err := form.id("userID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	result := &docdb.CreateVersionResult{
+		WriteFiles:  form.files,
+		RemoveFiles: form.values["remove"],
+	}
+	if form.has("version") {
+		result.Version, err = form.version("version")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if form.has("newCompanyID") {
+		id, err := form.id("newCompanyID")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		result.NewCompanyID = id.Nullable()
+	}
+	replayed, err := docdb.IdempotentVersionInfo(r.Context(), h.conn, docID, docdb.IdempotencyKeyFromContext(r.Context()))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if replayed != nil {
+		writeJSON(w, http.StatusOK, replayed)
+		return
+	}
+	// createVersion is not called if the Conn replays
+	// a version committed concurrently with the same key
+	created := false
+	createVersion := func(ctx context.Context, docID uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+		created = true
+		if result.Version.Time.IsZero() {
+			result.Version = docdb.NewVersionTime()
+			if !result.Version.After(prevVersion) {
+				result.Version = docdb.VersionTimeFrom(prevVersion.Time.Add(time.Millisecond))
+			}
+		}
+		return result, nil
+	}
+
+	var info *docdb.VersionInfo
+	if form.has("expectedPrev") {
+		var expectedPrev docdb.VersionTime
+		expectedPrev, err = form.version("expectedPrev")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		err = h.conn.AddDocumentVersionIfLatest(r.Context(), docID, expectedPrev, userID, form.value("reason"), createVersion, docdb.CaptureNewVersionInfo(&info))
+	} else {
+		err = h.conn.AddDocumentVersion(r.Context(), docID, userID, form.value("reason"), createVersion, docdb.CaptureNewVersionInfo(&info))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !created {
+		writeJSON(w, http.StatusOK, info)
+		return
+	}
+	writeJSON(w, http.StatusCreated, info)
+}
+
+func (h *Handler) restoreDocument(w http.ResponseWriter, r *http.Request) {
+	docID, err := pathID(r, "docID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var doc docdb.HashedDocument
+	err = json.NewDecoder(r.Body).Decode(&doc)
+	if err != nil {
+		writeError(w, errBadRequest{fmt.Errorf("invalid HashedDocument: %w", err)})
+		return
+	}
+	if doc.ID != docID {
+		writeError(w, errBadRequest{fmt.Errorf("HashedDocument.ID %s does not match document %s", doc.ID, docID)})
+		return
+	}
+	err = h.conn.RestoreDocument(r.Context(), &doc, r.URL.Query().Get("recreate") == "true")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) idempotentVersionInfo(w http.ResponseWriter, r *http.Request) {
+	docID, err := pathID(r, "docID")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	info, err := docdb.IdempotentVersionInfo(r.Context(), h.conn, docID, r.PathValue("key"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if info == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// pathDocVersion returns the document ID and version of the request path
+// where the version "latest" is resolved with LatestDocumentVersion.
+func (h *Handler) pathDocVersion(r *http.Request) (docID uu.ID, version docdb.VersionTime, err error) {
+	docID, err = pathID(r, "docID")
+	if err != nil {
+		return uu.IDNil, docdb.VersionTime{}, err
+	}
+	if r.PathValue("version") == LatestVersion {
+		version, err = h.conn.LatestDocumentVersion(r.Context(), docID)
+		return docID, version, err
+	}
+	version, err = docdb.VersionTimeFromString(r.PathValue("version"))
+	if err != nil {
+		return uu.IDNil, docdb.VersionTime{}, errBadRequest{err}
+	}
+	return docID, version, nil
+}
+
+func pathID(r *http.Request, name string) (uu.ID, error) {
+	id, err := uu.IDFromString(r.PathValue(name))
+	if err != nil {
+		return uu.IDNil, errBadRequest{fmt.Errorf("invalid %s: %w", name, err)}
+	}
+	return id, nil
+}
+
+// nonNil returns an empty slice for nil
+// so that it is encoded as JSON array instead of null.
+func nonNil[S ~[]E, E any](s S) S {
+	if s == nil {
+		return S{}
+	}
+	return s
+}
+
+// form holds the parts of a multipart form request.
+type form struct {
+	values map[string][]string
+	files  []fs.FileReader
+}
+
+// readForm reads the multipart form of a request.
+// Parts with a filename are read into memory as files,
+// other parts larger than maxFormValueSize are rejected.
+func readForm(r *http.Request) (*form, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, errBadRequest{err}
+	}
+	f := &form{values: make(map[string][]string)}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return f, nil
+		}
+		if err != nil {
+			return nil, errBadRequest{err}
+		}
+		if part.FileName() != "" {
+			data, err := io.ReadAll(part)
+			if err != nil {
+				return nil, errBadRequest{err}
+			}
+			f.files = append(f.files, fs.NewMemFile(part.FileName(), data))
+			continue
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxFormValueSize+1))
+		if err != nil {
+			return nil, errBadRequest{err}
+		}
+		if len(value) > maxFormValueSize {
+			return nil, errBadRequest{fmt.Errorf("form field %s is larger than %d bytes", part.FormName(), maxFormValueSize)}
+		}
+		f.values[part.FormName()] = append(f.values[part.FormName()], string(value))
+	}
+}
+
+func (f *form) has(name string) bool {
+	return len(f.values[name]) > 0
+}
+
+func (f *form) value(name string) string {
+	if !f.has(name) {
+		return ""
+	}
+	return f.values[name][0]
+}
+
+func (f *form) id(name string) (uu.ID, error) {
+	id, err := uu.IDFromString(f.value(name))
+	if err != nil {
+		return uu.IDNil, errBadRequest{fmt.Errorf("invalid form field %s: %w", name, err)}
+	}
+	return id, nil
+}
+
+func (f *form) version(name string) (docdb.VersionTime, error) {
+	version, err := docdb.VersionTimeFromString(f.value(name))
+	if err != nil {
+		return docdb.VersionTime{}, errBadRequest{fmt.Errorf("invalid form field %s: %w", name, err)}
+	}
+	return version, nil
+}
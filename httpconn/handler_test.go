@@ -0,0 +1,222 @@
+package httpconn_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/httpconn"
+	"github.com/domonda/go-docdb/memconn"
+	"github.com/domonda/go-types/uu"
+)
+
+var (
+	version1 = docdb.MustVersionTimeFromString("2024-01-01_00-00-00.000")
+	version2 = docdb.MustVersionTimeFromString("2024-01-02_00-00-00.000")
+)
+
+// multipartBody returns a multipart form with the fields
+// and files from filename to content.
+func multipartBody(t *testing.T, fields map[string][]string, files map[string]string) (body io.Reader, contentType string) {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for name, values := range fields {
+		for _, value := range values {
+			require.NoError(t, writer.WriteField(name, value))
+		}
+	}
+	for filename, content := range files {
+		part, err := writer.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		_, err = part.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	return &buf, writer.FormDataContentType()
+}
+
+func do(t *testing.T, method, url string, body io.Reader, contentType string, header ...string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequestWithContext(t.Context(), method, url, body)
+	require.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func decode[T any](t *testing.T, resp *http.Response) T {
+	t.Helper()
+	var value T
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&value))
+	return value
+}
+
+func requireError(t *testing.T, resp *http.Response, status int, errorType string) {
+	t.Helper()
+	require.Equal(t, status, resp.StatusCode)
+	require.Equal(t, errorType, decode[httpconn.ErrorResponse](t, resp).Type)
+}
+
+func TestHandler(t *testing.T) {
+	var (
+		conn      = memconn.New()
+		server    = httptest.NewServer(httpconn.NewHandler(conn))
+		companyID = uu.IDv7()
+		docID     = uu.IDv7()
+		userID    = uu.IDv7()
+		docURL    = server.URL + "/documents/" + docID.String()
+	)
+	t.Cleanup(server.Close)
+
+	// Create
+	body, contentType := multipartBody(t,
+		map[string][]string{"companyID": {companyID.String()}, "userID": {userID.String()}, "reason": {"create"}, "version": {version1.String()}},
+		map[string]string{"doc.pdf": "pdf", "doc.json": `{"a":1}`},
+	)
+	resp := do(t, http.MethodPost, docURL, body, contentType)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	info := decode[docdb.VersionInfo](t, resp)
+	require.Equal(t, version1, info.Version)
+	require.Equal(t, []string{"doc.json", "doc.pdf"}, info.AddedFiles)
+
+	body, contentType = multipartBody(t,
+		map[string][]string{"companyID": {companyID.String()}, "userID": {userID.String()}},
+		map[string]string{"doc.pdf": "pdf"},
+	)
+	requireError(t, do(t, http.MethodPost, docURL, body, contentType), http.StatusConflict, httpconn.ErrorTypeDocumentAlreadyExists)
+
+	// Read
+	require.Equal(t, http.StatusOK, do(t, http.MethodHead, docURL, nil, "").StatusCode)
+	require.Equal(t, http.StatusNotFound, do(t, http.MethodHead, server.URL+"/documents/"+uu.IDv7().String(), nil, "").StatusCode)
+	require.Equal(t, uu.IDSlice{companyID}, decode[uu.IDSlice](t, do(t, http.MethodGet, server.URL+"/companies", nil, "")))
+	require.Equal(t, uu.IDSlice{docID}, decode[uu.IDSlice](t, do(t, http.MethodGet, server.URL+"/companies/"+companyID.String()+"/documents", nil, "")))
+	require.Equal(t, companyID, decode[uu.ID](t, do(t, http.MethodGet, docURL+"/company", nil, "")))
+	require.Equal(t, []docdb.VersionTime{version1}, decode[[]docdb.VersionTime](t, do(t, http.MethodGet, docURL+"/versions", nil, "")))
+	require.Equal(t, "create", decode[docdb.VersionInfo](t, do(t, http.MethodGet, docURL+"/versions/latest", nil, "")).CommitReason)
+
+	resp = do(t, http.MethodGet, docURL+"/versions/"+version1.String()+"/files/doc.json", nil, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"a":1}`, string(data))
+
+	requireError(t, do(t, http.MethodGet, docURL+"/versions/"+version2.String(), nil, ""), http.StatusNotFound, httpconn.ErrorTypeDocumentVersionNotFound)
+	requireError(t, do(t, http.MethodGet, docURL+"/versions/latest/files/missing.txt", nil, ""), http.StatusNotFound, httpconn.ErrorTypeDocumentFileNotFound)
+	requireError(t, do(t, http.MethodGet, server.URL+"/documents/"+uu.IDv7().String()+"/versions", nil, ""), http.StatusNotFound, httpconn.ErrorTypeDocumentNotFound)
+	requireError(t, do(t, http.MethodGet, server.URL+"/documents/invalid/versions", nil, ""), http.StatusBadRequest, httpconn.ErrorTypeBadRequest)
+
+	// Add version
+	addVersion := func(fields map[string][]string, files map[string]string, header ...string) *http.Response {
+		fields["userID"] = []string{userID.String()}
+		body, contentType := multipartBody(t, fields, files)
+		return do(t, http.MethodPost, docURL+"/versions", body, contentType, header...)
+	}
+	requireError(t,
+		addVersion(map[string][]string{"expectedPrev": {version2.String()}}, map[string]string{"doc.pdf": "pdf2"}),
+		http.StatusPreconditionFailed, httpconn.ErrorTypeDocumentChanged,
+	)
+	requireError(t,
+		addVersion(map[string][]string{}, map[string]string{"doc.pdf": "pdf"}),
+		http.StatusUnprocessableEntity, httpconn.ErrorTypeNoChanges,
+	)
+	resp = addVersion(
+		map[string][]string{"reason": {"update"}, "version": {version2.String()}, "expectedPrev": {version1.String()}, "remove": {"doc.json"}},
+		map[string]string{"doc.pdf": "pdf2"},
+		httpconn.IdempotencyKeyHeader, "key",
+	)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	info = decode[docdb.VersionInfo](t, resp)
+	require.Equal(t, version2, info.Version)
+	require.Equal(t, []string{"doc.pdf"}, info.ModifiedFiles)
+	require.Equal(t, []string{"doc.json"}, info.RemovedFiles)
+	require.Equal(t, "key", info.IdempotencyKey)
+
+	resp = addVersion(map[string][]string{}, map[string]string{"other.txt": "x"}, httpconn.IdempotencyKeyHeader, "key")
+	require.Equal(t, http.StatusOK, resp.StatusCode, "replayed")
+	require.Equal(t, version2, decode[docdb.VersionInfo](t, resp).Version, "replayed version")
+
+	// Company
+	newCompanyID := uu.IDv7()
+	resp = do(t, http.MethodPut, docURL+"/company", bytes.NewReader([]byte(`"`+newCompanyID.String()+`"`)), "application/json")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Equal(t, newCompanyID, decode[uu.ID](t, do(t, http.MethodGet, docURL+"/company", nil, "")))
+
+	// Delete
+	resp = do(t, http.MethodDelete, docURL+"/versions/"+version2.String(), nil, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []docdb.VersionTime{version1}, decode[[]docdb.VersionTime](t, resp))
+	require.Equal(t, http.StatusNoContent, do(t, http.MethodDelete, docURL, nil, "").StatusCode)
+	requireError(t, do(t, http.MethodDelete, docURL, nil, ""), http.StatusNotFound, httpconn.ErrorTypeDocumentNotFound)
+	require.Equal(t, []uu.ID{}, []uu.ID(decode[uu.IDSlice](t, do(t, http.MethodGet, server.URL+"/companies", nil, ""))))
+}
+
+func TestHandlerReadonly(t *testing.T) {
+	server := httptest.NewServer(httpconn.NewHandler(docdb.ReadonlyConn(memconn.New())))
+	t.Cleanup(server.Close)
+
+	resp := do(t, http.MethodDelete, server.URL+"/documents/"+uu.IDv7().String(), nil, "")
+	requireError(t, resp, http.StatusForbidden, httpconn.ErrorTypeReadonly)
+}
+
+func TestHandlerRequestSize(t *testing.T) {
+	var (
+		conn      = memconn.New()
+		server    = httptest.NewServer(httpconn.NewHandler(conn, httpconn.WithMaxRequestBodySize(1<<20)))
+		companyID = uu.IDv7()
+		userID    = uu.IDv7()
+	)
+	t.Cleanup(server.Close)
+
+	t.Run("file larger than the body limit", func(t *testing.T) {
+		docURL := server.URL + "/documents/" + uu.IDv7().String()
+		body, contentType := multipartBody(t,
+			map[string][]string{"companyID": {companyID.String()}, "userID": {userID.String()}},
+			map[string]string{"doc.pdf": strings.Repeat("x", 2<<20)},
+		)
+		requireError(t, do(t, http.MethodPost, docURL, body, contentType), http.StatusRequestEntityTooLarge, httpconn.ErrorTypeRequestTooLarge)
+	})
+
+	t.Run("JSON body larger than the body limit", func(t *testing.T) {
+		docURL := server.URL + "/documents/" + uu.IDv7().String()
+		body := `{"id":"` + strings.Repeat("x", 2<<20) + `"}`
+		requireError(t, do(t, http.MethodPut, docURL, strings.NewReader(body), "application/json"), http.StatusRequestEntityTooLarge, httpconn.ErrorTypeRequestTooLarge)
+	})
+
+	t.Run("form field larger than 64 KiB", func(t *testing.T) {
+		docURL := server.URL + "/documents/" + uu.IDv7().String()
+		body, contentType := multipartBody(t,
+			map[string][]string{"companyID": {companyID.String()}, "userID": {userID.String()}, "reason": {strings.Repeat("x", 64<<10+1)}},
+			map[string]string{"doc.pdf": "pdf"},
+		)
+		requireError(t, do(t, http.MethodPost, docURL, body, contentType), http.StatusBadRequest, httpconn.ErrorTypeBadRequest)
+	})
+
+	t.Run("form field of 64 KiB", func(t *testing.T) {
+		docID := uu.IDv7()
+		reason := strings.Repeat("x", 64<<10)
+		body, contentType := multipartBody(t,
+			map[string][]string{"companyID": {companyID.String()}, "userID": {userID.String()}, "reason": {reason}},
+			map[string]string{"doc.pdf": "pdf"},
+		)
+		resp := do(t, http.MethodPost, server.URL+"/documents/"+docID.String(), body, contentType)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		require.Equal(t, reason, decode[docdb.VersionInfo](t, resp).CommitReason)
+	})
+}
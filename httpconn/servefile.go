@@ -0,0 +1,139 @@
+package httpconn
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-types/uu"
+)
+
+// ServeDocumentVersionFile serves a file of a document version
+// with the content hash of the file as strong ETag.
+//
+// Requests with a matching If-None-Match header are answered
+// with status 304 without reading the file.
+// Byte range requests are supported with http.ServeContent.
+// If the reader returned by conn.OpenDocumentVersionFile implements io.Seeker,
+// like the ones of localfsdb and s3store, only the requested ranges are read.
+// Otherwise skipped bytes are read and discarded.
+//
+// The Content-Type is inferred from the filename extension
+// and defaults to "application/octet-stream".
+func ServeDocumentVersionFile(w http.ResponseWriter, r *http.Request, conn docdb.Conn, docID uu.ID, version docdb.VersionTime, filename string) {
+	info, err := conn.DocumentVersionInfo(r.Context(), docID, version)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	file, ok := info.Files[filename]
+	if !ok {
+		writeError(w, docdb.NewErrDocumentFileNotFound(docID, filename))
+		return
+	}
+
+	etag := `"` + file.Hash + `"`
+	w.Header().Set("ETag", etag)
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	reader, err := conn.OpenDocumentVersionFile(r.Context(), docID, version, filename)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	content, ok := reader.(io.ReadSeekCloser)
+	if !ok {
+		content = &discardSeeker{
+			open: func() (io.ReadCloser, error) {
+				return conn.OpenDocumentVersionFile(r.Context(), docID, version, filename)
+			},
+			reader: reader,
+			size:   file.Size,
+		}
+	}
+	defer content.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(filename))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, filename, time.Time{}, content)
+}
+
+// etagMatches reports if the value of an If-None-Match header
+// contains etag or is "*".
+// Weak ETags match because the comparison is weak for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// discardSeeker implements io.ReadSeeker for a reader that can't seek
+// by discarding bytes to seek forward and by reopening the reader
+// to seek backward.
+type discardSeeker struct {
+	open    func() (io.ReadCloser, error)
+	reader  io.ReadCloser
+	readPos int64 // position of reader
+	pos     int64 // position of the next Read
+	size    int64
+}
+
+func (s *discardSeeker) Read(p []byte) (n int, err error) {
+	if s.pos < s.readPos {
+		s.reader.Close()
+		s.reader = nil
+		reader, err := s.open()
+		if err != nil {
+			return 0, err
+		}
+		s.reader = reader
+		s.readPos = 0
+	}
+	if s.pos > s.readPos {
+		skipped, err := io.CopyN(io.Discard, s.reader, s.pos-s.readPos)
+		s.readPos += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err = s.reader.Read(p)
+	s.readPos += int64(n)
+	s.pos = s.readPos
+	return n, err
+}
+
+func (s *discardSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to negative offset")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *discardSeeker) Close() error {
+	if s.reader == nil {
+		return nil
+	}
+	return s.reader.Close()
+}
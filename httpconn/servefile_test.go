@@ -0,0 +1,94 @@
+package httpconn_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/docdbtest"
+	"github.com/domonda/go-docdb/httpconn"
+	"github.com/domonda/go-docdb/localfsdb"
+	"github.com/domonda/go-docdb/memconn"
+	"github.com/domonda/go-types/uu"
+)
+
+func TestServeDocumentVersionFile(t *testing.T) {
+	backends := map[string]func(t *testing.T) docdb.Conn{
+		"seekable localfsdb":   func(t *testing.T) docdb.Conn { return localfsdb.NewTestConn(t) },
+		"non-seekable memconn": func(t *testing.T) docdb.Conn { return memconn.New() },
+	}
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			conn := newBackend(t)
+			server := httptest.NewServer(httpconn.NewHandler(conn))
+			t.Cleanup(server.Close)
+
+			docID := uu.IDv7()
+			content := "0123456789"
+			err := conn.CreateDocument(t.Context(), uu.IDv7(), docID, uu.IDv7(), "create", version1, []fs.FileReader{
+				fs.NewMemFile("doc.txt", []byte(content)),
+				fs.NewMemFile("data.unknownext", []byte("data")),
+			}, docdbtest.NoopOnNewVersion)
+			require.NoError(t, err)
+			fileURL := server.URL + "/documents/" + docID.String() + "/versions/" + version1.String() + "/files/doc.txt"
+			etag := `"` + docdb.ContentHash([]byte(content)) + `"`
+
+			resp := do(t, http.MethodGet, fileURL, nil, "")
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, etag, resp.Header.Get("ETag"))
+			require.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+			require.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+			data, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, content, string(data))
+
+			resp = do(t, http.MethodHead, fileURL, nil, "")
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, "10", resp.Header.Get("Content-Length"))
+
+			resp = do(t, http.MethodGet, fileURL, nil, "", "If-None-Match", `"other", `+etag)
+			require.Equal(t, http.StatusNotModified, resp.StatusCode)
+			require.Equal(t, etag, resp.Header.Get("ETag"))
+			resp = do(t, http.MethodGet, fileURL, nil, "", "If-None-Match", `"other"`)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			resp = do(t, http.MethodGet, fileURL, nil, "", "Range", "bytes=7-")
+			require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+			require.Equal(t, "bytes 7-9/10", resp.Header.Get("Content-Range"))
+			data, err = io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, "789", string(data))
+
+			resp = do(t, http.MethodGet, fileURL, nil, "", "Range", "bytes=-2")
+			require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+			data, err = io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, "89", string(data))
+
+			// Multiple ranges seek backward from the end of the first range
+			resp = do(t, http.MethodGet, fileURL, nil, "", "Range", "bytes=6-7,1-2")
+			require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+			data, err = io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(data), "67")
+			require.Contains(t, string(data), "12")
+
+			resp = do(t, http.MethodGet, fileURL, nil, "", "Range", "bytes=20-")
+			require.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+
+			resp = do(t, http.MethodGet, server.URL+"/documents/"+docID.String()+"/versions/latest/files/data.unknownext", nil, "")
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+
+			requireError(t,
+				do(t, http.MethodGet, server.URL+"/documents/"+docID.String()+"/versions/latest/files/missing.txt", nil, ""),
+				http.StatusNotFound, httpconn.ErrorTypeDocumentFileNotFound,
+			)
+		})
+	}
+}
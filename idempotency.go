@@ -0,0 +1,86 @@
+package docdb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+)
+
+type idempotencyKeyCtxKey struct{}
+
+// ContextWithIdempotencyKey returns a context that makes
+// CreateDocument, AddDocumentVersion, AddDocumentVersionIfLatest,
+// and AddMultiDocumentVersion idempotent per document.
+//
+// The key is stored in the VersionInfo of the new version.
+// If a version of the document was already committed with the same key,
+// the call creates no new version and does not call createVersion.
+// Instead onNewVersion is called with the VersionInfo of the original
+// version and its result returned, so a redelivered request receives
+// the same VersionInfo as the original one, for example via
+// CaptureNewVersionInfo. Returning an error from onNewVersion
+// does not remove the original version.
+//
+// Keys are compared per document, the same key can be used
+// for versions of different documents.
+// An empty key disables the idempotency check.
+func ContextWithIdempotencyKey(parent context.Context, key string) context.Context {
+	return context.WithValue(parent, idempotencyKeyCtxKey{}, key)
+}
+
+// IdempotencyKeyFromContext returns the key set with
+// ContextWithIdempotencyKey or an empty string.
+func IdempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyCtxKey{}).(string)
+	return key
+}
+
+// idempotentVersionInfoLookup is implemented by Conn
+// implementations that can look up an idempotency key
+// without reading every version of a document.
+type idempotentVersionInfoLookup interface {
+	IdempotentVersionInfo(ctx context.Context, docID uu.ID, key string) (*VersionInfo, error)
+}
+
+// IdempotentVersionInfo returns the VersionInfo of the version
+// of a document that was committed with the idempotency key
+// or nil if there is no such version or document.
+//
+// If conn implements a method
+//
+//	IdempotentVersionInfo(ctx context.Context, docID uu.ID, key string) (*VersionInfo, error)
+//
+// that looks up the key with an index, then that method is used.
+// Else the versions are checked from the latest to the first,
+// because a redelivered request usually matches a recent version.
+// Conn implementations use it to check for an existing version
+// before committing a new one with the key.
+func IdempotentVersionInfo(ctx context.Context, conn Conn, docID uu.ID, key string) (versionInfo *VersionInfo, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, conn, docID, key)
+
+	if key == "" {
+		return nil, nil
+	}
+	if lookup, ok := conn.(idempotentVersionInfoLookup); ok {
+		return lookup.IdempotentVersionInfo(ctx, docID, key)
+	}
+	versions, err := conn.DocumentVersions(ctx, docID)
+	if err != nil {
+		if errors.As(err, new(ErrDocumentNotFound)) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		versionInfo, err = conn.DocumentVersionInfo(ctx, docID, versions[i])
+		if err != nil {
+			return nil, err
+		}
+		if versionInfo.IdempotencyKey == key {
+			return versionInfo, nil
+		}
+	}
+	return nil, nil
+}
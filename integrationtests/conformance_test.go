@@ -0,0 +1,43 @@
+package integrationtests
+
+import (
+	"testing"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/docdbtest"
+	"github.com/domonda/go-docdb/storeconn"
+	"github.com/domonda/go-docdb/storeconn/pgstore"
+	"github.com/domonda/go-docdb/storeconn/pgstore/pgfixtures"
+	"github.com/domonda/go-docdb/storeconn/s3store"
+	"github.com/domonda/go-docdb/storeconn/s3store/s3fixtures"
+)
+
+// TestStoreconnConformance runs the docdbtest conformance tests against
+// both layouts of the Postgres + S3 backed storeconn.Conn.
+// Every sub-test runs in its own rolled-back transaction.
+func TestStoreconnConformance(t *testing.T) {
+	t.Run("storeconn", func(t *testing.T) {
+		docdbtest.RunConnTests(t,
+			func(t *testing.T) docdb.Conn {
+				s3fixtures.FixtureCleanBucket(t)
+				return storeconn.New(
+					s3fixtures.FixtureGlobalDocumentStore(t),
+					pgstore.NewMetadataStore(),
+				)
+			},
+			docdbtest.WithContext(pgfixtures.FixtureCtxWithTestTx),
+		)
+	})
+
+	t.Run("storeconn-content-addressed", func(t *testing.T) {
+		docdbtest.RunConnTests(t,
+			func(t *testing.T) docdb.Conn {
+				return storeconn.NewContentAddressed(
+					s3store.NewBlobStore(s3fixtures.FixtureCleanBucket(t), s3fixtures.FixtureGlobalS3Client(t)),
+					pgstore.NewMetadataStore(),
+				)
+			},
+			docdbtest.WithContext(pgfixtures.FixtureCtxWithTestTx),
+		)
+	})
+}
@@ -0,0 +1,99 @@
+package integrationtests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/docdbtest"
+	"github.com/domonda/go-docdb/faultconn"
+	"github.com/domonda/go-docdb/storeconn"
+	"github.com/domonda/go-docdb/storeconn/pgstore"
+	"github.com/domonda/go-docdb/storeconn/pgstore/pgfixtures"
+	"github.com/domonda/go-docdb/storeconn/s3store"
+	"github.com/domonda/go-docdb/storeconn/s3store/s3fixtures"
+	"github.com/domonda/go-types/uu"
+)
+
+// TestStoreconnFaults checks that storeconn leaves no trace
+// of a write that failed in one of its stores.
+func TestStoreconnFaults(t *testing.T) {
+	newConn := func(t *testing.T, rules ...faultconn.Rule) (docdb.Conn, storeconn.DocumentStore) {
+		documentStore := s3store.NewDocumentStore(s3fixtures.FixtureCleanBucket(t), s3fixtures.FixtureGlobalS3Client(t))
+		inj := faultconn.NewInjector(rules...)
+		return storeconn.New(
+			faultconn.NewDocumentStore(documentStore, inj),
+			faultconn.NewMetadataStore(pgstore.NewMetadataStore(), inj),
+		), documentStore
+	}
+
+	t.Run("CreateDocument deletes uploaded files if metadata fails", func(t *testing.T) {
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		conn, documentStore := newConn(t, faultconn.Rule{
+			Methods: []string{"MetadataStore.CreateDocumentVersion"},
+			Err:     faultconn.ErrInjected,
+		})
+		docID := uu.IDv7()
+
+		err := docdbtest.CreateDocument(ctx, conn, docID)
+		require.ErrorIs(t, err, faultconn.ErrInjected)
+
+		exists, err := documentStore.DocumentExists(ctx, docID)
+		require.NoError(t, err)
+		require.False(t, exists)
+		exists, err = conn.DocumentExists(ctx, docID)
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
+	t.Run("AddDocumentVersion removes metadata if file upload fails", func(t *testing.T) {
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		// The first upload creates the document, the second one fails
+		conn, _ := newConn(t, faultconn.Rule{
+			Methods: []string{"DocumentStore.CreateDocumentVersion"},
+			Nth:     2,
+			Err:     faultconn.ErrInjected,
+		})
+		docID := uu.IDv7()
+		err := docdbtest.CreateDocument(ctx, conn, docID)
+		require.NoError(t, err)
+
+		err = conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "add",
+			docdb.CreateVersionWriteFiles(fs.NewMemFile("a.txt", []byte("a"))),
+			docdbtest.NoopOnNewVersion,
+		)
+		require.ErrorIs(t, err, faultconn.ErrInjected)
+
+		versions, err := conn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, []docdb.VersionTime{docdbtest.Version1}, versions)
+	})
+
+	t.Run("content-addressed AddDocumentVersion removes metadata if blob write fails", func(t *testing.T) {
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		inj := faultconn.NewInjector(faultconn.Rule{
+			Methods: []string{"BlobStore.WriteBlob"},
+			Nth:     2,
+			Err:     faultconn.ErrInjected,
+		})
+		conn := storeconn.NewContentAddressed(
+			faultconn.NewBlobStore(s3store.NewBlobStore(s3fixtures.FixtureCleanBucket(t), s3fixtures.FixtureGlobalS3Client(t)), inj),
+			pgstore.NewMetadataStore(),
+		)
+		docID := uu.IDv7()
+		err := docdbtest.CreateDocument(ctx, conn, docID)
+		require.NoError(t, err)
+
+		err = conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "add",
+			docdb.CreateVersionWriteFiles(fs.NewMemFile("a.txt", []byte("a"))),
+			docdbtest.NoopOnNewVersion,
+		)
+		require.ErrorIs(t, err, faultconn.ErrInjected)
+
+		versions, err := conn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, []docdb.VersionTime{docdbtest.Version1}, versions)
+	})
+}
@@ -0,0 +1,59 @@
+package integrationtests
+
+import (
+	"testing"
+
+	"github.com/domonda/go-docdb/docdbtest"
+	"github.com/domonda/go-docdb/localfsdb"
+	"github.com/domonda/go-docdb/memconn"
+	"github.com/domonda/go-docdb/storeconn"
+	"github.com/domonda/go-docdb/storeconn/pgstore"
+	"github.com/domonda/go-docdb/storeconn/pgstore/pgfixtures"
+	"github.com/domonda/go-docdb/storeconn/s3store"
+	"github.com/domonda/go-docdb/storeconn/s3store/s3fixtures"
+)
+
+// FuzzLocalConns compares localfsdb and memconn
+// with the docdbtest reference model:
+//
+//	go test ./integrationtests -run '^$' -fuzz FuzzLocalConns
+func FuzzLocalConns(f *testing.F) {
+	docdbtest.FuzzConns(f, func(t *testing.T) []docdbtest.NamedConn {
+		return []docdbtest.NamedConn{
+			{Name: "localfsdb", Conn: localfsdb.NewTestConn(t)},
+			{Name: "memconn", Conn: memconn.New()},
+		}
+	})
+}
+
+// FuzzStoreconn compares the Postgres + S3 backed storeconn.Conn
+// with memconn and the docdbtest reference model.
+// The storeconn layouts are fuzzed separately because
+// they share the Postgres metadata of the test transaction.
+func FuzzStoreconn(f *testing.F) {
+	docdbtest.FuzzConns(f,
+		func(t *testing.T) []docdbtest.NamedConn {
+			s3fixtures.FixtureCleanBucket(t)
+			return []docdbtest.NamedConn{
+				{Name: "memconn", Conn: memconn.New()},
+				{Name: "storeconn", Conn: storeconn.New(s3fixtures.FixtureGlobalDocumentStore(t), pgstore.NewMetadataStore())},
+			}
+		},
+		docdbtest.WithContext(pgfixtures.FixtureCtxWithTestTx),
+	)
+}
+
+// FuzzStoreconnContentAddressed is FuzzStoreconn
+// for the content-addressed storeconn layout.
+func FuzzStoreconnContentAddressed(f *testing.F) {
+	docdbtest.FuzzConns(f,
+		func(t *testing.T) []docdbtest.NamedConn {
+			blobStore := s3store.NewBlobStore(s3fixtures.FixtureCleanBucket(t), s3fixtures.FixtureGlobalS3Client(t))
+			return []docdbtest.NamedConn{
+				{Name: "memconn", Conn: memconn.New()},
+				{Name: "storeconn-content-addressed", Conn: storeconn.NewContentAddressed(blobStore, pgstore.NewMetadataStore())},
+			}
+		},
+		docdbtest.WithContext(pgfixtures.FixtureCtxWithTestTx),
+	)
+}
@@ -12,9 +12,11 @@ import (
 
 	"github.com/domonda/go-docdb"
 	"github.com/domonda/go-docdb/localfsdb"
+	"github.com/domonda/go-docdb/memconn"
 	"github.com/domonda/go-docdb/storeconn"
 	"github.com/domonda/go-docdb/storeconn/pgstore"
 	"github.com/domonda/go-docdb/storeconn/pgstore/pgfixtures"
+	"github.com/domonda/go-docdb/storeconn/s3store"
 	"github.com/domonda/go-docdb/storeconn/s3store/s3fixtures"
 	"github.com/domonda/go-types/uu"
 )
@@ -31,14 +33,17 @@ func TestMain(m *testing.M) {
 // exercise as both source and destination connection.
 type syncBackend struct {
 	name string
-	// storeconn is true for the Postgres + S3 backed storeconn.Conn and
-	// false for the local filesystem localfsdb.Conn. The flag is also used
+	// storeconn is true for the Postgres + S3 backed storeconn.Conn
+	// in both its per-document and content-addressed layout and
+	// false for the local filesystem localfsdb.Conn and the in-memory
+	// memconn.Conn. The flag is also used
 	// to detect the storeconn->storeconn combination, where source and
-	// destination share a single backend.
+	// destination share a single Postgres metadata store.
 	storeconn bool
 	// newConn builds a fresh Conn. Every backend registers its own cleanup
 	// so nothing survives the test:
 	//   - localfsdb.NewTestConn removes its temp directory on t.Cleanup.
+	//   - memconn.New keeps everything in memory.
 	//   - the storeconn backend uses s3fixtures.FixtureCleanBucket, which
 	//     deletes the bucket and all of its objects on t.Cleanup, and runs
 	//     inside the rolled-back transaction of pgfixtures.FixtureCtxWithTestTx.
@@ -53,6 +58,12 @@ func syncBackends() []syncBackend {
 				return localfsdb.NewTestConn(t)
 			},
 		},
+		{
+			name: "memconn",
+			newConn: func(t *testing.T) docdb.Conn {
+				return memconn.New()
+			},
+		},
 		{
 			name:      "storeconn",
 			storeconn: true,
@@ -67,13 +78,23 @@ func syncBackends() []syncBackend {
 				)
 			},
 		},
+		{
+			name:      "storeconn-content-addressed",
+			storeconn: true,
+			newConn: func(t *testing.T) docdb.Conn {
+				return storeconn.NewContentAddressed(
+					s3store.NewBlobStore(s3fixtures.FixtureCleanBucket(t), s3fixtures.FixtureGlobalS3Client(t)),
+					pgstore.NewMetadataStore(),
+				)
+			},
+		},
 	}
 }
 
 // syncTestContext returns the context used by a sync test. When any backend is
 // the storeconn backend the context must carry the Postgres test transaction
 // so that every metadata write is rolled back on t.Cleanup. Tests that only
-// use localfsdb need no database and get a plain context.
+// use localfsdb or memconn need no database and get a plain context.
 func syncTestContext(t *testing.T, backends ...syncBackend) context.Context {
 	for _, b := range backends {
 		if b.storeconn {
@@ -148,7 +169,7 @@ func countDocIDsInError(err error, docIDs uu.IDSlice) int {
 }
 
 // TestSyncDocument syncs a multi-version document for every combination of
-// localfsdb and storeconn as source and destination connection.
+// the syncBackends as source and destination connection.
 func TestSyncDocument(t *testing.T) {
 	for _, src := range syncBackends() {
 		for _, dst := range syncBackends() {
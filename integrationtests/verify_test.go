@@ -0,0 +1,75 @@
+package integrationtests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-types/uu"
+)
+
+// TestVerify checks that documents written through every backend,
+// including removed files, a company change with a new version
+// and a move with SetDocumentCompanyID, verify without problems.
+func TestVerify(t *testing.T) {
+	for _, backend := range syncBackends() {
+		t.Run(backend.name, func(t *testing.T) {
+			ctx := syncTestContext(t, backend)
+			conn := backend.newConn(t)
+
+			var (
+				companyID  = uu.IDv7()
+				companyID2 = uu.IDv7()
+				docID1     = uu.IDv7()
+				docID2     = uu.IDv7()
+				userID     = uu.IDv7()
+			)
+			createSyncTestDoc(t, ctx, conn, companyID, docID1, userID, "doc1")
+			createSyncTestDoc(t, ctx, conn, companyID, docID2, userID, "doc2")
+
+			err := conn.AddDocumentVersion(
+				ctx, docID2, userID, "remove file and move company",
+				func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+					return &docdb.CreateVersionResult{
+						Version:      docdb.MustVersionTimeFromString("2024-01-01_00-00-00.002"),
+						WriteFiles:   []fs.FileReader{fs.NewMemFile("a.txt", []byte("doc2-a-modified"))},
+						RemoveFiles:  []string{"b.txt"},
+						NewCompanyID: uu.NullableID(companyID2),
+					}, nil
+				},
+				func(context.Context, *docdb.VersionInfo) error { return nil },
+			)
+			require.NoError(t, err)
+
+			// Moves the document without adding a version
+			// for localfsdb and memconn
+			err = conn.SetDocumentCompanyID(ctx, docID1, companyID2)
+			require.NoError(t, err)
+
+			var verified uu.IDSlice
+			reports, err := docdb.Verify(ctx, conn, func(ctx context.Context, docID uu.ID, index, total int) {
+				verified = append(verified, docID)
+			})
+			require.NoError(t, err)
+			require.ElementsMatch(t, uu.IDSlice{docID1, docID2}, verified)
+			wantVersions := map[uu.ID]int{docID1: 2, docID2: 3}
+			for _, report := range reports {
+				require.True(t, report.OK(), report.String())
+				require.Len(t, report.Versions, wantVersions[report.DocID])
+			}
+
+			report, err := docdb.VerifyDocument(ctx, conn, docID2)
+			require.NoError(t, err)
+			require.True(t, report.OK(), report.String())
+			require.Equal(t, companyID2, report.CompanyID)
+
+			report, err = docdb.VerifyDocument(ctx, conn, docID1)
+			require.NoError(t, err)
+			require.True(t, report.OK(), report.String())
+			require.Equal(t, companyID2, report.CompanyID)
+		})
+	}
+}
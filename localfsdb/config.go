@@ -1,6 +1,8 @@
 package localfsdb
 
 import (
+	"time"
+
 	"github.com/domonda/go-types/uu"
 	rootlog "github.com/domonda/golog/log"
 )
@@ -10,3 +12,46 @@ var (
 
 	docWriteMtx = uu.NewIDMutex()
 )
+
+// DefaultLockStaleAfter is the duration after which a lock file
+// that has not been refreshed by its owner is considered stale
+// if zero is passed to WithFileLocks.
+const DefaultLockStaleAfter = time.Minute
+
+// Option configures a Conn created by NewConn.
+type Option func(*Conn)
+
+// WithFileLocks enables cross-process locking of document writes so that
+// multiple processes can safely share the same documents directory,
+// for example via an NFS mount.
+//
+// Writes to a document are serialized in-process by a mutex per document.
+// With file locks enabled, every write additionally creates a lock file
+// for the document in the hidden ".locks" sub-directory of documentsDir
+// and waits until no other process holds the lock.
+//
+// Lock files are refreshed while held. A lock file that was not refreshed
+// for staleAfter, for example because its process crashed, is removed
+// by the next writer. staleAfter must be well above the clock skew
+// between the hosts sharing the directory. Zero means DefaultLockStaleAfter.
+func WithFileLocks(staleAfter time.Duration) Option {
+	if staleAfter <= 0 {
+		staleAfter = DefaultLockStaleAfter
+	}
+	return func(c *Conn) {
+		c.lockStaleAfter = staleAfter
+	}
+}
+
+// WithRecovery runs Conn.Recover when the Conn is created by NewConn
+// to find and, if repair is true, repair state left behind by a crashed
+// or killed process. Found issues and errors are logged.
+//
+// Recover checks every document, so opening a large store takes
+// accordingly longer.
+func WithRecovery(repair bool) Option {
+	return func(c *Conn) {
+		c.recoverOnOpen = true
+		c.recoverRepair = repair
+	}
+}
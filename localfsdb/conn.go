@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"slices"
 	"testing"
+	"time"
 
 	"github.com/ungerik/go-fs"
 	"github.com/ungerik/go-fs/uuiddir"
@@ -28,9 +30,21 @@ type Conn struct {
 	// Directories are used as atomic, threadsafe filesystem level
 	// mapping mechanism between companyID and docID.
 	companiesDir fs.File
+
+	// lockStaleAfter is set by WithFileLocks
+	lockStaleAfter time.Duration
+	// fileLocker is nil if file locks are not enabled
+	fileLocker *fileLocker
+
+	// recoverOnOpen and recoverRepair are set by WithRecovery
+	recoverOnOpen bool
+	recoverRepair bool
 }
 
-func NewConn(documentsDir, companiesDir fs.File) *Conn {
+// NewConn returns a Conn storing documents in documentsDir
+// and the company to document mapping in companiesDir.
+// Both directories must exist on the local file-system.
+func NewConn(documentsDir, companiesDir fs.File, options ...Option) *Conn {
 	if !documentsDir.IsDir() {
 		panic("documentsDir does not exist: '" + string(documentsDir) + "'")
 	}
@@ -43,10 +57,24 @@ func NewConn(documentsDir, companiesDir fs.File) *Conn {
 	if companiesDir.FileSystem() != fs.Local {
 		panic("companiesDir is not on local file-system: '" + string(companiesDir) + "'")
 	}
-	return &Conn{
+	c := &Conn{
 		documentsDir: documentsDir,
 		companiesDir: companiesDir,
 	}
+	for _, option := range options {
+		option(c)
+	}
+	if c.lockStaleAfter > 0 {
+		var err error
+		c.fileLocker, err = newFileLocker(documentsDir, c.lockStaleAfter)
+		if err != nil {
+			panic(fmt.Sprintf("can't create lock files directory in '%s': %s", documentsDir, err))
+		}
+	}
+	if c.recoverOnOpen {
+		c.recover(c.recoverRepair)
+	}
+	return c
 }
 
 // NewTestConn creates a new db in a temporary
@@ -91,6 +119,25 @@ func (c *Conn) String() string {
 	)
 }
 
+// lockDocument acquires the in-process write mutex of a document and,
+// if enabled by WithFileLocks, its cross-process lock file.
+// The returned unlock function releases both.
+func (c *Conn) lockDocument(ctx context.Context, docID uu.ID) (unlock func(), err error) {
+	docWriteMtx.Lock(docID)
+	if c.fileLocker == nil {
+		return func() { docWriteMtx.Unlock(docID) }, nil
+	}
+	unlockFile, err := c.fileLocker.lock(ctx, docID)
+	if err != nil {
+		docWriteMtx.Unlock(docID)
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		docWriteMtx.Unlock(docID)
+	}, nil
+}
+
 func (c *Conn) documentDir(docID uu.ID) fs.File {
 	return uuiddir.Join(c.documentsDir, docID)
 }
@@ -275,8 +322,11 @@ func (c *Conn) SetDocumentCompanyID(ctx context.Context, docID, companyID uu.ID)
 		return err
 	}
 
-	docWriteMtx.Lock(docID)
-	defer docWriteMtx.Unlock(docID)
+	unlock, err := c.lockDocument(ctx, docID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	return c.setDocumentCompanyID(ctx, docID, companyID)
 }
@@ -318,7 +368,7 @@ func (c *Conn) setDocumentCompanyID(ctx context.Context, docID, companyID uu.ID)
 		}
 	}
 
-	err = docDir.Join("company.id").WriteAllString(companyID.String())
+	err = c.writeFileAtomic(ctx, docID, docDir.Join("company.id"), companyID.StringBytes())
 	if err != nil {
 		return err
 	}
@@ -488,6 +538,51 @@ func (c *Conn) ReadDocumentVersionFile(ctx context.Context, docID uu.ID, version
 	return file.ReadAllContext(ctx)
 }
 
+func (c *Conn) OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (reader io.ReadCloser, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version, filename)
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	_, versionDir, err := c.documentAndVersionDir(docID, version)
+	if err != nil {
+		return nil, err
+	}
+	file := versionDir.Join(filename)
+	if !file.Exists() {
+		return nil, docdb.NewErrDocumentFileNotFound(docID, filename)
+	}
+	return file.OpenReader()
+}
+
+func (c *Conn) ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string, offset, length int64) (data []byte, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version, filename, offset, length)
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, docdb.ErrNegativeOffset
+	}
+
+	_, versionDir, err := c.documentAndVersionDir(docID, version)
+	if err != nil {
+		return nil, err
+	}
+	file := versionDir.Join(filename)
+	if !file.Exists() {
+		return nil, docdb.NewErrDocumentFileNotFound(docID, filename)
+	}
+	reader, err := file.OpenReadSeeker()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return docdb.ReadRange(reader, offset, length)
+}
+
 func (c *Conn) DocumentVersionFileProvider(ctx context.Context, docID uu.ID, version docdb.VersionTime) (p docdb.FileProvider, err error) {
 	defer errs.WrapWithFuncParams(&err, ctx, docID, version)
 
@@ -508,8 +603,11 @@ func (c *Conn) DeleteDocument(ctx context.Context, docID uu.ID) (err error) {
 	if ctx.Err() != nil {
 		return ctx.Err()
 	}
-	docWriteMtx.Lock(docID)
-	defer docWriteMtx.Unlock(docID)
+	unlock, err := c.lockDocument(ctx, docID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	log.InfoCtx(ctx, "DeleteDocument").
 		UUID("docID", docID).
@@ -520,12 +618,18 @@ func (c *Conn) DeleteDocument(ctx context.Context, docID uu.ID) (err error) {
 		return docdb.NewErrDocumentNotFound(docID)
 	}
 
-	companyID, err := c.documentCompanyID(ctx, docID)
-	if err == nil {
-		err = uuiddir.Remove(c.companiesDir.Join(companyID.String()), docID)
+	// Removing the document directory commits the deletion,
+	// the company document marker is removed afterwards
+	// so that a crash in between leaves only a stale marker
+	companyID, companyErr := c.documentCompanyID(ctx, docID)
+	err = c.removeDocumentDir(ctx, docID, docDir)
+	if err != nil {
+		return err
 	}
-
-	return errors.Join(err, uuiddir.RemoveDir(c.documentsDir, docDir))
+	if companyErr != nil {
+		return companyErr
+	}
+	return uuiddir.Remove(c.companiesDir.Join(companyID.String()), docID)
 }
 
 func (c *Conn) DeleteDocumentVersion(ctx context.Context, docID uu.ID, version docdb.VersionTime) (leftVersions []docdb.VersionTime, err error) {
@@ -534,42 +638,42 @@ func (c *Conn) DeleteDocumentVersion(ctx context.Context, docID uu.ID, version d
 	if ctx.Err() != nil {
 		return nil, ctx.Err()
 	}
-	docWriteMtx.Lock(docID)
-	defer docWriteMtx.Unlock(docID)
+	unlock, err := c.lockDocument(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	log.InfoCtx(ctx, "DeleteDocumentVersion").
 		UUID("docID", docID).
 		Stringer("version", version).
 		Log()
 
-	docDir, versionDir, err := c.documentAndVersionDir(docID, version)
+	docDir, _, err := c.documentAndVersionDir(docID, version)
 	if err != nil {
 		return nil, err
 	}
 
-	err = versionDir.RemoveRecursive()
+	err = uncommitVersion(docDir, version)
 	if err != nil {
 		return nil, err
 	}
 
-	versionInfoFile := docDir.Joinf("%s.json", version)
-	if versionInfoFile.Exists() {
-		err = errors.Join(err, versionInfoFile.Remove())
+	leftVersions, err = c.documentVersions(ctx, docID)
+	if err != nil {
+		return nil, err
 	}
-
-	leftVersions, lErr := c.documentVersions(ctx, docID)
-	err = errors.Join(err, lErr)
 	if len(leftVersions) == 0 {
-		// If no versions left, delete the company document entry
-		// and the document directory
-		companyID, e := c.documentCompanyID(ctx, docID)
-		if e == nil {
-			e = uuiddir.Remove(c.companiesDir.Join(companyID.String()), docID)
+		// If no versions left, delete the document directory
+		// and the company document entry
+		companyID, companyErr := c.documentCompanyID(ctx, docID)
+		err = c.removeDocumentDir(ctx, docID, docDir)
+		if err == nil {
+			err = companyErr
+		}
+		if err == nil {
+			err = uuiddir.Remove(c.companiesDir.Join(companyID.String()), docID)
 		}
-		err = errors.Join(err, e)
-
-		e = uuiddir.RemoveDir(c.documentsDir, docDir)
-		err = errors.Join(err, e)
 	}
 
 	return leftVersions, err
@@ -664,20 +768,39 @@ func (c *Conn) CreateDocument(ctx context.Context, companyID, docID, userID uu.I
 		return errs.Errorf("cannot create document %s without files", docID)
 	}
 
-	docWriteMtx.Lock(docID)
-	defer docWriteMtx.Unlock(docID)
+	unlock, err := c.lockDocument(ctx, docID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
+	idempotencyKey := docdb.IdempotencyKeyFromContext(ctx)
 	docDir := c.documentDir(docID)
 	if docDir.IsDir() {
+		original, err := docdb.IdempotentVersionInfo(ctx, c, docID, idempotencyKey)
+		if err != nil {
+			return err
+		}
+		if original != nil {
+			return safelyCallOnNewVersionFunc(ctx, original, onNewVersion)
+		}
 		return docdb.NewErrDocumentAlreadyExists(docID)
 	}
 
-	newVersionDir := docDir.Join(newVersion.String())
+	// The complete document directory is written into a staging directory
+	// and then atomically renamed to docDir, so a crash can't leave
+	// a partially written document behind
+	stagingDir, err := c.makeStagingDir(docID)
+	if err != nil {
+		return err
+	}
+	defer removeStagingDir(ctx, stagingDir)
 
+	committed := false
 	defer func() {
 		if err != nil {
-			if docDir.Exists() {
-				e := uuiddir.RemoveDir(c.documentsDir, docDir)
+			if committed {
+				e := c.removeDocumentDir(ctx, docID, docDir)
 				err = errors.Join(err, e)
 			}
 			e := c.removeCompanyDocumentDirIfExists(companyID, docID)
@@ -685,19 +808,15 @@ func (c *Conn) CreateDocument(ctx context.Context, companyID, docID, userID uu.I
 		}
 	}()
 
-	err = newVersionDir.MakeAllDirs()
-	if err != nil {
-		return wrapMakeAllDirsErr(companyID, docID, c.documentsDir, newVersionDir, err)
-	}
-
-	err = docDir.Join("company.id").WriteAll(companyID.StringBytes())
+	newVersionDir := stagingDir.Join(newVersion.String())
+	err = newVersionDir.MakeDir()
 	if err != nil {
 		return err
 	}
 
-	err = c.makeCompanyDocumentDir(companyID, docID)
+	err = stagingDir.Join("company.id").WriteAll(companyID.StringBytes())
 	if err != nil {
-		return wrapMakeAllDirsErr(companyID, docID, c.companiesDir.Join(companyID.String()), c.companyDocumentDir(companyID, docID), err)
+		return err
 	}
 
 	for _, file := range files {
@@ -723,17 +842,43 @@ func (c *Conn) CreateDocument(ctx context.Context, companyID, docID, userID uu.I
 	if err != nil {
 		return err
 	}
-	err = versionInfo.WriteJSON(docDir.Joinf("%s.json", newVersion))
+	versionInfo.IdempotencyKey = idempotencyKey
+	err = versionInfo.WriteJSON(stagingDir.Joinf("%s.json", newVersion))
 	if err != nil {
 		return err
 	}
 
+	err = c.commitDocumentDir(stagingDir, docDir)
+	if err != nil {
+		return wrapMakeAllDirsErr(companyID, docID, c.documentsDir, docDir, err)
+	}
+	committed = true
+
+	err = c.makeCompanyDocumentDir(companyID, docID)
+	if err != nil {
+		return wrapMakeAllDirsErr(companyID, docID, c.companiesDir.Join(companyID.String()), c.companyDocumentDir(companyID, docID), err)
+	}
+
 	return safelyCallOnNewVersionFunc(ctx, versionInfo, onNewVersion)
 }
 
 func (c *Conn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
 	defer errs.WrapWithFuncParams(&err, ctx, docID, userID, reason, createVersion, onNewVersion)
 
+	return c.addDocumentVersion(ctx, docID, nil, userID, reason, createVersion, onNewVersion)
+}
+
+func (c *Conn) AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, expectedPrev, userID, reason, createVersion, onNewVersion)
+
+	return c.addDocumentVersion(ctx, docID, &expectedPrev, userID, reason, createVersion, onNewVersion)
+}
+
+// addDocumentVersion implements AddDocumentVersion and, with a non-nil
+// expectedPrev, AddDocumentVersionIfLatest. The latest version is compared
+// with expectedPrev while holding the document's write lock,
+// so no other version can be added in between.
+func (c *Conn) addDocumentVersion(ctx context.Context, docID uu.ID, expectedPrev *docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
 	if err = ctx.Err(); err != nil {
 		return err
 	}
@@ -750,32 +895,42 @@ func (c *Conn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reas
 		return errs.New("nil onNewVersion func passed to AddDocumentVersion")
 	}
 
-	docWriteMtx.Lock(docID)
-	defer docWriteMtx.Unlock(docID)
+	unlock, err := c.lockDocument(ctx, docID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	// Register the rollback after acquiring the lock so cleanup runs while the
 	// lock is still held (defers are LIFO). Otherwise the unlock would fire
 	// first and a concurrent writer could chain a new version off the
-	// half-written one this call is about to remove.
+	// committed one this call is about to remove.
 	var (
-		newVersionDir      fs.File
-		newVersionInfoFile fs.File
+		docDir    = c.documentDir(docID)
+		committed *docdb.VersionTime
 	)
 	defer func() {
-		if err != nil {
-			if newVersionDir.Exists() {
-				err = errors.Join(err, newVersionDir.RemoveRecursive())
-			}
-			if newVersionInfoFile.Exists() {
-				err = errors.Join(err, newVersionInfoFile.Remove())
-			}
+		if err != nil && committed != nil {
+			err = errors.Join(err, uncommitVersion(docDir, *committed))
 		}
 	}()
 
+	idempotencyKey := docdb.IdempotencyKeyFromContext(ctx)
+	original, err := docdb.IdempotentVersionInfo(ctx, c, docID, idempotencyKey)
+	if err != nil {
+		return err
+	}
+	if original != nil {
+		return safelyCallOnNewVersionFunc(ctx, original, onNewVersion)
+	}
+
 	prevVersionInfo, prevVersionDir, err := c.latestDocumentVersionInfo(ctx, docID)
 	if err != nil {
 		return err
 	}
+	if expectedPrev != nil && !prevVersionInfo.Version.Equal(*expectedPrev) {
+		return docdb.NewErrDocumentChanged(docID, *expectedPrev)
+	}
 
 	result, err := safelyCallCreateVersionFunc(
 		ctx,
@@ -794,38 +949,75 @@ func (c *Conn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reas
 		return errs.Errorf("version %s returned from CreateVersionFunc is not after previous version %s", result.Version, prevVersionInfo.Version)
 	}
 
-	docDir := c.documentDir(docID)
-	newVersionDir = docDir.Join(result.Version.String())
-	newVersionInfoFile = docDir.Joinf("%s.json", result.Version)
+	if versionDir := docDir.Join(result.Version.String()); versionDir.Exists() {
+		if docDir.Joinf("%s.json", result.Version).Exists() {
+			return errs.Errorf("new version %s directory already exists", result.Version)
+		}
+		// A version directory without info JSON file was never committed,
+		// it's left over from a crashed process and can be replaced
+		log.WarnCtx(ctx, "Removing uncommitted version directory").
+			UUID("docID", docID).
+			Stringer("version", result.Version).
+			Log()
+		err = versionDir.RemoveRecursive()
+		if err != nil {
+			return err
+		}
+	}
 
-	if newVersionDir.Exists() {
-		return errs.Errorf("new version %s directory already exists", result.Version)
+	// The new version is written into a staging directory
+	// and committed by renaming it into docDir
+	stagingDir, err := c.makeStagingDir(docID)
+	if err != nil {
+		return err
 	}
+	defer removeStagingDir(ctx, stagingDir)
+
+	newVersionDir := stagingDir.Join(result.Version.String())
 	err = newVersionDir.MakeDir()
 	if err != nil {
 		return err
 	}
 
-	// Copy previous version files that are not in writeFiles or deleteFiles
+	// Link previous version files that are not in writeFiles or deleteFiles
 	for filename := range prevVersionInfo.Files {
 		if fs.NameIndex(result.WriteFiles, filename) >= 0 || slices.Contains(result.RemoveFiles, filename) {
-			continue // Don't copy writeFiles or deleteFiles
+			continue // Don't link writeFiles or deleteFiles
 		}
-		err = fs.CopyFile(ctx, prevVersionDir.Join(filename), newVersionDir)
+		err = linkOrCopyFile(ctx, prevVersionDir.Join(filename), newVersionDir)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Write new files of version
+	// Write new files of version, files written again
+	// with unchanged content are linked like the files above
 	for _, writeFile := range result.WriteFiles {
-		err = fs.CopyFile(ctx, writeFile, newVersionDir)
+		prevFile, hasPrevFile := prevVersionInfo.Files[writeFile.Name()]
+		unchanged := false
+		if hasPrevFile {
+			unchanged, err = hasContent(ctx, writeFile, prevFile)
+			if err != nil {
+				return err
+			}
+		}
+		if unchanged {
+			err = linkOrCopyFile(ctx, prevVersionDir.Join(prevFile.Name), newVersionDir)
+		} else {
+			err = fs.CopyFile(ctx, writeFile, newVersionDir)
+		}
 		if err != nil {
 			return err
 		}
 	}
 
-	companyID := result.NewCompanyID.GetOr(prevVersionInfo.CompanyID)
+	// The company of the document can differ from the company
+	// of the previous version after SetDocumentCompanyID
+	prevCompanyID, err := c.documentCompanyID(ctx, docID)
+	if err != nil {
+		return err
+	}
+	companyID := result.NewCompanyID.GetOr(prevCompanyID)
 
 	// NewVersionInfo reads newVersionDir and prevVersionDir, this could be optimized
 	// by copying and content hashing the files in one loop
@@ -843,6 +1035,7 @@ func (c *Conn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reas
 	if err != nil {
 		return err
 	}
+	versionInfo.IdempotencyKey = idempotencyKey
 
 	if len(versionInfo.Files) == 0 {
 		// Every version must contain at least one file: removing all files of a
@@ -854,13 +1047,18 @@ func (c *Conn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reas
 		return docdb.ErrNoChanges
 	}
 
-	err = versionInfo.WriteJSON(newVersionInfoFile)
+	err = versionInfo.WriteJSON(stagingDir.Joinf("%s.json", result.Version))
 	if err != nil {
 		return err
 	}
+	err = commitVersion(stagingDir, docDir, result.Version)
+	if err != nil {
+		return err
+	}
+	committed = &result.Version
 
 	// Change company as last step after everything else succeeded
-	if companyID != prevVersionInfo.CompanyID {
+	if companyID != prevCompanyID {
 		err = c.setDocumentCompanyID(ctx, docID, companyID)
 		if err != nil {
 			return err
@@ -870,8 +1068,8 @@ func (c *Conn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reas
 	err = safelyCallOnNewVersionFunc(ctx, versionInfo, onNewVersion)
 	if err != nil {
 		// Undo company change
-		if companyID != prevVersionInfo.CompanyID {
-			err = errors.Join(err, c.setDocumentCompanyID(ctx, docID, prevVersionInfo.CompanyID))
+		if companyID != prevCompanyID {
+			err = errors.Join(err, c.setDocumentCompanyID(ctx, docID, prevCompanyID))
 		}
 		return err
 	}
@@ -974,8 +1172,11 @@ func (c *Conn) RestoreDocument(ctx context.Context, doc *docdb.HashedDocument, r
 		return err
 	}
 
-	docWriteMtx.Lock(doc.ID)
-	defer docWriteMtx.Unlock(doc.ID)
+	unlock, err := c.lockDocument(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	docDir := c.documentDir(doc.ID)
 
@@ -992,11 +1193,11 @@ func (c *Conn) RestoreDocument(ctx context.Context, doc *docdb.HashedDocument, r
 		if e != nil {
 			return e
 		}
-		e = uuiddir.Remove(c.companiesDir.Join(currCompanyID.String()), doc.ID)
+		e = c.removeDocumentDir(ctx, doc.ID, docDir)
 		if e != nil {
 			return e
 		}
-		e = uuiddir.RemoveDir(c.documentsDir, docDir)
+		e = uuiddir.Remove(c.companiesDir.Join(currCompanyID.String()), doc.ID)
 		if e != nil {
 			return e
 		}
@@ -1004,10 +1205,23 @@ func (c *Conn) RestoreDocument(ctx context.Context, doc *docdb.HashedDocument, r
 
 	docExisted := docDir.Exists()
 
+	// Versions are written into a staging directory. Versions of an existing
+	// document are committed one by one into docDir, a new document
+	// is written completely into the staging directory and committed
+	// by renaming the staging directory to docDir.
+	stagingDir, err := c.makeStagingDir(doc.ID)
+	if err != nil {
+		return err
+	}
+	defer removeStagingDir(ctx, stagingDir)
+
 	var (
 		existingVersions []docdb.VersionTime
 		prevVersion      *docdb.VersionTime
 		prevVersionDir   fs.File
+		// prevFileHashes maps the filenames of prevVersionDir
+		// to their content hashes to find unchanged files
+		prevFileHashes map[string]string
 	)
 
 	if docExisted {
@@ -1031,62 +1245,61 @@ func (c *Conn) RestoreDocument(ctx context.Context, doc *docdb.HashedDocument, r
 		// ones), so the earliest missing version is correctly diffed against
 		// its real predecessor (or none) rather than the latest on-disk version.
 	} else {
-		err = docDir.MakeAllDirs()
-		if err != nil {
-			return err
-		}
-		err = docDir.Join("company.id").WriteAll(doc.CompanyID.StringBytes())
-		if err != nil {
-			return err
-		}
-		err = c.makeCompanyDocumentDir(doc.CompanyID, doc.ID)
+		err = stagingDir.Join("company.id").WriteAll(doc.CompanyID.StringBytes())
 		if err != nil {
 			return err
 		}
 	}
 
 	var (
-		createdVersionDirs []fs.File
-		createdInfoFiles   []fs.File
+		committedVersions []docdb.VersionTime
+		committedDocDir   bool
 	)
 	defer func() {
 		if err == nil {
 			return
 		}
-		for _, d := range createdVersionDirs {
-			err = errors.Join(err, d.RemoveRecursive())
-		}
-		for _, f := range createdInfoFiles {
-			if f.Exists() {
-				err = errors.Join(err, f.Remove())
-			}
+		for _, v := range committedVersions {
+			err = errors.Join(err, uncommitVersion(docDir, v))
 		}
-		if !docExisted {
+		if committedDocDir {
+			err = errors.Join(err, c.removeDocumentDir(ctx, doc.ID, docDir))
 			err = errors.Join(err, c.removeCompanyDocumentDirIfExists(doc.CompanyID, doc.ID))
-			if docDir.Exists() {
-				err = errors.Join(err, uuiddir.RemoveDir(c.documentsDir, docDir))
-			}
 		}
 	}()
 
 	for _, v := range doc.VersionTimes() {
 		if !recreate && versionTimeIn(existingVersions, v) {
+			// Link unchanged files against the stored version,
+			// which may differ from the version of the backup
+			existingInfo, _, err := c.documentVersionInfo(ctx, doc.ID, v)
+			if err != nil {
+				return err
+			}
+			prevFileHashes = make(map[string]string, len(existingInfo.Files))
+			for filename, file := range existingInfo.Files {
+				prevFileHashes[filename] = file.Hash
+			}
 			cur := v
 			prevVersion = &cur
 			prevVersionDir = docDir.Join(v.String())
 			continue
 		}
 
-		versionDir := docDir.Join(v.String())
+		versionDir := stagingDir.Join(v.String())
 		err = versionDir.MakeDir()
 		if err != nil {
 			return err
 		}
-		createdVersionDirs = append(createdVersionDirs, versionDir)
 
 		hv := doc.Versions[v]
 		for filename, hash := range hv.FileHashes {
-			if err = versionDir.Join(filename).WriteAllContext(ctx, doc.HashedFiles[hash]); err != nil {
+			if prevFileHashes[filename] == hash {
+				err = linkOrCopyFile(ctx, prevVersionDir.Join(filename), versionDir)
+			} else {
+				err = versionDir.Join(filename).WriteAllContext(ctx, doc.HashedFiles[hash])
+			}
+			if err != nil {
 				return err
 			}
 		}
@@ -1106,15 +1319,32 @@ func (c *Conn) RestoreDocument(ctx context.Context, doc *docdb.HashedDocument, r
 			return viErr
 		}
 
-		infoFile := docDir.Joinf("%s.json", v)
-		if err = versionInfo.WriteJSON(infoFile); err != nil {
+		if err = versionInfo.WriteJSON(stagingDir.Joinf("%s.json", v)); err != nil {
 			return err
 		}
-		createdInfoFiles = append(createdInfoFiles, infoFile)
+
+		if docExisted {
+			if err = commitVersion(stagingDir, docDir, v); err != nil {
+				return err
+			}
+			committedVersions = append(committedVersions, v)
+			versionDir = docDir.Join(v.String())
+		}
 
 		cur := v
 		prevVersion = &cur
 		prevVersionDir = versionDir
+		prevFileHashes = hv.FileHashes
+	}
+
+	if !docExisted {
+		if err = c.commitDocumentDir(stagingDir, docDir); err != nil {
+			return err
+		}
+		committedDocDir = true
+		if err = c.makeCompanyDocumentDir(doc.CompanyID, doc.ID); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -4,15 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/ungerik/go-fs"
 
 	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/docdbtest"
 	"github.com/domonda/go-docdb/localfsdb"
+	"github.com/domonda/go-errs"
 	"github.com/domonda/go-types/uu"
 )
 
@@ -634,6 +638,126 @@ func TestRestoreDocument(t *testing.T) {
 	})
 }
 
+func TestOpenDocumentVersionFile(t *testing.T) {
+	ctx := t.Context()
+	conn := localfsdb.NewTestConn(t)
+	companyID := uu.IDFrom("2fc110fd-ed66-4a8f-9498-4dcb8386d300")
+	docID := uu.IDFrom("5a0b9f4e-8c1d-4c1e-9d55-0f8a3f6b7e21")
+	userID := uu.IDFrom("ce6f0867-0172-4ffc-a0c0-c5878b921171")
+	version := docdb.MustVersionTimeFromString("2023-01-01_00-00-00.000")
+	noopOnNew := func(context.Context, *docdb.VersionInfo) error { return nil }
+
+	require.NoError(t, conn.CreateDocument(
+		ctx, companyID, docID, userID, "init", version,
+		newTestMemFiles("a.txt", "b.txt"), noopOnNew,
+	))
+
+	t.Run("streams file content", func(t *testing.T) {
+		reader, err := conn.OpenDocumentVersionFile(ctx, docID, version, "b.txt")
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, []byte("b.txt"), data)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := conn.OpenDocumentVersionFile(ctx, docID, version, "missing.txt")
+		require.True(t, errs.Has[docdb.ErrDocumentFileNotFound](err))
+	})
+
+	t.Run("missing version", func(t *testing.T) {
+		otherVersion := docdb.MustVersionTimeFromString("2023-01-02_00-00-00.000")
+		_, err := conn.OpenDocumentVersionFile(ctx, docID, otherVersion, "a.txt")
+		require.True(t, errs.Has[docdb.ErrDocumentVersionNotFound](err))
+	})
+
+	t.Run("missing document", func(t *testing.T) {
+		_, err := conn.OpenDocumentVersionFile(ctx, uu.IDv7(), version, "a.txt")
+		require.True(t, errs.Has[docdb.ErrDocumentNotFound](err))
+	})
+}
+
+func TestAddDocumentVersionIfLatest(t *testing.T) {
+	ctx := t.Context()
+	conn := localfsdb.NewTestConn(t)
+	companyID := uu.IDFrom("2fc110fd-ed66-4a8f-9498-4dcb8386d300")
+	userID := uu.IDFrom("ce6f0867-0172-4ffc-a0c0-c5878b921171")
+	version0 := docdb.MustVersionTimeFromString("2023-01-01_00-00-00.000")
+	noopOnNew := func(context.Context, *docdb.VersionInfo) error { return nil }
+
+	t.Run("adds version when expected version is latest", func(t *testing.T) {
+		docID := uu.IDv7()
+		require.NoError(t, conn.CreateDocument(ctx, companyID, docID, userID, "init", version0, newTestMemFiles("a.txt"), noopOnNew))
+
+		err := conn.AddDocumentVersionIfLatest(ctx, docID, version0, userID, "update",
+			docdb.CreateVersionWriteFiles(fs.NewMemFile("b.txt", []byte("b.txt"))),
+			noopOnNew,
+		)
+		require.NoError(t, err)
+		versions, err := conn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+	})
+
+	t.Run("returns ErrDocumentChanged for stale expected version", func(t *testing.T) {
+		docID := uu.IDv7()
+		require.NoError(t, conn.CreateDocument(ctx, companyID, docID, userID, "init", version0, newTestMemFiles("a.txt"), noopOnNew))
+		require.NoError(t, conn.AddDocumentVersion(ctx, docID, userID, "update",
+			docdb.CreateVersionWriteFiles(fs.NewMemFile("b.txt", []byte("b.txt"))),
+			noopOnNew,
+		))
+
+		err := conn.AddDocumentVersionIfLatest(ctx, docID, version0, userID, "stale update",
+			func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+				t.Fatal("createVersion must not be called")
+				return nil, nil
+			},
+			noopOnNew,
+		)
+		require.True(t, errs.Has[docdb.ErrDocumentChanged](err))
+		versions, err := conn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+	})
+
+	t.Run("only one of concurrent writers with the same base succeeds", func(t *testing.T) {
+		docID := uu.IDv7()
+		require.NoError(t, conn.CreateDocument(ctx, companyID, docID, userID, "init", version0, newTestMemFiles("a.txt"), noopOnNew))
+
+		const numWriters = 8
+		results := make([]error, numWriters)
+		var wg sync.WaitGroup
+		for i := range numWriters {
+			wg.Go(func() {
+				results[i] = conn.AddDocumentVersionIfLatest(ctx, docID, version0, userID, "concurrent update",
+					func(ctx context.Context, docID uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+						return &docdb.CreateVersionResult{
+							Version:    docdb.VersionTimeFrom(prevVersion.Time.Add(time.Duration(i+1) * time.Second)),
+							WriteFiles: []fs.FileReader{fs.NewMemFile(fmt.Sprintf("%d.txt", i), []byte("data"))},
+						}, nil
+					},
+					noopOnNew,
+				)
+			})
+		}
+		wg.Wait()
+
+		succeeded := 0
+		for _, err := range results {
+			if err == nil {
+				succeeded++
+			} else {
+				require.True(t, errs.Has[docdb.ErrDocumentChanged](err), "unexpected error: %v", err)
+			}
+		}
+		require.Equal(t, 1, succeeded)
+		versions, err := conn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+	})
+}
+
 func TestCompanyIDs(t *testing.T) {
 	ctx := t.Context()
 	conn := localfsdb.NewTestConn(t)
@@ -895,3 +1019,9 @@ func TestCreateDocument_ConcurrentSharedPathPrefix(t *testing.T) {
 		require.Equal(t, companyID, gotCompanyID, "doc %s mapped to wrong company", id)
 	}
 }
+
+func TestConnConformance(t *testing.T) {
+	docdbtest.RunConnTests(t, func(t *testing.T) docdb.Conn {
+		return localfsdb.NewTestConn(t)
+	})
+}
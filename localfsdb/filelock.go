@@ -0,0 +1,231 @@
+package localfsdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+)
+
+// lockFilesDirName is the name of the hidden directory within the documents
+// directory that holds the lock files of documents that are currently written.
+// It can't collide with a uuiddir path segment which only uses hex characters.
+const lockFilesDirName = ".locks"
+
+const (
+	minLockRetryInterval = 10 * time.Millisecond
+	maxLockRetryInterval = time.Second
+)
+
+// lockFileContent is written as JSON into every lock file to identify its owner.
+type lockFileContent struct {
+	Token    string    `json:"token"`
+	Hostname string    `json:"hostname"`
+	PID      int       `json:"pid"`
+	Created  time.Time `json:"created"`
+}
+
+// fileLocker serializes writes to the same document across processes
+// that share a documents directory by exclusively creating a lock file
+// per document. Exclusive file creation is atomic on local file systems
+// and on NFS v3 and later.
+//
+// The owner of a lock file refreshes its modification time while holding it.
+// A lock file that has not been refreshed for staleAfter is considered
+// stale, for example because its owner crashed, and is removed by the next
+// process that tries to acquire the lock.
+type fileLocker struct {
+	dir        fs.File
+	staleAfter time.Duration
+}
+
+func newFileLocker(documentsDir fs.File, staleAfter time.Duration) (*fileLocker, error) {
+	dir := documentsDir.Join(lockFilesDirName)
+	if !dir.IsDir() {
+		err := dir.MakeDir()
+		if err != nil && !dir.IsDir() {
+			return nil, err
+		}
+	}
+	return &fileLocker{dir: dir, staleAfter: staleAfter}, nil
+}
+
+func (l *fileLocker) lockFile(docID uu.ID) string {
+	return l.dir.Join(docID.String() + ".lock").LocalPath()
+}
+
+// lock waits until it exclusively created the lock file of the document
+// or ctx is canceled. The returned unlock function removes the lock file.
+func (l *fileLocker) lock(ctx context.Context, docID uu.ID) (unlock func(), err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID)
+
+	hostname, _ := os.Hostname()
+	content, err := json.Marshal(lockFileContent{
+		Token:    uu.IDv4().String(),
+		Hostname: hostname,
+		PID:      os.Getpid(),
+		Created:  time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	path := l.lockFile(docID)
+	retryInterval := minLockRetryInterval
+	for {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		created, err := createLockFile(path, content)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			return l.startRefresh(ctx, docID, path, content), nil
+		}
+
+		if l.removeIfStale(ctx, docID, path) {
+			continue // Retry immediately
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryInterval):
+		}
+		retryInterval = min(retryInterval*2, maxLockRetryInterval)
+	}
+}
+
+// createLockFile creates the file at path with the passed content
+// and returns false if the file already exists.
+func createLockFile(path string, content []byte) (created bool, err error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, err = file.Write(content)
+	err = errors.Join(err, file.Close())
+	if err != nil {
+		return false, errors.Join(err, os.Remove(path))
+	}
+	return true, nil
+}
+
+// startRefresh keeps the lock file at path from becoming stale by
+// touching it periodically until the returned unlock function is called.
+func (l *fileLocker) startRefresh(ctx context.Context, docID uu.ID, path string, content []byte) (unlock func()) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(l.staleAfter / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				if err := os.Chtimes(path, now, now); err != nil {
+					log.ErrorCtx(ctx, "Can't refresh document lock file").
+						UUID("docID", docID).
+						Str("lockFile", path).
+						Err(err).
+						Log()
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+
+		// Only remove the lock file if it is still ours,
+		// it could have been removed as stale by another process
+		current, err := os.ReadFile(path)
+		if err != nil || string(current) != string(content) {
+			log.ErrorCtx(ctx, "Document lock file was removed or replaced while holding the lock").
+				UUID("docID", docID).
+				Str("lockFile", path).
+				Err(err).
+				Log()
+			return
+		}
+		if err = os.Remove(path); err != nil {
+			log.ErrorCtx(ctx, "Can't remove document lock file").
+				UUID("docID", docID).
+				Str("lockFile", path).
+				Err(err).
+				Log()
+		}
+	}
+}
+
+// removeIfStale removes the lock file at path if it has not been
+// refreshed for staleAfter and returns true if it was removed
+// or did not exist anymore.
+//
+// To not remove a lock file that was replaced by a new owner between
+// the staleness check and the removal, the file is first renamed to
+// a unique name and put back if its content changed.
+func (l *fileLocker) removeIfStale(ctx context.Context, docID uu.ID, path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Is(err, os.ErrNotExist)
+	}
+	if time.Since(info.ModTime()) < l.staleAfter {
+		return false
+	}
+	staleContent, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Is(err, os.ErrNotExist)
+	}
+
+	renamed := path + "." + uu.IDv4().String() + ".stale"
+	if err = os.Rename(path, renamed); err != nil {
+		return errors.Is(err, os.ErrNotExist)
+	}
+	renamedContent, err := os.ReadFile(renamed)
+	if err == nil && string(renamedContent) != string(staleContent) {
+		// A new owner created the lock file after the staleness check,
+		// link it back to its path and keep waiting
+		err = os.Link(renamed, path)
+		err = errors.Join(err, os.Remove(renamed))
+		if err != nil {
+			log.ErrorCtx(ctx, "Can't restore document lock file").
+				UUID("docID", docID).
+				Str("lockFile", path).
+				Err(err).
+				Log()
+		}
+		return false
+	}
+
+	log.WarnCtx(ctx, "Removing stale document lock file").
+		UUID("docID", docID).
+		Str("lockFile", path).
+		Str("content", string(staleContent)).
+		Duration("age", time.Since(info.ModTime())).
+		Log()
+	if err = os.Remove(renamed); err != nil {
+		log.ErrorCtx(ctx, "Can't remove stale document lock file").
+			UUID("docID", docID).
+			Str("lockFile", renamed).
+			Err(err).
+			Log()
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package localfsdb
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-types/uu"
+)
+
+func newTestFileLocker(t *testing.T, staleAfter time.Duration) *fileLocker {
+	t.Helper()
+
+	dir, err := fs.MakeTempDir()
+	require.NoError(t, err)
+	t.Cleanup(func() { dir.RemoveRecursive() }) //#nosec G104
+
+	locker, err := newFileLocker(dir, staleAfter)
+	require.NoError(t, err)
+	require.True(t, dir.Join(lockFilesDirName).IsDir())
+	return locker
+}
+
+func TestFileLocker(t *testing.T) {
+	t.Run("excludes other lockers until unlocked", func(t *testing.T) {
+		locker := newTestFileLocker(t, time.Minute)
+		docID := uu.IDv7()
+
+		unlock, err := locker.lock(t.Context(), docID)
+		require.NoError(t, err)
+		require.FileExists(t, locker.lockFile(docID))
+
+		ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+		defer cancel()
+		_, err = locker.lock(ctx, docID)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// Other documents are not affected
+		unlockOther, err := locker.lock(t.Context(), uu.IDv7())
+		require.NoError(t, err)
+		unlockOther()
+
+		unlock()
+		require.NoFileExists(t, locker.lockFile(docID))
+
+		unlock, err = locker.lock(t.Context(), docID)
+		require.NoError(t, err)
+		unlock()
+	})
+
+	t.Run("waiting locker acquires after unlock", func(t *testing.T) {
+		locker := newTestFileLocker(t, time.Minute)
+		docID := uu.IDv7()
+
+		unlock, err := locker.lock(t.Context(), docID)
+		require.NoError(t, err)
+		time.AfterFunc(50*time.Millisecond, unlock)
+
+		unlock, err = locker.lock(t.Context(), docID)
+		require.NoError(t, err)
+		unlock()
+	})
+
+	t.Run("removes stale lock file", func(t *testing.T) {
+		locker := newTestFileLocker(t, time.Minute)
+		docID := uu.IDv7()
+
+		// Lock file left behind by a crashed process
+		path := locker.lockFile(docID)
+		require.NoError(t, os.WriteFile(path, []byte(`{"token":"crashed"}`), 0o644))
+		old := time.Now().Add(-2 * time.Minute)
+		require.NoError(t, os.Chtimes(path, old, old))
+
+		ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+		defer cancel()
+		unlock, err := locker.lock(ctx, docID)
+		require.NoError(t, err)
+		unlock()
+
+		entries, err := os.ReadDir(locker.dir.LocalPath())
+		require.NoError(t, err)
+		require.Empty(t, entries, "no renamed stale lock file must be left behind")
+	})
+
+	t.Run("held lock is refreshed and does not become stale", func(t *testing.T) {
+		locker := newTestFileLocker(t, 100*time.Millisecond)
+		docID := uu.IDv7()
+
+		unlock, err := locker.lock(t.Context(), docID)
+		require.NoError(t, err)
+		defer unlock()
+
+		ctx, cancel := context.WithTimeout(t.Context(), 400*time.Millisecond)
+		defer cancel()
+		_, err = locker.lock(ctx, docID)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("unlock keeps lock file of new owner", func(t *testing.T) {
+		locker := newTestFileLocker(t, time.Minute)
+		docID := uu.IDv7()
+
+		unlock, err := locker.lock(t.Context(), docID)
+		require.NoError(t, err)
+
+		// Simulate another process that removed the lock as stale
+		// and acquired it in the meantime
+		path := locker.lockFile(docID)
+		require.NoError(t, os.WriteFile(path, []byte(`{"token":"other"}`), 0o644))
+
+		unlock()
+		require.FileExists(t, path)
+	})
+}
+
+func TestConn_WithFileLocks(t *testing.T) {
+	dir, err := fs.MakeTempDir()
+	require.NoError(t, err)
+	t.Cleanup(func() { dir.RemoveRecursive() }) //#nosec G104
+	documentsDir := dir.Join("documents")
+	companiesDir := dir.Join("companies")
+	require.NoError(t, documentsDir.MakeDir())
+	require.NoError(t, companiesDir.MakeDir())
+
+	conn := NewConn(documentsDir, companiesDir, WithFileLocks(0))
+	require.NotNil(t, conn.fileLocker)
+	require.Equal(t, DefaultLockStaleAfter, conn.fileLocker.staleAfter)
+
+	ctx := t.Context()
+	docID := uu.IDv7()
+	userID := uu.IDv7()
+	version := docdb.MustVersionTimeFromString("2023-01-01_00-00-00.000")
+	noopOnNew := func(context.Context, *docdb.VersionInfo) error { return nil }
+
+	err = conn.CreateDocument(ctx, uu.IDv7(), docID, userID, "init", version,
+		[]fs.FileReader{fs.NewMemFile("a.txt", []byte("a"))}, noopOnNew,
+	)
+	require.NoError(t, err)
+	err = conn.AddDocumentVersion(ctx, docID, userID, "update",
+		docdb.CreateVersionWriteFiles(fs.NewMemFile("b.txt", []byte("b"))), noopOnNew,
+	)
+	require.NoError(t, err)
+	require.NoError(t, conn.DeleteDocument(ctx, docID))
+
+	entries, err := os.ReadDir(conn.fileLocker.dir.LocalPath())
+	require.NoError(t, err)
+	require.Empty(t, entries, "all lock files must be removed after the writes")
+}
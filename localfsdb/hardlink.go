@@ -0,0 +1,49 @@
+package localfsdb
+
+import (
+	"context"
+	"os"
+
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+)
+
+// linkFile creates newname as hard link to oldname.
+// It is a variable so tests can simulate a file system without hard links.
+var linkFile = os.Link
+
+// linkOrCopyFile adds file to dir under its name as hard link,
+// so that unchanged files of consecutive versions share their storage.
+//
+// Sharing the inode is safe because files of a version are never modified
+// in place: they are only removed together with their version directory,
+// which removes one link and leaves the content of other versions intact.
+//
+// If no hard link can be created, for example because the file system
+// does not support hard links or the maximum number of links
+// to the file is reached, the file is copied instead.
+func linkOrCopyFile(ctx context.Context, file, dir fs.File) error {
+	err := linkFile(file.LocalPath(), dir.Join(file.Name()).LocalPath())
+	if err == nil {
+		return nil
+	}
+	log.DebugCtx(ctx, "Can't hard link file, copying instead").
+		Str("file", file.LocalPath()).
+		Err(err).
+		Log()
+	return fs.CopyFile(ctx, file, dir)
+}
+
+// hasContent returns true if file has the size and content hash of info.
+// The content is only hashed if the size matches.
+func hasContent(ctx context.Context, file fs.FileReader, info docdb.FileInfo) (bool, error) {
+	if file.Size() != info.Size {
+		return false, nil
+	}
+	fileInfo, err := docdb.ReadFileInfo(ctx, file)
+	if err != nil {
+		return false, err
+	}
+	return fileInfo.Hash == info.Hash, nil
+}
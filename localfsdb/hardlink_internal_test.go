@@ -0,0 +1,180 @@
+package localfsdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-types/uu"
+)
+
+func noopOnNewVersion(context.Context, *docdb.VersionInfo) error { return nil }
+
+// sameVersionFile returns true if filename of version1 and version2
+// of the document share the same inode.
+func sameVersionFile(t *testing.T, c *Conn, docID uu.ID, version1, version2 docdb.VersionTime, filename string) bool {
+	t.Helper()
+	stat1, err := os.Stat(c.documentDir(docID).Join(version1.String(), filename).LocalPath())
+	require.NoError(t, err)
+	stat2, err := os.Stat(c.documentDir(docID).Join(version2.String(), filename).LocalPath())
+	require.NoError(t, err)
+	return os.SameFile(stat1, stat2)
+}
+
+func addTestVersion(t *testing.T, c *Conn, docID uu.ID, version docdb.VersionTime, writeFiles ...fs.FileReader) {
+	t.Helper()
+	err := c.AddDocumentVersion(t.Context(), docID, uu.IDv7(), "add",
+		func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			return &docdb.CreateVersionResult{Version: version, WriteFiles: writeFiles}, nil
+		},
+		noopOnNewVersion,
+	)
+	require.NoError(t, err)
+}
+
+func TestAddDocumentVersion_LinksUnchangedFiles(t *testing.T) {
+	var (
+		ctx      = t.Context()
+		c        = NewTestConn(t)
+		docID    = uu.IDv7()
+		version1 = docdb.MustVersionTimeFromString("2023-01-01_00-00-00.000")
+		version2 = docdb.MustVersionTimeFromString("2023-01-02_00-00-00.000")
+		version3 = docdb.MustVersionTimeFromString("2023-01-03_00-00-00.000")
+	)
+	err := c.CreateDocument(ctx, uu.IDv7(), docID, uu.IDv7(), "create", version1,
+		[]fs.FileReader{
+			fs.NewMemFile("doc.pdf", []byte("large pdf")),
+			fs.NewMemFile("a.txt", []byte("a")),
+		},
+		noopOnNewVersion,
+	)
+	require.NoError(t, err)
+
+	// doc.pdf is carried forward, a.txt is written again with the same content
+	addTestVersion(t, c, docID, version2,
+		fs.NewMemFile("a.txt", []byte("a")),
+		fs.NewMemFile("ocr-data.json", []byte("{}")),
+	)
+	require.True(t, sameVersionFile(t, c, docID, version1, version2, "doc.pdf"))
+	require.True(t, sameVersionFile(t, c, docID, version1, version2, "a.txt"))
+
+	addTestVersion(t, c, docID, version3, fs.NewMemFile("a.txt", []byte("modified")))
+	require.True(t, sameVersionFile(t, c, docID, version1, version3, "doc.pdf"))
+	require.False(t, sameVersionFile(t, c, docID, version2, version3, "a.txt"))
+
+	// Deleting the first version must leave the linked content intact
+	_, err = c.DeleteDocumentVersion(ctx, docID, version1)
+	require.NoError(t, err)
+	for _, version := range []docdb.VersionTime{version2, version3} {
+		data, err := c.ReadDocumentVersionFile(ctx, docID, version, "doc.pdf")
+		require.NoError(t, err)
+		require.Equal(t, []byte("large pdf"), data)
+	}
+	data, err := c.ReadDocumentVersionFile(ctx, docID, version2, "a.txt")
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), data)
+}
+
+func TestAddDocumentVersion_RollbackKeepsLinkedFiles(t *testing.T) {
+	var (
+		ctx      = t.Context()
+		c        = NewTestConn(t)
+		docID    = uu.IDv7()
+		version1 = docdb.MustVersionTimeFromString("2023-01-01_00-00-00.000")
+		version2 = docdb.MustVersionTimeFromString("2023-01-02_00-00-00.000")
+	)
+	err := c.CreateDocument(ctx, uu.IDv7(), docID, uu.IDv7(), "create", version1,
+		[]fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))},
+		noopOnNewVersion,
+	)
+	require.NoError(t, err)
+
+	err = c.AddDocumentVersion(ctx, docID, uu.IDv7(), "add",
+		func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			return &docdb.CreateVersionResult{
+				Version:    version2,
+				WriteFiles: []fs.FileReader{fs.NewMemFile("b.txt", []byte("b"))},
+			}, nil
+		},
+		func(context.Context, *docdb.VersionInfo) error { return errors.New("onNewVersion rejected") },
+	)
+	require.Error(t, err)
+
+	versions, err := c.DocumentVersions(ctx, docID)
+	require.NoError(t, err)
+	require.Equal(t, []docdb.VersionTime{version1}, versions)
+	data, err := c.ReadDocumentVersionFile(ctx, docID, version1, "doc.pdf")
+	require.NoError(t, err)
+	require.Equal(t, []byte("pdf"), data)
+}
+
+func TestRestoreDocument_LinksUnchangedFiles(t *testing.T) {
+	var (
+		ctx      = t.Context()
+		c        = NewTestConn(t)
+		docID    = uu.IDv7()
+		version1 = docdb.MustVersionTimeFromString("2023-01-01_00-00-00.000")
+		version2 = docdb.MustVersionTimeFromString("2023-01-02_00-00-00.000")
+		version3 = docdb.MustVersionTimeFromString("2023-01-03_00-00-00.000")
+		pdfHash  = docdb.ContentHash([]byte("pdf"))
+		aHash    = docdb.ContentHash([]byte("a"))
+		bHash    = docdb.ContentHash([]byte("b"))
+	)
+	doc := &docdb.HashedDocument{
+		ID:        docID,
+		CompanyID: uu.IDv7(),
+		Versions: map[docdb.VersionTime]*docdb.HashedVersion{
+			version1: {CommitUserID: uu.IDv7(), CommitReason: "v1", FileHashes: map[string]string{"doc.pdf": pdfHash}},
+			version2: {CommitUserID: uu.IDv7(), CommitReason: "v2", FileHashes: map[string]string{"doc.pdf": pdfHash, "a.txt": aHash}},
+			version3: {CommitUserID: uu.IDv7(), CommitReason: "v3", FileHashes: map[string]string{"doc.pdf": pdfHash, "a.txt": bHash}},
+		},
+		HashedFiles: map[string][]byte{pdfHash: []byte("pdf"), aHash: []byte("a"), bHash: []byte("b")},
+	}
+
+	t.Run("new document", func(t *testing.T) {
+		require.NoError(t, c.RestoreDocument(ctx, doc, false))
+		require.True(t, sameVersionFile(t, c, docID, version1, version2, "doc.pdf"))
+		require.True(t, sameVersionFile(t, c, docID, version2, version3, "doc.pdf"))
+		require.False(t, sameVersionFile(t, c, docID, version2, version3, "a.txt"))
+	})
+
+	t.Run("merged into existing document", func(t *testing.T) {
+		_, err := c.DeleteDocumentVersion(ctx, docID, version3)
+		require.NoError(t, err)
+
+		require.NoError(t, c.RestoreDocument(ctx, doc, false))
+		require.True(t, sameVersionFile(t, c, docID, version2, version3, "doc.pdf"))
+		data, err := c.ReadDocumentVersionFile(ctx, docID, version3, "a.txt")
+		require.NoError(t, err)
+		require.Equal(t, []byte("b"), data)
+	})
+}
+
+func TestLinkOrCopyFile_FallsBackToCopy(t *testing.T) {
+	linkFile = func(string, string) error { return errors.New("hard links not supported") }
+	t.Cleanup(func() { linkFile = os.Link })
+
+	var (
+		ctx      = t.Context()
+		c        = NewTestConn(t)
+		docID    = uu.IDv7()
+		version1 = docdb.MustVersionTimeFromString("2023-01-01_00-00-00.000")
+		version2 = docdb.MustVersionTimeFromString("2023-01-02_00-00-00.000")
+	)
+	err := c.CreateDocument(ctx, uu.IDv7(), docID, uu.IDv7(), "create", version1,
+		[]fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))},
+		noopOnNewVersion,
+	)
+	require.NoError(t, err)
+	addTestVersion(t, c, docID, version2, fs.NewMemFile("b.txt", []byte("b")))
+
+	require.False(t, sameVersionFile(t, c, docID, version1, version2, "doc.pdf"))
+	data, err := c.ReadDocumentVersionFile(ctx, docID, version2, "doc.pdf")
+	require.NoError(t, err)
+	require.Equal(t, []byte("pdf"), data)
+}
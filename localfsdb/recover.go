@@ -0,0 +1,301 @@
+package localfsdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ungerik/go-fs"
+	"github.com/ungerik/go-fs/uuiddir"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+)
+
+// RecoveryIssue describes an inconsistency in the documents
+// or companies directory found by Conn.Recover.
+type RecoveryIssue struct {
+	DocID uu.ID
+	// Path of the affected file or directory
+	Path string
+	// Problem describes the inconsistency
+	Problem string
+	// Repaired is true if the inconsistency was repaired
+	Repaired bool
+}
+
+func (i RecoveryIssue) String() string {
+	repaired := ""
+	if i.Repaired {
+		repaired = " (repaired)"
+	}
+	return fmt.Sprintf("document %s: %s: %s%s", i.DocID, i.Problem, i.Path, repaired)
+}
+
+// Recover checks the documents and companies directories for
+// half-written or half-deleted state left behind by a crashed
+// or killed process and returns the found issues.
+// If repair is true, the issues are repaired by removing
+// everything that was never committed and by completing
+// the company mapping of committed documents.
+//
+// The company.id file of a document is authoritative,
+// SetDocumentCompanyID changes it without adding a version,
+// so the company of the latest version is not checked.
+//
+// Writes are committed by atomically renaming staged files into place,
+// so a crash can only leave behind:
+//   - entries in the staging directory
+//   - version directories without version info JSON file
+//     (invisible to readers) and the reverse
+//   - document directories without any complete version
+//   - missing or stale company document marker directories
+//
+// Every document is locked while it is checked. Recover may run
+// concurrently with writes from other processes sharing the directories
+// only if all of them use WithFileLocks.
+func (c *Conn) Recover(ctx context.Context, repair bool) (issues []RecoveryIssue, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, repair)
+
+	addIssue := func(docID uu.ID, path fs.File, problem string, repairFunc func() error) error {
+		issue := RecoveryIssue{DocID: docID, Path: path.LocalPath(), Problem: problem}
+		if repair {
+			if err := repairFunc(); err != nil {
+				return errs.Errorf("can't repair %s: %w", issue, err)
+			}
+			issue.Repaired = true
+		}
+		issues = append(issues, issue)
+		return nil
+	}
+
+	err = c.recoverStagingDir(ctx, addIssue)
+	if err != nil {
+		return issues, err
+	}
+
+	var docIDs uu.IDSlice
+	err = uuiddir.Enum(ctx, c.documentsDir, func(_ fs.File, id [16]byte) error {
+		docIDs = append(docIDs, id)
+		return nil
+	})
+	if err != nil {
+		return issues, err
+	}
+	for _, docID := range docIDs {
+		err = c.recoverDocument(ctx, docID, addIssue)
+		if err != nil {
+			return issues, err
+		}
+	}
+
+	err = c.recoverCompanyDocumentDirs(ctx, addIssue)
+	if err != nil {
+		return issues, err
+	}
+	return issues, nil
+}
+
+type addRecoveryIssueFunc func(docID uu.ID, path fs.File, problem string, repair func() error) error
+
+func (c *Conn) recoverStagingDir(ctx context.Context, addIssue addRecoveryIssueFunc) error {
+	stagingDir := c.stagingDir()
+	if !stagingDir.IsDir() {
+		return nil
+	}
+	var entries []fs.File
+	err := stagingDir.ListDirContext(ctx, func(entry fs.File) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		docID, err := stagingDirDocID(entry.Name())
+		if err != nil {
+			err = addIssue(uu.IDNil, entry, "unexpected file in staging directory", entry.RemoveRecursive)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		// Writes remove their staging directory before releasing
+		// the document lock, so after acquiring the lock
+		// the staging directory must be a left over
+		unlock, err := c.lockDocument(ctx, docID)
+		if err != nil {
+			return err
+		}
+		if entry.Exists() {
+			err = addIssue(docID, entry, "uncommitted staging directory", entry.RemoveRecursive)
+		}
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) recoverDocument(ctx context.Context, docID uu.ID, addIssue addRecoveryIssueFunc) error {
+	unlock, err := c.lockDocument(ctx, docID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	docDir := c.documentDir(docID)
+	if !docDir.IsDir() {
+		return nil // Deleted in the meantime
+	}
+
+	var (
+		versionDirs  = make(map[docdb.VersionTime]fs.File)
+		versionInfos = make(map[docdb.VersionTime]fs.File)
+	)
+	err = docDir.ListDirInfoContext(ctx, func(info *fs.FileInfo) error {
+		if info.IsHidden {
+			return nil
+		}
+		name, isJSON := strings.CutSuffix(info.Name, ".json")
+		version, err := docdb.VersionTimeFromString(name)
+		if err != nil {
+			return nil //nolint:nilerr // Not a version, like company.id
+		}
+		switch {
+		case info.IsDir && !isJSON:
+			versionDirs[version] = info.File
+		case !info.IsDir && isJSON:
+			versionInfos[version] = info.File
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var latest docdb.VersionTime
+	for version, dir := range versionDirs {
+		if _, ok := versionInfos[version]; !ok {
+			err = addIssue(docID, dir, "version directory without version info JSON file", dir.RemoveRecursive)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if version.After(latest) {
+			latest = version
+		}
+	}
+	for version, file := range versionInfos {
+		if _, ok := versionDirs[version]; !ok {
+			err = addIssue(docID, file, "version info JSON file without version directory", file.Remove)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if latest.Time.IsZero() {
+		return addIssue(docID, docDir, "document directory without complete version", func() error {
+			if companyID, err := c.documentCompanyIDFile(docID); err == nil {
+				err = c.removeCompanyDocumentDirIfExists(companyID, docID)
+				if err != nil {
+					return err
+				}
+			}
+			return c.removeDocumentDir(ctx, docID, docDir)
+		})
+	}
+
+	companyID, err := c.documentCompanyID(ctx, docID)
+	if err != nil {
+		return err
+	}
+	if companyDocumentDir := c.companyDocumentDir(companyID, docID); !companyDocumentDir.IsDir() {
+		return addIssue(docID, companyDocumentDir, "missing company document directory", func() error {
+			return c.makeCompanyDocumentDir(companyID, docID)
+		})
+	}
+	return nil
+}
+
+// documentCompanyIDFile reads the company.id file of a document
+// without falling back to the versions like documentCompanyID.
+func (c *Conn) documentCompanyIDFile(docID uu.ID) (uu.ID, error) {
+	uuidStr, err := c.documentDir(docID).Join("company.id").ReadAllString()
+	if err != nil {
+		return uu.IDNil, err
+	}
+	return uu.IDFromString(uuidStr)
+}
+
+// recoverCompanyDocumentDirs removes company document marker directories
+// of documents that don't exist or belong to another company.
+func (c *Conn) recoverCompanyDocumentDirs(ctx context.Context, addIssue addRecoveryIssueFunc) error {
+	companyIDs, err := c.CompanyIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, companyID := range companyIDs {
+		docIDs, err := c.CompanyDocumentIDs(ctx, companyID)
+		if err != nil {
+			return err
+		}
+		for _, docID := range docIDs {
+			err = c.recoverCompanyDocumentDir(ctx, companyID, docID, addIssue)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Conn) recoverCompanyDocumentDir(ctx context.Context, companyID, docID uu.ID, addIssue addRecoveryIssueFunc) error {
+	unlock, err := c.lockDocument(ctx, docID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	companyDocumentDir := c.companyDocumentDir(companyID, docID)
+	if !companyDocumentDir.IsDir() {
+		return nil // Removed in the meantime
+	}
+	removeMarker := func() error { return c.removeCompanyDocumentDirIfExists(companyID, docID) }
+
+	if !c.documentDir(docID).IsDir() {
+		return addIssue(docID, companyDocumentDir, "company document directory of non existing document", removeMarker)
+	}
+	docCompanyID, err := c.documentCompanyID(ctx, docID)
+	if err != nil {
+		return err
+	}
+	if docCompanyID != companyID {
+		return addIssue(docID, companyDocumentDir, "company document directory of document belonging to company "+docCompanyID.String(), removeMarker)
+	}
+	return nil
+}
+
+// recover runs Recover for WithRecovery and logs the results.
+func (c *Conn) recover(repair bool) {
+	ctx := context.Background()
+	issues, err := c.Recover(ctx, repair)
+	for _, issue := range issues {
+		log.WarnCtx(ctx, "Found inconsistent localfsdb state").
+			UUID("docID", issue.DocID).
+			Str("path", issue.Path).
+			Str("problem", issue.Problem).
+			Bool("repaired", issue.Repaired).
+			Log()
+	}
+	if err != nil {
+		log.ErrorCtx(ctx, "Recovery of localfsdb failed").
+			Stringer("conn", c).
+			Err(err).
+			Log()
+	}
+}
@@ -0,0 +1,239 @@
+package localfsdb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+	"github.com/ungerik/go-fs/uuiddir"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/localfsdb"
+	"github.com/domonda/go-types/uu"
+)
+
+func TestConn_Recover(t *testing.T) {
+	tmp := fs.File(t.TempDir())
+	documentsDir := tmp.Join("documents")
+	companiesDir := tmp.Join("companies")
+	require.NoError(t, documentsDir.MakeDir())
+	require.NoError(t, companiesDir.MakeDir())
+
+	var (
+		ctx        = t.Context()
+		conn       = localfsdb.NewConn(documentsDir, companiesDir)
+		companyID  = uu.IDFrom("2fc110fd-ed66-4a8f-9498-4dcb8386d300")
+		companyID2 = uu.IDFrom("6f296458-24cd-4146-ac3a-33ca885a993e")
+		userID     = uu.IDFrom("ce6f0867-0172-4ffc-a0c0-c5878b921171")
+		version1   = docdb.MustVersionTimeFromString("2023-01-01_00-00-00.000")
+		version2   = docdb.MustVersionTimeFromString("2023-01-02_00-00-00.000")
+		version3   = docdb.MustVersionTimeFromString("2023-01-03_00-00-00.000")
+		noopOnNew  = func(context.Context, *docdb.VersionInfo) error { return nil }
+	)
+	createDoc := func(companyID uu.ID) (docID uu.ID, docDir fs.File) {
+		t.Helper()
+		docID = uu.IDv7()
+		err := conn.CreateDocument(ctx, companyID, docID, userID, "create", version1, newTestMemFiles("a.txt"), noopOnNew)
+		require.NoError(t, err)
+		return docID, uuiddir.Join(documentsDir, docID)
+	}
+
+	// A consistent document that must stay untouched
+	okDocID, _ := createDoc(companyID)
+
+	// Crashed before committing the version info JSON file of version2
+	orphanDirDocID, orphanDirDocDir := createDoc(companyID)
+	require.NoError(t, orphanDirDocDir.Join(version2.String()).MakeDir())
+	require.NoError(t, orphanDirDocDir.Join(version2.String(), "b.txt").WriteAllString("b"))
+
+	// Version info JSON file without version directory
+	orphanJSONDocID, orphanJSONDocDir := createDoc(companyID)
+	require.NoError(t, orphanJSONDocDir.Joinf("%s.json", version3).WriteAllString("{}"))
+
+	// Document directory without any complete version
+	noVersionDocID, noVersionDocDir := createDoc(companyID)
+	require.NoError(t, noVersionDocDir.Joinf("%s.json", version1).Remove())
+
+	// Crashed between writing company.id and moving
+	// the company document directory to the new company
+	movedDocID, _ := createDoc(companyID)
+	require.NoError(t, conn.SetDocumentCompanyID(ctx, movedDocID, companyID2))
+	require.NoError(t, uuiddir.Remove(companiesDir.Join(companyID2.String()), movedDocID))
+	require.NoError(t, uuiddir.Join(companiesDir.Join(companyID.String()), movedDocID).MakeAllDirs())
+
+	// Crashed before creating the company document directory
+	noMarkerDocID, _ := createDoc(companyID)
+	require.NoError(t, uuiddir.Remove(companiesDir.Join(companyID.String()), noMarkerDocID))
+
+	// Crashed between deleting the document directory
+	// and its company document directory
+	deletedDocID := uu.IDv7()
+	require.NoError(t, uuiddir.Join(companiesDir.Join(companyID.String()), deletedDocID).MakeAllDirs())
+
+	// Left over staging directory of a crashed write
+	stagingDir := documentsDir.Join(".staging", okDocID.String()+".crashed")
+	require.NoError(t, stagingDir.MakeAllDirs())
+	require.NoError(t, stagingDir.Join("a.txt").WriteAllString("a"))
+
+	wantIssueDocIDs := uu.IDSlice{
+		okDocID, // staging directory
+		orphanDirDocID,
+		orphanJSONDocID,
+		noVersionDocID, // version directory without JSON
+		noVersionDocID, // document without complete version
+		movedDocID,     // missing company document directory
+		movedDocID,     // stale company document directory
+		noMarkerDocID,
+		deletedDocID,
+	}
+
+	t.Run("report only", func(t *testing.T) {
+		issues, err := conn.Recover(ctx, false)
+		require.NoError(t, err)
+		var docIDs uu.IDSlice
+		for _, issue := range issues {
+			require.False(t, issue.Repaired, issue.String())
+			docIDs = append(docIDs, issue.DocID)
+		}
+		require.ElementsMatch(t, wantIssueDocIDs, docIDs)
+
+		// Nothing changed
+		require.True(t, stagingDir.Exists())
+		require.True(t, orphanDirDocDir.Join(version2.String()).Exists())
+		require.True(t, noVersionDocDir.Exists())
+	})
+
+	t.Run("repair", func(t *testing.T) {
+		issues, err := conn.Recover(ctx, true)
+		require.NoError(t, err)
+		require.Len(t, issues, len(wantIssueDocIDs))
+		for _, issue := range issues {
+			require.True(t, issue.Repaired, issue.String())
+		}
+
+		issues, err = conn.Recover(ctx, false)
+		require.NoError(t, err)
+		require.Empty(t, issues, "no issues left after repair")
+
+		require.False(t, stagingDir.Exists())
+		require.False(t, orphanDirDocDir.Join(version2.String()).Exists())
+		require.False(t, orphanJSONDocDir.Joinf("%s.json", version3).Exists())
+
+		exists, err := conn.DocumentExists(ctx, noVersionDocID)
+		require.NoError(t, err)
+		require.False(t, exists)
+
+		movedCompanyID, err := conn.DocumentCompanyID(ctx, movedDocID)
+		require.NoError(t, err)
+		require.Equal(t, companyID2, movedCompanyID)
+		company2DocIDs, err := conn.CompanyDocumentIDs(ctx, companyID2)
+		require.NoError(t, err)
+		require.Equal(t, uu.IDSlice{movedDocID}, company2DocIDs)
+
+		companyDocIDs, err := conn.CompanyDocumentIDs(ctx, companyID)
+		require.NoError(t, err)
+		require.ElementsMatch(t, uu.IDSlice{okDocID, orphanDirDocID, orphanJSONDocID, noMarkerDocID}, companyDocIDs)
+
+		// The version of the removed orphan directory can be written again
+		err = conn.AddDocumentVersion(ctx, orphanDirDocID, userID, "retry",
+			createVersionWithFiles(version2, "b.txt"),
+			noopOnNew,
+		)
+		require.NoError(t, err)
+	})
+}
+
+func TestAddDocumentVersion_ReplacesUncommittedVersionDir(t *testing.T) {
+	tmp := fs.File(t.TempDir())
+	documentsDir := tmp.Join("documents")
+	companiesDir := tmp.Join("companies")
+	require.NoError(t, documentsDir.MakeDir())
+	require.NoError(t, companiesDir.MakeDir())
+
+	var (
+		ctx       = t.Context()
+		conn      = localfsdb.NewConn(documentsDir, companiesDir)
+		docID     = uu.IDv7()
+		userID    = uu.IDv7()
+		version1  = docdb.MustVersionTimeFromString("2023-01-01_00-00-00.000")
+		version2  = docdb.MustVersionTimeFromString("2023-01-02_00-00-00.000")
+		noopOnNew = func(context.Context, *docdb.VersionInfo) error { return nil }
+	)
+	err := conn.CreateDocument(ctx, uu.IDv7(), docID, userID, "create", version1, newTestMemFiles("a.txt"), noopOnNew)
+	require.NoError(t, err)
+
+	// Version directory of a write that crashed before its commit
+	orphanDir := uuiddir.Join(documentsDir, docID, version2.String())
+	require.NoError(t, orphanDir.MakeDir())
+	require.NoError(t, orphanDir.Join("garbage.txt").WriteAllString("garbage"))
+
+	err = conn.AddDocumentVersion(ctx, docID, userID, "update",
+		createVersionWithFiles(version2, "b.txt"),
+		noopOnNew,
+	)
+	require.NoError(t, err)
+
+	info, err := conn.DocumentVersionInfo(ctx, docID, version2)
+	require.NoError(t, err)
+	require.Equal(t, newTestFileInfos("a.txt", "b.txt"), info.Files)
+	require.False(t, orphanDir.Join("garbage.txt").Exists())
+
+	// Nothing is left in the staging directory
+	require.True(t, documentsDir.Join(".staging").IsEmptyDir())
+}
+
+func TestNewConn_WithRecovery(t *testing.T) {
+	tmp := fs.File(t.TempDir())
+	documentsDir := tmp.Join("documents")
+	companiesDir := tmp.Join("companies")
+	require.NoError(t, documentsDir.MakeDir())
+	require.NoError(t, companiesDir.MakeDir())
+
+	stagingDir := documentsDir.Join(".staging", uu.IDv7().String()+".crashed")
+	require.NoError(t, stagingDir.MakeAllDirs())
+
+	localfsdb.NewConn(documentsDir, companiesDir, localfsdb.WithRecovery(false))
+	require.True(t, stagingDir.Exists(), "not repaired")
+
+	localfsdb.NewConn(documentsDir, companiesDir, localfsdb.WithRecovery(true))
+	require.False(t, stagingDir.Exists(), "repaired")
+}
+
+// createVersionWithFiles returns a CreateVersionFunc writing
+// the files created by newTestMemFiles as version.
+func createVersionWithFiles(version docdb.VersionTime, filenames ...string) docdb.CreateVersionFunc {
+	return func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+		return &docdb.CreateVersionResult{
+			Version:    version,
+			WriteFiles: newTestMemFiles(filenames...),
+		}, nil
+	}
+}
+
+func TestConn_Recover_KeepsDocumentCompanyID(t *testing.T) {
+	var (
+		ctx        = t.Context()
+		conn       = localfsdb.NewTestConn(t)
+		companyID  = uu.IDv7()
+		companyID2 = uu.IDv7()
+		docID      = uu.IDv7()
+		noopOnNew  = func(context.Context, *docdb.VersionInfo) error { return nil }
+	)
+	err := conn.CreateDocument(ctx, companyID, docID, uu.IDv7(), "create", docdb.NewVersionTime(), newTestMemFiles("a.txt"), noopOnNew)
+	require.NoError(t, err)
+	require.NoError(t, conn.SetDocumentCompanyID(ctx, docID, companyID2))
+
+	// The latest version still has the old company,
+	// but company.id is authoritative
+	issues, err := conn.Recover(ctx, true)
+	require.NoError(t, err)
+	require.Empty(t, issues)
+
+	docCompanyID, err := conn.DocumentCompanyID(ctx, docID)
+	require.NoError(t, err)
+	require.Equal(t, companyID2, docCompanyID)
+	docIDs, err := conn.CompanyDocumentIDs(ctx, companyID2)
+	require.NoError(t, err)
+	require.Equal(t, uu.IDSlice{docID}, docIDs)
+}
@@ -0,0 +1,198 @@
+package localfsdb
+
+import (
+	"context"
+	"errors"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-types/uu"
+)
+
+// stagingDirName is the name of the hidden directory within the documents
+// directory where writes are prepared before they are committed by
+// atomically renaming them into place. Deleted documents are moved there
+// before their files are removed.
+//
+// Every entry is named "{docID}.{random}" so it can be attributed to a
+// document. Entries are removed when the write finishes. Entries left
+// behind by a crashed process never were committed and are removed by
+// Conn.Recover.
+const stagingDirName = ".staging"
+
+func (c *Conn) stagingDir() fs.File {
+	return c.documentsDir.Join(stagingDirName)
+}
+
+// makeStagingDir creates a new empty staging directory for a write to docID.
+func (c *Conn) makeStagingDir(docID uu.ID) (fs.File, error) {
+	dir := c.stagingDir().Join(docID.String() + "." + uu.IDv4().String())
+	return dir, dir.MakeAllDirs()
+}
+
+// removeStagingDir removes a staging directory if it still exists.
+// Errors are only logged because whatever was staged has already been
+// committed or abandoned and Conn.Recover removes left over staging directories.
+func removeStagingDir(ctx context.Context, dir fs.File) {
+	if !dir.Exists() {
+		return
+	}
+	if err := dir.RemoveRecursive(); err != nil {
+		log.ErrorCtx(ctx, "Can't remove staging directory").
+			Str("dir", dir.LocalPath()).
+			Err(err).
+			Log()
+	}
+}
+
+// stagingDirDocID parses the document ID from the name of a staging directory entry.
+func stagingDirDocID(name string) (uu.ID, error) {
+	docIDStr, _, _ := strings.Cut(name, ".")
+	return uu.IDFromString(docIDStr)
+}
+
+// commitVersion moves the version directory and the version info JSON file
+// of version from stagingDir into docDir. The JSON file is moved last because
+// its existence marks the version as committed: readers skip version
+// directories without JSON file and Conn.Recover removes them.
+func commitVersion(stagingDir, docDir fs.File, version docdb.VersionTime) error {
+	var (
+		versionDir      = version.String()
+		versionInfoFile = version.String() + ".json"
+	)
+	err := syncTree(stagingDir)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(stagingDir.Join(versionDir).LocalPath(), docDir.Join(versionDir).LocalPath())
+	if err != nil {
+		return err
+	}
+	err = os.Rename(stagingDir.Join(versionInfoFile).LocalPath(), docDir.Join(versionInfoFile).LocalPath())
+	if err != nil {
+		return errors.Join(err, docDir.Join(versionDir).RemoveRecursive())
+	}
+	return syncDir(docDir.LocalPath())
+}
+
+// uncommitVersion removes a committed version from docDir
+// in the reverse order of commitVersion.
+func uncommitVersion(docDir fs.File, version docdb.VersionTime) (err error) {
+	versionInfoFile := docDir.Joinf("%s.json", version)
+	if versionInfoFile.Exists() {
+		err = versionInfoFile.Remove()
+		if err != nil {
+			return err
+		}
+	}
+	versionDir := docDir.Join(version.String())
+	if versionDir.Exists() {
+		return versionDir.RemoveRecursive()
+	}
+	return nil
+}
+
+// commitDocumentDir moves a complete document directory
+// from stagingDir to docDir, which must not exist.
+func (c *Conn) commitDocumentDir(stagingDir, docDir fs.File) error {
+	err := syncTree(stagingDir)
+	if err != nil {
+		return err
+	}
+	parentDir := docDir.Dir()
+	err = parentDir.MakeAllDirs()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(stagingDir.LocalPath(), docDir.LocalPath())
+	if err != nil {
+		return err
+	}
+	return syncDir(parentDir.LocalPath())
+}
+
+// removeDocumentDir deletes docDir by first atomically moving it into
+// the staging directory, so a crash can't leave a partially deleted
+// document behind, and then removes its now empty parent directories.
+func (c *Conn) removeDocumentDir(ctx context.Context, docID uu.ID, docDir fs.File) error {
+	trashDir := c.stagingDir().Join(docID.String() + "." + uu.IDv4().String())
+	err := c.stagingDir().MakeAllDirs()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(docDir.LocalPath(), trashDir.LocalPath())
+	if err != nil {
+		return err
+	}
+	for dir := docDir.Dir(); dir.Path() != c.documentsDir.Path() && dir.IsEmptyDir(); dir = dir.Dir() {
+		err = dir.Remove()
+		if err != nil {
+			return err
+		}
+	}
+	removeStagingDir(ctx, trashDir)
+	return nil
+}
+
+// writeFileAtomic replaces the content of file by writing
+// a temporary file in the staging directory and renaming it to file.
+func (c *Conn) writeFileAtomic(ctx context.Context, docID uu.ID, file fs.File, data []byte) (err error) {
+	stagingDir, err := c.makeStagingDir(docID)
+	if err != nil {
+		return err
+	}
+	defer removeStagingDir(ctx, stagingDir)
+
+	tempFile := stagingDir.Join(file.Name())
+	err = tempFile.WriteAll(data)
+	if err != nil {
+		return err
+	}
+	err = syncFile(tempFile.LocalPath())
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tempFile.LocalPath(), file.LocalPath())
+	if err != nil {
+		return err
+	}
+	return syncDir(file.Dir().LocalPath())
+}
+
+// syncTree flushes all files and directories below
+// and including dir to stable storage.
+func syncTree(dir fs.File) error {
+	return filepath.WalkDir(dir.LocalPath(), func(path string, entry iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return syncDir(path)
+		}
+		return syncFile(path)
+	})
+}
+
+func syncFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return errors.Join(file.Sync(), file.Close())
+}
+
+// syncDir flushes the directory entries of the directory at path
+// so that renames into it survive a crash.
+// Directories can't be synced on Windows where this is a no-op.
+func syncDir(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	return syncFile(path)
+}
@@ -7,6 +7,7 @@ package logconn
 
 import (
 	"context"
+	"io"
 
 	"github.com/ungerik/go-fs"
 
@@ -51,6 +52,32 @@ func (c *logConn) ReadDocumentVersionFile(ctx context.Context, docID uu.ID, vers
 	return data, nil
 }
 
+// OpenDocumentVersionFile logs the number of bytes read
+// from the returned reader when it is closed.
+func (c *logConn) OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (io.ReadCloser, error) {
+	reader, err := c.Conn.OpenDocumentVersionFile(ctx, docID, version, filename)
+	if err != nil {
+		return nil, err
+	}
+	return &logReadCloser{ReadCloser: reader, ctx: ctx, log: c.log, docID: docID, version: version, filename: filename}, nil
+}
+
+func (c *logConn) ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string, offset, length int64) ([]byte, error) {
+	data, err := c.Conn.ReadDocumentVersionFileRange(ctx, docID, version, filename, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	c.log.InfoCtx(ctx, "Read file range").
+		UUID("docID", docID).
+		Stringer("version", version).
+		Str("filename", filename).
+		Int64("offset", offset).
+		Int64("length", length).
+		Int("sizeBytes", len(data)).
+		Log()
+	return data, nil
+}
+
 func (c *logConn) CreateDocument(
 	ctx context.Context,
 	companyID, docID, userID uu.ID,
@@ -77,7 +104,25 @@ func (c *logConn) AddDocumentVersion(
 	createVersion docdb.CreateVersionFunc,
 	onNewVersion docdb.OnNewVersionFunc,
 ) error {
-	wrappedCreate := func(ctx context.Context, docID uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+	return c.Conn.AddDocumentVersion(ctx, docID, userID, reason, c.logCreateVersion(createVersion), onNewVersion)
+}
+
+func (c *logConn) AddDocumentVersionIfLatest(
+	ctx context.Context,
+	docID uu.ID,
+	expectedPrev docdb.VersionTime,
+	userID uu.ID,
+	reason string,
+	createVersion docdb.CreateVersionFunc,
+	onNewVersion docdb.OnNewVersionFunc,
+) error {
+	return c.Conn.AddDocumentVersionIfLatest(ctx, docID, expectedPrev, userID, reason, c.logCreateVersion(createVersion), onNewVersion)
+}
+
+// logCreateVersion wraps createVersion so that reads of the previous
+// version files and the files written by the new version are logged.
+func (c *logConn) logCreateVersion(createVersion docdb.CreateVersionFunc) docdb.CreateVersionFunc {
+	return func(ctx context.Context, docID uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider) (*docdb.CreateVersionResult, error) {
 		wrappedPrev := &logFileProvider{FileProvider: prevFiles, log: c.log, docID: docID, version: prevVersion}
 		result, err := createVersion(ctx, docID, prevVersion, wrappedPrev)
 		if err != nil || result == nil {
@@ -93,7 +138,6 @@ func (c *logConn) AddDocumentVersion(
 		}
 		return result, nil
 	}
-	return c.Conn.AddDocumentVersion(ctx, docID, userID, reason, wrappedCreate, onNewVersion)
 }
 
 func (c *logConn) AddMultiDocumentVersion(
@@ -130,6 +174,42 @@ func (p *logFileProvider) ReadFile(ctx context.Context, filename string) ([]byte
 	return data, nil
 }
 
+func (p *logFileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	reader, err := p.FileProvider.OpenFile(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	return &logReadCloser{ReadCloser: reader, ctx: ctx, log: p.log, docID: p.docID, version: p.version, filename: filename}, nil
+}
+
+// logReadCloser counts the bytes read from the wrapped io.ReadCloser
+// and logs them as a single "Read file" entry on Close.
+type logReadCloser struct {
+	io.ReadCloser
+	ctx      context.Context
+	log      *golog.Logger
+	docID    uu.ID
+	version  docdb.VersionTime
+	filename string
+	size     int64
+}
+
+func (r *logReadCloser) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *logReadCloser) Close() error {
+	r.log.InfoCtx(r.ctx, "Read file").
+		UUID("docID", r.docID).
+		Stringer("version", r.version).
+		Str("filename", r.filename).
+		Int64("sizeBytes", r.size).
+		Log()
+	return r.ReadCloser.Close()
+}
+
 var (
 	_ docdb.Conn         = (*logConn)(nil)
 	_ docdb.FileProvider = (*logFileProvider)(nil)
@@ -0,0 +1,765 @@
+// Package memconn provides a docdb.Conn implementation that keeps all
+// documents in memory.
+//
+// It implements the documented semantics of docdb.Conn like the persistent
+// backends and is meant for tests and ephemeral use, for example as
+// destination of docdb.SyncDocument for a short-lived copy of documents.
+// All data is lost when the Conn is garbage collected.
+//
+// File data is copied when it is written and when it is read,
+// so callers can't modify stored versions through shared slices.
+// Versions are immutable after they were committed and share
+// the data of files that were carried forward unchanged.
+//
+// Writes to a document are serialized by a mutex per document.
+// A new version is only committed after the onNewVersion callback
+// returned without error, so a failing or panicking callback
+// leaves no trace and readers never see an uncommitted version.
+package memconn
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+)
+
+// Compiler check if *Conn implements docdb.Conn
+var _ docdb.Conn = new(Conn)
+
+// Conn is an in-memory docdb.Conn.
+// The zero value is not usable, use New to create a Conn.
+type Conn struct {
+	// mtx guards documents and the fields of the documents.
+	// It is only held briefly and never while calling callbacks.
+	mtx       sync.RWMutex
+	documents map[uu.ID]*document
+
+	// docWriteMtx serializes writes per document
+	// including the calls of their callbacks.
+	docWriteMtx *uu.IDMutex
+}
+
+type document struct {
+	companyID uu.ID
+	// versions is sorted by version time in ascending order
+	versions []*version
+}
+
+type version struct {
+	info *docdb.VersionInfo
+	// files maps filenames to their data,
+	// the data must never be modified.
+	files map[string][]byte
+}
+
+// New returns an empty in-memory Conn.
+func New() *Conn {
+	return &Conn{
+		documents:   make(map[uu.ID]*document),
+		docWriteMtx: uu.NewIDMutex(),
+	}
+}
+
+func (c *Conn) String() string {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return fmt.Sprintf("memconn.Conn{Documents: %d}", len(c.documents))
+}
+
+// lockDocument acquires the write mutex of a document.
+func (c *Conn) lockDocument(docID uu.ID) (unlock func()) {
+	c.docWriteMtx.Lock(docID)
+	return func() { c.docWriteMtx.Unlock(docID) }
+}
+
+// getDocument returns a snapshot of the document with a copy
+// of its version list or ErrDocumentNotFound.
+func (c *Conn) getDocument(docID uu.ID) (companyID uu.ID, versions []*version, err error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	doc, ok := c.documents[docID]
+	if !ok {
+		return uu.IDNil, nil, docdb.NewErrDocumentNotFound(docID)
+	}
+	return doc.companyID, slices.Clone(doc.versions), nil
+}
+
+// getVersion returns a document version or
+// ErrDocumentNotFound or ErrDocumentVersionNotFound.
+func (c *Conn) getVersion(docID uu.ID, versionTime docdb.VersionTime) (*version, error) {
+	_, versions, err := c.getDocument(docID)
+	if err != nil {
+		return nil, err
+	}
+	i, found := findVersion(versions, versionTime)
+	if !found {
+		return nil, docdb.NewErrDocumentVersionNotFound(docID, versionTime)
+	}
+	return versions[i], nil
+}
+
+// getFile returns the data of a file of a document version or
+// ErrDocumentNotFound, ErrDocumentVersionNotFound or ErrDocumentFileNotFound.
+// The returned data must not be modified.
+func (c *Conn) getFile(docID uu.ID, versionTime docdb.VersionTime, filename string) ([]byte, error) {
+	v, err := c.getVersion(docID, versionTime)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := v.files[filename]
+	if !ok {
+		return nil, docdb.NewErrDocumentFileNotFound(docID, filename)
+	}
+	return data, nil
+}
+
+func (c *Conn) DocumentExists(ctx context.Context, docID uu.ID) (exists bool, err error) {
+	if err = ctx.Err(); err != nil {
+		return false, err
+	}
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	_, exists = c.documents[docID]
+	return exists, nil
+}
+
+func (c *Conn) CompanyIDs(ctx context.Context) (companyIDs uu.IDSlice, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx)
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	companies := make(uu.IDSet)
+	for _, doc := range c.documents {
+		companies.Add(doc.companyID)
+	}
+	companyIDs = companies.AsSlice()
+	companyIDs.Sort() // Sort by ID for a consistent order
+	return companyIDs, nil
+}
+
+func (c *Conn) CompanyDocumentIDs(ctx context.Context, companyID uu.ID) (docIDs uu.IDSlice, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, companyID)
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	for docID, doc := range c.documents {
+		if doc.companyID == companyID {
+			docIDs = append(docIDs, docID)
+		}
+	}
+	docIDs.Sort() // Sort by ID for a consistent order
+	return docIDs, nil
+}
+
+func (c *Conn) DocumentCompanyID(ctx context.Context, docID uu.ID) (companyID uu.ID, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID)
+
+	if err = ctx.Err(); err != nil {
+		return uu.IDNil, err
+	}
+
+	companyID, _, err = c.getDocument(docID)
+	return companyID, err
+}
+
+func (c *Conn) SetDocumentCompanyID(ctx context.Context, docID, companyID uu.ID) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, companyID)
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = companyID.Validate(); err != nil {
+		return err
+	}
+	unlock := c.lockDocument(docID)
+	defer unlock()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	doc, ok := c.documents[docID]
+	if !ok {
+		return docdb.NewErrDocumentNotFound(docID)
+	}
+	doc.companyID = companyID
+	return nil
+}
+
+func (c *Conn) DocumentVersions(ctx context.Context, docID uu.ID) (versions []docdb.VersionTime, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID)
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	_, docVersions, err := c.getDocument(docID)
+	if err != nil {
+		return nil, err
+	}
+	return versionTimes(docVersions), nil
+}
+
+func (c *Conn) LatestDocumentVersion(ctx context.Context, docID uu.ID) (latest docdb.VersionTime, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID)
+
+	if err = ctx.Err(); err != nil {
+		return docdb.VersionTime{}, err
+	}
+
+	_, versions, err := c.getDocument(docID)
+	if err != nil {
+		return docdb.VersionTime{}, err
+	}
+	return versions[len(versions)-1].info.Version, nil
+}
+
+func (c *Conn) DocumentVersionInfo(ctx context.Context, docID uu.ID, version docdb.VersionTime) (versionInfo *docdb.VersionInfo, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version)
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err = version.Validate(); err != nil {
+		return nil, err
+	}
+
+	v, err := c.getVersion(docID, version)
+	if err != nil {
+		return nil, err
+	}
+	return v.info.Clone(), nil
+}
+
+func (c *Conn) LatestDocumentVersionInfo(ctx context.Context, docID uu.ID) (versionInfo *docdb.VersionInfo, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID)
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	_, versions, err := c.getDocument(docID)
+	if err != nil {
+		return nil, err
+	}
+	return versions[len(versions)-1].info.Clone(), nil
+}
+
+func (c *Conn) DocumentVersionFileProvider(ctx context.Context, docID uu.ID, version docdb.VersionTime) (p docdb.FileProvider, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version)
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	v, err := c.getVersion(docID, version)
+	if err != nil {
+		return nil, err
+	}
+	return fileProvider(v.files), nil
+}
+
+func (c *Conn) ReadDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (data []byte, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version, filename)
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err = c.getFile(docID, version, filename)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(data), nil
+}
+
+func (c *Conn) OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (reader io.ReadCloser, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version, filename)
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := c.getFile(docID, version, filename)
+	if err != nil {
+		return nil, err
+	}
+	// Stored data is never modified, so it can be read without copying
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c *Conn) ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string, offset, length int64) (data []byte, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version, filename, offset, length)
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err = c.getFile(docID, version, filename)
+	if err != nil {
+		return nil, err
+	}
+	// ReadRange returns a copy of the range
+	return docdb.ReadRange(bytes.NewReader(data), offset, length)
+}
+
+func (c *Conn) DeleteDocument(ctx context.Context, docID uu.ID) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID)
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	unlock := c.lockDocument(docID)
+	defer unlock()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.documents[docID]; !ok {
+		return docdb.NewErrDocumentNotFound(docID)
+	}
+	delete(c.documents, docID)
+	return nil
+}
+
+func (c *Conn) DeleteDocumentVersion(ctx context.Context, docID uu.ID, version docdb.VersionTime) (leftVersions []docdb.VersionTime, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, version)
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	unlock := c.lockDocument(docID)
+	defer unlock()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	doc, ok := c.documents[docID]
+	if !ok {
+		return nil, docdb.NewErrDocumentNotFound(docID)
+	}
+	i, found := findVersion(doc.versions, version)
+	if !found {
+		return nil, docdb.NewErrDocumentVersionNotFound(docID, version)
+	}
+	// Don't modify the backing array of the slice
+	// that may have been returned by getDocument
+	doc.versions = slices.Concat(doc.versions[:i], doc.versions[i+1:])
+	if len(doc.versions) == 0 {
+		// If no versions left, delete the document
+		delete(c.documents, docID)
+		return nil, nil
+	}
+	return versionTimes(doc.versions), nil
+}
+
+func (c *Conn) CreateDocument(ctx context.Context, companyID, docID, userID uu.ID, reason string, newVersion docdb.VersionTime, files []fs.FileReader, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, companyID, docID, userID, reason, newVersion, files, onNewVersion)
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = companyID.Validate(); err != nil {
+		return err
+	}
+	if err = docID.Validate(); err != nil {
+		return err
+	}
+	if err = userID.Validate(); err != nil {
+		return err
+	}
+	if err = newVersion.Validate(); err != nil {
+		return err
+	}
+	if onNewVersion == nil {
+		return errs.New("nil onNewVersion func passed to CreateDocument")
+	}
+	if len(files) == 0 {
+		// The first version of a document must contain at least one file:
+		// a document cannot start with an empty, change-less version.
+		return errs.Errorf("cannot create document %s without files", docID)
+	}
+
+	unlock := c.lockDocument(docID)
+	defer unlock()
+
+	idempotencyKey := docdb.IdempotencyKeyFromContext(ctx)
+	if _, existing, e := c.getDocument(docID); e == nil {
+		if original := idempotentVersion(existing, idempotencyKey); original != nil {
+			return safelyCallOnNewVersionFunc(ctx, original.info.Clone(), onNewVersion)
+		}
+		return docdb.NewErrDocumentAlreadyExists(docID)
+	}
+
+	versionFiles := make(map[string][]byte, len(files))
+	err = readFiles(ctx, versionFiles, files)
+	if err != nil {
+		return err
+	}
+	v := buildVersion(companyID, docID, newVersion, nil, userID, reason, versionFiles)
+	v.info.IdempotencyKey = idempotencyKey
+
+	err = safelyCallOnNewVersionFunc(ctx, v.info.Clone(), onNewVersion)
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.documents[docID] = &document{
+		companyID: companyID,
+		versions:  []*version{v},
+	}
+	return nil
+}
+
+func (c *Conn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, userID, reason, createVersion, onNewVersion)
+
+	return c.addDocumentVersion(ctx, docID, nil, userID, reason, createVersion, onNewVersion)
+}
+
+func (c *Conn) AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, expectedPrev, userID, reason, createVersion, onNewVersion)
+
+	return c.addDocumentVersion(ctx, docID, &expectedPrev, userID, reason, createVersion, onNewVersion)
+}
+
+// addDocumentVersion implements AddDocumentVersion and, with a non-nil
+// expectedPrev, AddDocumentVersionIfLatest. The latest version is compared
+// with expectedPrev while holding the document's write lock,
+// so no other version can be added in between.
+func (c *Conn) addDocumentVersion(ctx context.Context, docID uu.ID, expectedPrev *docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = docID.Validate(); err != nil {
+		return err
+	}
+	if err = userID.Validate(); err != nil {
+		return err
+	}
+	if createVersion == nil {
+		return errs.New("nil createVersion func passed to AddDocumentVersion")
+	}
+	if onNewVersion == nil {
+		return errs.New("nil onNewVersion func passed to AddDocumentVersion")
+	}
+
+	unlock := c.lockDocument(docID)
+	defer unlock()
+
+	prevCompanyID, versions, err := c.getDocument(docID)
+	if err != nil {
+		return err
+	}
+	idempotencyKey := docdb.IdempotencyKeyFromContext(ctx)
+	if original := idempotentVersion(versions, idempotencyKey); original != nil {
+		return safelyCallOnNewVersionFunc(ctx, original.info.Clone(), onNewVersion)
+	}
+	prev := versions[len(versions)-1]
+	if expectedPrev != nil && !prev.info.Version.Equal(*expectedPrev) {
+		return docdb.NewErrDocumentChanged(docID, *expectedPrev)
+	}
+
+	result, err := safelyCallCreateVersionFunc(
+		ctx,
+		docID,
+		prev.info.Version,
+		fileProvider(prev.files),
+		createVersion,
+	)
+	if err != nil {
+		return err
+	}
+	if err = result.Validate(); err != nil {
+		return err
+	}
+	if !result.Version.After(prev.info.Version) {
+		return errs.Errorf("version %s returned from CreateVersionFunc is not after previous version %s", result.Version, prev.info.Version)
+	}
+
+	// Carry forward previous version files that are not in
+	// WriteFiles or RemoveFiles, their data is shared
+	versionFiles := make(map[string][]byte, len(prev.files)+len(result.WriteFiles))
+	for filename, data := range prev.files {
+		if fs.NameIndex(result.WriteFiles, filename) >= 0 || slices.Contains(result.RemoveFiles, filename) {
+			continue
+		}
+		versionFiles[filename] = data
+	}
+	err = readFiles(ctx, versionFiles, result.WriteFiles)
+	if err != nil {
+		return err
+	}
+
+	companyID := result.NewCompanyID.GetOr(prevCompanyID)
+	v := buildVersion(companyID, docID, result.Version, prev.info, userID, reason, versionFiles)
+	v.info.IdempotencyKey = idempotencyKey
+
+	if len(v.files) == 0 {
+		// Every version must contain at least one file: removing all files of a
+		// document is not allowed (use DeleteDocument to remove the document).
+		return errs.Errorf("cannot remove all files of document %s: every version must contain at least one file", docID)
+	}
+
+	if v.info.EqualFiles(prev.info) {
+		return docdb.ErrNoChanges
+	}
+
+	err = safelyCallOnNewVersionFunc(ctx, v.info.Clone(), onNewVersion)
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	// The write lock guarantees that the document was not changed
+	// by another writer, but it can't be modified in place because
+	// readers might still use a snapshot of the versions slice
+	doc := c.documents[docID]
+	doc.versions = append(slices.Clip(doc.versions), v)
+	doc.companyID = companyID
+	return nil
+}
+
+func (c *Conn) AddMultiDocumentVersion(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docIDs, userID, reason, createVersion, onNewVersion)
+
+	return docdb.AddMultiDocumentVersionImpl(ctx, c, docIDs, userID, reason, createVersion, onNewVersion)
+}
+
+func (c *Conn) RestoreDocument(ctx context.Context, doc *docdb.HashedDocument, recreate bool) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, doc, recreate)
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = doc.Validate(); err != nil {
+		return err
+	}
+
+	unlock := c.lockDocument(doc.ID)
+	defer unlock()
+
+	// With recreate an existing document is replaced as a whole,
+	// so its versions are not merged
+	var existingVersions []*version
+	existingCompanyID, versions, err := c.getDocument(doc.ID)
+	if err == nil && !recreate {
+		if existingCompanyID != doc.CompanyID {
+			return errs.Errorf(
+				"cannot restore document %s into existing document with different companyID: backup %s != existing %s",
+				doc.ID, doc.CompanyID, existingCompanyID,
+			)
+		}
+		existingVersions = versions
+	}
+
+	// All versions are built before the document is replaced at once,
+	// so an error leaves the document unchanged
+	var (
+		restored []*version
+		prev     *version
+	)
+	for _, vt := range doc.VersionTimes() {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if i, found := findVersion(existingVersions, vt); found {
+			prev = existingVersions[i]
+			restored = append(restored, prev)
+			continue
+		}
+		hv := doc.Versions[vt]
+		versionFiles := make(map[string][]byte, len(hv.FileHashes))
+		for filename, hash := range hv.FileHashes {
+			versionFiles[filename] = bytes.Clone(doc.HashedFiles[hash])
+		}
+		var prevInfo *docdb.VersionInfo
+		if prev != nil {
+			prevInfo = prev.info
+		}
+		prev = buildVersion(doc.CompanyID, doc.ID, vt, prevInfo, hv.CommitUserID, hv.CommitReason, versionFiles)
+		restored = append(restored, prev)
+	}
+	// Existing versions that are not in the backup are kept
+	for _, v := range existingVersions {
+		if _, found := findVersion(restored, v.info.Version); !found {
+			restored = append(restored, v)
+		}
+	}
+	slices.SortFunc(restored, func(a, b *version) int {
+		return a.info.Version.Compare(b.info.Version)
+	})
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.documents[doc.ID] = &document{
+		companyID: doc.CompanyID,
+		versions:  restored,
+	}
+	return nil
+}
+
+func safelyCallCreateVersionFunc(ctx context.Context, docID uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider, createVersion docdb.CreateVersionFunc) (result *docdb.CreateVersionResult, err error) {
+	defer errs.RecoverPanicAsError(&err)
+
+	return createVersion(ctx, docID, prevVersion, prevFiles)
+}
+
+func safelyCallOnNewVersionFunc(ctx context.Context, versionInfo *docdb.VersionInfo, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	defer errs.RecoverPanicAsError(&err)
+
+	return onNewVersion(ctx, versionInfo)
+}
+
+// readFiles reads a copy of the data of files into versionFiles.
+func readFiles(ctx context.Context, versionFiles map[string][]byte, files []fs.FileReader) error {
+	for _, file := range files {
+		data, err := file.ReadAllContext(ctx)
+		if err != nil {
+			return err
+		}
+		versionFiles[file.Name()] = bytes.Clone(data)
+	}
+	return nil
+}
+
+// idempotentVersion returns the latest of versions
+// committed with the idempotency key or nil.
+func idempotentVersion(versions []*version, idempotencyKey string) *version {
+	if idempotencyKey == "" {
+		return nil
+	}
+	for _, v := range slices.Backward(versions) {
+		if v.info.IdempotencyKey == idempotencyKey {
+			return v
+		}
+	}
+	return nil
+}
+
+// buildVersion returns a version with files and a VersionInfo
+// diffing files against prevInfo (if not nil).
+func buildVersion(companyID, docID uu.ID, versionTime docdb.VersionTime, prevInfo *docdb.VersionInfo, commitUserID uu.ID, commitReason string, files map[string][]byte) *version {
+	info := &docdb.VersionInfo{
+		CompanyID:    companyID,
+		DocID:        docID,
+		Version:      versionTime,
+		CommitUserID: commitUserID,
+		CommitReason: commitReason,
+		Files:        make(map[string]docdb.FileInfo, len(files)),
+	}
+	for filename, data := range files {
+		info.Files[filename] = docdb.FileInfo{
+			Name: filename,
+			Size: int64(len(data)),
+			Hash: docdb.ContentHash(data),
+		}
+	}
+
+	if prevInfo == nil {
+		info.AddedFiles = slices.Collect(maps.Keys(info.Files))
+	} else {
+		prevVersion := prevInfo.Version
+		info.PrevVersion = &prevVersion
+		for filename, file := range info.Files {
+			prevFile, prevHasFile := prevInfo.Files[filename]
+			switch {
+			case !prevHasFile:
+				info.AddedFiles = append(info.AddedFiles, filename)
+			case file.Hash != prevFile.Hash:
+				info.ModifiedFiles = append(info.ModifiedFiles, filename)
+			}
+		}
+		for filename := range prevInfo.Files {
+			if _, hasFile := info.Files[filename]; !hasFile {
+				info.RemovedFiles = append(info.RemovedFiles, filename)
+			}
+		}
+	}
+	slices.Sort(info.AddedFiles)
+	slices.Sort(info.RemovedFiles)
+	slices.Sort(info.ModifiedFiles)
+
+	return &version{info: info, files: files}
+}
+
+// findVersion returns the index of versionTime in the sorted versions.
+func findVersion(versions []*version, versionTime docdb.VersionTime) (int, bool) {
+	return slices.BinarySearchFunc(versions, versionTime, func(v *version, t docdb.VersionTime) int {
+		return v.info.Version.Compare(t)
+	})
+}
+
+func versionTimes(versions []*version) []docdb.VersionTime {
+	times := make([]docdb.VersionTime, len(versions))
+	for i, v := range versions {
+		times[i] = v.info.Version
+	}
+	return times
+}
+
+// fileProvider implements docdb.FileProvider for the files of a version.
+type fileProvider map[string][]byte
+
+func (p fileProvider) HasFile(filename string) (bool, error) {
+	_, ok := p[filename]
+	return ok, nil
+}
+
+func (p fileProvider) ListFiles(ctx context.Context) (filenames []string, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(p)), nil
+}
+
+func (p fileProvider) ReadFile(ctx context.Context, filename string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, ok := p[filename]
+	if !ok {
+		return nil, fs.NewErrPathDoesNotExist(filename)
+	}
+	return bytes.Clone(data), nil
+}
+
+func (p fileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, ok := p[filename]
+	if !ok {
+		return nil, fs.NewErrPathDoesNotExist(filename)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
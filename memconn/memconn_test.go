@@ -0,0 +1,449 @@
+package memconn_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/docdbtest"
+	"github.com/domonda/go-docdb/memconn"
+	"github.com/domonda/go-types/uu"
+)
+
+var (
+	version1 = docdb.MustVersionTimeFromString("2023-01-01_00-00-00.000")
+	version2 = docdb.MustVersionTimeFromString("2023-01-02_00-00-00.000")
+	version3 = docdb.MustVersionTimeFromString("2023-01-03_00-00-00.000")
+)
+
+func noopOnNewVersion(context.Context, *docdb.VersionInfo) error { return nil }
+
+func createTestDocument(t *testing.T, conn *memconn.Conn, companyID, docID uu.ID, files ...fs.FileReader) {
+	t.Helper()
+	err := conn.CreateDocument(t.Context(), companyID, docID, uu.IDv7(), "create", version1, files, noopOnNewVersion)
+	require.NoError(t, err)
+}
+
+func writeFiles(version docdb.VersionTime, files ...fs.FileReader) docdb.CreateVersionFunc {
+	return func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+		return &docdb.CreateVersionResult{Version: version, WriteFiles: files}, nil
+	}
+}
+
+func TestCreateDocument(t *testing.T) {
+	var (
+		ctx       = t.Context()
+		conn      = memconn.New()
+		companyID = uu.IDv7()
+		docID     = uu.IDv7()
+		userID    = uu.IDv7()
+		content   = []byte("pdf")
+	)
+
+	var captured *docdb.VersionInfo
+	err := conn.CreateDocument(ctx, companyID, docID, userID, "create", version1,
+		[]fs.FileReader{fs.NewMemFile("doc.pdf", content)},
+		docdb.CaptureNewVersionInfo(&captured),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"doc.pdf"}, captured.AddedFiles)
+	require.Nil(t, captured.PrevVersion)
+
+	info, err := conn.LatestDocumentVersionInfo(ctx, docID)
+	require.NoError(t, err)
+	require.True(t, info.Equal(captured))
+	require.Equal(t, companyID, info.CompanyID)
+	require.Equal(t, userID, info.CommitUserID)
+
+	// Stored data is isolated from the written and returned slices
+	content[0] = 'X'
+	data, err := conn.ReadDocumentVersionFile(ctx, docID, version1, "doc.pdf")
+	require.NoError(t, err)
+	require.Equal(t, []byte("pdf"), data)
+	data[0] = 'X'
+	reader, err := conn.OpenDocumentVersionFile(ctx, docID, version1, "doc.pdf")
+	require.NoError(t, err)
+	data, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, []byte("pdf"), data)
+
+	err = conn.CreateDocument(ctx, companyID, docID, userID, "create", version2,
+		[]fs.FileReader{fs.NewMemFile("doc.pdf", content)},
+		noopOnNewVersion,
+	)
+	require.ErrorIs(t, err, docdb.NewErrDocumentAlreadyExists(docID))
+
+	err = conn.CreateDocument(ctx, companyID, uu.IDv7(), userID, "create", version1, nil, noopOnNewVersion)
+	require.Error(t, err, "document without files")
+}
+
+func TestCreateDocument_RollsBack(t *testing.T) {
+	for name, onNewVersion := range map[string]docdb.OnNewVersionFunc{
+		"error": func(context.Context, *docdb.VersionInfo) error { return errors.New("rejected") },
+		"panic": func(context.Context, *docdb.VersionInfo) error { panic("bug") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				conn      = memconn.New()
+				companyID = uu.IDv7()
+				docID     = uu.IDv7()
+			)
+			err := conn.CreateDocument(t.Context(), companyID, docID, uu.IDv7(), "create", version1,
+				[]fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))},
+				onNewVersion,
+			)
+			require.Error(t, err)
+
+			exists, err := conn.DocumentExists(t.Context(), docID)
+			require.NoError(t, err)
+			require.False(t, exists)
+			companyIDs, err := conn.CompanyIDs(t.Context())
+			require.NoError(t, err)
+			require.Empty(t, companyIDs)
+		})
+	}
+}
+
+func TestAddDocumentVersion(t *testing.T) {
+	var (
+		ctx       = t.Context()
+		conn      = memconn.New()
+		companyID = uu.IDv7()
+		docID     = uu.IDv7()
+	)
+	createTestDocument(t, conn, companyID, docID,
+		fs.NewMemFile("doc.pdf", []byte("pdf")),
+		fs.NewMemFile("a.txt", []byte("a")),
+	)
+
+	var captured *docdb.VersionInfo
+	err := conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "add",
+		func(ctx context.Context, _ uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			require.Equal(t, version1, prevVersion)
+			filenames, err := prevFiles.ListFiles(ctx)
+			require.NoError(t, err)
+			require.Equal(t, []string{"a.txt", "doc.pdf"}, filenames)
+			return &docdb.CreateVersionResult{
+				Version:     version2,
+				WriteFiles:  []fs.FileReader{fs.NewMemFile("a.txt", []byte("modified")), fs.NewMemFile("b.txt", []byte("b"))},
+				RemoveFiles: []string{"doc.pdf"},
+			}, nil
+		},
+		docdb.CaptureNewVersionInfo(&captured),
+	)
+	require.NoError(t, err)
+	require.Equal(t, &version1, captured.PrevVersion)
+	require.Equal(t, []string{"b.txt"}, captured.AddedFiles)
+	require.Equal(t, []string{"a.txt"}, captured.ModifiedFiles)
+	require.Equal(t, []string{"doc.pdf"}, captured.RemovedFiles)
+
+	versions, err := conn.DocumentVersions(ctx, docID)
+	require.NoError(t, err)
+	require.Equal(t, []docdb.VersionTime{version1, version2}, versions)
+
+	// The previous version is unchanged
+	data, err := conn.ReadDocumentVersionFile(ctx, docID, version1, "a.txt")
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), data)
+	_, err = conn.ReadDocumentVersionFile(ctx, docID, version2, "doc.pdf")
+	require.ErrorIs(t, err, docdb.NewErrDocumentFileNotFound(docID, "doc.pdf"))
+
+	t.Run("ErrNoChanges", func(t *testing.T) {
+		err := conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "same", writeFiles(version3, fs.NewMemFile("b.txt", []byte("b"))), noopOnNewVersion)
+		require.ErrorIs(t, err, docdb.ErrNoChanges)
+	})
+
+	t.Run("version not after previous", func(t *testing.T) {
+		err := conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "old", writeFiles(version1, fs.NewMemFile("c.txt", []byte("c"))), noopOnNewVersion)
+		require.Error(t, err)
+	})
+
+	t.Run("removing all files is rejected", func(t *testing.T) {
+		err := conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "remove",
+			func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+				return &docdb.CreateVersionResult{Version: version3, RemoveFiles: []string{"a.txt", "b.txt"}}, nil
+			},
+			noopOnNewVersion,
+		)
+		require.Error(t, err)
+	})
+
+	t.Run("document not found", func(t *testing.T) {
+		missingID := uu.IDv7()
+		err := conn.AddDocumentVersion(ctx, missingID, uu.IDv7(), "add", writeFiles(version3, fs.NewMemFile("c.txt", []byte("c"))), noopOnNewVersion)
+		require.ErrorIs(t, err, docdb.NewErrDocumentNotFound(missingID))
+	})
+
+	versions, err = conn.DocumentVersions(ctx, docID)
+	require.NoError(t, err)
+	require.Equal(t, []docdb.VersionTime{version1, version2}, versions)
+}
+
+func TestAddDocumentVersion_RollsBack(t *testing.T) {
+	for name, onNewVersion := range map[string]docdb.OnNewVersionFunc{
+		"error": func(context.Context, *docdb.VersionInfo) error { return errors.New("rejected") },
+		"panic": func(context.Context, *docdb.VersionInfo) error { panic("bug") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				ctx          = t.Context()
+				conn         = memconn.New()
+				companyID    = uu.IDv7()
+				newCompanyID = uu.IDv7()
+				docID        = uu.IDv7()
+			)
+			createTestDocument(t, conn, companyID, docID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+			err := conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "add",
+				func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+					return &docdb.CreateVersionResult{
+						Version:      version2,
+						WriteFiles:   []fs.FileReader{fs.NewMemFile("b.txt", []byte("b"))},
+						NewCompanyID: uu.NullableID(newCompanyID),
+					}, nil
+				},
+				onNewVersion,
+			)
+			require.Error(t, err)
+
+			versions, err := conn.DocumentVersions(ctx, docID)
+			require.NoError(t, err)
+			require.Equal(t, []docdb.VersionTime{version1}, versions)
+			gotCompanyID, err := conn.DocumentCompanyID(ctx, docID)
+			require.NoError(t, err)
+			require.Equal(t, companyID, gotCompanyID)
+		})
+	}
+}
+
+func TestAddDocumentVersion_NewCompanyID(t *testing.T) {
+	var (
+		ctx          = t.Context()
+		conn         = memconn.New()
+		companyID    = uu.IDv7()
+		newCompanyID = uu.IDv7()
+		docID        = uu.IDv7()
+	)
+	createTestDocument(t, conn, companyID, docID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+	err := conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "move",
+		func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			return &docdb.CreateVersionResult{
+				Version:      version2,
+				WriteFiles:   []fs.FileReader{fs.NewMemFile("b.txt", []byte("b"))},
+				NewCompanyID: uu.NullableID(newCompanyID),
+			}, nil
+		},
+		noopOnNewVersion,
+	)
+	require.NoError(t, err)
+
+	gotCompanyID, err := conn.DocumentCompanyID(ctx, docID)
+	require.NoError(t, err)
+	require.Equal(t, newCompanyID, gotCompanyID)
+	docIDs, err := conn.CompanyDocumentIDs(ctx, companyID)
+	require.NoError(t, err)
+	require.Empty(t, docIDs)
+	docIDs, err = conn.CompanyDocumentIDs(ctx, newCompanyID)
+	require.NoError(t, err)
+	require.Equal(t, uu.IDSlice{docID}, docIDs)
+}
+
+func TestAddDocumentVersionIfLatest(t *testing.T) {
+	var (
+		ctx   = t.Context()
+		conn  = memconn.New()
+		docID = uu.IDv7()
+	)
+	createTestDocument(t, conn, uu.IDv7(), docID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+	err := conn.AddDocumentVersionIfLatest(ctx, docID, version1, uu.IDv7(), "add", writeFiles(version2, fs.NewMemFile("a.txt", []byte("a"))), noopOnNewVersion)
+	require.NoError(t, err)
+
+	err = conn.AddDocumentVersionIfLatest(ctx, docID, version1, uu.IDv7(), "stale", writeFiles(version3, fs.NewMemFile("b.txt", []byte("b"))), noopOnNewVersion)
+	require.ErrorIs(t, err, docdb.NewErrDocumentChanged(docID, version1))
+
+	latest, err := conn.LatestDocumentVersion(ctx, docID)
+	require.NoError(t, err)
+	require.Equal(t, version2, latest)
+}
+
+func TestAddMultiDocumentVersion_RollsBack(t *testing.T) {
+	var (
+		ctx    = t.Context()
+		conn   = memconn.New()
+		docID1 = uu.IDv7()
+		docID2 = uu.IDv7()
+	)
+	createTestDocument(t, conn, uu.IDv7(), docID1, fs.NewMemFile("doc.pdf", []byte("pdf")))
+	createTestDocument(t, conn, uu.IDv7(), docID2, fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+	err := conn.AddMultiDocumentVersion(ctx, uu.IDSlice{docID1, docID2}, uu.IDv7(), "multi",
+		writeFiles(version2, fs.NewMemFile("a.txt", []byte("a"))),
+		func(_ context.Context, versionInfo *docdb.VersionInfo) error {
+			if versionInfo.DocID == docID2 {
+				return errors.New("rejected")
+			}
+			return nil
+		},
+	)
+	require.Error(t, err)
+
+	for _, docID := range []uu.ID{docID1, docID2} {
+		versions, err := conn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, []docdb.VersionTime{version1}, versions)
+	}
+}
+
+func TestDeleteDocumentVersion(t *testing.T) {
+	var (
+		ctx       = t.Context()
+		conn      = memconn.New()
+		companyID = uu.IDv7()
+		docID     = uu.IDv7()
+	)
+	createTestDocument(t, conn, companyID, docID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+	err := conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "add", writeFiles(version2, fs.NewMemFile("a.txt", []byte("a"))), noopOnNewVersion)
+	require.NoError(t, err)
+
+	_, err = conn.DeleteDocumentVersion(ctx, docID, version3)
+	require.ErrorIs(t, err, docdb.NewErrDocumentVersionNotFound(docID, version3))
+
+	leftVersions, err := conn.DeleteDocumentVersion(ctx, docID, version1)
+	require.NoError(t, err)
+	require.Equal(t, []docdb.VersionTime{version2}, leftVersions)
+
+	// Deleting the last version deletes the document
+	leftVersions, err = conn.DeleteDocumentVersion(ctx, docID, version2)
+	require.NoError(t, err)
+	require.Empty(t, leftVersions)
+	exists, err := conn.DocumentExists(ctx, docID)
+	require.NoError(t, err)
+	require.False(t, exists)
+	docIDs, err := conn.CompanyDocumentIDs(ctx, companyID)
+	require.NoError(t, err)
+	require.Empty(t, docIDs)
+
+	err = conn.DeleteDocument(ctx, docID)
+	require.ErrorIs(t, err, docdb.NewErrDocumentNotFound(docID))
+}
+
+func TestCompanyIDs(t *testing.T) {
+	var (
+		ctx        = t.Context()
+		conn       = memconn.New()
+		companyID1 = uu.IDFrom("ffffffff-0000-4000-8000-000000000000")
+		companyID2 = uu.IDFrom("00000000-0000-4000-8000-000000000000")
+		docID1     = uu.IDFrom("ffffffff-0000-4000-8000-000000000001")
+		docID2     = uu.IDFrom("00000000-0000-4000-8000-000000000001")
+		docID3     = uu.IDFrom("88888888-0000-4000-8000-000000000001")
+	)
+	createTestDocument(t, conn, companyID1, docID1, fs.NewMemFile("doc.pdf", []byte("pdf")))
+	createTestDocument(t, conn, companyID1, docID2, fs.NewMemFile("doc.pdf", []byte("pdf")))
+	createTestDocument(t, conn, companyID2, docID3, fs.NewMemFile("doc.pdf", []byte("pdf")))
+
+	companyIDs, err := conn.CompanyIDs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uu.IDSlice{companyID2, companyID1}, companyIDs)
+
+	docIDs, err := conn.CompanyDocumentIDs(ctx, companyID1)
+	require.NoError(t, err)
+	require.Equal(t, uu.IDSlice{docID2, docID1}, docIDs)
+
+	docIDs, err = conn.CompanyDocumentIDs(ctx, uu.IDv7())
+	require.NoError(t, err)
+	require.Nil(t, docIDs)
+}
+
+func TestRestoreDocument(t *testing.T) {
+	var (
+		ctx       = t.Context()
+		companyID = uu.IDv7()
+		docID     = uu.IDv7()
+		pdfHash   = docdb.ContentHash([]byte("pdf"))
+		aHash     = docdb.ContentHash([]byte("a"))
+	)
+	doc := &docdb.HashedDocument{
+		ID:        docID,
+		CompanyID: companyID,
+		Versions: map[docdb.VersionTime]*docdb.HashedVersion{
+			version1: {CommitUserID: uu.IDv7(), CommitReason: "v1", FileHashes: map[string]string{"doc.pdf": pdfHash}},
+			version2: {CommitUserID: uu.IDv7(), CommitReason: "v2", FileHashes: map[string]string{"doc.pdf": pdfHash, "a.txt": aHash}},
+		},
+		HashedFiles: map[string][]byte{pdfHash: []byte("pdf"), aHash: []byte("a")},
+	}
+
+	t.Run("new document", func(t *testing.T) {
+		conn := memconn.New()
+
+		require.NoError(t, conn.RestoreDocument(ctx, doc, false))
+
+		restored, err := docdb.ReadHashedDocument(ctx, conn, docID)
+		require.NoError(t, err)
+		require.Equal(t, doc, restored)
+		info, err := conn.DocumentVersionInfo(ctx, docID, version2)
+		require.NoError(t, err)
+		require.Equal(t, &version1, info.PrevVersion)
+		require.Equal(t, []string{"a.txt"}, info.AddedFiles)
+	})
+
+	t.Run("merges into existing document", func(t *testing.T) {
+		conn := memconn.New()
+		createTestDocument(t, conn, companyID, docID, fs.NewMemFile("doc.pdf", []byte("pdf")))
+		existing, err := conn.DocumentVersionInfo(ctx, docID, version1)
+		require.NoError(t, err)
+		err = conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "v3", writeFiles(version3, fs.NewMemFile("c.txt", []byte("c"))), noopOnNewVersion)
+		require.NoError(t, err)
+
+		require.NoError(t, conn.RestoreDocument(ctx, doc, false))
+
+		versions, err := conn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, []docdb.VersionTime{version1, version2, version3}, versions)
+		// Existing versions are kept as-is
+		info, err := conn.DocumentVersionInfo(ctx, docID, version1)
+		require.NoError(t, err)
+		require.True(t, info.Equal(existing))
+	})
+
+	t.Run("refuses to merge into document of other company", func(t *testing.T) {
+		conn := memconn.New()
+		createTestDocument(t, conn, uu.IDv7(), docID, fs.NewMemFile("other.pdf", []byte("other")))
+
+		require.Error(t, conn.RestoreDocument(ctx, doc, false))
+
+		versions, err := conn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, []docdb.VersionTime{version1}, versions)
+		_, err = conn.ReadDocumentVersionFile(ctx, docID, version1, "other.pdf")
+		require.NoError(t, err)
+	})
+
+	t.Run("recreate replaces existing document", func(t *testing.T) {
+		conn := memconn.New()
+		createTestDocument(t, conn, uu.IDv7(), docID, fs.NewMemFile("other.pdf", []byte("other")))
+		err := conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "v3", writeFiles(version3, fs.NewMemFile("c.txt", []byte("c"))), noopOnNewVersion)
+		require.NoError(t, err)
+
+		require.NoError(t, conn.RestoreDocument(ctx, doc, true))
+
+		restored, err := docdb.ReadHashedDocument(ctx, conn, docID)
+		require.NoError(t, err)
+		require.Equal(t, doc, restored)
+		companyIDs, err := conn.CompanyIDs(ctx)
+		require.NoError(t, err)
+		require.Equal(t, uu.IDSlice{companyID}, companyIDs)
+	})
+}
+
+func TestConnConformance(t *testing.T) {
+	docdbtest.RunConnTests(t, func(t *testing.T) docdb.Conn {
+		return memconn.New()
+	})
+}
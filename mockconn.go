@@ -2,6 +2,7 @@ package docdb
 
 import (
 	"context"
+	"io"
 
 	"github.com/ungerik/go-fs"
 
@@ -11,23 +12,26 @@ import (
 // MockConn is a Conn implementation with function fields
 // for each method, intended for use in unit tests.
 type MockConn struct {
-	DocumentExistsMock              func(ctx context.Context, docID uu.ID) (exists bool, err error)
-	CompanyIDsMock                  func(ctx context.Context) (uu.IDSlice, error)
-	CompanyDocumentIDsMock          func(ctx context.Context, companyID uu.ID) (uu.IDSlice, error)
-	DocumentCompanyIDMock           func(ctx context.Context, docID uu.ID) (companyID uu.ID, err error)
-	SetDocumentCompanyIDMock        func(ctx context.Context, docID, companyID uu.ID) error
-	DocumentVersionsMock            func(ctx context.Context, docID uu.ID) ([]VersionTime, error)
-	LatestDocumentVersionMock       func(ctx context.Context, docID uu.ID) (VersionTime, error)
-	DocumentVersionInfoMock         func(ctx context.Context, docID uu.ID, version VersionTime) (*VersionInfo, error)
-	LatestDocumentVersionInfoMock   func(ctx context.Context, docID uu.ID) (*VersionInfo, error)
-	DocumentVersionFileProviderMock func(ctx context.Context, docID uu.ID, version VersionTime) (FileProvider, error)
-	ReadDocumentVersionFileMock     func(ctx context.Context, docID uu.ID, version VersionTime, filename string) (data []byte, err error)
-	DeleteDocumentMock              func(ctx context.Context, docID uu.ID) error
-	DeleteDocumentVersionMock       func(ctx context.Context, docID uu.ID, version VersionTime) (leftVersions []VersionTime, err error)
-	CreateDocumentMock              func(ctx context.Context, companyID, docID, userID uu.ID, reason string, version VersionTime, files []fs.FileReader, onNewVersion OnNewVersionFunc) error
-	AddDocumentVersionMock          func(ctx context.Context, docID, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) error
-	AddMultiDocumentVersionMock     func(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) error
-	RestoreDocumentMock             func(ctx context.Context, doc *HashedDocument, recreate bool) error
+	DocumentExistsMock               func(ctx context.Context, docID uu.ID) (exists bool, err error)
+	CompanyIDsMock                   func(ctx context.Context) (uu.IDSlice, error)
+	CompanyDocumentIDsMock           func(ctx context.Context, companyID uu.ID) (uu.IDSlice, error)
+	DocumentCompanyIDMock            func(ctx context.Context, docID uu.ID) (companyID uu.ID, err error)
+	SetDocumentCompanyIDMock         func(ctx context.Context, docID, companyID uu.ID) error
+	DocumentVersionsMock             func(ctx context.Context, docID uu.ID) ([]VersionTime, error)
+	LatestDocumentVersionMock        func(ctx context.Context, docID uu.ID) (VersionTime, error)
+	DocumentVersionInfoMock          func(ctx context.Context, docID uu.ID, version VersionTime) (*VersionInfo, error)
+	LatestDocumentVersionInfoMock    func(ctx context.Context, docID uu.ID) (*VersionInfo, error)
+	DocumentVersionFileProviderMock  func(ctx context.Context, docID uu.ID, version VersionTime) (FileProvider, error)
+	ReadDocumentVersionFileMock      func(ctx context.Context, docID uu.ID, version VersionTime, filename string) (data []byte, err error)
+	OpenDocumentVersionFileMock      func(ctx context.Context, docID uu.ID, version VersionTime, filename string) (io.ReadCloser, error)
+	ReadDocumentVersionFileRangeMock func(ctx context.Context, docID uu.ID, version VersionTime, filename string, offset, length int64) ([]byte, error)
+	DeleteDocumentMock               func(ctx context.Context, docID uu.ID) error
+	DeleteDocumentVersionMock        func(ctx context.Context, docID uu.ID, version VersionTime) (leftVersions []VersionTime, err error)
+	CreateDocumentMock               func(ctx context.Context, companyID, docID, userID uu.ID, reason string, version VersionTime, files []fs.FileReader, onNewVersion OnNewVersionFunc) error
+	AddDocumentVersionMock           func(ctx context.Context, docID, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) error
+	AddDocumentVersionIfLatestMock   func(ctx context.Context, docID uu.ID, expectedPrev VersionTime, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) error
+	AddMultiDocumentVersionMock      func(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) error
+	RestoreDocumentMock              func(ctx context.Context, doc *HashedDocument, recreate bool) error
 }
 
 func (mock *MockConn) DocumentExists(ctx context.Context, docID uu.ID) (exists bool, err error) {
@@ -73,6 +77,14 @@ func (mock *MockConn) ReadDocumentVersionFile(ctx context.Context, docID uu.ID,
 	return mock.ReadDocumentVersionFileMock(ctx, docID, version, filename)
 }
 
+func (mock *MockConn) OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version VersionTime, filename string) (io.ReadCloser, error) {
+	return mock.OpenDocumentVersionFileMock(ctx, docID, version, filename)
+}
+
+func (mock *MockConn) ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version VersionTime, filename string, offset, length int64) ([]byte, error) {
+	return mock.ReadDocumentVersionFileRangeMock(ctx, docID, version, filename, offset, length)
+}
+
 func (mock *MockConn) DeleteDocument(ctx context.Context, docID uu.ID) error {
 	return mock.DeleteDocumentMock(ctx, docID)
 }
@@ -89,6 +101,10 @@ func (mock *MockConn) AddDocumentVersion(ctx context.Context, docID, userID uu.I
 	return mock.AddDocumentVersionMock(ctx, docID, userID, reason, createVersion, onNewVersion)
 }
 
+func (mock *MockConn) AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev VersionTime, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) error {
+	return mock.AddDocumentVersionIfLatestMock(ctx, docID, expectedPrev, userID, reason, createVersion, onNewVersion)
+}
+
 func (mock *MockConn) AddMultiDocumentVersion(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion CreateVersionFunc, onNewVersion OnNewVersionFunc) error {
 	return mock.AddMultiDocumentVersionMock(ctx, docIDs, userID, reason, createVersion, onNewVersion)
 }
@@ -0,0 +1,497 @@
+// Package otelconn provides a docdb.Conn adapter that emits
+// OpenTelemetry spans and metrics for every method call.
+//
+// Spans are named like "docdb.CreateDocument" and carry the document ID,
+// company ID, version, file count and bytes read or written as attributes.
+// The CreateVersionFunc and OnNewVersionFunc callbacks get child spans
+// so slow callbacks are distinguishable from slow storage, and reads
+// through FileProviders returned or passed by the wrapped Conn
+// get spans like "docdb.FileProvider.ReadFile".
+//
+// The following histograms are recorded with the attributes
+// "docdb.method" and "docdb.backend":
+//
+//   - docdb.operation.duration: duration of a method call in seconds
+//   - docdb.read.size: bytes of file content read per call
+//   - docdb.write.size: bytes of file content written per call
+package otelconn
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/ungerik/go-fs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-types/uu"
+)
+
+// ScopeName is the instrumentation scope name of the
+// tracer and meter used by the Conn returned from New.
+const ScopeName = "github.com/domonda/go-docdb/otelconn"
+
+// Attribute keys set on spans.
+const (
+	BackendKey      = attribute.Key("docdb.backend")
+	MethodKey       = attribute.Key("docdb.method")
+	DocIDKey        = attribute.Key("docdb.doc_id")
+	DocIDsKey       = attribute.Key("docdb.doc_ids")
+	CompanyIDKey    = attribute.Key("docdb.company_id")
+	VersionKey      = attribute.Key("docdb.version")
+	FilenameKey     = attribute.Key("docdb.filename")
+	FileCountKey    = attribute.Key("docdb.file_count")
+	BytesReadKey    = attribute.Key("docdb.bytes_read")
+	BytesWrittenKey = attribute.Key("docdb.bytes_written")
+	OffsetKey       = attribute.Key("docdb.offset")
+	LengthKey       = attribute.Key("docdb.length")
+)
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+}
+
+// Option configures the Conn returned by New.
+type Option func(*config)
+
+// WithTracerProvider sets the TracerProvider
+// instead of the global otel.GetTracerProvider().
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = provider
+	}
+}
+
+// WithMeterProvider sets the MeterProvider
+// instead of the global otel.GetMeterProvider().
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = provider
+	}
+}
+
+// New returns a docdb.Conn that wraps conn and traces and measures
+// all method calls. The backend name, like "localfsdb" or "s3",
+// is recorded with every span and metric to tell wrapped Conns apart.
+//
+// Errors creating the instruments are passed to otel.Handle.
+func New(conn docdb.Conn, backend string, options ...Option) docdb.Conn {
+	config := config{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+	}
+	for _, option := range options {
+		option(&config)
+	}
+	meter := config.meterProvider.Meter(ScopeName)
+	duration, err := meter.Float64Histogram(
+		"docdb.operation.duration",
+		metric.WithDescription("Duration of docdb.Conn method calls"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	readSize, err := meter.Int64Histogram(
+		"docdb.read.size",
+		metric.WithDescription("Bytes of file content read per call"),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	writeSize, err := meter.Int64Histogram(
+		"docdb.write.size",
+		metric.WithDescription("Bytes of file content written per call"),
+		metric.WithUnit("By"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	return &otelConn{
+		conn:      conn,
+		backend:   backend,
+		tracer:    config.tracerProvider.Tracer(ScopeName),
+		duration:  duration,
+		readSize:  readSize,
+		writeSize: writeSize,
+	}
+}
+
+type otelConn struct {
+	conn      docdb.Conn
+	backend   string
+	tracer    trace.Tracer
+	duration  metric.Float64Histogram
+	readSize  metric.Int64Histogram
+	writeSize metric.Int64Histogram
+}
+
+// operation is a traced and measured call.
+type operation struct {
+	conn   *otelConn
+	method string
+	span   trace.Span
+	start  time.Time
+
+	filesWritten int
+	bytesWritten int64
+}
+
+// start starts a span named "docdb."+method
+// that is a child of the span in ctx.
+func (c *otelConn) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, *operation) {
+	attrs = append(attrs, BackendKey.String(c.backend))
+	ctx, span := c.tracer.Start(ctx, "docdb."+method, trace.WithAttributes(attrs...))
+	return ctx, &operation{conn: c, method: method, span: span, start: time.Now()}
+}
+
+func (op *operation) metricAttrs() metric.MeasurementOption {
+	return metric.WithAttributes(MethodKey.String(op.method), BackendKey.String(op.conn.backend))
+}
+
+func (op *operation) read(ctx context.Context, size int64) {
+	op.span.SetAttributes(BytesReadKey.Int64(size))
+	op.conn.readSize.Record(ctx, size, op.metricAttrs())
+}
+
+// write adds to the files and bytes written by the operation
+// which can write multiple times, like AddMultiDocumentVersion.
+func (op *operation) write(ctx context.Context, files int, size int64) {
+	op.filesWritten += files
+	op.bytesWritten += size
+	op.span.SetAttributes(FileCountKey.Int(op.filesWritten), BytesWrittenKey.Int64(op.bytesWritten))
+	op.conn.writeSize.Record(ctx, size, op.metricAttrs())
+}
+
+func filesSize(files []fs.FileReader) (size int64) {
+	for _, file := range files {
+		size += file.Size()
+	}
+	return size
+}
+
+// end records err and the duration and ends the span.
+func (op *operation) end(ctx context.Context, err error) {
+	if err != nil {
+		op.span.RecordError(err)
+		op.span.SetStatus(codes.Error, err.Error())
+	}
+	op.conn.duration.Record(ctx, time.Since(op.start).Seconds(), op.metricAttrs())
+	op.span.End()
+}
+
+func (c *otelConn) DocumentExists(ctx context.Context, docID uu.ID) (exists bool, err error) {
+	ctx, op := c.start(ctx, "DocumentExists", DocIDKey.String(docID.String()))
+	defer func() { op.end(ctx, err) }()
+
+	return c.conn.DocumentExists(ctx, docID)
+}
+
+func (c *otelConn) CompanyIDs(ctx context.Context) (companyIDs uu.IDSlice, err error) {
+	ctx, op := c.start(ctx, "CompanyIDs")
+	defer func() { op.end(ctx, err) }()
+
+	return c.conn.CompanyIDs(ctx)
+}
+
+func (c *otelConn) CompanyDocumentIDs(ctx context.Context, companyID uu.ID) (docIDs uu.IDSlice, err error) {
+	ctx, op := c.start(ctx, "CompanyDocumentIDs", CompanyIDKey.String(companyID.String()))
+	defer func() { op.end(ctx, err) }()
+
+	return c.conn.CompanyDocumentIDs(ctx, companyID)
+}
+
+func (c *otelConn) DocumentCompanyID(ctx context.Context, docID uu.ID) (companyID uu.ID, err error) {
+	ctx, op := c.start(ctx, "DocumentCompanyID", DocIDKey.String(docID.String()))
+	defer func() { op.end(ctx, err) }()
+
+	companyID, err = c.conn.DocumentCompanyID(ctx, docID)
+	if err == nil {
+		op.span.SetAttributes(CompanyIDKey.String(companyID.String()))
+	}
+	return companyID, err
+}
+
+func (c *otelConn) SetDocumentCompanyID(ctx context.Context, docID, companyID uu.ID) (err error) {
+	ctx, op := c.start(ctx, "SetDocumentCompanyID", DocIDKey.String(docID.String()), CompanyIDKey.String(companyID.String()))
+	defer func() { op.end(ctx, err) }()
+
+	return c.conn.SetDocumentCompanyID(ctx, docID, companyID)
+}
+
+func (c *otelConn) DocumentVersions(ctx context.Context, docID uu.ID) (versions []docdb.VersionTime, err error) {
+	ctx, op := c.start(ctx, "DocumentVersions", DocIDKey.String(docID.String()))
+	defer func() { op.end(ctx, err) }()
+
+	return c.conn.DocumentVersions(ctx, docID)
+}
+
+func (c *otelConn) LatestDocumentVersion(ctx context.Context, docID uu.ID) (version docdb.VersionTime, err error) {
+	ctx, op := c.start(ctx, "LatestDocumentVersion", DocIDKey.String(docID.String()))
+	defer func() { op.end(ctx, err) }()
+
+	version, err = c.conn.LatestDocumentVersion(ctx, docID)
+	if err == nil {
+		op.span.SetAttributes(VersionKey.String(version.String()))
+	}
+	return version, err
+}
+
+func (c *otelConn) DocumentVersionInfo(ctx context.Context, docID uu.ID, version docdb.VersionTime) (info *docdb.VersionInfo, err error) {
+	ctx, op := c.start(ctx, "DocumentVersionInfo", DocIDKey.String(docID.String()), VersionKey.String(version.String()))
+	defer func() { op.end(ctx, err) }()
+
+	info, err = c.conn.DocumentVersionInfo(ctx, docID, version)
+	if err == nil {
+		setVersionInfoAttributes(op.span, info)
+	}
+	return info, err
+}
+
+func (c *otelConn) LatestDocumentVersionInfo(ctx context.Context, docID uu.ID) (info *docdb.VersionInfo, err error) {
+	ctx, op := c.start(ctx, "LatestDocumentVersionInfo", DocIDKey.String(docID.String()))
+	defer func() { op.end(ctx, err) }()
+
+	info, err = c.conn.LatestDocumentVersionInfo(ctx, docID)
+	if err == nil {
+		setVersionInfoAttributes(op.span, info)
+	}
+	return info, err
+}
+
+func setVersionInfoAttributes(span trace.Span, info *docdb.VersionInfo) {
+	span.SetAttributes(
+		CompanyIDKey.String(info.CompanyID.String()),
+		VersionKey.String(info.Version.String()),
+		FileCountKey.Int(len(info.Files)),
+	)
+}
+
+// DocumentVersionFileProvider traces the call returning the provider
+// and every read through the returned provider separately.
+func (c *otelConn) DocumentVersionFileProvider(ctx context.Context, docID uu.ID, version docdb.VersionTime) (provider docdb.FileProvider, err error) {
+	ctx, op := c.start(ctx, "DocumentVersionFileProvider", DocIDKey.String(docID.String()), VersionKey.String(version.String()))
+	defer func() { op.end(ctx, err) }()
+
+	provider, err = c.conn.DocumentVersionFileProvider(ctx, docID, version)
+	if err != nil {
+		return nil, err
+	}
+	return &otelFileProvider{FileProvider: provider, conn: c, docID: docID, version: version}, nil
+}
+
+func (c *otelConn) ReadDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (data []byte, err error) {
+	ctx, op := c.start(ctx, "ReadDocumentVersionFile",
+		DocIDKey.String(docID.String()),
+		VersionKey.String(version.String()),
+		FilenameKey.String(filename),
+	)
+	defer func() { op.end(ctx, err) }()
+
+	data, err = c.conn.ReadDocumentVersionFile(ctx, docID, version, filename)
+	if err != nil {
+		return nil, err
+	}
+	op.read(ctx, int64(len(data)))
+	return data, nil
+}
+
+// OpenDocumentVersionFile ends the span when the returned reader
+// is closed, so its duration includes the streaming of the file.
+func (c *otelConn) OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (io.ReadCloser, error) {
+	ctx, op := c.start(ctx, "OpenDocumentVersionFile",
+		DocIDKey.String(docID.String()),
+		VersionKey.String(version.String()),
+		FilenameKey.String(filename),
+	)
+	reader, err := c.conn.OpenDocumentVersionFile(ctx, docID, version, filename)
+	if err != nil {
+		op.end(ctx, err)
+		return nil, err
+	}
+	return &otelReadCloser{ReadCloser: reader, ctx: ctx, op: op}, nil
+}
+
+func (c *otelConn) ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string, offset, length int64) (data []byte, err error) {
+	ctx, op := c.start(ctx, "ReadDocumentVersionFileRange",
+		DocIDKey.String(docID.String()),
+		VersionKey.String(version.String()),
+		FilenameKey.String(filename),
+		OffsetKey.Int64(offset),
+		LengthKey.Int64(length),
+	)
+	defer func() { op.end(ctx, err) }()
+
+	data, err = c.conn.ReadDocumentVersionFileRange(ctx, docID, version, filename, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	op.read(ctx, int64(len(data)))
+	return data, nil
+}
+
+func (c *otelConn) DeleteDocument(ctx context.Context, docID uu.ID) (err error) {
+	ctx, op := c.start(ctx, "DeleteDocument", DocIDKey.String(docID.String()))
+	defer func() { op.end(ctx, err) }()
+
+	return c.conn.DeleteDocument(ctx, docID)
+}
+
+func (c *otelConn) DeleteDocumentVersion(ctx context.Context, docID uu.ID, version docdb.VersionTime) (leftVersions []docdb.VersionTime, err error) {
+	ctx, op := c.start(ctx, "DeleteDocumentVersion", DocIDKey.String(docID.String()), VersionKey.String(version.String()))
+	defer func() { op.end(ctx, err) }()
+
+	return c.conn.DeleteDocumentVersion(ctx, docID, version)
+}
+
+func (c *otelConn) CreateDocument(ctx context.Context, companyID, docID, userID uu.ID, reason string, version docdb.VersionTime, files []fs.FileReader, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	ctx, op := c.start(ctx, "CreateDocument",
+		DocIDKey.String(docID.String()),
+		CompanyIDKey.String(companyID.String()),
+		VersionKey.String(version.String()),
+	)
+	defer func() { op.end(ctx, err) }()
+
+	op.write(ctx, len(files), filesSize(files))
+	return c.conn.CreateDocument(ctx, companyID, docID, userID, reason, version, files, c.traceOnNewVersion(onNewVersion))
+}
+
+func (c *otelConn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	ctx, op := c.start(ctx, "AddDocumentVersion", DocIDKey.String(docID.String()))
+	defer func() { op.end(ctx, err) }()
+
+	return c.conn.AddDocumentVersion(ctx, docID, userID, reason, c.traceCreateVersion(op, createVersion), c.traceOnNewVersion(onNewVersion))
+}
+
+func (c *otelConn) AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	ctx, op := c.start(ctx, "AddDocumentVersionIfLatest", DocIDKey.String(docID.String()))
+	defer func() { op.end(ctx, err) }()
+
+	return c.conn.AddDocumentVersionIfLatest(ctx, docID, expectedPrev, userID, reason, c.traceCreateVersion(op, createVersion), c.traceOnNewVersion(onNewVersion))
+}
+
+func (c *otelConn) AddMultiDocumentVersion(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	ctx, op := c.start(ctx, "AddMultiDocumentVersion", DocIDsKey.StringSlice(docIDs.Strings()))
+	defer func() { op.end(ctx, err) }()
+
+	return c.conn.AddMultiDocumentVersion(ctx, docIDs, userID, reason, c.traceCreateVersion(op, createVersion), c.traceOnNewVersion(onNewVersion))
+}
+
+func (c *otelConn) RestoreDocument(ctx context.Context, doc *docdb.HashedDocument, recreate bool) (err error) {
+	ctx, op := c.start(ctx, "RestoreDocument",
+		DocIDKey.String(doc.ID.String()),
+		CompanyIDKey.String(doc.CompanyID.String()),
+		attribute.Bool("docdb.recreate", recreate),
+	)
+	defer func() { op.end(ctx, err) }()
+
+	var size int64
+	for _, data := range doc.HashedFiles {
+		size += int64(len(data))
+	}
+	op.write(ctx, len(doc.HashedFiles), size)
+	return c.conn.RestoreDocument(ctx, doc, recreate)
+}
+
+// traceCreateVersion wraps createVersion with a child span
+// and traces the reads of the previous version files.
+// The files written by the new version are recorded
+// with the write size of the calling operation.
+func (c *otelConn) traceCreateVersion(op *operation, createVersion docdb.CreateVersionFunc) docdb.CreateVersionFunc {
+	return func(ctx context.Context, docID uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider) (result *docdb.CreateVersionResult, err error) {
+		ctx, callbackOp := c.start(ctx, "CreateVersionFunc", DocIDKey.String(docID.String()), VersionKey.String(prevVersion.String()))
+		defer func() { callbackOp.end(ctx, err) }()
+
+		wrappedPrev := &otelFileProvider{FileProvider: prevFiles, conn: c, docID: docID, version: prevVersion}
+		result, err = createVersion(ctx, docID, prevVersion, wrappedPrev)
+		if err != nil || result == nil {
+			return result, err
+		}
+		op.write(ctx, len(result.WriteFiles), filesSize(result.WriteFiles))
+		return result, nil
+	}
+}
+
+// traceOnNewVersion wraps onNewVersion with a child span.
+func (c *otelConn) traceOnNewVersion(onNewVersion docdb.OnNewVersionFunc) docdb.OnNewVersionFunc {
+	return func(ctx context.Context, versionInfo *docdb.VersionInfo) (err error) {
+		ctx, op := c.start(ctx, "OnNewVersionFunc", DocIDKey.String(versionInfo.DocID.String()))
+		defer func() { op.end(ctx, err) }()
+
+		setVersionInfoAttributes(op.span, versionInfo)
+		return onNewVersion(ctx, versionInfo)
+	}
+}
+
+// otelFileProvider wraps a docdb.FileProvider and traces
+// every ReadFile and OpenFile call.
+type otelFileProvider struct {
+	docdb.FileProvider
+	conn    *otelConn
+	docID   uu.ID
+	version docdb.VersionTime
+}
+
+func (p *otelFileProvider) ReadFile(ctx context.Context, filename string) (data []byte, err error) {
+	ctx, op := p.conn.start(ctx, "FileProvider.ReadFile",
+		DocIDKey.String(p.docID.String()),
+		VersionKey.String(p.version.String()),
+		FilenameKey.String(filename),
+	)
+	defer func() { op.end(ctx, err) }()
+
+	data, err = p.FileProvider.ReadFile(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	op.read(ctx, int64(len(data)))
+	return data, nil
+}
+
+func (p *otelFileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	ctx, op := p.conn.start(ctx, "FileProvider.OpenFile",
+		DocIDKey.String(p.docID.String()),
+		VersionKey.String(p.version.String()),
+		FilenameKey.String(filename),
+	)
+	reader, err := p.FileProvider.OpenFile(ctx, filename)
+	if err != nil {
+		op.end(ctx, err)
+		return nil, err
+	}
+	return &otelReadCloser{ReadCloser: reader, ctx: ctx, op: op}, nil
+}
+
+// otelReadCloser counts the bytes read from the wrapped io.ReadCloser
+// and records them and ends the span of op on Close.
+type otelReadCloser struct {
+	io.ReadCloser
+	ctx  context.Context
+	op   *operation
+	size int64
+}
+
+func (r *otelReadCloser) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *otelReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.op.read(r.ctx, r.size)
+	r.op.end(r.ctx, err)
+	return err
+}
+
+var (
+	_ docdb.Conn         = (*otelConn)(nil)
+	_ docdb.FileProvider = (*otelFileProvider)(nil)
+)
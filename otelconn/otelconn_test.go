@@ -0,0 +1,210 @@
+package otelconn_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/docdbtest"
+	"github.com/domonda/go-docdb/memconn"
+	"github.com/domonda/go-docdb/otelconn"
+	"github.com/domonda/go-types/uu"
+)
+
+func newConn(t *testing.T) (docdb.Conn, *tracetest.SpanRecorder, *sdkmetric.ManualReader) {
+	t.Helper()
+	spans := tracetest.NewSpanRecorder()
+	metrics := sdkmetric.NewManualReader()
+	conn := otelconn.New(memconn.New(), "memconn",
+		otelconn.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))),
+		otelconn.WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(metrics))),
+	)
+	return conn, spans, metrics
+}
+
+// endedSpans returns the ended spans by name.
+func endedSpans(spans *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans.Ended() {
+		byName[span.Name()] = span
+	}
+	return byName
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// histogramSums returns the sums of the data points
+// of a histogram by the value of the method attribute.
+func histogramSums(t *testing.T, reader *sdkmetric.ManualReader, name string) map[string]int64 {
+	t.Helper()
+	var data metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(t.Context(), &data))
+	sums := make(map[string]int64)
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			if m.Name != name {
+				continue
+			}
+			switch hist := m.Data.(type) {
+			case metricdata.Histogram[int64]:
+				for _, point := range hist.DataPoints {
+					method, _ := point.Attributes.Value(otelconn.MethodKey)
+					sums[method.AsString()] += point.Sum
+				}
+			case metricdata.Histogram[float64]:
+				for _, point := range hist.DataPoints {
+					method, _ := point.Attributes.Value(otelconn.MethodKey)
+					sums[method.AsString()] += int64(point.Count)
+				}
+			}
+		}
+	}
+	return sums
+}
+
+func TestConnConformance(t *testing.T) {
+	docdbtest.RunConnTests(t, func(t *testing.T) docdb.Conn {
+		conn, _, _ := newConn(t)
+		return conn
+	})
+}
+
+func TestCreateDocument(t *testing.T) {
+	conn, spans, metrics := newConn(t)
+	companyID, docID := uu.IDv7(), uu.IDv7()
+
+	err := conn.CreateDocument(t.Context(), companyID, docID, uu.IDv7(), "create", docdbtest.Version1,
+		[]fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf")), fs.NewMemFile("a.txt", []byte("a"))},
+		docdbtest.NoopOnNewVersion,
+	)
+	require.NoError(t, err)
+
+	ended := endedSpans(spans)
+	create := ended["docdb.CreateDocument"]
+	require.NotNil(t, create)
+	require.Equal(t, docID.String(), spanAttr(create, otelconn.DocIDKey).AsString())
+	require.Equal(t, companyID.String(), spanAttr(create, otelconn.CompanyIDKey).AsString())
+	require.Equal(t, docdbtest.Version1.String(), spanAttr(create, otelconn.VersionKey).AsString())
+	require.Equal(t, "memconn", spanAttr(create, otelconn.BackendKey).AsString())
+	require.Equal(t, int64(2), spanAttr(create, otelconn.FileCountKey).AsInt64())
+	require.Equal(t, int64(4), spanAttr(create, otelconn.BytesWrittenKey).AsInt64())
+
+	onNewVersion := ended["docdb.OnNewVersionFunc"]
+	require.NotNil(t, onNewVersion)
+	require.Equal(t, create.SpanContext().SpanID(), onNewVersion.Parent().SpanID(), "callback span must be child of method span")
+
+	require.Equal(t, int64(4), histogramSums(t, metrics, "docdb.write.size")["CreateDocument"])
+	require.Equal(t, int64(1), histogramSums(t, metrics, "docdb.operation.duration")["CreateDocument"])
+}
+
+func TestAddDocumentVersion(t *testing.T) {
+	conn, spans, metrics := newConn(t)
+	ctx := t.Context()
+	docID := uu.IDv7()
+	require.NoError(t, docdbtest.CreateDocument(ctx, conn, docID))
+
+	err := conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "add",
+		func(ctx context.Context, docID uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			_, err := prevFiles.ReadFile(ctx, "doc.pdf")
+			if err != nil {
+				return nil, err
+			}
+			reader, err := prevFiles.OpenFile(ctx, "doc.pdf")
+			if err != nil {
+				return nil, err
+			}
+			defer reader.Close()
+			if _, err = io.ReadAll(reader); err != nil {
+				return nil, err
+			}
+			return &docdb.CreateVersionResult{
+				Version:    docdbtest.Version2,
+				WriteFiles: []fs.FileReader{fs.NewMemFile("ab.txt", []byte("ab"))},
+			}, nil
+		},
+		docdbtest.NoopOnNewVersion,
+	)
+	require.NoError(t, err)
+
+	ended := endedSpans(spans)
+	add := ended["docdb.AddDocumentVersion"]
+	createVersion := ended["docdb.CreateVersionFunc"]
+	readFile := ended["docdb.FileProvider.ReadFile"]
+	openFile := ended["docdb.FileProvider.OpenFile"]
+	for _, span := range []sdktrace.ReadOnlySpan{add, createVersion, readFile, openFile} {
+		require.NotNil(t, span)
+	}
+	require.Equal(t, add.SpanContext().SpanID(), createVersion.Parent().SpanID())
+	require.Equal(t, createVersion.SpanContext().SpanID(), readFile.Parent().SpanID())
+	require.Equal(t, int64(3), spanAttr(readFile, otelconn.BytesReadKey).AsInt64())
+	require.Equal(t, int64(3), spanAttr(openFile, otelconn.BytesReadKey).AsInt64())
+	require.Equal(t, int64(2), spanAttr(add, otelconn.BytesWrittenKey).AsInt64())
+
+	readSizes := histogramSums(t, metrics, "docdb.read.size")
+	require.Equal(t, int64(3), readSizes["FileProvider.ReadFile"])
+	require.Equal(t, int64(3), readSizes["FileProvider.OpenFile"])
+	require.Equal(t, int64(2), histogramSums(t, metrics, "docdb.write.size")["AddDocumentVersion"])
+	require.Equal(t, int64(1), histogramSums(t, metrics, "docdb.operation.duration")["CreateVersionFunc"])
+}
+
+func TestReadDocumentVersionFile(t *testing.T) {
+	conn, spans, metrics := newConn(t)
+	ctx := t.Context()
+	docID := uu.IDv7()
+	require.NoError(t, docdbtest.CreateDocument(ctx, conn, docID))
+
+	_, err := conn.ReadDocumentVersionFile(ctx, docID, docdbtest.Version1, "doc.pdf")
+	require.NoError(t, err)
+
+	reader, err := conn.OpenDocumentVersionFile(ctx, docID, docdbtest.Version1, "doc.pdf")
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NotContains(t, endedSpans(spans), "docdb.OpenDocumentVersionFile", "span must end on Close")
+	require.NoError(t, reader.Close())
+
+	ended := endedSpans(spans)
+	require.Equal(t, "doc.pdf", spanAttr(ended["docdb.ReadDocumentVersionFile"], otelconn.FilenameKey).AsString())
+	require.Equal(t, int64(3), spanAttr(ended["docdb.OpenDocumentVersionFile"], otelconn.BytesReadKey).AsInt64())
+	readSizes := histogramSums(t, metrics, "docdb.read.size")
+	require.Equal(t, int64(3), readSizes["ReadDocumentVersionFile"])
+	require.Equal(t, int64(3), readSizes["OpenDocumentVersionFile"])
+}
+
+func TestErrorStatus(t *testing.T) {
+	errCallback := errors.New("callback failed")
+	conn, spans, _ := newConn(t)
+	ctx := t.Context()
+	docID := uu.IDv7()
+	err := conn.CreateDocument(ctx, uu.IDv7(), docID, uu.IDv7(), "create", docdbtest.Version1,
+		[]fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))},
+		func(context.Context, *docdb.VersionInfo) error { return errCallback },
+	)
+	require.ErrorIs(t, err, errCallback)
+
+	ended := endedSpans(spans)
+	require.Equal(t, codes.Error, ended["docdb.OnNewVersionFunc"].Status().Code)
+	require.Equal(t, codes.Error, ended["docdb.CreateDocument"].Status().Code)
+
+	_, err = conn.LatestDocumentVersion(ctx, docID)
+	require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound))
+	require.Equal(t, codes.Error, endedSpans(spans)["docdb.LatestDocumentVersion"].Status().Code)
+}
@@ -41,6 +41,10 @@ func (c readonlyConn) AddDocumentVersion(_ context.Context, docID, _ uu.ID, _ st
 	return errs.Errorf("cannot add version to document %s: %w", docID, ErrReadonly)
 }
 
+func (c readonlyConn) AddDocumentVersionIfLatest(_ context.Context, docID uu.ID, _ VersionTime, _ uu.ID, _ string, _ CreateVersionFunc, _ OnNewVersionFunc) error {
+	return errs.Errorf("cannot add version to document %s: %w", docID, ErrReadonly)
+}
+
 func (c readonlyConn) AddMultiDocumentVersion(_ context.Context, docIDs uu.IDSlice, _ uu.ID, _ string, _ CreateVersionFunc, _ OnNewVersionFunc) error {
 	return errs.Errorf("cannot add version to documents %s: %w", docIDs, ErrReadonly)
 }
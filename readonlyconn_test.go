@@ -81,6 +81,9 @@ func TestReadonlyConn_WriteMethodsReturnErrReadonly(t *testing.T) {
 		{"AddDocumentVersion", func() error {
 			return conn.AddDocumentVersion(ctx, docID, userID, "reason", nil, nil)
 		}},
+		{"AddDocumentVersionIfLatest", func() error {
+			return conn.AddDocumentVersionIfLatest(ctx, docID, VersionTime{}, userID, "reason", nil, nil)
+		}},
 		{"AddMultiDocumentVersion", func() error {
 			return conn.AddMultiDocumentVersion(ctx, uu.IDSlice{docID}, userID, "reason", nil, nil)
 		}},
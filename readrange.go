@@ -0,0 +1,55 @@
+package docdb
+
+import (
+	"context"
+	"io"
+
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+)
+
+// ReadRange reads up to length bytes from reader starting at offset
+// with the semantics of Conn.ReadDocumentVersionFileRange.
+// If reader implements io.Seeker it is seeked to offset,
+// else the bytes before offset are read and discarded.
+// ErrNegativeOffset is returned for a negative offset.
+func ReadRange(reader io.Reader, offset, length int64) (data []byte, err error) {
+	if offset < 0 {
+		return nil, ErrNegativeOffset
+	}
+	if seeker, ok := reader.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, reader, offset)
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if length >= 0 {
+		reader = io.LimitReader(reader, length)
+	}
+	data, err = io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ReadDocumentVersionFileRangeImpl implements Conn.ReadDocumentVersionFileRange
+// with conn.OpenDocumentVersionFile and ReadRange.
+// Conn implementations that can't read a range directly from their storage
+// can delegate to this function.
+func ReadDocumentVersionFileRangeImpl(ctx context.Context, conn Conn, docID uu.ID, version VersionTime, filename string, offset, length int64) (data []byte, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, conn, docID, version, filename, offset, length)
+
+	if offset < 0 {
+		return nil, ErrNegativeOffset
+	}
+	reader, err := conn.OpenDocumentVersionFile(ctx, docID, version, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ReadRange(reader, offset, length)
+}
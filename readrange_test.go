@@ -0,0 +1,67 @@
+package docdb
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/domonda/go-types/uu"
+)
+
+func TestReadRange(t *testing.T) {
+	content := []byte("0123456789")
+	readers := map[string]func() io.Reader{
+		"seeker":     func() io.Reader { return bytes.NewReader(content) },
+		"non-seeker": func() io.Reader { return iotest.OneByteReader(bytes.NewReader(content)) },
+	}
+	for name, newReader := range readers {
+		t.Run(name, func(t *testing.T) {
+			for _, tt := range []struct {
+				offset, length int64
+				want           string
+			}{
+				{0, 3, "012"},
+				{4, 2, "45"},
+				{8, 5, "89"},
+				{2, -1, "23456789"},
+				{3, 0, ""},
+				{10, -1, ""},
+				{15, 2, ""},
+			} {
+				data, err := ReadRange(newReader(), tt.offset, tt.length)
+				require.NoError(t, err, "offset %d length %d", tt.offset, tt.length)
+				require.Equal(t, tt.want, string(data), "offset %d length %d", tt.offset, tt.length)
+			}
+
+			_, err := ReadRange(newReader(), -1, 3)
+			require.ErrorIs(t, err, ErrNegativeOffset)
+		})
+	}
+}
+
+func TestReadDocumentVersionFileRangeImpl(t *testing.T) {
+	var closed bool
+	conn := &MockConn{
+		OpenDocumentVersionFileMock: func(ctx context.Context, docID uu.ID, version VersionTime, filename string) (io.ReadCloser, error) {
+			return readCloser{Reader: bytes.NewReader([]byte("0123456789")), closed: &closed}, nil
+		},
+	}
+	data, err := ReadDocumentVersionFileRangeImpl(t.Context(), conn, uu.IDv7(), VersionTime{}, "doc.txt", 5, 2)
+	require.NoError(t, err)
+	require.Equal(t, "56", string(data))
+	require.True(t, closed, "reader closed")
+}
+
+type readCloser struct {
+	io.Reader
+	closed *bool
+}
+
+func (r readCloser) Close() error {
+	*r.closed = true
+	return nil
+}
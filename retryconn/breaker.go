@@ -0,0 +1,86 @@
+package retryconn
+
+import (
+	"sync"
+	"time"
+
+	"github.com/domonda/go-errs"
+)
+
+// ErrCircuitOpen is returned without calling the wrapped Conn
+// while the circuit breaker of a Conn is open.
+const ErrCircuitOpen errs.Sentinel = "circuit breaker open"
+
+// Default circuit breaker settings used by New without WithBreaker.
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// Breaker is a circuit breaker that opens after a number of consecutive
+// failed calls. While it is open all calls fail with ErrCircuitOpen.
+// After the cooldown a single trial call is let through that closes
+// the breaker if it succeeds or opens it again if it fails.
+//
+// Only retryable errors count as failures. Errors like
+// docdb.ErrDocumentNotFound show that the backend is working.
+//
+// A Breaker is safe for concurrent use and can be shared between
+// Conns wrapping the same backend.
+type Breaker struct {
+	mtx       sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// NewBreaker returns a Breaker that opens after threshold
+// consecutive failures for cooldown.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: max(threshold, 1), cooldown: cooldown}
+}
+
+// IsOpen reports whether calls currently fail with ErrCircuitOpen.
+func (b *Breaker) IsOpen() bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.failures >= b.threshold && (time.Now().Before(b.openUntil) || b.trial)
+}
+
+// allow returns ErrCircuitOpen if the breaker is open
+// or if the trial call after the cooldown is in progress.
+func (b *Breaker) allow() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	if b.trial || time.Now().Before(b.openUntil) {
+		return ErrCircuitOpen
+	}
+	b.trial = true
+	return nil
+}
+
+func (b *Breaker) succeed() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.failures = 0
+	b.trial = false
+}
+
+func (b *Breaker) fail() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.failures++
+	b.trial = false
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
@@ -0,0 +1,611 @@
+// Package retryconn provides a docdb.Conn wrapper that retries calls
+// failing with transient errors and stops calling a failing backend
+// with a circuit breaker.
+//
+// Read methods are retried with exponential backoff and jitter.
+// A write that failed with a transient error may still have been
+// committed by the backend, for example if the connection broke
+// while the response was sent. Before a write is retried
+// the wrapper checks if it was committed:
+//
+//   - CreateDocument, AddDocumentVersion, AddDocumentVersionIfLatest:
+//     the version is looked up with DocumentVersionInfo and its
+//     commit user, reason and file hashes are compared with the write.
+//     A matching version means the write succeeded.
+//   - AddMultiDocumentVersion: succeeds if the versions of all documents
+//     were committed as for AddDocumentVersion and is only retried
+//     if none of them was committed.
+//   - DeleteDocument and DeleteDocumentVersion: succeed if the document
+//     or version no longer exists.
+//
+// If the check fails or finds a different version, the original error
+// is returned instead of retrying blindly. SetDocumentCompanyID and
+// RestoreDocument are idempotent and retried without check,
+// as are writes with a key from docdb.ContextWithIdempotencyKey.
+//
+// The CreateVersionFunc of a retried write is called again,
+// errors returned by the callbacks are never retried.
+package retryconn
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-sqldb"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-types/uu"
+)
+
+// Default retry settings used by New.
+const (
+	DefaultMaxAttempts    = 3
+	DefaultInitialBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff     = 5 * time.Second
+)
+
+type config struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	retryable      func(error) bool
+	breaker        *Breaker
+}
+
+// Option configures the Conn returned by New.
+type Option func(*config)
+
+// WithMaxAttempts sets the maximum number of attempts per call
+// including the first one. One disables retries.
+func WithMaxAttempts(n int) Option {
+	return func(c *config) {
+		c.maxAttempts = max(n, 1)
+	}
+}
+
+// WithBackoff sets the backoff before the first retry
+// which doubles with every further retry up to maxBackoff.
+// A random jitter of up to half the backoff is subtracted.
+func WithBackoff(initial, maxBackoff time.Duration) Option {
+	return func(c *config) {
+		c.initialBackoff = initial
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WithRetryable sets the function that decides if an error
+// is transient and the call should be retried.
+// The default is IsRetryable.
+func WithRetryable(retryable func(error) bool) Option {
+	return func(c *config) {
+		c.retryable = retryable
+	}
+}
+
+// WithBreaker sets the circuit breaker of the Conn,
+// for example to share it with other Conns of the same backend.
+// By default every Conn has its own Breaker with
+// DefaultBreakerThreshold and DefaultBreakerCooldown.
+func WithBreaker(breaker *Breaker) Option {
+	return func(c *config) {
+		c.breaker = breaker
+	}
+}
+
+// IsRetryable is the default for WithRetryable.
+// It returns true for errors that are likely transient:
+// network and connection errors as well as throttling and server errors
+// of AWS services as classified by the AWS SDK, database deadlocks,
+// serialization failures and broken database connections.
+// Context errors are never retryable.
+func IsRetryable(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, sqldb.ErrDeadlock),
+		errors.Is(err, sqldb.ErrSerializationFailure),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	return awsRetryables.IsErrorRetryable(err) == aws.TrueTernary
+}
+
+var awsRetryables = retry.IsErrorRetryables(retry.DefaultRetryables)
+
+// New returns a docdb.Conn that wraps conn and retries calls
+// failing with transient errors.
+func New(conn docdb.Conn, options ...Option) docdb.Conn {
+	config := config{
+		maxAttempts:    DefaultMaxAttempts,
+		initialBackoff: DefaultInitialBackoff,
+		maxBackoff:     DefaultMaxBackoff,
+		retryable:      IsRetryable,
+	}
+	for _, option := range options {
+		option(&config)
+	}
+	if config.breaker == nil {
+		config.breaker = NewBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown)
+	}
+	return &retryConn{conn: conn, config: config}
+}
+
+type retryConn struct {
+	conn docdb.Conn
+	config
+}
+
+var (
+	_ docdb.Conn         = (*retryConn)(nil)
+	_ docdb.FileProvider = (*retryFileProvider)(nil)
+)
+
+// commitState is the result of checking
+// if a failed write was committed.
+type commitState int
+
+const (
+	notCommitted commitState = iota
+	committed
+	unknown
+)
+
+// call makes one attempt of do guarded by the circuit breaker.
+// Retryable errors not returned by callbacks count as failures
+// of the breaker, all other outcomes including panics as successes.
+func (c *retryConn) call(ctx context.Context, callbackErr *atomic.Bool, do func(context.Context) error) (err error) {
+	if err = c.breaker.allow(); err != nil {
+		return err
+	}
+	succeeded := true
+	defer func() {
+		if succeeded {
+			c.breaker.succeed()
+		} else {
+			c.breaker.fail()
+		}
+	}()
+	err = do(ctx)
+	succeeded = err == nil || !c.retryable(err) || callbackErr.Load()
+	return err
+}
+
+// backoff waits before the retry following attempt.
+func (c *retryConn) backoff(ctx context.Context, attempt int) error {
+	delay := c.initialBackoff << (attempt - 1)
+	if delay > c.maxBackoff || delay <= 0 {
+		delay = c.maxBackoff
+	}
+	if delay > 1 {
+		delay -= rand.N(delay / 2)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// read calls do until it succeeds, fails with an error
+// that is not retryable or the attempts are used up.
+func read[T any](ctx context.Context, c *retryConn, do func(context.Context) (T, error)) (result T, err error) {
+	for attempt := 1; ; attempt++ {
+		err = c.call(ctx, new(atomic.Bool), func(ctx context.Context) (e error) {
+			result, e = do(ctx)
+			return e
+		})
+		if err == nil || !c.retryable(err) || attempt >= c.maxAttempts {
+			return result, err
+		}
+		if e := c.backoff(ctx, attempt); e != nil {
+			return result, err
+		}
+	}
+}
+
+// write calls do like read, but checks with check
+// if a write that failed with a retryable error was committed.
+// It is retried only if check returns notCommitted.
+// A nil check means the write is idempotent and can be retried without check.
+//
+// Errors returned by the callbacks of a write set callbackErr
+// and are returned without retry.
+func (c *retryConn) write(ctx context.Context, callbackErr *atomic.Bool, do func(context.Context) error, check func(context.Context) (commitState, error)) (err error) {
+	ambiguous := false
+	for attempt := 1; ; attempt++ {
+		callbackErr.Store(false)
+		err = c.call(ctx, callbackErr, do)
+		if err == nil {
+			return nil
+		}
+		if callbackErr.Load() || !c.retryable(err) {
+			// A write that failed ambiguously could have been
+			// committed concurrently to the check before the retry
+			if ambiguous && check != nil {
+				if state, e := check(ctx); e == nil && state == committed {
+					return nil
+				}
+			}
+			return err
+		}
+		ambiguous = true
+		if check != nil {
+			state, e := check(ctx)
+			if e == nil && state == committed {
+				return nil
+			}
+			if e != nil || state != notCommitted {
+				return err
+			}
+		}
+		if attempt >= c.maxAttempts {
+			return err
+		}
+		if e := c.backoff(ctx, attempt); e != nil {
+			return err
+		}
+	}
+}
+
+// writeVersion is write for the methods creating a version.
+// With an idempotency key in ctx the wrapped Conn does not create
+// a version again that was already committed with the key,
+// so the write is retried without check.
+func (c *retryConn) writeVersion(ctx context.Context, callbackErr *atomic.Bool, do func(context.Context) error, check func(context.Context) (commitState, error)) error {
+	if docdb.IdempotencyKeyFromContext(ctx) != "" {
+		check = nil
+	}
+	return c.write(ctx, callbackErr, do, check)
+}
+
+func (c *retryConn) DocumentExists(ctx context.Context, docID uu.ID) (bool, error) {
+	return read(ctx, c, func(ctx context.Context) (bool, error) {
+		return c.conn.DocumentExists(ctx, docID)
+	})
+}
+
+func (c *retryConn) CompanyIDs(ctx context.Context) (uu.IDSlice, error) {
+	return read(ctx, c, c.conn.CompanyIDs)
+}
+
+func (c *retryConn) CompanyDocumentIDs(ctx context.Context, companyID uu.ID) (uu.IDSlice, error) {
+	return read(ctx, c, func(ctx context.Context) (uu.IDSlice, error) {
+		return c.conn.CompanyDocumentIDs(ctx, companyID)
+	})
+}
+
+func (c *retryConn) DocumentCompanyID(ctx context.Context, docID uu.ID) (uu.ID, error) {
+	return read(ctx, c, func(ctx context.Context) (uu.ID, error) {
+		return c.conn.DocumentCompanyID(ctx, docID)
+	})
+}
+
+func (c *retryConn) SetDocumentCompanyID(ctx context.Context, docID, companyID uu.ID) error {
+	return c.write(ctx, new(atomic.Bool), func(ctx context.Context) error {
+		return c.conn.SetDocumentCompanyID(ctx, docID, companyID)
+	}, nil)
+}
+
+func (c *retryConn) DocumentVersions(ctx context.Context, docID uu.ID) ([]docdb.VersionTime, error) {
+	return read(ctx, c, func(ctx context.Context) ([]docdb.VersionTime, error) {
+		return c.conn.DocumentVersions(ctx, docID)
+	})
+}
+
+func (c *retryConn) LatestDocumentVersion(ctx context.Context, docID uu.ID) (docdb.VersionTime, error) {
+	return read(ctx, c, func(ctx context.Context) (docdb.VersionTime, error) {
+		return c.conn.LatestDocumentVersion(ctx, docID)
+	})
+}
+
+func (c *retryConn) DocumentVersionInfo(ctx context.Context, docID uu.ID, version docdb.VersionTime) (*docdb.VersionInfo, error) {
+	return read(ctx, c, func(ctx context.Context) (*docdb.VersionInfo, error) {
+		return c.conn.DocumentVersionInfo(ctx, docID, version)
+	})
+}
+
+func (c *retryConn) LatestDocumentVersionInfo(ctx context.Context, docID uu.ID) (*docdb.VersionInfo, error) {
+	return read(ctx, c, func(ctx context.Context) (*docdb.VersionInfo, error) {
+		return c.conn.LatestDocumentVersionInfo(ctx, docID)
+	})
+}
+
+// DocumentVersionFileProvider returns a FileProvider
+// that retries its reads.
+func (c *retryConn) DocumentVersionFileProvider(ctx context.Context, docID uu.ID, version docdb.VersionTime) (docdb.FileProvider, error) {
+	provider, err := read(ctx, c, func(ctx context.Context) (docdb.FileProvider, error) {
+		return c.conn.DocumentVersionFileProvider(ctx, docID, version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &retryFileProvider{FileProvider: provider, conn: c}, nil
+}
+
+func (c *retryConn) ReadDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) ([]byte, error) {
+	return read(ctx, c, func(ctx context.Context) ([]byte, error) {
+		return c.conn.ReadDocumentVersionFile(ctx, docID, version, filename)
+	})
+}
+
+// OpenDocumentVersionFile retries opening the file,
+// reads from the returned reader are not retried.
+func (c *retryConn) OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (io.ReadCloser, error) {
+	return read(ctx, c, func(ctx context.Context) (io.ReadCloser, error) {
+		return c.conn.OpenDocumentVersionFile(ctx, docID, version, filename)
+	})
+}
+
+func (c *retryConn) ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string, offset, length int64) ([]byte, error) {
+	return read(ctx, c, func(ctx context.Context) ([]byte, error) {
+		return c.conn.ReadDocumentVersionFileRange(ctx, docID, version, filename, offset, length)
+	})
+}
+
+func (c *retryConn) DeleteDocument(ctx context.Context, docID uu.ID) error {
+	return c.write(ctx, new(atomic.Bool),
+		func(ctx context.Context) error {
+			return c.conn.DeleteDocument(ctx, docID)
+		},
+		func(ctx context.Context) (commitState, error) {
+			exists, err := c.conn.DocumentExists(ctx, docID)
+			if err != nil {
+				return unknown, err
+			}
+			if exists {
+				return notCommitted, nil
+			}
+			return committed, nil
+		},
+	)
+}
+
+func (c *retryConn) DeleteDocumentVersion(ctx context.Context, docID uu.ID, version docdb.VersionTime) (leftVersions []docdb.VersionTime, err error) {
+	err = c.write(ctx, new(atomic.Bool),
+		func(ctx context.Context) (err error) {
+			leftVersions, err = c.conn.DeleteDocumentVersion(ctx, docID, version)
+			return err
+		},
+		func(ctx context.Context) (commitState, error) {
+			versions, err := c.conn.DocumentVersions(ctx, docID)
+			if errors.As(err, new(docdb.ErrDocumentNotFound)) {
+				// The deleted version was the only one
+				leftVersions = nil
+				return committed, nil
+			}
+			if err != nil {
+				return unknown, err
+			}
+			if slices.Contains(versions, version) {
+				return notCommitted, nil
+			}
+			leftVersions = versions
+			return committed, nil
+		},
+	)
+	return leftVersions, err
+}
+
+func (c *retryConn) CreateDocument(ctx context.Context, companyID, docID, userID uu.ID, reason string, version docdb.VersionTime, files []fs.FileReader, onNewVersion docdb.OnNewVersionFunc) error {
+	var rec recorder
+	return c.writeVersion(ctx, &rec.callbackErr,
+		func(ctx context.Context) error {
+			return c.conn.CreateDocument(ctx, companyID, docID, userID, reason, version, files, rec.onNewVersion(onNewVersion))
+		},
+		func(ctx context.Context) (commitState, error) {
+			info, err := c.conn.DocumentVersionInfo(ctx, docID, version)
+			if errors.Is(err, errs.ErrNotFound) {
+				exists, err := c.conn.DocumentExists(ctx, docID)
+				if err != nil || exists {
+					// Created by someone else with another version
+					return unknown, err
+				}
+				return notCommitted, nil
+			}
+			if err != nil {
+				return unknown, err
+			}
+			if info.CompanyID != companyID || len(info.Files) != len(files) {
+				return unknown, nil
+			}
+			return versionState(ctx, info, userID, reason, files)
+		},
+	)
+}
+
+func (c *retryConn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+	var rec recorder
+	return c.writeVersion(ctx, &rec.callbackErr,
+		func(ctx context.Context) error {
+			return c.conn.AddDocumentVersion(ctx, docID, userID, reason, rec.createVersion(createVersion), rec.onNewVersion(onNewVersion))
+		},
+		func(ctx context.Context) (commitState, error) {
+			return c.versionCommitted(ctx, docID, userID, reason, rec.docResults(docID))
+		},
+	)
+}
+
+func (c *retryConn) AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+	var rec recorder
+	return c.writeVersion(ctx, &rec.callbackErr,
+		func(ctx context.Context) error {
+			return c.conn.AddDocumentVersionIfLatest(ctx, docID, expectedPrev, userID, reason, rec.createVersion(createVersion), rec.onNewVersion(onNewVersion))
+		},
+		func(ctx context.Context) (commitState, error) {
+			return c.versionCommitted(ctx, docID, userID, reason, rec.docResults(docID))
+		},
+	)
+}
+
+func (c *retryConn) AddMultiDocumentVersion(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+	var rec recorder
+	return c.writeVersion(ctx, &rec.callbackErr,
+		func(ctx context.Context) error {
+			return c.conn.AddMultiDocumentVersion(ctx, docIDs, userID, reason, rec.createVersion(createVersion), rec.onNewVersion(onNewVersion))
+		},
+		func(ctx context.Context) (commitState, error) {
+			var states []commitState
+			for _, docID := range docIDs {
+				state, err := c.versionCommitted(ctx, docID, userID, reason, rec.docResults(docID))
+				if err != nil {
+					return unknown, err
+				}
+				if !slices.Contains(states, state) {
+					states = append(states, state)
+				}
+			}
+			if len(states) != 1 {
+				return unknown, nil
+			}
+			return states[0], nil
+		},
+	)
+}
+
+// versionCommitted checks if the version of one of the results
+// recorded by all attempts of a write was committed.
+// The version of a failed attempt can be committed late and make
+// the next attempt fail with ErrDocumentChanged before createVersion
+// is called, so the results of earlier attempts are checked as well.
+// No results mean that every attempt failed before createVersion
+// returned and nothing was committed.
+func (c *retryConn) versionCommitted(ctx context.Context, docID, userID uu.ID, reason string, results []*docdb.CreateVersionResult) (commitState, error) {
+	state := notCommitted
+	for _, result := range results {
+		resultState, err := c.resultCommitted(ctx, docID, userID, reason, result)
+		if err != nil {
+			return unknown, err
+		}
+		switch resultState {
+		case committed:
+			return committed, nil
+		case unknown:
+			state = unknown
+		}
+	}
+	return state, nil
+}
+
+// resultCommitted checks if the version of result was committed.
+func (c *retryConn) resultCommitted(ctx context.Context, docID, userID uu.ID, reason string, result *docdb.CreateVersionResult) (commitState, error) {
+	info, err := c.conn.DocumentVersionInfo(ctx, docID, result.Version)
+	if errors.Is(err, errs.ErrNotFound) {
+		return notCommitted, nil
+	}
+	if err != nil {
+		return unknown, err
+	}
+	if result.NewCompanyID.IsNotNull() && info.CompanyID != result.NewCompanyID.Get() {
+		return unknown, nil
+	}
+	for _, filename := range result.RemoveFiles {
+		if _, ok := info.Files[filename]; ok {
+			return unknown, nil
+		}
+	}
+	return versionState(ctx, info, userID, reason, result.WriteFiles)
+}
+
+// versionState returns committed if info was committed by userID
+// with reason and contains files with the same content.
+func versionState(ctx context.Context, info *docdb.VersionInfo, userID uu.ID, reason string, files []fs.FileReader) (commitState, error) {
+	if info.CommitUserID != userID || info.CommitReason != reason {
+		return unknown, nil
+	}
+	for _, file := range files {
+		fileInfo, err := docdb.ReadFileInfo(ctx, file)
+		if err != nil {
+			return unknown, err
+		}
+		if info.Files[fileInfo.Name].Hash != fileInfo.Hash {
+			return unknown, nil
+		}
+	}
+	return committed, nil
+}
+
+// recorder wraps the callbacks of a write to record the results
+// of createVersion of all attempts and if a callback failed.
+type recorder struct {
+	callbackErr atomic.Bool
+	mtx         sync.Mutex
+	results     map[uu.ID][]*docdb.CreateVersionResult
+}
+
+func (r *recorder) docResults(docID uu.ID) []*docdb.CreateVersionResult {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return slices.Clone(r.results[docID])
+}
+
+func (r *recorder) createVersion(createVersion docdb.CreateVersionFunc) docdb.CreateVersionFunc {
+	return func(ctx context.Context, docID uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+		result, err := createVersion(ctx, docID, prevVersion, prevFiles)
+		if err != nil {
+			r.callbackErr.Store(true)
+			return nil, err
+		}
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		if r.results == nil {
+			r.results = make(map[uu.ID][]*docdb.CreateVersionResult)
+		}
+		r.results[docID] = append(r.results[docID], result)
+		return result, nil
+	}
+}
+
+func (r *recorder) onNewVersion(onNewVersion docdb.OnNewVersionFunc) docdb.OnNewVersionFunc {
+	return func(ctx context.Context, versionInfo *docdb.VersionInfo) error {
+		err := onNewVersion(ctx, versionInfo)
+		if err != nil {
+			r.callbackErr.Store(true)
+		}
+		return err
+	}
+}
+
+func (c *retryConn) RestoreDocument(ctx context.Context, doc *docdb.HashedDocument, recreate bool) error {
+	return c.write(ctx, new(atomic.Bool), func(ctx context.Context) error {
+		return c.conn.RestoreDocument(ctx, doc, recreate)
+	}, nil)
+}
+
+// retryFileProvider retries the reads of the wrapped FileProvider.
+type retryFileProvider struct {
+	docdb.FileProvider
+	conn *retryConn
+}
+
+func (p *retryFileProvider) ListFiles(ctx context.Context) ([]string, error) {
+	return read(ctx, p.conn, p.FileProvider.ListFiles)
+}
+
+func (p *retryFileProvider) ReadFile(ctx context.Context, filename string) ([]byte, error) {
+	return read(ctx, p.conn, func(ctx context.Context) ([]byte, error) {
+		return p.FileProvider.ReadFile(ctx, filename)
+	})
+}
+
+func (p *retryFileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return read(ctx, p.conn, func(ctx context.Context) (io.ReadCloser, error) {
+		return p.FileProvider.OpenFile(ctx, filename)
+	})
+}
@@ -0,0 +1,298 @@
+package retryconn_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/domonda/go-sqldb"
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/docdbtest"
+	"github.com/domonda/go-docdb/faultconn"
+	"github.com/domonda/go-docdb/memconn"
+	"github.com/domonda/go-docdb/retryconn"
+	"github.com/domonda/go-types/uu"
+)
+
+// retryInjected makes faultconn.ErrInjected retryable
+// and shortens the backoff for tests.
+func retryInjected(options ...retryconn.Option) []retryconn.Option {
+	return append(options,
+		retryconn.WithRetryable(func(err error) bool { return errors.Is(err, faultconn.ErrInjected) }),
+		retryconn.WithBackoff(time.Millisecond, time.Millisecond),
+	)
+}
+
+// newConn returns a retrying Conn wrapping a memconn.Conn
+// via a faultconn.Conn with the rules.
+func newConn(rules ...faultconn.Rule) (docdb.Conn, *faultconn.Injector) {
+	inj := faultconn.NewInjector(rules...)
+	return retryconn.New(faultconn.New(memconn.New(), inj), retryInjected()...), inj
+}
+
+// commitThenFail forwards writes to Conn and then
+// returns ErrInjected as if the response was lost.
+type commitThenFail struct {
+	docdb.Conn
+	failures int
+}
+
+func (c *commitThenFail) fail(err error) error {
+	if err == nil && c.failures > 0 {
+		c.failures--
+		return faultconn.ErrInjected
+	}
+	return err
+}
+
+func (c *commitThenFail) CreateDocument(ctx context.Context, companyID, docID, userID uu.ID, reason string, version docdb.VersionTime, files []fs.FileReader, onNewVersion docdb.OnNewVersionFunc) error {
+	return c.fail(c.Conn.CreateDocument(ctx, companyID, docID, userID, reason, version, files, onNewVersion))
+}
+
+func (c *commitThenFail) AddDocumentVersion(ctx context.Context, docID, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+	return c.fail(c.Conn.AddDocumentVersion(ctx, docID, userID, reason, createVersion, onNewVersion))
+}
+
+func (c *commitThenFail) DeleteDocument(ctx context.Context, docID uu.ID) error {
+	return c.fail(c.Conn.DeleteDocument(ctx, docID))
+}
+
+// commitLate fails the first AddDocumentVersionIfLatest with ErrInjected
+// after calling createVersion and commits its result before the next call,
+// as if the first write was committed after its response was lost.
+type commitLate struct {
+	docdb.Conn
+	calls int
+	late  *docdb.CreateVersionResult
+}
+
+func (c *commitLate) AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	c.calls++
+	if c.calls == 1 {
+		prevFiles, err := c.Conn.DocumentVersionFileProvider(ctx, docID, expectedPrev)
+		if err != nil {
+			return err
+		}
+		c.late, err = createVersion(ctx, docID, expectedPrev, prevFiles)
+		if err != nil {
+			return err
+		}
+		return faultconn.ErrInjected
+	}
+	if late := c.late; late != nil {
+		c.late = nil
+		err = c.Conn.AddDocumentVersionIfLatest(ctx, docID, expectedPrev, userID, reason,
+			func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+				return late, nil
+			},
+			docdbtest.NoopOnNewVersion,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return c.Conn.AddDocumentVersionIfLatest(ctx, docID, expectedPrev, userID, reason, createVersion, onNewVersion)
+}
+
+func TestConnConformance(t *testing.T) {
+	docdbtest.RunConnTests(t, func(t *testing.T) docdb.Conn {
+		return retryconn.New(memconn.New())
+	})
+}
+
+func TestRead(t *testing.T) {
+	t.Run("retries transient error", func(t *testing.T) {
+		conn, inj := newConn(faultconn.Rule{Methods: []string{"Conn.CompanyIDs"}, Nth: 1, Err: faultconn.ErrInjected})
+		_, err := conn.CompanyIDs(t.Context())
+		require.NoError(t, err)
+		require.Equal(t, 2, inj.Calls("Conn.CompanyIDs"))
+	})
+
+	t.Run("returns error after max attempts", func(t *testing.T) {
+		conn, inj := newConn(faultconn.Rule{Methods: []string{"Conn.CompanyIDs"}, Err: faultconn.ErrInjected})
+		_, err := conn.CompanyIDs(t.Context())
+		require.ErrorIs(t, err, faultconn.ErrInjected)
+		require.Equal(t, retryconn.DefaultMaxAttempts, inj.Calls("Conn.CompanyIDs"))
+	})
+
+	t.Run("does not retry not found", func(t *testing.T) {
+		conn, inj := newConn()
+		_, err := conn.DocumentVersions(t.Context(), uu.IDv7())
+		require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound))
+		require.Equal(t, 1, inj.Calls("Conn.DocumentVersions"))
+	})
+}
+
+func TestBreaker(t *testing.T) {
+	ctx := t.Context()
+	inj := faultconn.NewInjector(faultconn.Rule{Methods: []string{"Conn.CompanyIDs"}, Err: faultconn.ErrInjected})
+	breaker := retryconn.NewBreaker(2, 20*time.Millisecond)
+	conn := retryconn.New(faultconn.New(memconn.New(), inj), retryInjected(retryconn.WithMaxAttempts(1), retryconn.WithBreaker(breaker))...)
+
+	for range 2 {
+		_, err := conn.CompanyIDs(ctx)
+		require.ErrorIs(t, err, faultconn.ErrInjected)
+	}
+	require.True(t, breaker.IsOpen())
+	_, err := conn.CompanyIDs(ctx)
+	require.ErrorIs(t, err, retryconn.ErrCircuitOpen)
+	require.Equal(t, 2, inj.Calls("Conn.CompanyIDs"), "open breaker must not forward calls")
+
+	time.Sleep(30 * time.Millisecond)
+	inj.Reset()
+	_, err = conn.CompanyIDs(ctx)
+	require.NoError(t, err, "trial call after cooldown")
+	require.False(t, breaker.IsOpen())
+
+	t.Run("not found errors don't open", func(t *testing.T) {
+		for range 3 {
+			_, err := conn.DocumentVersions(ctx, uu.IDv7())
+			require.ErrorAs(t, err, new(docdb.ErrDocumentNotFound))
+		}
+		require.False(t, breaker.IsOpen())
+	})
+}
+
+func TestCreateDocument(t *testing.T) {
+	t.Run("committed despite error", func(t *testing.T) {
+		inj := faultconn.NewInjector()
+		conn := retryconn.New(&commitThenFail{Conn: faultconn.New(memconn.New(), inj), failures: 1}, retryInjected()...)
+		docID := uu.IDv7()
+
+		require.NoError(t, docdbtest.CreateDocument(t.Context(), conn, docID))
+		require.Equal(t, 1, inj.Calls("Conn.CreateDocument"), "committed write must not be retried")
+	})
+
+	t.Run("retried if not committed", func(t *testing.T) {
+		conn, inj := newConn(faultconn.Rule{Methods: []string{"Conn.CreateDocument"}, Nth: 1, Err: faultconn.ErrInjected})
+		docID := uu.IDv7()
+
+		require.NoError(t, docdbtest.CreateDocument(t.Context(), conn, docID))
+		require.Equal(t, 2, inj.Calls("Conn.CreateDocument"))
+	})
+
+	t.Run("different document not mistaken as committed", func(t *testing.T) {
+		conn, inj := newConn()
+		docID := uu.IDv7()
+		require.NoError(t, docdbtest.CreateDocument(t.Context(), conn, docID, fs.NewMemFile("doc.pdf", []byte("other"))))
+		inj.AddRule(faultconn.Rule{Methods: []string{"Conn.CreateDocument"}, Err: faultconn.ErrInjected})
+
+		err := docdbtest.CreateDocument(t.Context(), conn, docID)
+		require.ErrorIs(t, err, faultconn.ErrInjected)
+		require.Equal(t, 2, inj.Calls("Conn.CreateDocument"), "must not retry")
+	})
+}
+
+func TestAddDocumentVersion(t *testing.T) {
+	ctx := t.Context()
+	userID := uu.IDv7()
+	createCalls := 0
+	createVersion := func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+		createCalls++
+		return &docdb.CreateVersionResult{Version: docdbtest.Version2, WriteFiles: []fs.FileReader{fs.NewMemFile("a.txt", []byte("a"))}}, nil
+	}
+
+	t.Run("committed despite error", func(t *testing.T) {
+		createCalls = 0
+		failing := &commitThenFail{Conn: memconn.New()}
+		conn := retryconn.New(failing, retryInjected()...)
+		docID := uu.IDv7()
+		require.NoError(t, docdbtest.CreateDocument(ctx, conn, docID))
+
+		failing.failures = 1
+		require.NoError(t, conn.AddDocumentVersion(ctx, docID, userID, "add", createVersion, docdbtest.NoopOnNewVersion))
+		require.Equal(t, 1, createCalls)
+		versions, err := conn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, []docdb.VersionTime{docdbtest.Version1, docdbtest.Version2}, versions)
+	})
+
+	t.Run("retried if not committed", func(t *testing.T) {
+		createCalls = 0
+		conn, inj := newConn()
+		docID := uu.IDv7()
+		require.NoError(t, docdbtest.CreateDocument(ctx, conn, docID))
+		inj.AddRule(faultconn.Rule{Methods: []string{"Conn.AddDocumentVersion"}, Nth: 1, Err: faultconn.ErrInjected})
+
+		require.NoError(t, conn.AddDocumentVersion(ctx, docID, userID, "add", createVersion, docdbtest.NoopOnNewVersion))
+		require.Equal(t, 1, createCalls, "failed before createVersion was called")
+		require.Equal(t, 2, inj.Calls("Conn.AddDocumentVersion"))
+	})
+
+	t.Run("retried with idempotency key", func(t *testing.T) {
+		createCalls = 0
+		inj := faultconn.NewInjector()
+		failing := &commitThenFail{Conn: faultconn.New(memconn.New(), inj)}
+		conn := retryconn.New(failing, retryInjected()...)
+		docID := uu.IDv7()
+		require.NoError(t, docdbtest.CreateDocument(ctx, conn, docID))
+
+		failing.failures = 1
+		keyCtx := docdb.ContextWithIdempotencyKey(ctx, "key")
+		require.NoError(t, conn.AddDocumentVersion(keyCtx, docID, userID, "add", createVersion, docdbtest.NoopOnNewVersion))
+		require.Equal(t, 2, inj.Calls("Conn.AddDocumentVersion"), "retried without check")
+		require.Equal(t, 1, createCalls, "retry must return the committed version")
+		versions, err := conn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, []docdb.VersionTime{docdbtest.Version1, docdbtest.Version2}, versions)
+	})
+
+	t.Run("committed late by previous attempt", func(t *testing.T) {
+		createCalls = 0
+		late := &commitLate{Conn: memconn.New()}
+		conn := retryconn.New(late, retryInjected()...)
+		docID := uu.IDv7()
+		require.NoError(t, docdbtest.CreateDocument(ctx, conn, docID))
+
+		// The second attempt fails with ErrDocumentChanged
+		// before createVersion is called
+		err := conn.AddDocumentVersionIfLatest(ctx, docID, docdbtest.Version1, userID, "add", createVersion, docdbtest.NoopOnNewVersion)
+		require.NoError(t, err)
+		require.Equal(t, 2, late.calls)
+		require.Equal(t, 1, createCalls)
+		versions, err := conn.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, []docdb.VersionTime{docdbtest.Version1, docdbtest.Version2}, versions)
+	})
+
+	t.Run("callback error not retried", func(t *testing.T) {
+		conn, inj := newConn()
+		docID := uu.IDv7()
+		require.NoError(t, docdbtest.CreateDocument(ctx, conn, docID))
+		errCallback := errors.New("callback failed")
+
+		err := conn.AddDocumentVersion(ctx, docID, userID, "add", createVersion,
+			func(context.Context, *docdb.VersionInfo) error { return errCallback },
+		)
+		require.ErrorIs(t, err, errCallback)
+		require.Equal(t, 1, inj.Calls("Conn.AddDocumentVersion"))
+	})
+}
+
+func TestDeleteDocument(t *testing.T) {
+	failing := &commitThenFail{Conn: memconn.New()}
+	conn := retryconn.New(failing, retryInjected()...)
+	docID := uu.IDv7()
+	require.NoError(t, docdbtest.CreateDocument(t.Context(), conn, docID))
+
+	failing.failures = 1
+	require.NoError(t, conn.DeleteDocument(t.Context(), docID))
+}
+
+func TestIsRetryable(t *testing.T) {
+	require.True(t, retryconn.IsRetryable(&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}))
+	require.True(t, retryconn.IsRetryable(fmt.Errorf("query: %w", sqldb.ErrDeadlock)))
+	require.True(t, retryconn.IsRetryable(driver.ErrBadConn))
+	require.False(t, retryconn.IsRetryable(context.Canceled))
+	require.False(t, retryconn.IsRetryable(docdb.NewErrDocumentNotFound(uu.IDv7())))
+	require.False(t, retryconn.IsRetryable(docdb.ErrNoChanges))
+	require.False(t, retryconn.IsRetryable(errors.New("invalid document")))
+}
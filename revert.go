@@ -0,0 +1,107 @@
+package docdb
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+)
+
+const revertReasonPrefix = " (reverted to version "
+
+// RevertDocumentToVersion adds a new version to a document
+// whose files equal the files of targetVersion
+// and returns the VersionInfo of the new version.
+//
+// The files of the latest version are compared with the files
+// of targetVersion by content hash. Only files with different content
+// are read from targetVersion and written, files not in targetVersion
+// are removed, and unchanged files are carried forward
+// without reading or writing their content.
+//
+// The commit reason of the new version is reason with targetVersion
+// appended, see RevertedToVersion.
+//
+// The new version timestamp is the current time, or one millisecond
+// after the latest version if that is not in the past.
+//
+// Returns a wrapped not found error if the document or targetVersion
+// does not exist and wrapped ErrNoChanges if the latest version
+// already has the files of targetVersion.
+func RevertDocumentToVersion(ctx context.Context, conn Conn, docID uu.ID, targetVersion VersionTime, userID uu.ID, reason string) (versionInfo *VersionInfo, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, conn, docID, targetVersion, userID, reason)
+
+	target, err := conn.DocumentVersionInfo(ctx, docID, targetVersion)
+	if err != nil {
+		return nil, err
+	}
+	targetFiles, err := conn.DocumentVersionFileProvider(ctx, docID, targetVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	createVersion := func(ctx context.Context, docID uu.ID, prevVersion VersionTime, prevFiles FileProvider) (*CreateVersionResult, error) {
+		prev, err := conn.DocumentVersionInfo(ctx, docID, prevVersion)
+		if err != nil {
+			return nil, err
+		}
+		result := &CreateVersionResult{Version: NewVersionTime()}
+		if !result.Version.After(prevVersion) {
+			result.Version = VersionTimeFrom(prevVersion.Time.Add(time.Millisecond))
+		}
+		for _, filename := range slices.Sorted(maps.Keys(target.Files)) {
+			if prev.Files[filename] == target.Files[filename] {
+				continue
+			}
+			file, err := ReadMemFile(ctx, targetFiles, filename)
+			if err != nil {
+				return nil, err
+			}
+			result.WriteFiles = append(result.WriteFiles, file)
+		}
+		for _, filename := range slices.Sorted(maps.Keys(prev.Files)) {
+			if _, ok := target.Files[filename]; !ok {
+				result.RemoveFiles = append(result.RemoveFiles, filename)
+			}
+		}
+		return result, nil
+	}
+
+	err = conn.AddDocumentVersion(ctx, docID, userID, revertReason(reason, targetVersion), createVersion, CaptureNewVersionInfo(&versionInfo))
+	if err != nil {
+		return nil, err
+	}
+	return versionInfo, nil
+}
+
+// RevertedToVersion returns the version that the files of versionInfo
+// were reverted to by RevertDocumentToVersion,
+// or false if the version was not created by a revert.
+func RevertedToVersion(versionInfo *VersionInfo) (VersionTime, bool) {
+	if versionInfo == nil {
+		return VersionTime{}, false
+	}
+	rest, ok := strings.CutSuffix(versionInfo.CommitReason, ")")
+	if !ok {
+		return VersionTime{}, false
+	}
+	i := strings.LastIndex(rest, revertReasonPrefix)
+	if i < 0 {
+		return VersionTime{}, false
+	}
+	version, err := VersionTimeFromString(rest[i+len(revertReasonPrefix):])
+	if err != nil {
+		return VersionTime{}, false
+	}
+	return version, true
+}
+
+// revertReason returns the commit reason RevertDocumentToVersion uses.
+func revertReason(reason string, targetVersion VersionTime) string {
+	return fmt.Sprintf("%s%s%s)", reason, revertReasonPrefix, targetVersion)
+}
@@ -0,0 +1,30 @@
+package docdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevertedToVersion(t *testing.T) {
+	version := MustVersionTimeFromString("2024-01-02_03-04-05.678")
+
+	got, ok := RevertedToVersion(&VersionInfo{CommitReason: revertReason("undo bad edit", version)})
+	require.True(t, ok)
+	require.Equal(t, version, got)
+
+	got, ok = RevertedToVersion(&VersionInfo{CommitReason: revertReason("", version)})
+	require.True(t, ok, "empty reason")
+	require.Equal(t, version, got)
+
+	for _, reason := range []string{
+		"",
+		"edited (see ticket)",
+		"edited (reverted to version tomorrow)",
+	} {
+		_, ok = RevertedToVersion(&VersionInfo{CommitReason: reason})
+		require.False(t, ok, reason)
+	}
+	_, ok = RevertedToVersion(nil)
+	require.False(t, ok, "nil VersionInfo")
+}
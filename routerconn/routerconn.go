@@ -20,6 +20,7 @@ package routerconn
 
 import (
 	"context"
+	"io"
 
 	"github.com/ungerik/go-fs"
 
@@ -168,6 +169,22 @@ func (r *routerConn) ReadDocumentVersionFile(ctx context.Context, docID uu.ID, v
 	return conn.ReadDocumentVersionFile(ctx, docID, version, filename)
 }
 
+func (r *routerConn) OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (reader io.ReadCloser, err error) {
+	conn, err := r.connForDocID(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	return conn.OpenDocumentVersionFile(ctx, docID, version, filename)
+}
+
+func (r *routerConn) ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string, offset, length int64) (data []byte, err error) {
+	conn, err := r.connForDocID(ctx, docID)
+	if err != nil {
+		return nil, err
+	}
+	return conn.ReadDocumentVersionFileRange(ctx, docID, version, filename, offset, length)
+}
+
 func (r *routerConn) DeleteDocument(ctx context.Context, docID uu.ID) error {
 	conn, err := r.connForDocID(ctx, docID)
 	if err != nil {
@@ -202,6 +219,14 @@ func (r *routerConn) AddDocumentVersion(ctx context.Context, docID, userID uu.ID
 	return conn.AddDocumentVersion(ctx, docID, userID, reason, createVersion, onNewVersion)
 }
 
+func (r *routerConn) AddDocumentVersionIfLatest(ctx context.Context, docID uu.ID, expectedPrev docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+	conn, err := r.connForDocID(ctx, docID)
+	if err != nil {
+		return err
+	}
+	return conn.AddDocumentVersionIfLatest(ctx, docID, expectedPrev, userID, reason, createVersion, onNewVersion)
+}
+
 func (r *routerConn) AddMultiDocumentVersion(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
 	return docdb.AddMultiDocumentVersionImpl(ctx, r, docIDs, userID, reason, createVersion, onNewVersion)
 }
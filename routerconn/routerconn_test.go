@@ -9,6 +9,8 @@ import (
 	"github.com/ungerik/go-fs"
 
 	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/docdbtest"
+	"github.com/domonda/go-docdb/memconn"
 	"github.com/domonda/go-docdb/routerconn"
 	"github.com/domonda/go-types/uu"
 )
@@ -83,6 +85,25 @@ func TestRouterConn(t *testing.T) {
 		require.True(t, called)
 	})
 
+	t.Run("routes AddDocumentVersionIfLatest by document ID", func(t *testing.T) {
+		docID := uu.IDv7()
+		expectedPrev := docdb.NewVersionTime()
+		called := false
+		backend := &docdb.MockConn{
+			AddDocumentVersionIfLatestMock: func(ctx context.Context, id uu.ID, prev docdb.VersionTime, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
+				called = true
+				require.Equal(t, docID, id)
+				require.Equal(t, expectedPrev, prev)
+				return nil
+			},
+		}
+		conn := routerconn.New(unusedConn(t), connFor(docID, backend), backend)
+
+		err := conn.AddDocumentVersionIfLatest(t.Context(), docID, expectedPrev, uu.IDv7(), "reason", nil, nil)
+		require.NoError(t, err)
+		require.True(t, called)
+	})
+
 	t.Run("routes CreateDocument by company ID", func(t *testing.T) {
 		companyID := uu.IDv7()
 		docID := uu.IDv7()
@@ -258,3 +279,58 @@ func TestRouterConn(t *testing.T) {
 		require.Panics(t, func() { routerconn.New(validConn, validConn) })
 	})
 }
+
+func TestCloneDocumentAcrossBackends(t *testing.T) {
+	var (
+		ctx          = t.Context()
+		companyID    = uu.IDv7()
+		newCompanyID = uu.IDv7()
+		backend      = memconn.New()
+		newBackend   = memconn.New()
+		srcDocID     = uu.IDv7()
+	)
+	conn := routerconn.New(
+		func(_ context.Context, id uu.ID) (docdb.Conn, error) {
+			if id == newCompanyID {
+				return newBackend, nil
+			}
+			return backend, nil
+		},
+		// A document is stored by the backend that has it
+		func(ctx context.Context, id uu.ID) (docdb.Conn, error) {
+			for _, c := range []docdb.Conn{backend, newBackend} {
+				exists, err := c.DocumentExists(ctx, id)
+				if err != nil || exists {
+					return c, err
+				}
+			}
+			return nil, docdb.NewErrDocumentNotFound(id)
+		},
+		backend, newBackend,
+	)
+	err := conn.CreateDocument(ctx, companyID, srcDocID, uu.IDv7(), "create", docdb.MustVersionTimeFromString("2023-01-01_00-00-00.000"),
+		[]fs.FileReader{fs.NewMemFile("doc.pdf", []byte("pdf"))},
+		docdbtest.NoopOnNewVersion,
+	)
+	require.NoError(t, err)
+	err = conn.AddDocumentVersion(ctx, srcDocID, uu.IDv7(), "add",
+		docdb.CreateVersionWriteFiles(fs.NewMemFile("a.txt", []byte("a"))),
+		docdbtest.NoopOnNewVersion,
+	)
+	require.NoError(t, err)
+
+	for _, withHistory := range []bool{true, false} {
+		newDocID := uu.IDv7()
+		err = docdb.CloneDocument(ctx, conn, srcDocID, newDocID, newCompanyID, uu.IDv7(), "clone", withHistory)
+		require.NoError(t, err, "withHistory: %t", withHistory)
+
+		exists, err := newBackend.DocumentExists(ctx, newDocID)
+		require.NoError(t, err)
+		require.True(t, exists, "clone must be stored by the backend of the new company")
+		latest, err := conn.LatestDocumentVersion(ctx, newDocID)
+		require.NoError(t, err)
+		data, err := conn.ReadDocumentVersionFile(ctx, newDocID, latest, "a.txt")
+		require.NoError(t, err)
+		require.Equal(t, []byte("a"), data)
+	}
+}
@@ -51,3 +51,129 @@ func TestConn_AddDocumentVersion_RemoveAllFilesRejected(t *testing.T) {
 	require.ErrorContains(t, err, "at least one file")
 	require.False(t, meta.deleteVersionCalled, "must be rejected before any metadata commit/rollback")
 }
+
+// TestConn_AddDocumentVersionIfLatest verifies that storeconn rejects a stale
+// expected previous version before calling createVersion, and otherwise
+// delegates the atomic check to the MetadataStore.
+func TestConn_AddDocumentVersionIfLatest(t *testing.T) {
+	content := []byte("a content")
+	noopOnNew := func(context.Context, *docdb.VersionInfo) error { return nil }
+
+	t.Run("stale expected version", func(t *testing.T) {
+		meta, conn, docID := singleFileBackend(content)
+		stale := docdb.MustVersionTimeFromString("2023-12-31_00-00-00.000")
+
+		err := conn.AddDocumentVersionIfLatest(context.Background(), docID, stale, uu.IDv4(), "stale",
+			func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+				t.Fatal("createVersion must not be called")
+				return nil, nil
+			},
+			noopOnNew,
+		)
+		require.ErrorIs(t, err, docdb.NewErrDocumentChanged(docID, stale))
+		require.True(t, meta.addedVersion.Time.IsZero(), "no version must be committed")
+	})
+
+	t.Run("latest expected version", func(t *testing.T) {
+		meta, conn, docID := singleFileBackend(content)
+
+		err := conn.AddDocumentVersionIfLatest(context.Background(), docID, meta.latest.Version, uu.IDv4(), "update",
+			docdb.CreateVersionWriteFiles(fs.NewMemFile("b.txt", []byte("b content"))),
+			noopOnNew,
+		)
+		require.NoError(t, err)
+		require.True(t, meta.previousMustBeLatest, "MetadataStore must check the previous version atomically")
+	})
+}
+
+// TestConn_AddDocumentVersion_UnchangedFiles verifies that storeconn only
+// reports files with changed content as modified and returns ErrNoChanges
+// without committing metadata if no file changed.
+func TestConn_AddDocumentVersion_UnchangedFiles(t *testing.T) {
+	content := []byte("a content")
+	noopOnNew := func(context.Context, *docdb.VersionInfo) error { return nil }
+
+	t.Run("no changes", func(t *testing.T) {
+		meta, conn, docID := singleFileBackend(content)
+
+		err := conn.AddDocumentVersion(context.Background(), docID, uu.IDv4(), "same",
+			docdb.CreateVersionWriteFiles(fs.NewMemFile("a.txt", content)),
+			noopOnNew,
+		)
+		require.ErrorIs(t, err, docdb.ErrNoChanges)
+		require.True(t, meta.addedVersion.Time.IsZero(), "no version must be committed")
+	})
+
+	t.Run("unchanged file is not modified", func(t *testing.T) {
+		meta, conn, docID := singleFileBackend(content)
+
+		err := conn.AddDocumentVersion(context.Background(), docID, uu.IDv4(), "add",
+			docdb.CreateVersionWriteFiles(
+				fs.NewMemFile("a.txt", content),
+				fs.NewMemFile("b.txt", []byte("b content")),
+			),
+			noopOnNew,
+		)
+		require.NoError(t, err)
+		require.Empty(t, meta.modifiedFiles)
+		require.Len(t, meta.addedFiles, 1)
+		require.Equal(t, "b.txt", meta.addedFiles[0].Name)
+	})
+
+	t.Run("missing removed file is not recorded", func(t *testing.T) {
+		meta, conn, docID := singleFileBackend(content)
+
+		err := conn.AddDocumentVersion(context.Background(), docID, uu.IDv4(), "add",
+			func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+				return &docdb.CreateVersionResult{
+					Version:     docdb.NewVersionTime(),
+					WriteFiles:  []fs.FileReader{fs.NewMemFile("b.txt", []byte("b content"))},
+					RemoveFiles: []string{"missing.txt"},
+				}, nil
+			},
+			noopOnNew,
+		)
+		require.NoError(t, err)
+		require.Empty(t, meta.removedFiles)
+	})
+}
+
+// TestConn_AddDocumentVersion_IdempotencyKey verifies that storeconn looks up
+// the idempotency key with the MetadataStore instead of reading every version.
+// The DocumentVersions method of the fake panics if called.
+func TestConn_AddDocumentVersion_IdempotencyKey(t *testing.T) {
+	meta, conn, docID := singleFileBackend([]byte("a content"))
+	meta.idempotent = &docdb.VersionInfo{
+		DocID:          docID,
+		CompanyID:      meta.latest.CompanyID,
+		Version:        meta.latest.Version,
+		IdempotencyKey: "key",
+	}
+
+	t.Run("committed key", func(t *testing.T) {
+		var replayed *docdb.VersionInfo
+		err := conn.AddDocumentVersion(docdb.ContextWithIdempotencyKey(context.Background(), "key"), docID, uu.IDv4(), "redelivered",
+			func(context.Context, uu.ID, docdb.VersionTime, docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+				t.Fatal("createVersion must not be called")
+				return nil, nil
+			},
+			docdb.CaptureNewVersionInfo(&replayed),
+		)
+		require.NoError(t, err)
+		require.Equal(t, meta.idempotent, replayed)
+		require.True(t, meta.addedVersion.Time.IsZero(), "no version must be committed")
+
+		info, err := docdb.IdempotentVersionInfo(context.Background(), conn, docID, "key")
+		require.NoError(t, err)
+		require.Equal(t, meta.idempotent, info)
+	})
+
+	t.Run("new key", func(t *testing.T) {
+		err := conn.AddDocumentVersion(docdb.ContextWithIdempotencyKey(context.Background(), "other"), docID, uu.IDv4(), "add",
+			docdb.CreateVersionWriteFiles(fs.NewMemFile("b.txt", []byte("b content"))),
+			func(context.Context, *docdb.VersionInfo) error { return nil },
+		)
+		require.NoError(t, err)
+		require.False(t, meta.addedVersion.Time.IsZero(), "new version must be committed")
+	})
+}
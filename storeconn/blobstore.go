@@ -0,0 +1,43 @@
+package storeconn
+
+import (
+	"context"
+	"io"
+
+	"github.com/ungerik/go-fs"
+)
+
+// BlobStore is the interface for storing file content keyed only by
+// content hash, so identical content is stored once and shared across
+// all documents and companies. It is used together with MetadataStore
+// by the content-addressed docdb.Conn implementation returned by
+// NewContentAddressed, which deletes content only after no version
+// of any document references its hash anymore.
+type BlobStore interface {
+	// WriteBlob stores the content of file under the passed content hash,
+	// which the caller has already computed with docdb.ContentHash
+	// or docdb.ReadFileInfo. Content that is already stored under the hash
+	// is not written again.
+	WriteBlob(ctx context.Context, hash string, file fs.FileReader) error
+
+	// OpenBlob opens the content stored under a hash for streaming reads.
+	// The caller must close the returned io.ReadCloser.
+	// Returns an error matching os.ErrNotExist if no content
+	// is stored under the hash.
+	OpenBlob(ctx context.Context, hash string) (reader io.ReadCloser, err error)
+
+	// ReadBlobRange reads up to length bytes starting at offset
+	// of the content stored under a hash
+	// with the semantics of docdb.Conn.ReadDocumentVersionFileRange.
+	// Returns an error matching os.ErrNotExist if no content
+	// is stored under the hash.
+	ReadBlobRange(ctx context.Context, hash string, offset, length int64) (data []byte, err error)
+
+	// ListBlobs calls onHashes with the hashes of all stored content
+	// in pages of an implementation defined size.
+	ListBlobs(ctx context.Context, onHashes func(ctx context.Context, hashes []string) error) error
+
+	// DeleteBlobs deletes the content stored under the passed hashes.
+	// Hashes without stored content are silently ignored.
+	DeleteBlobs(ctx context.Context, hashes []string) error
+}
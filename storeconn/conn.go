@@ -6,6 +6,7 @@ package storeconn
 import (
 	"context"
 	"errors"
+	"io"
 	"maps"
 	"os"
 
@@ -26,14 +27,21 @@ func New(documentStore DocumentStore, metadataStore MetadataStore) docdb.Conn {
 	}
 }
 
+// conn stores file content either per document in documentStore
+// or, if returned by NewContentAddressed, shared across documents
+// in blobStore. Exactly one of both is set.
 type conn struct {
 	documentStore DocumentStore
+	blobStore     BlobStore
 	metadataStore MetadataStore
 }
 
 var _ docdb.Conn = (*conn)(nil)
 
 func (c *conn) DocumentExists(ctx context.Context, docID uu.ID) (exists bool, err error) {
+	if c.blobStore != nil {
+		return c.metadataDocumentExists(ctx, docID)
+	}
 	return c.documentStore.DocumentExists(ctx, docID)
 }
 
@@ -43,8 +51,17 @@ func (c *conn) DocumentVersionFileProvider(ctx context.Context, docID uu.ID, ver
 		return nil, err
 	}
 
-	hashes := make([]string, 0, len(versionInfo.Files))
-	for _, fi := range versionInfo.Files {
+	return c.fileProvider(ctx, docID, versionInfo.Files)
+}
+
+// fileProvider returns a FileProvider for the passed files of a document.
+func (c *conn) fileProvider(ctx context.Context, docID uu.ID, files map[string]docdb.FileInfo) (docdb.FileProvider, error) {
+	if c.blobStore != nil {
+		return &blobFileProvider{blobStore: c.blobStore, docID: docID, files: files}, nil
+	}
+
+	hashes := make([]string, 0, len(files))
+	for _, fi := range files {
 		hashes = append(hashes, fi.Hash)
 	}
 
@@ -62,9 +79,49 @@ func (c *conn) ReadDocumentVersionFile(ctx context.Context, docID uu.ID, version
 		return nil, docdb.NewErrDocumentFileNotFound(docID, filename)
 	}
 
+	if c.blobStore != nil {
+		return readBlob(ctx, c.blobStore, docID, fileInfo)
+	}
 	return c.documentStore.ReadDocumentHashFile(ctx, docID, filename, fileInfo.Hash)
 }
 
+func (c *conn) OpenDocumentVersionFile(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string) (reader io.ReadCloser, err error) {
+	versionInfo, err := c.metadataStore.DocumentVersionInfo(ctx, docID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, ok := versionInfo.Files[filename]
+	if !ok {
+		return nil, docdb.NewErrDocumentFileNotFound(docID, filename)
+	}
+
+	if c.blobStore != nil {
+		return openBlob(ctx, c.blobStore, docID, fileInfo)
+	}
+	return c.documentStore.OpenDocumentHashFile(ctx, docID, filename, fileInfo.Hash)
+}
+
+func (c *conn) ReadDocumentVersionFileRange(ctx context.Context, docID uu.ID, version docdb.VersionTime, filename string, offset, length int64) (data []byte, err error) {
+	if offset < 0 {
+		return nil, docdb.ErrNegativeOffset
+	}
+	versionInfo, err := c.metadataStore.DocumentVersionInfo(ctx, docID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, ok := versionInfo.Files[filename]
+	if !ok {
+		return nil, docdb.NewErrDocumentFileNotFound(docID, filename)
+	}
+
+	if c.blobStore != nil {
+		return readBlobRange(ctx, c.blobStore, docID, fileInfo, offset, length)
+	}
+	return c.documentStore.ReadDocumentHashFileRange(ctx, docID, filename, fileInfo.Hash, offset, length)
+}
+
 func (c *conn) DocumentCompanyID(ctx context.Context, docID uu.ID) (companyID uu.ID, err error) {
 	return c.metadataStore.DocumentCompanyID(ctx, docID)
 }
@@ -97,7 +154,19 @@ func (c *conn) LatestDocumentVersionInfo(ctx context.Context, docID uu.ID) (*doc
 	return c.metadataStore.LatestDocumentVersionInfo(ctx, docID)
 }
 
+// IdempotentVersionInfo looks up the idempotency key with the MetadataStore
+// and is used by docdb.IdempotentVersionInfo instead of reading every version.
+func (c *conn) IdempotentVersionInfo(ctx context.Context, docID uu.ID, key string) (*docdb.VersionInfo, error) {
+	if key == "" {
+		return nil, nil
+	}
+	return c.metadataStore.IdempotentVersionInfo(ctx, docID, key)
+}
+
 func (c *conn) DeleteDocument(ctx context.Context, docID uu.ID) error {
+	if c.blobStore != nil {
+		return c.deleteContentAddressedDocument(ctx, docID)
+	}
 	err := c.metadataStore.DeleteDocument(ctx, docID)
 	if err != nil {
 		return err
@@ -111,7 +180,7 @@ func (c *conn) DeleteDocumentVersion(ctx context.Context, docID uu.ID, version d
 		return nil, err
 	}
 
-	err = c.documentStore.DeleteDocumentHashes(ctx, docID, hashesToDelete)
+	err = c.deleteHashes(ctx, docID, hashesToDelete)
 	if err != nil {
 		return nil, err
 	}
@@ -119,6 +188,34 @@ func (c *conn) DeleteDocumentVersion(ctx context.Context, docID uu.ID, version d
 	return leftVersions, err
 }
 
+// deleteHashes deletes the content of hashes that are no longer
+// referenced by any version of the document.
+func (c *conn) deleteHashes(ctx context.Context, docID uu.ID, hashes []string) error {
+	if c.blobStore != nil {
+		// Other documents may still reference the shared content
+		return c.metadataStore.DeleteUnreferencedHashes(ctx, hashes, c.blobStore.DeleteBlobs)
+	}
+	return c.documentStore.DeleteDocumentHashes(ctx, docID, hashes)
+}
+
+// writeFiles writes the content of the files of a new version
+// after its metadata was written. fileInfos holds the already
+// computed FileInfo of every file by filename.
+func (c *conn) writeFiles(ctx context.Context, docID uu.ID, version docdb.VersionTime, files []fs.FileReader, fileInfos map[string]docdb.FileInfo) error {
+	if c.blobStore != nil {
+		for _, file := range files {
+			err := c.blobStore.WriteBlob(ctx, fileInfos[file.Name()].Hash, file)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// The FileInfos returned by the DocumentStore are not needed again
+	_, err := c.documentStore.CreateDocumentVersion(ctx, docID, version, files)
+	return err
+}
+
 func (c *conn) CreateDocument(
 	ctx context.Context,
 	companyID uu.ID,
@@ -140,6 +237,16 @@ func (c *conn) CreateDocument(
 	if onNewVersion == nil {
 		return errs.New("nil onNewVersion func passed to createDocumentVersion")
 	}
+	original, err := c.IdempotentVersionInfo(ctx, docID, docdb.IdempotencyKeyFromContext(ctx))
+	if err != nil {
+		return err
+	}
+	if original != nil {
+		return safelyCallOnNewVersionFunc(ctx, original, onNewVersion)
+	}
+	if c.blobStore != nil {
+		return c.createContentAddressedDocument(ctx, companyID, docID, userID, reason, version, files, onNewVersion)
+	}
 
 	// Refuse to create a genesis document whose files already exist in the
 	// documentStore. Conn.CreateDocument is documented to return
@@ -223,7 +330,8 @@ func (c *conn) CreateDocument(
 		Reason:     reason,
 		NewVersion: version,
 		// PreviousVersion nil: first (genesis) version
-		AddedFiles: addedFiles,
+		AddedFiles:     addedFiles,
+		IdempotencyKey: docdb.IdempotencyKeyFromContext(ctx),
 	})
 	if err != nil {
 		return err
@@ -242,6 +350,40 @@ func (c *conn) AddDocumentVersion(
 ) (err error) {
 	defer errs.WrapWithFuncParams(&err, ctx, docID, userID, reason, createVersion, onNewVersion)
 
+	return c.addDocumentVersion(ctx, docID, nil, userID, reason, createVersion, onNewVersion)
+}
+
+func (c *conn) AddDocumentVersionIfLatest(
+	ctx context.Context,
+	docID uu.ID,
+	expectedPrev docdb.VersionTime,
+	userID uu.ID,
+	reason string,
+	createVersion docdb.CreateVersionFunc,
+	onNewVersion docdb.OnNewVersionFunc,
+) (err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, docID, expectedPrev, userID, reason, createVersion, onNewVersion)
+
+	return c.addDocumentVersion(ctx, docID, &expectedPrev, userID, reason, createVersion, onNewVersion)
+}
+
+// addDocumentVersion implements AddDocumentVersion and, with a non-nil
+// expectedPrev, AddDocumentVersionIfLatest.
+//
+// There is no lock spanning the whole operation, so expectedPrev is checked
+// twice: up front to fail before calling createVersion and writing anything,
+// and again by the MetadataStore atomically with inserting the new version
+// (CreateDocumentVersionInput.PreviousMustBeLatest), which is the check
+// that actually guards against a concurrent writer.
+func (c *conn) addDocumentVersion(
+	ctx context.Context,
+	docID uu.ID,
+	expectedPrev *docdb.VersionTime,
+	userID uu.ID,
+	reason string,
+	createVersion docdb.CreateVersionFunc,
+	onNewVersion docdb.OnNewVersionFunc,
+) (err error) {
 	if err = ctx.Err(); err != nil {
 		return err
 	}
@@ -258,17 +400,24 @@ func (c *conn) AddDocumentVersion(
 		return errs.New("nil onNewVersion func passed to AddDocumentVersion")
 	}
 
-	latestVersionInfo, err := c.metadataStore.LatestDocumentVersionInfo(ctx, docID)
+	idempotencyKey := docdb.IdempotencyKeyFromContext(ctx)
+	original, err := c.IdempotentVersionInfo(ctx, docID, idempotencyKey)
 	if err != nil {
 		return err
 	}
+	if original != nil {
+		return safelyCallOnNewVersionFunc(ctx, original, onNewVersion)
+	}
 
-	hashes := make([]string, 0, len(latestVersionInfo.Files))
-	for _, file := range latestVersionInfo.Files {
-		hashes = append(hashes, file.Hash)
+	latestVersionInfo, err := c.metadataStore.LatestDocumentVersionInfo(ctx, docID)
+	if err != nil {
+		return err
+	}
+	if expectedPrev != nil && !latestVersionInfo.Version.Equal(*expectedPrev) {
+		return docdb.NewErrDocumentChanged(docID, *expectedPrev)
 	}
 
-	fileProvider, err := c.documentStore.DocumentHashFileProvider(ctx, docID, hashes)
+	fileProvider, err := c.fileProvider(ctx, docID, latestVersionInfo.Files)
 	if err != nil {
 		return err
 	}
@@ -295,19 +444,21 @@ func (c *conn) AddDocumentVersion(
 	addedFiles := []*docdb.FileInfo{}
 	modifiedFiles := []*docdb.FileInfo{}
 
-	var data []byte
 	for _, file := range result.WriteFiles {
-		data, err = file.ReadAll()
+		info, err := docdb.ReadFileInfo(ctx, file)
 		if err != nil {
 			return err
 		}
 
-		fileInfo := &docdb.FileInfo{Name: file.Name(), Size: file.Size(), Hash: docdb.ContentHash(data)}
-		if fileExists, _ := fileProvider.HasFile(file.Name()); fileExists {
-			modifiedFiles = append(modifiedFiles, fileInfo)
-		} else {
-			addedFiles = append(addedFiles, fileInfo)
+		prevInfo, prevHasFile := latestVersionInfo.Files[file.Name()]
+		switch {
+		case !prevHasFile:
+			addedFiles = append(addedFiles, &info)
+		case prevInfo.Hash != info.Hash:
+			modifiedFiles = append(modifiedFiles, &info)
 		}
+		// A file written again with unchanged content
+		// is carried forward like the files not written
 	}
 
 	// Compute the resulting full file set (previous files, minus the removed
@@ -318,8 +469,14 @@ func (c *conn) AddDocumentVersion(
 	// predecessor and re-derive the identical set.
 	resultingFiles := make(map[string]docdb.FileInfo, len(latestVersionInfo.Files))
 	maps.Copy(resultingFiles, latestVersionInfo.Files)
+	// Removing a file that does not exist in the previous version
+	// is not recorded as removed, like for the other Conn implementations
+	var removedFiles []string
 	for _, name := range result.RemoveFiles {
-		delete(resultingFiles, name)
+		if _, ok := resultingFiles[name]; ok {
+			delete(resultingFiles, name)
+			removedFiles = append(removedFiles, name)
+		}
 	}
 	for _, fi := range addedFiles {
 		resultingFiles[fi.Name] = *fi
@@ -330,57 +487,42 @@ func (c *conn) AddDocumentVersion(
 	if len(resultingFiles) == 0 {
 		return errs.Errorf("cannot remove all files of document %s: every version must contain at least one file", docID)
 	}
+	if (&docdb.VersionInfo{Files: resultingFiles}).EqualFiles(latestVersionInfo) {
+		return docdb.ErrNoChanges
+	}
 
 	// Copy the previous version into a local before taking its address, rather
 	// than aliasing the fetched struct's field into the new version's metadata.
 	prevVersion := latestVersionInfo.Version
 	newVersionInfo, err := c.metadataStore.CreateDocumentVersion(ctx, CreateDocumentVersionInput{
-		DocID:           docID,
-		CompanyID:       companyID,
-		UserID:          userID,
-		Reason:          reason,
-		NewVersion:      result.Version,
-		PreviousVersion: &prevVersion,
-		AddedFiles:      addedFiles,
-		ModifiedFiles:   modifiedFiles,
-		RemovedFiles:    result.RemoveFiles,
-		Files:           resultingFiles,
+		DocID:                docID,
+		CompanyID:            companyID,
+		UserID:               userID,
+		Reason:               reason,
+		NewVersion:           result.Version,
+		PreviousVersion:      &prevVersion,
+		AddedFiles:           addedFiles,
+		ModifiedFiles:        modifiedFiles,
+		RemovedFiles:         removedFiles,
+		Files:                resultingFiles,
+		PreviousMustBeLatest: expectedPrev != nil,
+		IdempotencyKey:       idempotencyKey,
 	})
 	if err != nil {
-		return err
-	}
-
-	// rollbackNewVersion removes the metadata version just added plus the file
-	// blobs written for it, joining any cleanup error onto cause. Used when a
-	// later step fails after the metadata version is already committed, so the
-	// store is not left with a version that references missing file content.
-	//
-	// The blobs to delete are taken from the hash set DeleteDocumentVersion
-	// reports as referenced only by the removed version. Deleting the version's
-	// addedFiles/modifiedFiles hashes directly would also wipe blobs that share
-	// their content hash with a sibling version (content is deduplicated by
-	// hash across the whole document) and corrupt those versions.
-	rollbackNewVersion := func(cause error) error {
-		_, hashesToDelete, pgErr := c.metadataStore.DeleteDocumentVersion(ctx, docID, result.Version)
-		if pgErr != nil {
-			// Without the metadata delete the safe hash set is unknown, so do
-			// not guess: leaving the blobs is preferable to deleting shared ones.
-			return errors.Join(cause, pgErr)
+		// A concurrent call with the same idempotency key
+		// might have committed its version in the meantime
+		original, e := c.IdempotentVersionInfo(ctx, docID, idempotencyKey)
+		if e == nil && original != nil {
+			return safelyCallOnNewVersionFunc(ctx, original, onNewVersion)
 		}
-		if len(hashesToDelete) > 0 {
-			s3Err := c.documentStore.DeleteDocumentHashes(ctx, docID, hashesToDelete)
-			if s3Err != nil {
-				cause = errors.Join(cause, s3Err)
-			}
-		}
-		return cause
+		return err
 	}
 
 	// The added/modified FileInfos were already computed above to build the
-	// metadata version, so the hashes returned here are not needed again.
-	_, err = c.documentStore.CreateDocumentVersion(ctx, docID, result.Version, result.WriteFiles)
+	// metadata version and are passed with resultingFiles.
+	err = c.writeFiles(ctx, docID, result.Version, result.WriteFiles, resultingFiles)
 	if err != nil {
-		return rollbackNewVersion(err)
+		return c.rollbackNewVersion(ctx, docID, result.Version, err)
 	}
 
 	safeOnNewVersion := func() (err error) {
@@ -390,12 +532,38 @@ func (c *conn) AddDocumentVersion(
 
 	err = safeOnNewVersion()
 	if err != nil {
-		return rollbackNewVersion(err)
+		return c.rollbackNewVersion(ctx, docID, result.Version, err)
 	}
 
 	return nil
 }
 
+// rollbackNewVersion removes the metadata version just added plus the file
+// blobs written for it, joining any cleanup error onto cause. Used when a
+// later step fails after the metadata version is already committed, so the
+// store is not left with a version that references missing file content.
+//
+// The blobs to delete are taken from the hash set DeleteDocumentVersion
+// reports as referenced only by the removed version. Deleting the version's
+// addedFiles/modifiedFiles hashes directly would also wipe blobs that share
+// their content hash with a sibling version (content is deduplicated by
+// hash across the whole document) and corrupt those versions.
+func (c *conn) rollbackNewVersion(ctx context.Context, docID uu.ID, version docdb.VersionTime, cause error) error {
+	_, hashesToDelete, pgErr := c.metadataStore.DeleteDocumentVersion(ctx, docID, version)
+	if pgErr != nil {
+		// Without the metadata delete the safe hash set is unknown, so do
+		// not guess: leaving the blobs is preferable to deleting shared ones.
+		return errors.Join(cause, pgErr)
+	}
+	if len(hashesToDelete) > 0 {
+		s3Err := c.deleteHashes(ctx, docID, hashesToDelete)
+		if s3Err != nil {
+			cause = errors.Join(cause, s3Err)
+		}
+	}
+	return cause
+}
+
 func (c *conn) AddMultiDocumentVersion(ctx context.Context, docIDs uu.IDSlice, userID uu.ID, reason string, createVersion docdb.CreateVersionFunc, onNewVersion docdb.OnNewVersionFunc) error {
 	return docdb.AddMultiDocumentVersionImpl(ctx, c, docIDs, userID, reason, createVersion, onNewVersion)
 }
@@ -547,9 +715,7 @@ func (c *conn) RestoreDocument(ctx context.Context, doc *docdb.HashedDocument, r
 		// Record as created right after the metadata commit so the rollback
 		// also covers a failure of the following blob write.
 		createdVersions = append(createdVersions, v)
-		// The metadata version was already written above, so the FileInfos
-		// returned by the blob write are not needed here.
-		_, err = c.documentStore.CreateDocumentVersion(ctx, doc.ID, v, files)
+		err = c.writeFiles(ctx, doc.ID, v, files, resultingFiles)
 		if err != nil {
 			return err
 		}
@@ -587,3 +753,9 @@ func safelyCallCreateVersionFunc(
 
 	return createVersion(ctx, docID, prevVersion, prevFiles)
 }
+
+func safelyCallOnNewVersionFunc(ctx context.Context, versionInfo *docdb.VersionInfo, onNewVersion docdb.OnNewVersionFunc) (err error) {
+	defer errs.RecoverPanicAsError(&err)
+
+	return onNewVersion(ctx, versionInfo)
+}
@@ -0,0 +1,227 @@
+package storeconn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+
+	"github.com/domonda/go-docdb"
+)
+
+// NewContentAddressed returns a new docdb.Conn that stores file content
+// in the provided BlobStore keyed only by content hash and version metadata
+// in the provided MetadataStore. Identical content is stored once
+// and shared across all documents and companies.
+//
+// Content is shared, so it may only be deleted when no version of any
+// document references its hash anymore. It is deleted with
+// MetadataStore.DeleteUnreferencedHashes while the hashes are locked
+// against new references. To not race with such a delete,
+// every write commits the metadata referencing the content
+// before writing the content.
+func NewContentAddressed(blobStore BlobStore, metadataStore MetadataStore) docdb.Conn {
+	return &conn{
+		blobStore:     blobStore,
+		metadataStore: metadataStore,
+	}
+}
+
+// DeleteUnreferencedBlobs deletes all content from blobStore whose hash
+// is not referenced by any version of any document according to
+// metadataStore and returns the hashes. Such content is left behind
+// when a process crashes between deleting metadata and content
+// of a Conn returned by NewContentAddressed.
+// If dryRun is true, the hashes are only returned but not deleted.
+//
+// It is safe to call DeleteUnreferencedBlobs while documents are written,
+// because MetadataStore.DeleteUnreferencedHashes locks the hashes
+// against new references while their content is deleted.
+// The content of a hash whose first reference is committed
+// but whose content is not written yet is not listed.
+func DeleteUnreferencedBlobs(ctx context.Context, blobStore BlobStore, metadataStore MetadataStore, dryRun bool) (hashes []string, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, dryRun)
+
+	err = blobStore.ListBlobs(ctx, func(ctx context.Context, listed []string) error {
+		if dryRun {
+			unreferenced, err := metadataStore.UnreferencedHashes(ctx, listed)
+			hashes = append(hashes, unreferenced...)
+			return err
+		}
+		return metadataStore.DeleteUnreferencedHashes(ctx, listed, func(ctx context.Context, unreferenced []string) error {
+			err := blobStore.DeleteBlobs(ctx, unreferenced)
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, unreferenced...)
+			return nil
+		})
+	})
+	if err != nil {
+		return hashes, err
+	}
+	return hashes, nil
+}
+
+// metadataDocumentExists implements DocumentExists for NewContentAddressed
+// where the BlobStore has no content per document.
+func (c *conn) metadataDocumentExists(ctx context.Context, docID uu.ID) (bool, error) {
+	_, err := c.metadataStore.DocumentVersions(ctx, docID)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// createContentAddressedDocument implements CreateDocument for NewContentAddressed.
+//
+// Other than with a DocumentStore the metadata is written before the content,
+// like AddDocumentVersion does, so the content of the hashes is only written
+// while their references are committed and can't be deleted concurrently.
+// A concurrent CreateDocument with the same docID fails on the
+// one-genesis-per-document constraint of the MetadataStore
+// with ErrDocumentAlreadyExists before any content is written.
+func (c *conn) createContentAddressedDocument(
+	ctx context.Context,
+	companyID uu.ID,
+	docID uu.ID,
+	userID uu.ID,
+	reason string,
+	version docdb.VersionTime,
+	files []fs.FileReader,
+	onNewVersion docdb.OnNewVersionFunc,
+) (err error) {
+	var versionInfo *docdb.VersionInfo
+	defer func() {
+		errs.RecoverPanicAsErrorWithFuncParams(&err, ctx, companyID, docID, userID, reason, version, files, onNewVersion)
+		if err != nil && versionInfo != nil {
+			err = c.rollbackNewVersion(ctx, docID, version, err)
+		}
+	}()
+
+	addedFiles := make([]*docdb.FileInfo, len(files))
+	fileInfos := make(map[string]docdb.FileInfo, len(files))
+	for i, file := range files {
+		info, err := docdb.ReadFileInfo(ctx, file)
+		if err != nil {
+			return err
+		}
+		addedFiles[i] = &info
+		fileInfos[info.Name] = info
+	}
+
+	versionInfo, err = c.metadataStore.CreateDocumentVersion(ctx, CreateDocumentVersionInput{
+		DocID:      docID,
+		CompanyID:  companyID,
+		UserID:     userID,
+		Reason:     reason,
+		NewVersion: version,
+		// PreviousVersion nil: first (genesis) version
+		AddedFiles:     addedFiles,
+		IdempotencyKey: docdb.IdempotencyKeyFromContext(ctx),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.writeFiles(ctx, docID, version, files, fileInfos)
+	if err != nil {
+		return err
+	}
+
+	return onNewVersion(ctx, versionInfo)
+}
+
+// deleteContentAddressedDocument implements DeleteDocument for NewContentAddressed.
+// The hashes of the document are read before its metadata is deleted
+// and their content is deleted if no other document references it.
+func (c *conn) deleteContentAddressedDocument(ctx context.Context, docID uu.ID) error {
+	hashes, err := c.metadataStore.DocumentHashes(ctx, docID)
+	if err != nil {
+		return err
+	}
+	err = c.metadataStore.DeleteDocument(ctx, docID)
+	if err != nil {
+		return err
+	}
+	return c.metadataStore.DeleteUnreferencedHashes(ctx, hashes, c.blobStore.DeleteBlobs)
+}
+
+// openBlob opens the content of file of a document from blobStore
+// and returns docdb.ErrDocumentFileNotFound if it doesn't exist.
+func openBlob(ctx context.Context, blobStore BlobStore, docID uu.ID, file docdb.FileInfo) (io.ReadCloser, error) {
+	reader, err := blobStore.OpenBlob(ctx, file.Hash)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, docdb.NewErrDocumentFileNotFound(docID, file.Name)
+		}
+		return nil, err
+	}
+	return reader, nil
+}
+
+func readBlob(ctx context.Context, blobStore BlobStore, docID uu.ID, file docdb.FileInfo) ([]byte, error) {
+	reader, err := openBlob(ctx, blobStore, docID, file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// readBlobRange reads a range of the content of file of a document from blobStore
+// and returns docdb.ErrDocumentFileNotFound if it doesn't exist.
+func readBlobRange(ctx context.Context, blobStore BlobStore, docID uu.ID, file docdb.FileInfo, offset, length int64) ([]byte, error) {
+	data, err := blobStore.ReadBlobRange(ctx, file.Hash, offset, length)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, docdb.NewErrDocumentFileNotFound(docID, file.Name)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// blobFileProvider implements docdb.FileProvider for the files
+// of a document version stored in a BlobStore.
+type blobFileProvider struct {
+	blobStore BlobStore
+	docID     uu.ID
+	files     map[string]docdb.FileInfo
+}
+
+func (p *blobFileProvider) HasFile(filename string) (bool, error) {
+	_, ok := p.files[filename]
+	return ok, nil
+}
+
+func (p *blobFileProvider) ListFiles(ctx context.Context) (filenames []string, err error) {
+	return slices.Sorted(maps.Keys(p.files)), nil
+}
+
+func (p *blobFileProvider) ReadFile(ctx context.Context, filename string) ([]byte, error) {
+	file, ok := p.files[filename]
+	if !ok {
+		return nil, docdb.NewErrDocumentFileNotFound(p.docID, filename)
+	}
+	return readBlob(ctx, p.blobStore, p.docID, file)
+}
+
+func (p *blobFileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	file, ok := p.files[filename]
+	if !ok {
+		return nil, docdb.NewErrDocumentFileNotFound(p.docID, filename)
+	}
+	return openBlob(ctx, p.blobStore, p.docID, file)
+}
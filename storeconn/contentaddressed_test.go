@@ -0,0 +1,389 @@
+package storeconn_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-types/uu"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/storeconn"
+)
+
+// memBlobStore implements storeconn.BlobStore in memory
+// and counts the content actually written.
+type memBlobStore struct {
+	blobs     map[string][]byte
+	numWrites int
+}
+
+func newMemBlobStore() *memBlobStore {
+	return &memBlobStore{blobs: make(map[string][]byte)}
+}
+
+func (s *memBlobStore) WriteBlob(_ context.Context, hash string, file fs.FileReader) error {
+	if _, ok := s.blobs[hash]; ok {
+		return nil
+	}
+	data, err := file.ReadAll()
+	if err != nil {
+		return err
+	}
+	s.blobs[hash] = data
+	s.numWrites++
+	return nil
+}
+
+func (s *memBlobStore) OpenBlob(_ context.Context, hash string) (io.ReadCloser, error) {
+	data, ok := s.blobs[hash]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memBlobStore) ReadBlobRange(_ context.Context, hash string, offset, length int64) ([]byte, error) {
+	data, ok := s.blobs[hash]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return docdb.ReadRange(bytes.NewReader(data), offset, length)
+}
+
+func (s *memBlobStore) ListBlobs(ctx context.Context, onHashes func(ctx context.Context, hashes []string) error) error {
+	if len(s.blobs) == 0 {
+		return nil
+	}
+	return onHashes(ctx, slices.Sorted(maps.Keys(s.blobs)))
+}
+
+func (s *memBlobStore) DeleteBlobs(_ context.Context, hashes []string) error {
+	for _, hash := range hashes {
+		delete(s.blobs, hash)
+	}
+	return nil
+}
+
+// sharedMetadataStore implements the storeconn.MetadataStore methods
+// used by the content-addressed Conn in memory.
+type sharedMetadataStore struct {
+	storeconn.MetadataStore
+
+	docs map[uu.ID][]*docdb.VersionInfo
+}
+
+func newSharedMetadataStore() *sharedMetadataStore {
+	return &sharedMetadataStore{docs: make(map[uu.ID][]*docdb.VersionInfo)}
+}
+
+func (m *sharedMetadataStore) referenced(hash string) bool {
+	for _, versions := range m.docs {
+		for _, info := range versions {
+			for _, file := range info.Files {
+				if file.Hash == hash {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (m *sharedMetadataStore) CreateDocumentVersion(_ context.Context, in storeconn.CreateDocumentVersionInput) (*docdb.VersionInfo, error) {
+	if in.PreviousVersion == nil && len(m.docs[in.DocID]) > 0 {
+		return nil, docdb.NewErrDocumentAlreadyExists(in.DocID)
+	}
+	files := in.Files
+	if files == nil {
+		files = make(map[string]docdb.FileInfo, len(in.AddedFiles))
+		for _, file := range in.AddedFiles {
+			files[file.Name] = *file
+		}
+	}
+	info := &docdb.VersionInfo{
+		DocID:     in.DocID,
+		CompanyID: in.CompanyID,
+		Version:   in.NewVersion,
+		Files:     files,
+	}
+	m.docs[in.DocID] = append(m.docs[in.DocID], info)
+	return info, nil
+}
+
+func (m *sharedMetadataStore) DocumentVersions(_ context.Context, docID uu.ID) ([]docdb.VersionTime, error) {
+	versions := m.docs[docID]
+	if len(versions) == 0 {
+		return nil, docdb.NewErrDocumentNotFound(docID)
+	}
+	times := make([]docdb.VersionTime, len(versions))
+	for i, info := range versions {
+		times[i] = info.Version
+	}
+	return times, nil
+}
+
+func (m *sharedMetadataStore) DocumentVersionInfo(_ context.Context, docID uu.ID, version docdb.VersionTime) (*docdb.VersionInfo, error) {
+	for _, info := range m.docs[docID] {
+		if info.Version.Equal(version) {
+			return info, nil
+		}
+	}
+	return nil, docdb.NewErrDocumentVersionNotFound(docID, version)
+}
+
+func (m *sharedMetadataStore) LatestDocumentVersionInfo(_ context.Context, docID uu.ID) (*docdb.VersionInfo, error) {
+	versions := m.docs[docID]
+	if len(versions) == 0 {
+		return nil, docdb.NewErrDocumentNotFound(docID)
+	}
+	return versions[len(versions)-1], nil
+}
+
+func (m *sharedMetadataStore) LatestDocumentVersion(ctx context.Context, docID uu.ID) (docdb.VersionTime, error) {
+	info, err := m.LatestDocumentVersionInfo(ctx, docID)
+	if err != nil {
+		return docdb.VersionTime{}, err
+	}
+	return info.Version, nil
+}
+
+func (m *sharedMetadataStore) DocumentHashes(_ context.Context, docID uu.ID) ([]string, error) {
+	hashes := make(map[string]bool)
+	for _, info := range m.docs[docID] {
+		for _, file := range info.Files {
+			hashes[file.Hash] = true
+		}
+	}
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	return slices.Sorted(maps.Keys(hashes)), nil
+}
+
+func (m *sharedMetadataStore) DeleteDocument(_ context.Context, docID uu.ID) error {
+	if len(m.docs[docID]) == 0 {
+		return docdb.NewErrDocumentNotFound(docID)
+	}
+	delete(m.docs, docID)
+	return nil
+}
+
+func (m *sharedMetadataStore) DeleteDocumentVersion(_ context.Context, docID uu.ID, version docdb.VersionTime) (leftVersions []docdb.VersionTime, hashesToDelete []string, err error) {
+	versions := m.docs[docID]
+	i := slices.IndexFunc(versions, func(info *docdb.VersionInfo) bool { return info.Version.Equal(version) })
+	if i < 0 {
+		return nil, nil, docdb.NewErrDocumentVersionNotFound(docID, version)
+	}
+	deleted := versions[i]
+	m.docs[docID] = slices.Delete(versions, i, i+1)
+	for _, file := range deleted.Files {
+		hashesToDelete = append(hashesToDelete, file.Hash)
+	}
+	for _, info := range m.docs[docID] {
+		leftVersions = append(leftVersions, info.Version)
+	}
+	return leftVersions, hashesToDelete, nil
+}
+
+func (m *sharedMetadataStore) DeleteUnreferencedHashes(ctx context.Context, hashes []string, deleteContent func(ctx context.Context, hashes []string) error) error {
+	unreferenced, err := m.UnreferencedHashes(ctx, hashes)
+	if err != nil || len(unreferenced) == 0 {
+		return err
+	}
+	return deleteContent(ctx, unreferenced)
+}
+
+func (m *sharedMetadataStore) UnreferencedHashes(_ context.Context, hashes []string) ([]string, error) {
+	var unreferenced []string
+	for _, hash := range hashes {
+		if !m.referenced(hash) && !slices.Contains(unreferenced, hash) {
+			unreferenced = append(unreferenced, hash)
+		}
+	}
+	slices.Sort(unreferenced)
+	return unreferenced, nil
+}
+
+func createContentAddressedDoc(t *testing.T, conn docdb.Conn, docID uu.ID, content []byte, onNewVersion docdb.OnNewVersionFunc) error {
+	t.Helper()
+	return conn.CreateDocument(t.Context(), uu.IDv7(), docID, uu.IDv7(), "genesis",
+		docdb.NewVersionTime(),
+		[]fs.FileReader{fs.NewMemFile("a.txt", content)},
+		onNewVersion,
+	)
+}
+
+func noopOnNewVersion(context.Context, *docdb.VersionInfo) error { return nil }
+
+// TestConn_ContentAddressed_SharesContentAcrossDocuments verifies that
+// identical content of two documents is written once and only deleted
+// together with the last document referencing it.
+func TestConn_ContentAddressed_SharesContentAcrossDocuments(t *testing.T) {
+	ctx := t.Context()
+	blobs := newMemBlobStore()
+	conn := storeconn.NewContentAddressed(blobs, newSharedMetadataStore())
+	content := []byte("shared content")
+	hash := docdb.ContentHash(content)
+	docID1 := uu.IDv7()
+	docID2 := uu.IDv7()
+
+	require.NoError(t, createContentAddressedDoc(t, conn, docID1, content, noopOnNewVersion))
+	require.NoError(t, createContentAddressedDoc(t, conn, docID2, content, noopOnNewVersion))
+	require.Equal(t, 1, blobs.numWrites, "identical content must be written once")
+
+	version, err := conn.LatestDocumentVersion(ctx, docID2)
+	require.NoError(t, err)
+	data, err := conn.ReadDocumentVersionFile(ctx, docID2, version, "a.txt")
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+	data, err = conn.ReadDocumentVersionFileRange(ctx, docID2, version, "a.txt", 7, 4)
+	require.NoError(t, err)
+	require.Equal(t, []byte("cont"), data)
+
+	require.NoError(t, conn.DeleteDocument(ctx, docID1))
+	require.Contains(t, blobs.blobs, hash, "content still referenced by docID2 must be kept")
+	exists, err := conn.DocumentExists(ctx, docID1)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	require.NoError(t, conn.DeleteDocument(ctx, docID2))
+	require.NotContains(t, blobs.blobs, hash, "content of the last referencing document must be deleted")
+}
+
+// TestConn_ContentAddressed_AddDocumentVersionReadsSharedContent verifies
+// that the previous files passed to the CreateVersionFunc are read
+// from the shared content and that a new version writes only new content.
+func TestConn_ContentAddressed_AddDocumentVersionReadsSharedContent(t *testing.T) {
+	ctx := t.Context()
+	blobs := newMemBlobStore()
+	conn := storeconn.NewContentAddressed(blobs, newSharedMetadataStore())
+	docID := uu.IDv7()
+	require.NoError(t, createContentAddressedDoc(t, conn, docID, []byte("a"), noopOnNewVersion))
+
+	err := conn.AddDocumentVersion(ctx, docID, uu.IDv7(), "second",
+		func(ctx context.Context, _ uu.ID, prevVersion docdb.VersionTime, prevFiles docdb.FileProvider) (*docdb.CreateVersionResult, error) {
+			data, err := prevFiles.ReadFile(ctx, "a.txt")
+			if err != nil {
+				return nil, err
+			}
+			return &docdb.CreateVersionResult{
+				Version: docdb.VersionTimeFrom(prevVersion.Time.Add(time.Millisecond)),
+				WriteFiles: []fs.FileReader{
+					fs.NewMemFile("a.txt", data),
+					fs.NewMemFile("b.txt", append(data, 'b')),
+				},
+			}, nil
+		},
+		noopOnNewVersion,
+	)
+	require.NoError(t, err)
+	require.Equal(t, 2, blobs.numWrites, "unchanged content must not be written again")
+
+	info, err := conn.LatestDocumentVersionInfo(ctx, docID)
+	require.NoError(t, err)
+	require.Equal(t, docdb.ContentHash([]byte("ab")), info.Files["b.txt"].Hash)
+}
+
+// TestConn_ContentAddressed_CreateDocumentRollback verifies that a failed
+// onNewVersion removes the created version and deletes only content
+// that no other document references.
+func TestConn_ContentAddressed_CreateDocumentRollback(t *testing.T) {
+	ctx := t.Context()
+	blobs := newMemBlobStore()
+	conn := storeconn.NewContentAddressed(blobs, newSharedMetadataStore())
+	shared := []byte("shared content")
+	docID1 := uu.IDv7()
+	docID2 := uu.IDv7()
+	docID3 := uu.IDv7()
+	failOnNewVersion := func(context.Context, *docdb.VersionInfo) error { return errors.New("onNewVersion rejected") }
+
+	require.NoError(t, createContentAddressedDoc(t, conn, docID1, shared, noopOnNewVersion))
+
+	err := createContentAddressedDoc(t, conn, docID2, shared, failOnNewVersion)
+	require.Error(t, err)
+	exists, err := conn.DocumentExists(ctx, docID2)
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.Contains(t, blobs.blobs, docdb.ContentHash(shared), "content referenced by docID1 must be kept")
+
+	err = createContentAddressedDoc(t, conn, docID3, []byte("own content"), failOnNewVersion)
+	require.Error(t, err)
+	require.NotContains(t, blobs.blobs, docdb.ContentHash([]byte("own content")))
+}
+
+// TestConn_ContentAddressed_ExistingDocumentRefused verifies that creating
+// an existing document fails before its content is written.
+func TestConn_ContentAddressed_ExistingDocumentRefused(t *testing.T) {
+	blobs := newMemBlobStore()
+	conn := storeconn.NewContentAddressed(blobs, newSharedMetadataStore())
+	docID := uu.IDv7()
+	require.NoError(t, createContentAddressedDoc(t, conn, docID, []byte("first"), noopOnNewVersion))
+
+	err := createContentAddressedDoc(t, conn, docID, []byte("second"), noopOnNewVersion)
+	require.ErrorIs(t, err, docdb.NewErrDocumentAlreadyExists(docID))
+	require.NotContains(t, blobs.blobs, docdb.ContentHash([]byte("second")))
+	require.Contains(t, blobs.blobs, docdb.ContentHash([]byte("first")))
+}
+
+// TestConn_ContentAddressed_MissingContent verifies that content missing
+// from the BlobStore is reported as ErrDocumentFileNotFound.
+func TestConn_ContentAddressed_MissingContent(t *testing.T) {
+	ctx := t.Context()
+	blobs := newMemBlobStore()
+	conn := storeconn.NewContentAddressed(blobs, newSharedMetadataStore())
+	docID := uu.IDv7()
+	content := []byte("lost content")
+	require.NoError(t, createContentAddressedDoc(t, conn, docID, content, noopOnNewVersion))
+	delete(blobs.blobs, docdb.ContentHash(content))
+
+	version, err := conn.LatestDocumentVersion(ctx, docID)
+	require.NoError(t, err)
+	_, err = conn.ReadDocumentVersionFile(ctx, docID, version, "a.txt")
+	require.ErrorIs(t, err, docdb.NewErrDocumentFileNotFound(docID, "a.txt"))
+	_, err = conn.OpenDocumentVersionFile(ctx, docID, version, "a.txt")
+	require.ErrorIs(t, err, docdb.NewErrDocumentFileNotFound(docID, "a.txt"))
+	_, err = conn.ReadDocumentVersionFileRange(ctx, docID, version, "a.txt", 0, 4)
+	require.ErrorIs(t, err, docdb.NewErrDocumentFileNotFound(docID, "a.txt"))
+}
+
+// TestDeleteUnreferencedBlobs verifies that leaked content of unreferenced
+// hashes is only reported in a dry run and deleted otherwise,
+// while referenced content is kept.
+func TestDeleteUnreferencedBlobs(t *testing.T) {
+	ctx := t.Context()
+	blobs := newMemBlobStore()
+	meta := newSharedMetadataStore()
+	conn := storeconn.NewContentAddressed(blobs, meta)
+	referenced := []byte("referenced")
+	require.NoError(t, createContentAddressedDoc(t, conn, uu.IDv7(), referenced, noopOnNewVersion))
+
+	// Simulate a crash between deleting the metadata and the content
+	leaked := []byte("leaked")
+	leakedHash := docdb.ContentHash(leaked)
+	blobs.blobs[leakedHash] = leaked
+
+	hashes, err := storeconn.DeleteUnreferencedBlobs(ctx, blobs, meta, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{leakedHash}, hashes)
+	require.Contains(t, blobs.blobs, leakedHash, "dry run must not delete")
+
+	hashes, err = storeconn.DeleteUnreferencedBlobs(ctx, blobs, meta, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{leakedHash}, hashes)
+	require.NotContains(t, blobs.blobs, leakedHash)
+	require.Contains(t, blobs.blobs, docdb.ContentHash(referenced))
+
+	hashes, err = storeconn.DeleteUnreferencedBlobs(ctx, blobs, meta, false)
+	require.NoError(t, err)
+	require.Empty(t, hashes)
+}
@@ -2,6 +2,7 @@ package storeconn
 
 import (
 	"context"
+	"io"
 
 	"github.com/domonda/go-types/uu"
 	"github.com/ungerik/go-fs"
@@ -40,6 +41,19 @@ type DocumentStore interface {
 	// and hash exists for the document.
 	ReadDocumentHashFile(ctx context.Context, docID uu.ID, filename, hash string) (data []byte, err error)
 
+	// OpenDocumentHashFile opens a single file identified by its content hash
+	// for streaming reads. The caller must close the returned io.ReadCloser.
+	// Returns ErrDocumentFileNotFound if no file with the given filename
+	// and hash exists for the document.
+	OpenDocumentHashFile(ctx context.Context, docID uu.ID, filename, hash string) (reader io.ReadCloser, err error)
+
+	// ReadDocumentHashFileRange reads up to length bytes starting at offset
+	// of a single file identified by its content hash
+	// with the semantics of docdb.Conn.ReadDocumentVersionFileRange.
+	// Returns ErrDocumentFileNotFound if no file with the given filename
+	// and hash exists for the document.
+	ReadDocumentHashFileRange(ctx context.Context, docID uu.ID, filename, hash string, offset, length int64) (data []byte, err error)
+
 	// DeleteDocument deletes all stored files for a document.
 	// Returns ErrDocumentNotFound if the document does not exist.
 	DeleteDocument(ctx context.Context, docID uu.ID) error
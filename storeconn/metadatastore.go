@@ -35,6 +35,17 @@ type CreateDocumentVersionInput struct {
 	// caller must not mutate it after the call. AddedFiles/ModifiedFiles/
 	// RemovedFiles are still recorded as the version's change lists either way.
 	Files map[string]docdb.FileInfo
+	// PreviousMustBeLatest makes CreateDocumentVersion fail with
+	// docdb.ErrDocumentChanged if PreviousVersion is no longer the latest
+	// version of the document. The check must be atomic with writing the new
+	// version, so that of two concurrent calls with the same PreviousVersion
+	// at most one succeeds. Used by AddDocumentVersionIfLatest;
+	// ignored when PreviousVersion is nil.
+	PreviousMustBeLatest bool
+	// IdempotencyKey is stored with the new version if not empty,
+	// see docdb.ContextWithIdempotencyKey. A document must not have
+	// two versions with the same key.
+	IdempotencyKey string
 }
 
 // MetadataStore is the interface for storing and querying document version metadata.
@@ -49,6 +60,9 @@ type MetadataStore interface {
 	// to an existing document, carrying that version's files forward before
 	// applying the added/modified/removed deltas.
 	//
+	// Returns docdb.ErrDocumentChanged if in.PreviousMustBeLatest is set
+	// and in.PreviousVersion is not the latest version of the document.
+	//
 	// Returns the resulting full VersionInfo.
 	CreateDocumentVersion(ctx context.Context, in CreateDocumentVersionInput) (*docdb.VersionInfo, error)
 
@@ -81,6 +95,34 @@ type MetadataStore interface {
 	// LatestDocumentVersionInfo returns the VersionInfo for the latest version of a document.
 	LatestDocumentVersionInfo(ctx context.Context, docID uu.ID) (*docdb.VersionInfo, error)
 
+	// IdempotentVersionInfo returns the VersionInfo of the version
+	// of a document that was committed with the idempotency key
+	// or nil if there is no such version or document.
+	// Implementations should use an index instead of reading
+	// every version, see docdb.IdempotentVersionInfo.
+	IdempotentVersionInfo(ctx context.Context, docID uu.ID, key string) (*docdb.VersionInfo, error)
+
+	// DocumentHashes returns the distinct content hashes of the files
+	// of all versions of a document, sorted for a consistent order.
+	// Returns nil if the document has no versions.
+	// Used to find DocumentStore content that no version references.
+	DocumentHashes(ctx context.Context, docID uu.ID) ([]string, error)
+
+	// DeleteUnreferencedHashes calls deleteContent with those of the passed
+	// hashes that are not referenced by any version of any document.
+	// The hashes must stay locked against CreateDocumentVersion
+	// until deleteContent returned, so that a version referencing
+	// one of the hashes again is only committed after the content was deleted
+	// and writes the content anew.
+	// Used by the content-addressed Conn returned by NewContentAddressed
+	// where content is shared across documents.
+	DeleteUnreferencedHashes(ctx context.Context, hashes []string, deleteContent func(ctx context.Context, hashes []string) error) error
+
+	// UnreferencedHashes returns the distinct hashes of the passed hashes
+	// that are not referenced by any version of any document,
+	// sorted for a consistent order.
+	UnreferencedHashes(ctx context.Context, hashes []string) ([]string, error)
+
 	// DeleteDocument deletes all version metadata for a document.
 	DeleteDocument(ctx context.Context, docID uu.ID) error
 
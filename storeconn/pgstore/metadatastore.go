@@ -8,6 +8,7 @@ import (
 	"github.com/domonda/go-errs"
 	"github.com/domonda/go-sqldb"
 	"github.com/domonda/go-sqldb/db"
+	"github.com/domonda/go-types/nullable"
 	"github.com/domonda/go-types/uu"
 
 	"github.com/domonda/go-docdb"
@@ -96,16 +97,17 @@ func (store *postgresMetadataStore) CreateDocumentVersion(ctx context.Context, i
 		modifiedFilenames := namesFromFileInfos(in.ModifiedFiles)
 
 		info := &docdb.VersionInfo{
-			DocID:         in.DocID,
-			CompanyID:     in.CompanyID,
-			Version:       in.NewVersion,
-			PrevVersion:   in.PreviousVersion,
-			CommitUserID:  in.UserID,
-			CommitReason:  in.Reason,
-			AddedFiles:    addedFilenames,
-			RemovedFiles:  in.RemovedFiles,
-			ModifiedFiles: modifiedFilenames,
-			Files:         files,
+			DocID:          in.DocID,
+			CompanyID:      in.CompanyID,
+			Version:        in.NewVersion,
+			PrevVersion:    in.PreviousVersion,
+			CommitUserID:   in.UserID,
+			CommitReason:   in.Reason,
+			IdempotencyKey: in.IdempotencyKey,
+			AddedFiles:     addedFilenames,
+			RemovedFiles:   in.RemovedFiles,
+			ModifiedFiles:  modifiedFilenames,
+			Files:          files,
 		}
 
 		// In versions-exist mode the version is already stored (see
@@ -115,23 +117,38 @@ func (store *postgresMetadataStore) CreateDocumentVersion(ctx context.Context, i
 			return info, store.assertStoredVersionEquals(ctx, info)
 		}
 
+		if in.PreviousMustBeLatest && in.PreviousVersion != nil {
+			err := store.lockLatestDocumentVersion(ctx, in.DocID, *in.PreviousVersion)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		versionID := uu.IDv7()
 		err := db.InsertRowStruct(ctx, &DocumentVersion{
-			ID:            versionID,
-			DocumentID:    in.DocID,
-			CompanyID:     in.CompanyID,
-			Version:       in.NewVersion,
-			PrevVersion:   in.PreviousVersion, // nil => NULL
-			CommitUserID:  in.UserID,
-			CommitReason:  in.Reason,
-			AddedFiles:    addedFilenames,
-			RemovedFiles:  in.RemovedFiles,
-			ModifiedFiles: modifiedFilenames,
+			ID:             versionID,
+			DocumentID:     in.DocID,
+			CompanyID:      in.CompanyID,
+			Version:        in.NewVersion,
+			PrevVersion:    in.PreviousVersion, // nil => NULL
+			CommitUserID:   in.UserID,
+			CommitReason:   in.Reason,
+			AddedFiles:     addedFilenames,
+			RemovedFiles:   in.RemovedFiles,
+			ModifiedFiles:  modifiedFilenames,
+			IdempotencyKey: nullable.NonEmptyString(in.IdempotencyKey), // "" => NULL
 		})
 		if err != nil {
-			// document_version has two unique constraints: (document_id, version)
-			// and a partial unique index on (document_id) where prev_version is
-			// null (one genesis version per document). A genesis insert
+			// The idempotency key is unique per document, a violation means
+			// that a concurrent call with the same key committed first
+			var uniqueViolation sqldb.ErrUniqueViolation
+			if errors.As(err, &uniqueViolation) && uniqueViolation.Constraint == "document_version_idempotency_key_idx" {
+				return nil, errs.Errorf("document %s already has a version with idempotency key %q: %w", in.DocID, in.IdempotencyKey, err)
+			}
+			// Besides the idempotency key, document_version has two unique
+			// constraints: (document_id, version) and a partial unique index on
+			// (document_id) where prev_version is null (one genesis version per
+			// document). A genesis insert
 			// (PreviousVersion == nil) can violate either — a same-timestamp
 			// collision or another genesis with a different timestamp — and both
 			// mean the document already exists. An appended insert can only hit
@@ -146,7 +163,9 @@ func (store *postgresMetadataStore) CreateDocumentVersion(ctx context.Context, i
 		}
 
 		versionFiles := make([]*DocumentVersionFile, 0, len(files))
+		hashes := make([]string, 0, len(files))
 		for _, fi := range files {
+			hashes = append(hashes, fi.Hash)
 			versionFiles = append(versionFiles, &DocumentVersionFile{
 				DocumentVersionID: versionID,
 				Name:              fi.Name,
@@ -154,6 +173,12 @@ func (store *postgresMetadataStore) CreateDocumentVersion(ctx context.Context, i
 				Hash:              fi.Hash,
 			})
 		}
+		// Wait for a DeleteUnreferencedHashes deleting the content
+		// of one of the hashes and block new ones until committed
+		err = lockHashes(ctx, hashes, true)
+		if err != nil {
+			return nil, err
+		}
 		err = db.InsertRowStructs(ctx, versionFiles)
 		if err != nil {
 			return nil, err
@@ -162,6 +187,43 @@ func (store *postgresMetadataStore) CreateDocumentVersion(ctx context.Context, i
 	})
 }
 
+// lockLatestDocumentVersion locks the document_version row of expectedLatest
+// until the end of the current transaction and returns docdb.ErrDocumentChanged
+// if expectedLatest does not exist or is not the latest version of the document.
+//
+// Concurrent transactions locking the same version are serialized by the row lock.
+// The latest version is queried with a separate statement after the lock was
+// acquired, because under read committed isolation only a new statement sees
+// the version committed by the transaction that held the lock before.
+func (store *postgresMetadataStore) lockLatestDocumentVersion(ctx context.Context, docID uu.ID, expectedLatest docdb.VersionTime) error {
+	locked, err := db.QueryRowsAsSlice[docdb.VersionTime](ctx,
+		/* sql */ `
+			select version
+			from docdb.document_version
+			where document_id = $1
+				and version = $2
+			for update
+		`,
+		docID,          // $1
+		expectedLatest, // $2
+	)
+	if err != nil {
+		return err
+	}
+	if len(locked) == 0 {
+		return docdb.NewErrDocumentChanged(docID, expectedLatest)
+	}
+
+	latest, err := store.LatestDocumentVersion(ctx, docID)
+	if err != nil {
+		return err
+	}
+	if !latest.Equal(expectedLatest) {
+		return docdb.NewErrDocumentChanged(docID, expectedLatest)
+	}
+	return nil
+}
+
 // assertStoredVersionEquals verifies that the document version already stored in
 // the MetadataStore is identical to expected, returning an error if the version
 // is missing or differs. It backs CreateDocumentVersion's versions-exist mode.
@@ -298,32 +360,7 @@ func (store *postgresMetadataStore) DocumentVersionInfo(ctx context.Context, doc
 		return nil, docdb.NewErrDocumentNotFound(docID)
 	}
 
-	files := map[string]docdb.FileInfo{}
-	for _, rec := range records {
-		if rec.DocumentVersionID == nil {
-			continue
-		}
-
-		files[*rec.Name] = docdb.FileInfo{
-			Name: *rec.Name,
-			Size: *rec.Size,
-			Hash: *rec.Hash,
-		}
-	}
-
-	firstRec := records[0]
-	return &docdb.VersionInfo{
-		CompanyID:     firstRec.CompanyID,
-		DocID:         firstRec.DocumentID,
-		Version:       firstRec.Version,
-		PrevVersion:   firstRec.PrevVersion,
-		CommitUserID:  firstRec.CommitUserID,
-		CommitReason:  firstRec.CommitReason,
-		AddedFiles:    firstRec.AddedFiles,
-		ModifiedFiles: firstRec.ModifiedFiles,
-		RemovedFiles:  firstRec.RemovedFiles,
-		Files:         files,
-	}, nil
+	return versionInfoFromRecords(records), nil
 }
 
 func (store *postgresMetadataStore) LatestDocumentVersionInfo(ctx context.Context, docID uu.ID) (*docdb.VersionInfo, error) {
@@ -350,6 +387,43 @@ func (store *postgresMetadataStore) LatestDocumentVersionInfo(ctx context.Contex
 		return nil, docdb.NewErrDocumentNotFound(docID)
 	}
 
+	return versionInfoFromRecords(records), nil
+}
+
+// IdempotentVersionInfo uses the unique index
+// document_version_idempotency_key_idx to find the version.
+func (store *postgresMetadataStore) IdempotentVersionInfo(ctx context.Context, docID uu.ID, key string) (*docdb.VersionInfo, error) {
+	records, err := db.QueryRowsAsSlice[docVersionQueryResult](ctx,
+		/* sql */ `
+			select *
+			from docdb.document_version dv
+			left join docdb.document_version_file dvf on dv.id = dvf.document_version_id
+			where dv.document_id = $1 and dv.idempotency_key = $2
+		`,
+		docID, // $1
+		key,   // $2
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return versionInfoFromRecords(records), nil
+}
+
+type docVersionQueryResult struct {
+	DocumentVersion
+
+	DocumentVersionID *uu.ID  `db:"document_version_id"`
+	Name              *string `db:"name"`
+	Size              *int64  `db:"size"`
+	Hash              *string `db:"hash"`
+}
+
+// versionInfoFromRecords returns the VersionInfo of the non empty records
+// of a version joined with its files.
+func versionInfoFromRecords(records []docVersionQueryResult) *docdb.VersionInfo {
 	files := map[string]docdb.FileInfo{}
 	for _, rec := range records {
 		if rec.DocumentVersionID == nil {
@@ -364,29 +438,96 @@ func (store *postgresMetadataStore) LatestDocumentVersionInfo(ctx context.Contex
 	}
 
 	firstRec := records[0]
-	result := &docdb.VersionInfo{
-		CompanyID:     firstRec.CompanyID,
-		DocID:         firstRec.DocumentID,
-		Version:       firstRec.Version,
-		PrevVersion:   firstRec.PrevVersion,
-		CommitUserID:  firstRec.CommitUserID,
-		CommitReason:  firstRec.CommitReason,
-		AddedFiles:    firstRec.AddedFiles,
-		ModifiedFiles: firstRec.ModifiedFiles,
-		RemovedFiles:  firstRec.RemovedFiles,
-		Files:         files,
+	return &docdb.VersionInfo{
+		CompanyID:      firstRec.CompanyID,
+		DocID:          firstRec.DocumentID,
+		Version:        firstRec.Version,
+		PrevVersion:    firstRec.PrevVersion,
+		CommitUserID:   firstRec.CommitUserID,
+		CommitReason:   firstRec.CommitReason,
+		IdempotencyKey: firstRec.IdempotencyKey.Get(),
+		AddedFiles:     firstRec.AddedFiles,
+		ModifiedFiles:  firstRec.ModifiedFiles,
+		RemovedFiles:   firstRec.RemovedFiles,
+		Files:          files,
 	}
+}
 
-	return result, nil
+func (store *postgresMetadataStore) DocumentHashes(ctx context.Context, docID uu.ID) ([]string, error) {
+	// distinct because unchanged files are carried forward
+	// and share their hash across versions.
+	return db.QueryRowsAsSlice[string](ctx,
+		/* sql */ `
+			select distinct dvf.hash
+			from docdb.document_version_file dvf
+			join docdb.document_version dv on dv.id = dvf.document_version_id
+			where dv.document_id = $1
+			order by dvf.hash
+		`,
+		docID, // $1
+	)
 }
 
-type docVersionQueryResult struct {
-	DocumentVersion
+// DeleteUnreferencedHashes takes an exclusive transaction level advisory
+// lock per passed hash, calls deleteContent with the hashes that are not
+// referenced by any docdb.document_version_file row and releases the locks
+// with the commit. CreateDocumentVersion takes a shared lock per hash
+// of the inserted files, so a version referencing one of the hashes
+// is committed either before the references are queried
+// or after the content was deleted.
+//
+// In versions-exist mode (see ContextWithMetadataStoreVersionsExist)
+// nothing is deleted because no version was deleted.
+func (store *postgresMetadataStore) DeleteUnreferencedHashes(ctx context.Context, hashes []string, deleteContent func(ctx context.Context, hashes []string) error) error {
+	if len(hashes) == 0 || metadataStoreVersionsExist(ctx) {
+		return nil
+	}
+	return db.Transaction(ctx, func(ctx context.Context) error {
+		err := lockHashes(ctx, hashes, false)
+		if err != nil {
+			return err
+		}
+		// Queried with a separate statement after the locks were granted
+		// to see the versions committed by the holders of shared locks
+		unreferenced, err := store.UnreferencedHashes(ctx, hashes)
+		if err != nil || len(unreferenced) == 0 {
+			return err
+		}
+		return deleteContent(ctx, unreferenced)
+	})
+}
 
-	DocumentVersionID *uu.ID  `db:"document_version_id"`
-	Name              *string `db:"name"`
-	Size              *int64  `db:"size"`
-	Hash              *string `db:"hash"`
+func (store *postgresMetadataStore) UnreferencedHashes(ctx context.Context, hashes []string) ([]string, error) {
+	return db.QueryRowsAsSlice[string](ctx,
+		/* sql */ `
+			select distinct h.hash
+			from unnest($1::text[]) as h(hash)
+			where not exists (
+				select from docdb.document_version_file dvf
+				where dvf.hash = h.hash
+			)
+			order by h.hash
+		`,
+		hashes, // $1
+	)
+}
+
+// lockHashes takes a transaction level advisory lock per distinct hash,
+// shared or exclusive. The locks are taken ordered by hash
+// so concurrent transactions don't deadlock.
+func lockHashes(ctx context.Context, hashes []string, shared bool) error {
+	lockFunc := "pg_advisory_xact_lock"
+	if shared {
+		lockFunc = "pg_advisory_xact_lock_shared"
+	}
+	return db.Exec(ctx,
+		/* sql */ `
+			select `+lockFunc+`(hashtextextended(h.hash, 0))
+			from (select distinct unnest($1::text[]) as hash) as h
+			order by h.hash
+		`,
+		hashes, // $1
+	)
 }
 
 func (store *postgresMetadataStore) DeleteDocument(ctx context.Context, docID uu.ID) error {
@@ -1,7 +1,10 @@
 package pgstore_test
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"slices"
 	"testing"
 	"time"
 
@@ -574,6 +577,141 @@ func TestCreateDocumentVersionDuplicate(t *testing.T) {
 	})
 }
 
+func TestCreateDocumentVersionPreviousMustBeLatest(t *testing.T) {
+	addedFiles := []*docdb.FileInfo{{Name: "a.pdf", Size: 1, Hash: docdb.ContentHash([]byte("a"))}}
+
+	t.Run("Appends when the previous version is the latest", func(t *testing.T) {
+		t.Parallel()
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		docID := uu.IDv7()
+		companyID := uu.IDv7()
+		userID := uu.IDv7()
+		v1 := docdb.NewVersionTime()
+		v2 := docdb.VersionTimeFrom(time.Now().Add(time.Second))
+
+		_, err := store.CreateDocumentVersion(ctx, storeconn.CreateDocumentVersionInput{
+			DocID: docID, CompanyID: companyID, UserID: userID, Reason: "v1", NewVersion: v1, AddedFiles: addedFiles,
+		})
+		require.NoError(t, err)
+
+		added := []*docdb.FileInfo{{Name: "b.pdf", Size: 1, Hash: docdb.ContentHash([]byte("b"))}}
+		_, err = store.CreateDocumentVersion(ctx, storeconn.CreateDocumentVersionInput{
+			DocID: docID, CompanyID: companyID, UserID: userID, Reason: "v2", NewVersion: v2, PreviousVersion: &v1, AddedFiles: added,
+			PreviousMustBeLatest: true,
+		})
+		require.NoError(t, err)
+
+		latest, err := store.LatestDocumentVersion(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, v2, latest)
+	})
+
+	t.Run("Returns ErrDocumentChanged when a newer version exists", func(t *testing.T) {
+		t.Parallel()
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		docID := uu.IDv7()
+		companyID := uu.IDv7()
+		userID := uu.IDv7()
+		v1 := docdb.NewVersionTime()
+		v2 := docdb.VersionTimeFrom(time.Now().Add(time.Second))
+		v3 := docdb.VersionTimeFrom(time.Now().Add(2 * time.Second))
+
+		_, err := store.CreateDocumentVersion(ctx, storeconn.CreateDocumentVersionInput{
+			DocID: docID, CompanyID: companyID, UserID: userID, Reason: "v1", NewVersion: v1, AddedFiles: addedFiles,
+		})
+		require.NoError(t, err)
+		_, err = store.CreateDocumentVersion(ctx, storeconn.CreateDocumentVersionInput{
+			DocID: docID, CompanyID: companyID, UserID: userID, Reason: "v2", NewVersion: v2, PreviousVersion: &v1,
+			AddedFiles: []*docdb.FileInfo{{Name: "b.pdf", Size: 1, Hash: docdb.ContentHash([]byte("b"))}},
+		})
+		require.NoError(t, err)
+
+		// A second writer that also based its changes on v1
+		_, err = store.CreateDocumentVersion(ctx, storeconn.CreateDocumentVersionInput{
+			DocID: docID, CompanyID: companyID, UserID: userID, Reason: "v3", NewVersion: v3, PreviousVersion: &v1,
+			AddedFiles:           []*docdb.FileInfo{{Name: "c.pdf", Size: 1, Hash: docdb.ContentHash([]byte("c"))}},
+			PreviousMustBeLatest: true,
+		})
+		require.ErrorIs(t, err, docdb.NewErrDocumentChanged(docID, v1))
+
+		versions, err := store.DocumentVersions(ctx, docID)
+		require.NoError(t, err)
+		require.Equal(t, []docdb.VersionTime{v1, v2}, versions)
+	})
+
+	t.Run("Returns ErrDocumentChanged when the previous version does not exist", func(t *testing.T) {
+		t.Parallel()
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		docID := uu.IDv7()
+		companyID := uu.IDv7()
+		userID := uu.IDv7()
+		missingPrev := docdb.NewVersionTime()
+		newVersion := docdb.VersionTimeFrom(time.Now().Add(time.Second))
+
+		_, err := store.CreateDocumentVersion(ctx, storeconn.CreateDocumentVersionInput{
+			DocID: docID, CompanyID: companyID, UserID: userID, Reason: "reason",
+			NewVersion: newVersion, PreviousVersion: &missingPrev,
+			AddedFiles:           addedFiles,
+			Files:                map[string]docdb.FileInfo{"a.pdf": *addedFiles[0]},
+			PreviousMustBeLatest: true,
+		})
+		require.ErrorIs(t, err, docdb.NewErrDocumentChanged(docID, missingPrev))
+	})
+}
+
+func TestIdempotentVersionInfo(t *testing.T) {
+	addedFiles := []*docdb.FileInfo{{Name: "a.pdf", Size: 1, Hash: docdb.ContentHash([]byte("a"))}}
+
+	t.Run("Returns the version committed with the key", func(t *testing.T) {
+		t.Parallel()
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		docID := uu.IDv7()
+		companyID := uu.IDv7()
+		userID := uu.IDv7()
+		v1 := docdb.NewVersionTime()
+		v2 := docdb.VersionTimeFrom(time.Now().Add(time.Second))
+
+		_, err := store.CreateDocumentVersion(ctx, storeconn.CreateDocumentVersionInput{
+			DocID: docID, CompanyID: companyID, UserID: userID, Reason: "v1", NewVersion: v1, AddedFiles: addedFiles,
+			IdempotencyKey: "key1",
+		})
+		require.NoError(t, err)
+		_, err = store.CreateDocumentVersion(ctx, storeconn.CreateDocumentVersionInput{
+			DocID: docID, CompanyID: companyID, UserID: userID, Reason: "v2", NewVersion: v2, PreviousVersion: &v1,
+			AddedFiles:     []*docdb.FileInfo{{Name: "b.pdf", Size: 1, Hash: docdb.ContentHash([]byte("b"))}},
+			IdempotencyKey: "key2",
+		})
+		require.NoError(t, err)
+
+		info, err := store.IdempotentVersionInfo(ctx, docID, "key1")
+		require.NoError(t, err)
+		require.NotNil(t, info)
+		require.Equal(t, v1, info.Version)
+		require.Equal(t, "key1", info.IdempotencyKey)
+		require.Len(t, info.Files, 1)
+	})
+
+	t.Run("Returns nil for an unknown key or document", func(t *testing.T) {
+		t.Parallel()
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		docID := uu.IDv7()
+
+		_, err := store.CreateDocumentVersion(ctx, storeconn.CreateDocumentVersionInput{
+			DocID: docID, CompanyID: uu.IDv7(), UserID: uu.IDv7(), Reason: "v1", NewVersion: docdb.NewVersionTime(), AddedFiles: addedFiles,
+			IdempotencyKey: "key",
+		})
+		require.NoError(t, err)
+
+		info, err := store.IdempotentVersionInfo(ctx, docID, "other")
+		require.NoError(t, err)
+		require.Nil(t, info)
+
+		info, err = store.IdempotentVersionInfo(ctx, uu.IDv7(), "key")
+		require.NoError(t, err)
+		require.Nil(t, info)
+	})
+}
+
 func TestDocumentCompanyID(t *testing.T) {
 
 	// In theory all versions should have the same company_id, but if not, return the company_id from the most recent version
@@ -1002,6 +1140,131 @@ func TestLatestDocumentVersionInfo(t *testing.T) {
 	})
 }
 
+func TestDocumentHashes(t *testing.T) {
+	t.Run("Returns the distinct hashes of all versions sorted", func(t *testing.T) {
+		// given
+		t.Parallel()
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		populator := pgfixtures.FixturePopulator(t)
+		version1File := populator.DocumentVersionFile()
+		version2 := populator.DocumentVersion(map[string]any{
+			"DocumentID": version1File.DocumentVersion.DocumentID,
+			"Version":    docdb.VersionTimeFrom(time.Now().Add(time.Second)),
+		})
+		// carried forward file shares the hash of version 1
+		populator.DocumentVersionFile(map[string]any{
+			"DocumentVersion": version2,
+			"Name":            version1File.Name,
+			"Hash":            version1File.Hash,
+		})
+		version2File := populator.DocumentVersionFile(map[string]any{
+			"DocumentVersion": version2,
+		})
+
+		// not wanted, different doc ID
+		populator.DocumentVersionFile()
+
+		// when
+		hashes, err := store.DocumentHashes(ctx, version1File.DocumentVersion.DocumentID)
+
+		// then
+		require.NoError(t, err)
+		want := []string{version1File.Hash, version2File.Hash}
+		slices.Sort(want)
+		require.Equal(t, want, hashes)
+	})
+
+	t.Run("Returns nil if no versions", func(t *testing.T) {
+		// given
+		t.Parallel()
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+
+		// when
+		hashes, err := store.DocumentHashes(ctx, uu.IDv7())
+
+		// then
+		require.NoError(t, err)
+		require.Nil(t, hashes)
+	})
+}
+
+func TestUnreferencedHashes(t *testing.T) {
+	t.Run("Returns the distinct unreferenced hashes sorted", func(t *testing.T) {
+		// given
+		t.Parallel()
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		populator := pgfixtures.FixturePopulator(t)
+		referenced := populator.DocumentVersionFile()
+		unreferenced1 := docdb.ContentHash([]byte(uu.IDv7().String()))
+		unreferenced2 := docdb.ContentHash([]byte(uu.IDv7().String()))
+
+		// when
+		hashes, err := store.UnreferencedHashes(ctx, []string{unreferenced1, referenced.Hash, unreferenced2, unreferenced1})
+
+		// then
+		require.NoError(t, err)
+		want := []string{unreferenced1, unreferenced2}
+		slices.Sort(want)
+		require.Equal(t, want, hashes)
+	})
+}
+
+func TestDeleteUnreferencedHashes(t *testing.T) {
+	t.Run("Deletes the content of unreferenced hashes only", func(t *testing.T) {
+		// given
+		t.Parallel()
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		populator := pgfixtures.FixturePopulator(t)
+		referenced := populator.DocumentVersionFile()
+		unreferenced := docdb.ContentHash([]byte(uu.IDv7().String()))
+
+		// when
+		var deleted []string
+		err := store.DeleteUnreferencedHashes(ctx, []string{referenced.Hash, unreferenced}, func(ctx context.Context, hashes []string) error {
+			deleted = hashes
+			return nil
+		})
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []string{unreferenced}, deleted)
+	})
+
+	t.Run("Does not call deleteContent if all hashes are referenced", func(t *testing.T) {
+		// given
+		t.Parallel()
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		populator := pgfixtures.FixturePopulator(t)
+		referenced := populator.DocumentVersionFile()
+
+		// when
+		called := false
+		err := store.DeleteUnreferencedHashes(ctx, []string{referenced.Hash}, func(context.Context, []string) error {
+			called = true
+			return nil
+		})
+
+		// then
+		require.NoError(t, err)
+		require.False(t, called)
+	})
+
+	t.Run("Returns the error of deleteContent", func(t *testing.T) {
+		// given
+		t.Parallel()
+		ctx := pgfixtures.FixtureCtxWithTestTx(t)
+		deleteErr := errors.New("delete failed")
+
+		// when
+		err := store.DeleteUnreferencedHashes(ctx, []string{docdb.ContentHash([]byte(uu.IDv7().String()))}, func(context.Context, []string) error {
+			return deleteErr
+		})
+
+		// then
+		require.ErrorIs(t, err, deleteErr)
+	})
+}
+
 func TestDeleteDocument(t *testing.T) {
 	t.Run("Deletes document versions", func(t *testing.T) {
 		// given
@@ -5,6 +5,7 @@ import (
 
 	"github.com/domonda/go-docdb"
 	"github.com/domonda/go-sqldb"
+	"github.com/domonda/go-types/nullable"
 	"github.com/domonda/go-types/uu"
 )
 
@@ -34,14 +35,15 @@ type DocumentVersionFile struct {
 type DocumentVersion struct {
 	sqldb.TableName `db:"docdb.document_version"`
 
-	ID            uu.ID              `db:"id"`
-	DocumentID    uu.ID              `db:"document_id"`
-	CompanyID     uu.ID              `db:"company_id"`
-	Version       docdb.VersionTime  `db:"version"`
-	PrevVersion   *docdb.VersionTime `db:"prev_version"`
-	CommitUserID  uu.ID              `db:"commit_user_id"`
-	CommitReason  string             `db:"commit_reason"`
-	AddedFiles    []string           `db:"added_files"`
-	RemovedFiles  []string           `db:"removed_files"`
-	ModifiedFiles []string           `db:"modified_files"`
+	ID             uu.ID                   `db:"id"`
+	DocumentID     uu.ID                   `db:"document_id"`
+	CompanyID      uu.ID                   `db:"company_id"`
+	Version        docdb.VersionTime       `db:"version"`
+	PrevVersion    *docdb.VersionTime      `db:"prev_version"`
+	CommitUserID   uu.ID                   `db:"commit_user_id"`
+	CommitReason   string                  `db:"commit_reason"`
+	AddedFiles     []string                `db:"added_files"`
+	RemovedFiles   []string                `db:"removed_files"`
+	ModifiedFiles  []string                `db:"modified_files"`
+	IdempotencyKey nullable.NonEmptyString `db:"idempotency_key"`
 }
@@ -20,10 +20,11 @@ create table docdb.document_version (
     commit_user_id uuid not null default '08a34dc4-6e9a-4d61-b395-d123005e65d3', -- system-user ID for unknown user
     -- references public.user(id) on delete set default (only in prod, here the public schema is out of scope)
 
-    commit_reason  text not null,
-    added_files    text[],
-    removed_files  text[],
-    modified_files text[]
+    commit_reason   text not null,
+    added_files     text[],
+    removed_files   text[],
+    modified_files  text[],
+    idempotency_key text -- null if the version was committed without idempotency key
 );
 
 create index document_version_document_id_idx on docdb.document_version (document_id);
@@ -41,4 +42,11 @@ create unique index document_version_one_genesis_per_document_idx
     on docdb.document_version (document_id)
     where prev_version is null;
 
+-- A redelivered request with the same idempotency key must not create a second
+-- version of the document. Concurrent duplicates that both passed the lookup of
+-- an existing version are rejected by this index.
+create unique index document_version_idempotency_key_idx
+    on docdb.document_version (document_id, idempotency_key)
+    where idempotency_key is not null;
+
 comment on table docdb.document_version is 'Document version meta data';
\ No newline at end of file
@@ -0,0 +1,8 @@
+-- Adds the idempotency key to databases created before it was part of
+-- document_version.sql. Safe to run on new and already migrated databases.
+
+alter table docdb.document_version add column if not exists idempotency_key text;
+
+create unique index if not exists document_version_idempotency_key_idx
+    on docdb.document_version (document_id, idempotency_key)
+    where idempotency_key is not null;
@@ -24,8 +24,12 @@ type fakeMetadataStore struct {
 
 	latest *docdb.VersionInfo
 
-	addedVersion        docdb.VersionTime
-	deleteVersionCalled bool
+	addedVersion         docdb.VersionTime
+	addedFiles           []*docdb.FileInfo
+	modifiedFiles        []*docdb.FileInfo
+	removedFiles         []string
+	previousMustBeLatest bool
+	deleteVersionCalled  bool
 	// deletedVersion records the version passed to DeleteDocumentVersion, so a
 	// test can assert the genesis rollback targets exactly the version it
 	// created rather than wiping the whole document.
@@ -37,17 +41,31 @@ type fakeMetadataStore struct {
 	// DeleteDocumentVersion SQL returns: only hashes referenced solely by the
 	// version being deleted (hashes still shared with a sibling are excluded).
 	safeHashesToDelete []string
+	// idempotent is returned by IdempotentVersionInfo
+	// for the key it was committed with.
+	idempotent *docdb.VersionInfo
 }
 
 func (m *fakeMetadataStore) LatestDocumentVersionInfo(context.Context, uu.ID) (*docdb.VersionInfo, error) {
 	return m.latest, nil
 }
 
+func (m *fakeMetadataStore) IdempotentVersionInfo(_ context.Context, _ uu.ID, key string) (*docdb.VersionInfo, error) {
+	if m.idempotent == nil || m.idempotent.IdempotencyKey != key {
+		return nil, nil
+	}
+	return m.idempotent, nil
+}
+
 func (m *fakeMetadataStore) CreateDocumentVersion(_ context.Context, in storeconn.CreateDocumentVersionInput) (*docdb.VersionInfo, error) {
 	if m.createVersionErr != nil {
 		return nil, m.createVersionErr
 	}
 	m.addedVersion = in.NewVersion
+	m.addedFiles = in.AddedFiles
+	m.modifiedFiles = in.ModifiedFiles
+	m.removedFiles = in.RemovedFiles
+	m.previousMustBeLatest = in.PreviousMustBeLatest
 	return &docdb.VersionInfo{DocID: in.DocID, CompanyID: in.CompanyID, Version: in.NewVersion}, nil
 }
 
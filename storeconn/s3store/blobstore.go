@@ -0,0 +1,134 @@
+package s3store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb/storeconn"
+	"github.com/domonda/go-errs"
+)
+
+// BlobKeyPrefix is the prefix of the object keys of the content-addressed
+// layout of NewBlobStore. It separates them from the
+// "<docID>/<filename>/<hash>" keys of NewDocumentStore
+// so both layouts can share a bucket.
+const BlobKeyPrefix = "blobs/"
+
+// NewBlobStore returns a storeconn.BlobStore that stores file content
+// as objects in the given S3 bucket keyed only by content hash
+// in the form "blobs/<hash>", so identical content of any document
+// is stored once. Use it with storeconn.NewContentAddressed.
+// The bucket must already exist; this constructor does not create it.
+func NewBlobStore(bucketName string, s3Client *awss3.Client) storeconn.BlobStore {
+	return &blobStore{
+		objects: docStore{
+			client:     s3Client,
+			bucketName: bucketName,
+		},
+	}
+}
+
+// blobStore is the S3-backed implementation of storeconn.BlobStore.
+// It reuses the object helpers of docStore.
+type blobStore struct {
+	objects docStore
+}
+
+// WriteBlob streams the content of file as object with the key BlobKey(hash)
+// unless an object with that key already exists.
+func (s *blobStore) WriteBlob(ctx context.Context, hash string, file fs.FileReader) error {
+	key := BlobKey(hash)
+	_, err := s.objects.client.HeadObject(ctx, &awss3.HeadObjectInput{
+		Bucket: &s.objects.bucketName,
+		Key:    &key,
+	})
+	if err == nil {
+		return nil // Already stored
+	}
+	if _, ok := errors.AsType[*types.NotFound](err); !ok {
+		return err
+	}
+	return s.objects.putFile(ctx, key, file, file.Size())
+}
+
+// OpenBlob fetches the object with the key BlobKey(hash) and returns
+// a reader streaming the GetObject response body that also implements
+// io.Seeker by requesting the object with a Range header.
+// The caller must close the returned io.ReadCloser.
+// Returns an error matching os.ErrNotExist if no such object exists.
+func (s *blobStore) OpenBlob(ctx context.Context, hash string) (reader io.ReadCloser, err error) {
+	reader, err = openObject(ctx, s.objects.client, s.objects.bucketName, BlobKey(hash))
+	if err != nil {
+		if _, ok := errors.AsType[*types.NoSuchKey](err); ok {
+			return nil, errs.Errorf("blob %s: %w", hash, os.ErrNotExist)
+		}
+		return nil, err
+	}
+	return reader, nil
+}
+
+// ReadBlobRange fetches up to length bytes starting at offset
+// of the object with the key BlobKey(hash)
+// with a GetObject request with a Range header.
+// Returns an error matching os.ErrNotExist if no such object exists.
+func (s *blobStore) ReadBlobRange(ctx context.Context, hash string, offset, length int64) (data []byte, err error) {
+	data, err = readObjectRange(ctx, s.objects.client, s.objects.bucketName, BlobKey(hash), offset, length)
+	if err != nil {
+		if _, ok := errors.AsType[*types.NoSuchKey](err); ok {
+			return nil, errs.Errorf("blob %s: %w", hash, os.ErrNotExist)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// ListBlobs lists the objects under BlobKeyPrefix page by page
+// and calls onHashes with the hashes of every page of up to 1000 objects.
+func (s *blobStore) ListBlobs(ctx context.Context, onHashes func(ctx context.Context, hashes []string) error) error {
+	paginator := awss3.NewListObjectsV2Paginator(s.objects.client, &awss3.ListObjectsV2Input{
+		Bucket: &s.objects.bucketName,
+		Prefix: new(BlobKeyPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+		hashes := make([]string, len(page.Contents))
+		for i, obj := range page.Contents {
+			hashes[i] = strings.TrimPrefix(aws.ToString(obj.Key), BlobKeyPrefix)
+		}
+		err = onHashes(ctx, hashes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteBlobs deletes the objects with the keys BlobKey(hash) of the passed
+// hashes in batches. S3 does not report keys that don't exist as failure.
+func (s *blobStore) DeleteBlobs(ctx context.Context, hashes []string) error {
+	keys := make([]string, len(hashes))
+	for i, hash := range hashes {
+		keys[i] = BlobKey(hash)
+	}
+	return s.objects.deleteObjectKeys(ctx, keys)
+}
+
+// BlobKey returns the S3 object key used by NewBlobStore
+// for content in the form "blobs/<hash>".
+func BlobKey(hash string) string {
+	return BlobKeyPrefix + hash
+}
@@ -0,0 +1,132 @@
+package s3store_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/storeconn/s3store"
+	"github.com/domonda/go-docdb/storeconn/s3store/s3fixtures"
+	"github.com/domonda/go-types/uu"
+)
+
+func TestBlobStore(t *testing.T) {
+	content := []byte("blob content")
+	hash := docdb.ContentHash(content)
+
+	t.Run("Writes and opens content by hash", func(t *testing.T) {
+		// given
+		blobs := s3store.NewBlobStore(s3fixtures.FixtureCleanBucket(t), s3fixtures.FixtureGlobalS3Client(t))
+
+		// when
+		err := blobs.WriteBlob(t.Context(), hash, fs.NewMemFile("a.txt", content))
+
+		// then
+		require.NoError(t, err)
+		reader, err := blobs.OpenBlob(t.Context(), hash)
+		require.NoError(t, err)
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, content, data)
+	})
+
+	t.Run("Reads a range of content by hash", func(t *testing.T) {
+		// given
+		blobs := s3store.NewBlobStore(s3fixtures.FixtureCleanBucket(t), s3fixtures.FixtureGlobalS3Client(t))
+		require.NoError(t, blobs.WriteBlob(t.Context(), hash, fs.NewMemFile("a.txt", content)))
+
+		// when
+		data, err := blobs.ReadBlobRange(t.Context(), hash, 5, 7)
+
+		// then
+		require.NoError(t, err)
+		require.Equal(t, []byte("content"), data)
+		_, err = blobs.ReadBlobRange(t.Context(), docdb.ContentHash([]byte("missing")), 0, 1)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("Does not overwrite existing content", func(t *testing.T) {
+		// given
+		blobs := s3store.NewBlobStore(s3fixtures.FixtureCleanBucket(t), s3fixtures.FixtureGlobalS3Client(t))
+		require.NoError(t, blobs.WriteBlob(t.Context(), hash, fs.NewMemFile("a.txt", content)))
+
+		// when
+		err := blobs.WriteBlob(t.Context(), hash, fs.NewMemFile("b.txt", []byte("not written")))
+
+		// then
+		require.NoError(t, err)
+		reader, err := blobs.OpenBlob(t.Context(), hash)
+		require.NoError(t, err)
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, content, data)
+	})
+
+	t.Run("Returns os.ErrNotExist for missing content", func(t *testing.T) {
+		// given
+		blobs := s3store.NewBlobStore(s3fixtures.FixtureCleanBucket(t), s3fixtures.FixtureGlobalS3Client(t))
+
+		// when
+		_, err := blobs.OpenBlob(t.Context(), hash)
+
+		// then
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("Deletes content and ignores missing hashes", func(t *testing.T) {
+		// given
+		blobs := s3store.NewBlobStore(s3fixtures.FixtureCleanBucket(t), s3fixtures.FixtureGlobalS3Client(t))
+		require.NoError(t, blobs.WriteBlob(t.Context(), hash, fs.NewMemFile("a.txt", content)))
+
+		// when
+		err := blobs.DeleteBlobs(t.Context(), []string{hash, docdb.ContentHash([]byte("missing"))})
+
+		// then
+		require.NoError(t, err)
+		_, err = blobs.OpenBlob(t.Context(), hash)
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("Lists the hashes of all content", func(t *testing.T) {
+		// given
+		blobs := s3store.NewBlobStore(s3fixtures.FixtureCleanBucket(t), s3fixtures.FixtureGlobalS3Client(t))
+		otherHash := docdb.ContentHash([]byte("other"))
+		require.NoError(t, blobs.WriteBlob(t.Context(), hash, fs.NewMemFile("a.txt", content)))
+		require.NoError(t, blobs.WriteBlob(t.Context(), otherHash, fs.NewMemFile("b.txt", []byte("other"))))
+		s3fixtures.FixtureCreateDocument(t)(uu.IDv7(), "doc.pdf", content) // not listed
+
+		// when
+		var listed []string
+		err := blobs.ListBlobs(t.Context(), func(_ context.Context, hashes []string) error {
+			listed = append(listed, hashes...)
+			return nil
+		})
+
+		// then
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{hash, otherHash}, listed)
+	})
+
+	t.Run("Blobs are skipped by CollectGarbage", func(t *testing.T) {
+		// given
+		blobs := s3store.NewBlobStore(s3fixtures.FixtureCleanBucket(t), s3fixtures.FixtureGlobalS3Client(t))
+		require.NoError(t, blobs.WriteBlob(t.Context(), hash, fs.NewMemFile("a.txt", content)))
+
+		// when
+		report, err := s3store.CollectGarbage(t.Context(), s3fixtures.FixtureBucketName(t), s3fixtures.FixtureGlobalS3Client(t), &hashesMetadataStore{}, 0, false)
+
+		// then
+		require.NoError(t, err)
+		require.Zero(t, report.NumObjects)
+		require.Empty(t, report.UnknownKeys)
+		_, err = blobs.OpenBlob(t.Context(), hash)
+		require.NoError(t, err)
+	})
+}
@@ -4,7 +4,6 @@
 package s3store
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -62,6 +61,11 @@ func (s *docStore) DocumentExists(ctx context.Context, docID uu.ID) (exists bool
 // are rejected because "/" is the key separator. The version argument is
 // accepted for interface compatibility but not persisted at this layer;
 // version tracking is the MetadataStore's responsibility.
+//
+// File content is never buffered completely in memory: every file is read
+// twice, first to stream it through the content hasher that determines the
+// object key, then to stream it as the PutObject body. The files must not
+// change while CreateDocumentVersion is running.
 func (s *docStore) CreateDocumentVersion(ctx context.Context, docID uu.ID, version docdb.VersionTime, files []fs.FileReader) ([]*docdb.FileInfo, error) {
 	fileInfos := make([]*docdb.FileInfo, len(files))
 	for i, file := range files {
@@ -69,28 +73,42 @@ func (s *docStore) CreateDocumentVersion(ctx context.Context, docID uu.ID, versi
 			return nil, fmt.Errorf("filename '%s' contains '/'", file.Name())
 		}
 
-		data, err := file.ReadAll()
+		fileInfo, err := docdb.ReadFileInfo(ctx, file)
 		if err != nil {
 			return nil, err
 		}
-		hash := docdb.ContentHash(data)
-		_, err = s.client.PutObject(
-			ctx,
-			&awss3.PutObjectInput{
-				Bucket: &s.bucketName,
-				Key:    new(Key(docID, file.Name(), hash)),
-				Body:   bytes.NewReader(data),
-			},
-		)
+		err = s.putFile(ctx, Key(docID, file.Name(), fileInfo.Hash), file, fileInfo.Size)
 		if err != nil {
 			return nil, err
 		}
-		fileInfos[i] = &docdb.FileInfo{Name: file.Name(), Size: file.Size(), Hash: hash}
+		fileInfos[i] = &fileInfo
 	}
 
 	return fileInfos, nil
 }
 
+// putFile streams the content of file as object with the passed key.
+// The body is opened as io.ReadSeeker so the SDK can compute
+// payload checksums and retry requests without buffering the content.
+func (s *docStore) putFile(ctx context.Context, key string, file fs.FileReader, size int64) error {
+	body, err := file.OpenReadSeeker()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	_, err = s.client.PutObject(
+		ctx,
+		&awss3.PutObjectInput{
+			Bucket:        &s.bucketName,
+			Key:           new(key),
+			Body:          body,
+			ContentLength: new(size),
+		},
+	)
+	return err
+}
+
 // DocumentHashFileProvider lists all objects under the docID prefix, filters
 // them by the passed content hashes, and returns a FileProvider over the
 // matching keys. If hashes is empty an emptyFileProvider is returned.
@@ -116,22 +134,45 @@ func (s *docStore) DocumentHashFileProvider(ctx context.Context, docID uu.ID, ha
 // "<docID>/<filename>/<hash>" and returns its full content.
 // Returns docdb.ErrDocumentFileNotFound if no such object exists.
 func (s *docStore) ReadDocumentHashFile(ctx context.Context, docID uu.ID, filename, hash string) (data []byte, err error) {
-	res, err := s.client.GetObject(
-		ctx,
-		&awss3.GetObjectInput{
-			Bucket: new(s.bucketName),
-			Key:    new(Key(docID, filename, hash)),
-		},
-	)
+	body, err := s.OpenDocumentHashFile(ctx, docID, filename, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+// OpenDocumentHashFile fetches the single object at key
+// "<docID>/<filename>/<hash>" and returns a reader streaming the
+// GetObject response body. The reader also implements io.Seeker,
+// after seeking the rest of the object is requested with a Range header.
+// The caller must close the returned io.ReadCloser.
+// Returns docdb.ErrDocumentFileNotFound if no such object exists.
+func (s *docStore) OpenDocumentHashFile(ctx context.Context, docID uu.ID, filename, hash string) (reader io.ReadCloser, err error) {
+	reader, err = openObject(ctx, s.client, s.bucketName, Key(docID, filename, hash))
 	if err != nil {
 		if _, ok := errors.AsType[*types.NoSuchKey](err); ok {
 			return nil, docdb.NewErrDocumentFileNotFound(docID, filename)
 		}
 		return nil, err
 	}
-	defer res.Body.Close()
+	return reader, nil
+}
 
-	return io.ReadAll(res.Body)
+// ReadDocumentHashFileRange fetches up to length bytes starting at offset
+// of the object at key "<docID>/<filename>/<hash>"
+// with a GetObject request with a Range header.
+// Returns docdb.ErrDocumentFileNotFound if no such object exists.
+func (s *docStore) ReadDocumentHashFileRange(ctx context.Context, docID uu.ID, filename, hash string, offset, length int64) (data []byte, err error) {
+	data, err = readObjectRange(ctx, s.client, s.bucketName, Key(docID, filename, hash), offset, length)
+	if err != nil {
+		if _, ok := errors.AsType[*types.NoSuchKey](err); ok {
+			return nil, docdb.NewErrDocumentFileNotFound(docID, filename)
+		}
+		return nil, err
+	}
+	return data, nil
 }
 
 // DeleteDocument removes every object under the docID prefix.
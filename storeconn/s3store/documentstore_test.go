@@ -3,6 +3,7 @@ package s3store_test
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -286,6 +287,86 @@ func TestReadDocumentHashFile(t *testing.T) {
 	})
 }
 
+func TestOpenDocumentHashFileSeek(t *testing.T) {
+	// given
+	docID := uu.IDv7()
+	documentStore := s3fixtures.FixtureGlobalDocumentStore(t)
+	filename := "doc1.pdf"
+	content := []byte("0123456789")
+	createDocument := s3fixtures.FixtureCreateDocument(t)
+	createDocument(docID, filename, content)
+
+	// when
+	reader, err := documentStore.OpenDocumentHashFile(t.Context(), docID, filename, docdb.ContentHash(content))
+	require.NoError(t, err)
+	t.Cleanup(func() { reader.Close() })
+	seeker, ok := reader.(io.ReadSeeker)
+
+	// then
+	require.True(t, ok, "reader implements io.Seeker")
+	size, err := seeker.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(content)), size)
+
+	_, err = seeker.Seek(7, io.SeekStart)
+	require.NoError(t, err)
+	rest, err := io.ReadAll(seeker)
+	require.NoError(t, err)
+	require.Equal(t, []byte("789"), rest)
+
+	_, err = seeker.Seek(2, io.SeekStart)
+	require.NoError(t, err)
+	part := make([]byte, 3)
+	_, err = io.ReadFull(seeker, part)
+	require.NoError(t, err)
+	require.Equal(t, []byte("234"), part)
+}
+
+func TestReadDocumentHashFileRange(t *testing.T) {
+	t.Run("Returns the range of the file contents", func(t *testing.T) {
+		// given
+		docID := uu.IDv7()
+		documentStore := s3fixtures.FixtureGlobalDocumentStore(t)
+		filename := "doc1.pdf"
+		content := []byte("0123456789")
+		createDocument := s3fixtures.FixtureCreateDocument(t)
+		createDocument(docID, filename, content)
+		hash := docdb.ContentHash(content)
+
+		for _, tt := range []struct {
+			offset, length int64
+			want           string
+		}{
+			{2, 3, "234"},
+			{6, 10, "6789"},
+			{7, -1, "789"},
+			{4, 0, ""},
+			{10, 2, ""},
+		} {
+			// when
+			data, err := documentStore.ReadDocumentHashFileRange(t.Context(), docID, filename, hash, tt.offset, tt.length)
+
+			// then
+			require.NoError(t, err)
+			require.Equal(t, tt.want, string(data))
+		}
+	})
+
+	t.Run("Returns ErrDocumentFileNotFound if file does not exist", func(t *testing.T) {
+		// given
+		s3fixtures.FixtureCleanBucket(t)
+		documentStore := s3fixtures.FixtureGlobalDocumentStore(t)
+		docID := uu.IDv7()
+		filename := "doc1.pdf"
+
+		// when
+		_, err := documentStore.ReadDocumentHashFileRange(t.Context(), docID, filename, "hash", 0, 1)
+
+		// then
+		require.ErrorIs(t, err, docdb.NewErrDocumentFileNotFound(docID, filename))
+	})
+}
+
 func TestDeleteDocument(t *testing.T) {
 	t.Run("Deletes all objects belonging to a document", func(t *testing.T) {
 		// given
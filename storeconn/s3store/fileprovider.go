@@ -62,27 +62,33 @@ func (p *fileProvider) ListFiles(ctx context.Context) (filenames []string, err e
 // full contents. Returns docdb.ErrDocumentFileNotFound if no key matches
 // the filename, or if S3 reports NoSuchKey for the resolved object.
 func (p *fileProvider) ReadFile(ctx context.Context, filename string) ([]byte, error) {
+	body, err := p.OpenFile(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+// OpenFile fetches the object matching the passed filename and returns a
+// seekable reader like docStore.OpenDocumentHashFile. The caller must close the
+// returned io.ReadCloser. Returns docdb.ErrDocumentFileNotFound if no key
+// matches the filename, or if S3 reports NoSuchKey for the resolved object.
+func (p *fileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
 	key := p.findKey(filename)
 	if key == "" {
 		return nil, docdb.NewErrDocumentFileNotFound(p.docID, filename)
 	}
 
-	resp, err := p.client.GetObject(
-		ctx,
-		&awss3.GetObjectInput{
-			Bucket: &p.bucketName,
-			Key:    new(key),
-		},
-	)
+	reader, err := openObject(ctx, p.client, p.bucketName, key)
 	if err != nil {
 		if _, ok := errors.AsType[*types.NoSuchKey](err); ok {
 			return nil, docdb.NewErrDocumentFileNotFound(p.docID, filename)
 		}
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	return io.ReadAll(resp.Body)
+	return reader, nil
 }
 
 // findKey returns the first key whose filename component matches, or ""
@@ -129,3 +135,8 @@ func (p *emptyFileProvider) ListFiles(ctx context.Context) (filenames []string,
 func (p *emptyFileProvider) ReadFile(ctx context.Context, filename string) ([]byte, error) {
 	return nil, docdb.NewErrDocumentFileNotFound(p.docID, filename)
 }
+
+// OpenFile always returns docdb.ErrDocumentFileNotFound for the provider's docID.
+func (p *emptyFileProvider) OpenFile(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return nil, docdb.NewErrDocumentFileNotFound(p.docID, filename)
+}
@@ -0,0 +1,215 @@
+package s3store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/domonda/go-docdb/storeconn"
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+)
+
+// DefaultGarbageGracePeriod is a grace period for CollectGarbage
+// that is far longer than any document write takes.
+const DefaultGarbageGracePeriod = 24 * time.Hour
+
+// GarbageObject is an object in the bucket whose content hash
+// is not referenced by any version of its document.
+type GarbageObject struct {
+	Key          string
+	DocID        uu.ID
+	Filename     string
+	Hash         string
+	Size         int64
+	LastModified time.Time
+}
+
+// GarbageReport is the result of CollectGarbage.
+type GarbageReport struct {
+	// DryRun is true if the Orphans were only reported but not deleted.
+	DryRun bool
+	// NumObjects is the number of listed objects in the bucket
+	// without the objects of the content-addressed layout.
+	NumObjects int
+	// Orphans are the unreferenced objects older than the grace period.
+	// They are deleted unless DryRun is true.
+	Orphans []GarbageObject
+	// NumRecent is the number of unreferenced objects that were kept
+	// because they were modified within the grace period
+	// and may belong to a write that is still in progress.
+	NumRecent int
+	// UnknownKeys are keys not in the form "<docID>/<filename>/<hash>".
+	// They were not written by this package and are never deleted.
+	UnknownKeys []string
+}
+
+// OrphanBytes returns the summed size of all Orphans.
+func (r *GarbageReport) OrphanBytes() (size int64) {
+	for _, orphan := range r.Orphans {
+		size += orphan.Size
+	}
+	return size
+}
+
+func (r *GarbageReport) String() string {
+	var b strings.Builder
+	action := "deleted"
+	if r.DryRun {
+		action = "would delete"
+	}
+	fmt.Fprintf(&b, "%d objects, %s %d orphans with %d bytes, kept %d recent orphans, %d unknown keys",
+		r.NumObjects, action, len(r.Orphans), r.OrphanBytes(), r.NumRecent, len(r.UnknownKeys))
+	for _, orphan := range r.Orphans {
+		fmt.Fprintf(&b, "\n  %s (%d bytes, modified %s)", orphan.Key, orphan.Size, orphan.LastModified.Format(time.RFC3339))
+	}
+	return b.String()
+}
+
+// CollectGarbage lists all objects of the bucket and deletes the objects
+// whose content hash is not referenced by any version of their document
+// according to metadataStore.DocumentHashes. Such objects are left behind
+// when a process crashes between writing the blobs and the metadata
+// of a document version or when the rollback of a failed write fails.
+//
+// Only objects last modified before now minus gracePeriod are deleted,
+// because the blobs of a new document are written before its metadata
+// and must not be collected while the write is still in progress.
+// The grace period must be longer than the longest document write.
+// Objects that are uploaded again by a new version after they were listed
+// can still be deleted, so a short grace period is only safe
+// while no documents are written.
+//
+// An object with a content hash that is referenced by its document
+// under another filename is kept. Keys that are not in the form
+// "<docID>/<filename>/<hash>" are reported but never deleted.
+// Objects of the content-addressed layout of NewBlobStore under
+// BlobKeyPrefix are skipped, use storeconn.DeleteUnreferencedBlobs for them.
+//
+// If dryRun is true, nothing is deleted and the returned report
+// lists the objects that would have been deleted.
+//
+// The bucket is listed page by page and the objects of every document
+// are checked after they were listed completely, so only the objects
+// of a single document are held in memory besides the report.
+func CollectGarbage(ctx context.Context, bucketName string, s3Client *awss3.Client, metadataStore storeconn.MetadataStore, gracePeriod time.Duration, dryRun bool) (report *GarbageReport, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, bucketName, gracePeriod, dryRun)
+
+	if gracePeriod < 0 {
+		return nil, errs.Errorf("negative grace period %s", gracePeriod)
+	}
+
+	var (
+		s          = &docStore{client: s3Client, bucketName: bucketName}
+		cutoff     = time.Now().Add(-gracePeriod)
+		docID      uu.ID
+		docObjects []GarbageObject
+	)
+	report = &GarbageReport{DryRun: dryRun}
+
+	collectDocument := func() error {
+		if len(docObjects) == 0 {
+			return nil
+		}
+		// Query the referenced hashes after listing the objects,
+		// so that every listed object of a committed version is referenced
+		hashes, err := metadataStore.DocumentHashes(ctx, docID)
+		if err != nil {
+			return err
+		}
+		orphans, numRecent := unreferencedObjects(docObjects, hashes, cutoff)
+		report.NumRecent += numRecent
+		report.Orphans = append(report.Orphans, orphans...)
+		docObjects = docObjects[:0]
+		if dryRun || len(orphans) == 0 {
+			return nil
+		}
+		keys := make([]string, len(orphans))
+		for i, orphan := range orphans {
+			keys[i] = orphan.Key
+		}
+		return s.deleteObjectKeys(ctx, keys)
+	}
+
+	paginator := awss3.NewListObjectsV2Paginator(s3Client, &awss3.ListObjectsV2Input{
+		Bucket: &bucketName,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return report, err
+		}
+		for _, obj := range page.Contents {
+			if strings.HasPrefix(aws.ToString(obj.Key), BlobKeyPrefix) {
+				continue
+			}
+			report.NumObjects++
+			object, ok := garbageObjectFromListed(obj)
+			if !ok {
+				report.UnknownKeys = append(report.UnknownKeys, aws.ToString(obj.Key))
+				continue
+			}
+			// Keys are listed in lexicographical order,
+			// so the objects of a document are listed together
+			if object.DocID != docID {
+				err = collectDocument()
+				if err != nil {
+					return report, err
+				}
+				docID = object.DocID
+			}
+			docObjects = append(docObjects, object)
+		}
+	}
+	err = collectDocument()
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// garbageObjectFromListed parses the key of a listed object
+// in the form "<docID>/<filename>/<hash>".
+func garbageObjectFromListed(obj types.Object) (object GarbageObject, ok bool) {
+	object.Key = aws.ToString(obj.Key)
+	parts := strings.Split(object.Key, "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return GarbageObject{}, false
+	}
+	docID, err := uu.IDFromString(parts[0])
+	if err != nil {
+		return GarbageObject{}, false
+	}
+	object.DocID = docID
+	object.Filename = parts[1]
+	object.Hash = parts[2]
+	object.Size = aws.ToInt64(obj.Size)
+	object.LastModified = aws.ToTime(obj.LastModified)
+	return object, true
+}
+
+// unreferencedObjects returns the objects whose hash is not in hashes
+// and that were last modified before cutoff, and the number
+// of unreferenced objects modified after cutoff.
+func unreferencedObjects(objects []GarbageObject, hashes []string, cutoff time.Time) (orphans []GarbageObject, numRecent int) {
+	hashSet := make(map[string]struct{}, len(hashes))
+	for _, hash := range hashes {
+		hashSet[hash] = struct{}{}
+	}
+	for _, object := range objects {
+		if _, ok := hashSet[object.Hash]; ok {
+			continue
+		}
+		if object.LastModified.After(cutoff) {
+			numRecent++
+			continue
+		}
+		orphans = append(orphans, object)
+	}
+	return orphans, numRecent
+}
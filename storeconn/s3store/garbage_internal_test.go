@@ -0,0 +1,71 @@
+package s3store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/domonda/go-types/uu"
+)
+
+// TestGarbageObjectFromListed is a pure unit test for the key parsing
+// of CollectGarbage. It needs no S3 backend.
+func TestGarbageObjectFromListed(t *testing.T) {
+	docID := uu.IDFrom("c538ac93-2cf0-49a9-8378-22cd48b5ab84")
+	modified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Parses keys in the form <docID>/<filename>/<hash>", func(t *testing.T) {
+		got, ok := garbageObjectFromListed(types.Object{
+			Key:          new(Key(docID, "doc.pdf", "h1")),
+			Size:         new(int64(42)),
+			LastModified: &modified,
+		})
+		require.True(t, ok)
+		require.Equal(t, GarbageObject{
+			Key:          docID.String() + "/doc.pdf/h1",
+			DocID:        docID,
+			Filename:     "doc.pdf",
+			Hash:         "h1",
+			Size:         42,
+			LastModified: modified,
+		}, got)
+	})
+
+	for _, key := range []string{
+		"no-uuid/doc.pdf/h1",
+		docID.String() + "/doc.pdf",
+		docID.String() + "/doc.pdf/h1/extra",
+		docID.String() + "//h1",
+		docID.String() + "/doc.pdf/",
+	} {
+		t.Run("Rejects "+key, func(t *testing.T) {
+			_, ok := garbageObjectFromListed(types.Object{Key: new(key)})
+			require.False(t, ok)
+		})
+	}
+}
+
+// TestUnreferencedObjects is a pure unit test for the orphan
+// classification of CollectGarbage. It needs no S3 backend.
+func TestUnreferencedObjects(t *testing.T) {
+	cutoff := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	old := cutoff.Add(-time.Hour)
+	recent := cutoff.Add(time.Hour)
+	objects := []GarbageObject{
+		{Key: "referenced", Hash: "h1", LastModified: old},
+		{Key: "old-orphan", Hash: "h2", LastModified: old},
+		{Key: "recent-orphan", Hash: "h3", LastModified: recent},
+		{Key: "referenced-other-name", Hash: "h1", LastModified: old},
+		{Key: "cutoff-orphan", Hash: "h4", LastModified: cutoff},
+	}
+
+	orphans, numRecent := unreferencedObjects(objects, []string{"h1"}, cutoff)
+	require.Equal(t, []GarbageObject{objects[1], objects[4]}, orphans)
+	require.Equal(t, 1, numRecent)
+
+	orphans, numRecent = unreferencedObjects(objects, nil, cutoff)
+	require.Len(t, orphans, 4)
+	require.Equal(t, 1, numRecent)
+}
@@ -0,0 +1,107 @@
+package s3store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/domonda/go-docdb"
+	"github.com/domonda/go-docdb/storeconn"
+	"github.com/domonda/go-docdb/storeconn/s3store"
+	"github.com/domonda/go-docdb/storeconn/s3store/s3fixtures"
+	"github.com/domonda/go-types/uu"
+)
+
+// hashesMetadataStore implements only MetadataStore.DocumentHashes,
+// any other method panics if called.
+type hashesMetadataStore struct {
+	storeconn.MetadataStore
+
+	hashes map[uu.ID][]string
+}
+
+func (m *hashesMetadataStore) DocumentHashes(_ context.Context, docID uu.ID) ([]string, error) {
+	return m.hashes[docID], nil
+}
+
+func TestCollectGarbage(t *testing.T) {
+	var (
+		committedContent = []byte("committed")
+		orphanContent    = []byte("orphan")
+		committedHash    = docdb.ContentHash(committedContent)
+		orphanHash       = docdb.ContentHash(orphanContent)
+	)
+
+	t.Run("Dry run reports unreferenced objects without deleting them", func(t *testing.T) {
+		// given
+		createDocument := s3fixtures.FixtureCreateDocument(t)
+		exists := s3fixtures.FixtureObjectExists(t)
+		docID := uu.IDv7()
+		crashedDocID := uu.IDv7() // blobs written, metadata never committed
+		createDocument(docID, "doc.pdf", committedContent)
+		createDocument(docID, "doc.pdf", orphanContent)
+		createDocument(crashedDocID, "doc.pdf", orphanContent)
+		metadataStore := &hashesMetadataStore{hashes: map[uu.ID][]string{docID: {committedHash}}}
+
+		// when
+		report, err := s3store.CollectGarbage(t.Context(), s3fixtures.FixtureBucketName(t), s3fixtures.FixtureGlobalS3Client(t), metadataStore, 0, true)
+
+		// then
+		require.NoError(t, err)
+		require.True(t, report.DryRun)
+		require.Equal(t, 3, report.NumObjects)
+		require.Len(t, report.Orphans, 2)
+		require.Equal(t, int64(2*len(orphanContent)), report.OrphanBytes())
+		require.True(t, exists(docID, "doc.pdf", orphanHash))
+		require.True(t, exists(crashedDocID, "doc.pdf", orphanHash))
+	})
+
+	t.Run("Deletes unreferenced objects older than the grace period", func(t *testing.T) {
+		// given
+		createDocument := s3fixtures.FixtureCreateDocument(t)
+		exists := s3fixtures.FixtureObjectExists(t)
+		docID := uu.IDv7()
+		crashedDocID := uu.IDv7()
+		createDocument(docID, "doc.pdf", committedContent)
+		createDocument(docID, "renamed.pdf", committedContent) // hash referenced under another name
+		createDocument(docID, "doc.pdf", orphanContent)
+		createDocument(crashedDocID, "doc.pdf", orphanContent)
+		metadataStore := &hashesMetadataStore{hashes: map[uu.ID][]string{docID: {committedHash}}}
+
+		// when
+		report, err := s3store.CollectGarbage(t.Context(), s3fixtures.FixtureBucketName(t), s3fixtures.FixtureGlobalS3Client(t), metadataStore, 0, false)
+
+		// then
+		require.NoError(t, err)
+		require.False(t, report.DryRun)
+		require.Len(t, report.Orphans, 2)
+		require.True(t, exists(docID, "doc.pdf", committedHash))
+		require.True(t, exists(docID, "renamed.pdf", committedHash))
+		require.False(t, exists(docID, "doc.pdf", orphanHash))
+		require.False(t, exists(crashedDocID, "doc.pdf", orphanHash))
+	})
+
+	t.Run("Keeps unreferenced objects within the grace period", func(t *testing.T) {
+		// given
+		createDocument := s3fixtures.FixtureCreateDocument(t)
+		exists := s3fixtures.FixtureObjectExists(t)
+		docID := uu.IDv7()
+		createDocument(docID, "doc.pdf", orphanContent)
+
+		// when
+		report, err := s3store.CollectGarbage(t.Context(), s3fixtures.FixtureBucketName(t), s3fixtures.FixtureGlobalS3Client(t), &hashesMetadataStore{}, time.Hour, false)
+
+		// then
+		require.NoError(t, err)
+		require.Empty(t, report.Orphans)
+		require.Equal(t, 1, report.NumRecent)
+		require.True(t, exists(docID, "doc.pdf", orphanHash))
+	})
+
+	t.Run("Returns error for negative grace period", func(t *testing.T) {
+		_, err := s3store.CollectGarbage(t.Context(), "bucket", nil, &hashesMetadataStore{}, -time.Second, true)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,145 @@
+package s3store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+
+	"github.com/domonda/go-docdb"
+)
+
+// objectReader is the io.ReadSeekCloser returned for streaming reads of objects.
+//
+// It reads the body of the GetObject response of openObject until it is
+// seeked to another offset. Then the body is closed and the next Read
+// requests the object from the new offset with a Range header,
+// so the skipped bytes are not downloaded.
+type objectReader struct {
+	ctx    context.Context
+	client *awss3.Client
+	bucket string
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+// openObject fetches the object with key and returns an objectReader for it.
+// Errors of GetObject are returned unchanged.
+func openObject(ctx context.Context, client *awss3.Client, bucket, key string) (*objectReader, error) {
+	res, err := client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	size := int64(-1)
+	if res.ContentLength != nil {
+		size = *res.ContentLength
+	}
+	return &objectReader{
+		ctx:    ctx,
+		client: client,
+		bucket: bucket,
+		key:    key,
+		size:   size,
+		body:   res.Body,
+	}, nil
+}
+
+func (r *objectReader) Read(p []byte) (n int, err error) {
+	if r.body == nil {
+		if r.size >= 0 && r.offset >= r.size {
+			return 0, io.EOF
+		}
+		res, err := r.client.GetObject(r.ctx, &awss3.GetObjectInput{
+			Bucket: &r.bucket,
+			Key:    &r.key,
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", r.offset)),
+		})
+		if err != nil {
+			return 0, err
+		}
+		r.body = res.Body
+	}
+	n, err = r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		if r.size < 0 {
+			return 0, errors.New("seek from end of S3 object with unknown size")
+		}
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to negative offset")
+	}
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *objectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// readObjectRange reads up to length bytes of the object with key
+// starting at offset with a single GetObject request with a Range header.
+// A negative length reads until the end of the object and
+// an offset at or after the end of the object returns no data.
+// Errors of GetObject are returned unchanged.
+func readObjectRange(ctx context.Context, client *awss3.Client, bucket, key string, offset, length int64) ([]byte, error) {
+	if offset < 0 {
+		return nil, docdb.ErrNegativeOffset
+	}
+	var byteRange string
+	switch {
+	case length < 0 || length > math.MaxInt64-offset:
+		byteRange = fmt.Sprintf("bytes=%d-", offset)
+	case length == 0:
+		// A range can't be empty, request one byte
+		// to check that the object exists and discard it
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset)
+	default:
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	res, err := client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+		Range:  &byteRange,
+	})
+	if err != nil {
+		if apiErr, ok := errors.AsType[smithy.APIError](err); ok && apiErr.ErrorCode() == "InvalidRange" {
+			// The object exists but ends before offset
+			return []byte{}, nil
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if length == 0 {
+		return []byte{}, nil
+	}
+	return io.ReadAll(res.Body)
+}
@@ -0,0 +1,328 @@
+package docdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/domonda/go-errs"
+	"github.com/domonda/go-types/uu"
+)
+
+// VerifyProblem describes an inconsistency of a document found by VerifyDocument.
+type VerifyProblem struct {
+	// Version is the affected version or zero for problems
+	// that concern the document as a whole.
+	Version VersionTime
+	// Filename is the affected file or empty.
+	Filename string
+	// Problem describes the inconsistency.
+	Problem string
+}
+
+func (p VerifyProblem) String() string {
+	var b strings.Builder
+	if !p.Version.Time.IsZero() {
+		fmt.Fprintf(&b, "version %s: ", p.Version)
+	}
+	if p.Filename != "" {
+		fmt.Fprintf(&b, "file %q: ", p.Filename)
+	}
+	b.WriteString(p.Problem)
+	return b.String()
+}
+
+// VerifyReport is the result of verifying a single document.
+type VerifyReport struct {
+	DocID uu.ID
+	// CompanyID is the company returned by Conn.DocumentCompanyID
+	// or uu.IDNil if it could not be read.
+	CompanyID uu.ID
+	// Versions holds the versions returned by Conn.DocumentVersions.
+	Versions []VersionTime
+	// Problems holds all found inconsistencies, empty if the document is intact.
+	Problems []VerifyProblem
+}
+
+// OK returns true if no problems were found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerifyReport) String() string {
+	if r.OK() {
+		return fmt.Sprintf("document %s: OK", r.DocID)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "document %s: %d problems", r.DocID, len(r.Problems))
+	for _, p := range r.Problems {
+		b.WriteString("\n  ")
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+func (r *VerifyReport) addProblem(version VersionTime, filename, format string, args ...any) {
+	r.Problems = append(r.Problems, VerifyProblem{
+		Version:  version,
+		Filename: filename,
+		Problem:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Verify checks all documents of all companies of conn
+// by calling VerifyCompany for every company returned by conn.CompanyIDs.
+// Documents that are not listed under any company can't be found
+// through the Conn interface and are not checked.
+//
+// If onProgress is not nil it is called before verifying each document
+// with the document's zero-based index and the total number
+// of documents of the company.
+//
+// The returned reports contain an entry for every verified document,
+// use VerifyReport.OK to filter for documents with problems.
+func Verify(ctx context.Context, conn Conn, onProgress DocProgressCallback) (reports []*VerifyReport, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, conn, onProgress)
+
+	companyIDs, err := conn.CompanyIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, companyID := range companyIDs {
+		companyReports, err := VerifyCompany(ctx, conn, companyID, onProgress)
+		reports = append(reports, companyReports...)
+		if err != nil {
+			return reports, err
+		}
+	}
+	return reports, nil
+}
+
+// VerifyCompany checks all documents returned by conn.CompanyDocumentIDs
+// for companyID with VerifyDocument and additionally checks
+// that conn.DocumentCompanyID of every document returns companyID,
+// so the company-to-document mapping agrees with the document's company.
+//
+// If onProgress is not nil it is called before verifying each document
+// with the document's zero-based index and the total number of documents.
+//
+// Problems are returned as part of the reports, an error is only
+// returned if the documents of the company can't be listed or ctx is done.
+func VerifyCompany(ctx context.Context, conn Conn, companyID uu.ID, onProgress DocProgressCallback) (reports []*VerifyReport, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, conn, companyID, onProgress)
+
+	docIDs, err := conn.CompanyDocumentIDs(ctx, companyID)
+	if err != nil {
+		return nil, err
+	}
+	total := len(docIDs)
+	for index, docID := range docIDs {
+		if onProgress != nil {
+			onProgress(ctx, docID, index, total)
+		}
+		report, err := verifyDocument(ctx, conn, docID)
+		if err != nil {
+			return reports, err
+		}
+		if !report.CompanyID.IsNil() && report.CompanyID != companyID {
+			report.addProblem(VersionTime{}, "", "listed as document of company %s but belongs to company %s", companyID, report.CompanyID)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// VerifyDocument checks the integrity of a document by reading it
+// through the Conn interface:
+//   - every file listed in VersionInfo.Files exists with the listed size
+//     and its content matches the ContentHash, and no other files exist
+//   - VersionInfo.DocID and VersionInfo.Version match the read version
+//   - the PrevVersion of every version is the version before it
+//     and nil for the first version
+//   - AddedFiles, ModifiedFiles and RemovedFiles agree with the file sets
+//     of the version and the previous version
+//   - the document is listed in conn.CompanyDocumentIDs of its DocumentCompanyID
+//
+// Problems are returned as part of the report, an error is only returned if
+// ctx is done. Errors from reading the document are reported as problems,
+// a non existing document results in a single problem.
+func VerifyDocument(ctx context.Context, conn Conn, docID uu.ID) (report *VerifyReport, err error) {
+	defer errs.WrapWithFuncParams(&err, ctx, conn, docID)
+
+	report, err = verifyDocument(ctx, conn, docID)
+	if err != nil || report.CompanyID.IsNil() {
+		return report, err
+	}
+	companyDocIDs, err := conn.CompanyDocumentIDs(ctx, report.CompanyID)
+	if err = report.problemOrCtxErr(ctx, VersionTime{}, "", err); err != nil {
+		return report, err
+	}
+	if !slices.Contains(companyDocIDs, docID) {
+		report.addProblem(VersionTime{}, "", "not listed as document of its company %s", report.CompanyID)
+	}
+	return report, nil
+}
+
+// problemOrCtxErr adds err as problem to the report
+// and returns nil or the error of ctx if it is done.
+func (r *VerifyReport) problemOrCtxErr(ctx context.Context, version VersionTime, filename string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	r.addProblem(version, filename, "%s", err)
+	return nil
+}
+
+func verifyDocument(ctx context.Context, conn Conn, docID uu.ID) (report *VerifyReport, err error) {
+	report = &VerifyReport{DocID: docID}
+
+	report.Versions, err = conn.DocumentVersions(ctx, docID)
+	if err = report.problemOrCtxErr(ctx, VersionTime{}, "", err); err != nil {
+		return report, err
+	}
+	if len(report.Versions) == 0 {
+		if report.OK() {
+			report.addProblem(VersionTime{}, "", "document has no versions")
+		}
+		return report, nil
+	}
+
+	var (
+		prevVersion *VersionTime
+		prevInfo    *VersionInfo
+	)
+	for _, version := range report.Versions {
+		info, err := verifyVersion(ctx, conn, report, version, prevVersion, prevInfo)
+		if err != nil {
+			return report, err
+		}
+		prevVersion = &version
+		prevInfo = info
+	}
+
+	// The CompanyID of the latest version is not compared with the
+	// document company because SetDocumentCompanyID of localfsdb and
+	// memconn moves a document without adding a version
+	report.CompanyID, err = conn.DocumentCompanyID(ctx, docID)
+	if err = report.problemOrCtxErr(ctx, VersionTime{}, "", err); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// verifyVersion adds the problems of a version to the report and returns
+// its VersionInfo or nil if it could not be read. prevInfo is nil if the
+// previous version could not be read and the file changes can't be checked.
+func verifyVersion(ctx context.Context, conn Conn, report *VerifyReport, version VersionTime, prevVersion *VersionTime, prevInfo *VersionInfo) (*VersionInfo, error) {
+	docID := report.DocID
+
+	info, err := conn.DocumentVersionInfo(ctx, docID, version)
+	if err = report.problemOrCtxErr(ctx, version, "", err); err != nil || info == nil {
+		return nil, err
+	}
+
+	if info.DocID != docID {
+		report.addProblem(version, "", "VersionInfo.DocID is %s", info.DocID)
+	}
+	if !info.Version.Equal(version) {
+		report.addProblem(version, "", "VersionInfo.Version is %s", info.Version)
+	}
+	switch {
+	case prevVersion == nil && info.PrevVersion != nil:
+		report.addProblem(version, "", "first version has PrevVersion %s", *info.PrevVersion)
+	case prevVersion != nil && info.PrevVersion == nil:
+		report.addProblem(version, "", "PrevVersion is nil instead of %s", *prevVersion)
+	case prevVersion != nil && !info.PrevVersion.Equal(*prevVersion):
+		report.addProblem(version, "", "PrevVersion is %s instead of %s", *info.PrevVersion, *prevVersion)
+	}
+
+	if prevVersion == nil || prevInfo != nil {
+		var prevFiles map[string]FileInfo
+		if prevInfo != nil {
+			prevFiles = prevInfo.Files
+		}
+		var added, modified, removed []string
+		for filename, file := range info.Files {
+			prevFile, ok := prevFiles[filename]
+			switch {
+			case !ok:
+				added = append(added, filename)
+			case prevFile.Hash != file.Hash:
+				modified = append(modified, filename)
+			}
+		}
+		for filename := range prevFiles {
+			if _, ok := info.Files[filename]; !ok {
+				removed = append(removed, filename)
+			}
+		}
+		if !equalStringSets(info.AddedFiles, added) {
+			report.addProblem(version, "", "AddedFiles %v differ from changes to previous version %v", info.AddedFiles, slices.Sorted(slices.Values(added)))
+		}
+		if !equalStringSets(info.ModifiedFiles, modified) {
+			report.addProblem(version, "", "ModifiedFiles %v differ from changes to previous version %v", info.ModifiedFiles, slices.Sorted(slices.Values(modified)))
+		}
+		if !equalStringSets(info.RemovedFiles, removed) {
+			report.addProblem(version, "", "RemovedFiles %v differ from changes to previous version %v", info.RemovedFiles, slices.Sorted(slices.Values(removed)))
+		}
+	}
+
+	files, err := conn.DocumentVersionFileProvider(ctx, docID, version)
+	if err = report.problemOrCtxErr(ctx, version, "", err); err != nil || files == nil {
+		return info, err
+	}
+	filenames, err := files.ListFiles(ctx)
+	if err = report.problemOrCtxErr(ctx, version, "", err); err != nil {
+		return info, err
+	}
+	for _, filename := range filenames {
+		if _, ok := info.Files[filename]; !ok {
+			report.addProblem(version, filename, "file exists but is not listed in VersionInfo.Files")
+		}
+	}
+	for _, filename := range slices.Sorted(maps.Keys(info.Files)) {
+		fileInfo := info.Files[filename]
+		if fileInfo.Name != filename {
+			report.addProblem(version, filename, "VersionInfo.Files entry has name %q", fileInfo.Name)
+		}
+		if !slices.Contains(filenames, filename) {
+			report.addProblem(version, filename, "file listed in VersionInfo.Files does not exist")
+			continue
+		}
+		hash, size, err := readFileContentHash(ctx, files, filename)
+		if err = report.problemOrCtxErr(ctx, version, filename, err); err != nil {
+			return info, err
+		}
+		if hash == "" {
+			continue // Read error was added as problem
+		}
+		if size != fileInfo.Size {
+			report.addProblem(version, filename, "file has %d bytes instead of %d", size, fileInfo.Size)
+		}
+		if hash != fileInfo.Hash {
+			report.addProblem(version, filename, "file content hash is %s instead of %s", hash, fileInfo.Hash)
+		}
+	}
+	return info, nil
+}
+
+func readFileContentHash(ctx context.Context, files FileProvider, filename string) (hash string, size int64, err error) {
+	reader, err := files.OpenFile(ctx, filename)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		err = errors.Join(err, reader.Close())
+	}()
+
+	return ReadContentHash(ctx, reader)
+}
@@ -0,0 +1,309 @@
+package docdb
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ungerik/go-fs"
+
+	"github.com/domonda/go-types/uu"
+)
+
+// verifyTestDoc is a document served by newVerifyTestConn.
+// files holds the stored file content per version,
+// infos the VersionInfo per version.
+type verifyTestDoc struct {
+	companyID uu.ID
+	infos     []*VersionInfo
+	files     map[VersionTime]map[string][]byte
+}
+
+// newVerifyTestDoc returns an intact document with the two versions
+// v1: a.txt, b.txt and v2: a.txt modified, b.txt removed, c.txt added.
+func newVerifyTestDoc(docID, companyID uu.ID) *verifyTestDoc {
+	v1 := MustVersionTimeFromString("2024-01-01_00-00-00.000")
+	v2 := MustVersionTimeFromString("2024-01-02_00-00-00.000")
+	doc := &verifyTestDoc{
+		companyID: companyID,
+		files: map[VersionTime]map[string][]byte{
+			v1: {"a.txt": []byte("a1"), "b.txt": []byte("b1")},
+			v2: {"a.txt": []byte("a2"), "c.txt": []byte("c2")},
+		},
+	}
+	newInfo := func(version VersionTime, prevVersion *VersionTime) *VersionInfo {
+		info := &VersionInfo{
+			CompanyID:   companyID,
+			DocID:       docID,
+			Version:     version,
+			PrevVersion: prevVersion,
+			Files:       make(map[string]FileInfo),
+		}
+		for name, data := range doc.files[version] {
+			info.Files[name] = FileInfo{Name: name, Size: int64(len(data)), Hash: ContentHash(data)}
+		}
+		return info
+	}
+	info1 := newInfo(v1, nil)
+	info1.AddedFiles = []string{"a.txt", "b.txt"}
+	info2 := newInfo(v2, &v1)
+	info2.AddedFiles = []string{"c.txt"}
+	info2.ModifiedFiles = []string{"a.txt"}
+	info2.RemovedFiles = []string{"b.txt"}
+	doc.infos = []*VersionInfo{info1, info2}
+	return doc
+}
+
+// newVerifyTestConn returns a MockConn serving docs
+// and the company document lists of companyDocs.
+func newVerifyTestConn(docs map[uu.ID]*verifyTestDoc, companyDocs map[uu.ID]uu.IDSlice) *MockConn {
+	return &MockConn{
+		CompanyIDsMock: func(context.Context) (uu.IDSlice, error) {
+			var ids uu.IDSlice
+			for id := range companyDocs {
+				ids = append(ids, id)
+			}
+			ids.Sort()
+			return ids, nil
+		},
+		CompanyDocumentIDsMock: func(ctx context.Context, companyID uu.ID) (uu.IDSlice, error) {
+			return companyDocs[companyID], nil
+		},
+		DocumentCompanyIDMock: func(ctx context.Context, docID uu.ID) (uu.ID, error) {
+			doc, ok := docs[docID]
+			if !ok {
+				return uu.IDNil, NewErrDocumentNotFound(docID)
+			}
+			return doc.companyID, nil
+		},
+		DocumentVersionsMock: func(ctx context.Context, docID uu.ID) ([]VersionTime, error) {
+			doc, ok := docs[docID]
+			if !ok {
+				return nil, NewErrDocumentNotFound(docID)
+			}
+			var versions []VersionTime
+			for _, info := range doc.infos {
+				versions = append(versions, info.Version)
+			}
+			return versions, nil
+		},
+		DocumentVersionInfoMock: func(ctx context.Context, docID uu.ID, version VersionTime) (*VersionInfo, error) {
+			for _, info := range docs[docID].infos {
+				if info.Version.Equal(version) {
+					return info, nil
+				}
+			}
+			return nil, NewErrDocumentVersionNotFound(docID, version)
+		},
+		DocumentVersionFileProviderMock: func(ctx context.Context, docID uu.ID, version VersionTime) (FileProvider, error) {
+			var files []fs.FileReader
+			for name, data := range docs[docID].files[version] {
+				files = append(files, fs.NewMemFile(name, data))
+			}
+			return NewFileProvider(files...), nil
+		},
+	}
+}
+
+func TestVerifyDocument(t *testing.T) {
+	var (
+		ctx       = t.Context()
+		docID     = uu.IDFrom("c538ac93-2cf0-49a9-8378-22cd48b5ab84")
+		companyID = uu.IDFrom("6f296458-24cd-4146-ac3a-33ca885a993e")
+		otherID   = uu.IDFrom("2fc110fd-ed66-4a8f-9498-4dcb8386d300")
+		v1        = MustVersionTimeFromString("2024-01-01_00-00-00.000")
+		v2        = MustVersionTimeFromString("2024-01-02_00-00-00.000")
+	)
+
+	for _, tc := range []struct {
+		name         string
+		damage       func(doc *verifyTestDoc)
+		companyDocs  uu.IDSlice
+		wantProblems []VerifyProblem
+	}{
+		{
+			name:        "intact",
+			damage:      func(doc *verifyTestDoc) {},
+			companyDocs: uu.IDSlice{docID},
+		},
+		{
+			name: "changed file content",
+			damage: func(doc *verifyTestDoc) {
+				doc.files[v1]["a.txt"] = []byte("xx")
+			},
+			companyDocs: uu.IDSlice{docID},
+			wantProblems: []VerifyProblem{
+				{Version: v1, Filename: "a.txt", Problem: "file content hash is " + ContentHash([]byte("xx")) + " instead of " + ContentHash([]byte("a1"))},
+			},
+		},
+		{
+			name: "changed file size",
+			damage: func(doc *verifyTestDoc) {
+				doc.files[v2]["c.txt"] = []byte("c2c")
+			},
+			companyDocs: uu.IDSlice{docID},
+			wantProblems: []VerifyProblem{
+				{Version: v2, Filename: "c.txt", Problem: "file has 3 bytes instead of 2"},
+				{Version: v2, Filename: "c.txt", Problem: "file content hash is " + ContentHash([]byte("c2c")) + " instead of " + ContentHash([]byte("c2"))},
+			},
+		},
+		{
+			name: "missing and untracked file",
+			damage: func(doc *verifyTestDoc) {
+				delete(doc.files[v2], "c.txt")
+				doc.files[v2]["d.txt"] = []byte("d")
+			},
+			companyDocs: uu.IDSlice{docID},
+			wantProblems: []VerifyProblem{
+				{Version: v2, Filename: "d.txt", Problem: "file exists but is not listed in VersionInfo.Files"},
+				{Version: v2, Filename: "c.txt", Problem: "file listed in VersionInfo.Files does not exist"},
+			},
+		},
+		{
+			name: "broken PrevVersion chain",
+			damage: func(doc *verifyTestDoc) {
+				other := MustVersionTimeFromString("2023-12-31_00-00-00.000")
+				doc.infos[1].PrevVersion = &other
+			},
+			companyDocs: uu.IDSlice{docID},
+			wantProblems: []VerifyProblem{
+				{Version: v2, Problem: "PrevVersion is 2023-12-31_00-00-00.000 instead of 2024-01-01_00-00-00.000"},
+			},
+		},
+		{
+			name: "first version with PrevVersion",
+			damage: func(doc *verifyTestDoc) {
+				doc.infos[0].PrevVersion = &v2
+			},
+			companyDocs: uu.IDSlice{docID},
+			wantProblems: []VerifyProblem{
+				{Version: v1, Problem: "first version has PrevVersion 2024-01-02_00-00-00.000"},
+			},
+		},
+		{
+			name: "wrong file change lists",
+			damage: func(doc *verifyTestDoc) {
+				doc.infos[1].AddedFiles = []string{"a.txt", "c.txt"}
+				doc.infos[1].ModifiedFiles = nil
+			},
+			companyDocs: uu.IDSlice{docID},
+			wantProblems: []VerifyProblem{
+				{Version: v2, Problem: "AddedFiles [a.txt c.txt] differ from changes to previous version [c.txt]"},
+				{Version: v2, Problem: "ModifiedFiles [] differ from changes to previous version [a.txt]"},
+			},
+		},
+		{
+			name: "moved to company not listing it",
+			damage: func(doc *verifyTestDoc) {
+				doc.companyID = otherID
+			},
+			companyDocs: uu.IDSlice{docID},
+			wantProblems: []VerifyProblem{
+				{Problem: "not listed as document of its company " + otherID.String()},
+			},
+		},
+		{
+			name:        "not listed under company",
+			damage:      func(doc *verifyTestDoc) {},
+			companyDocs: uu.IDSlice{uu.IDv7()},
+			wantProblems: []VerifyProblem{
+				{Problem: "not listed as document of its company " + companyID.String()},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := newVerifyTestDoc(docID, companyID)
+			tc.damage(doc)
+			conn := newVerifyTestConn(
+				map[uu.ID]*verifyTestDoc{docID: doc},
+				map[uu.ID]uu.IDSlice{companyID: tc.companyDocs},
+			)
+
+			report, err := VerifyDocument(ctx, conn, docID)
+			require.NoError(t, err)
+			require.Equal(t, docID, report.DocID)
+			require.Equal(t, []VersionTime{v1, v2}, report.Versions)
+			require.Equal(t, tc.wantProblems, report.Problems, report.String())
+			require.Equal(t, len(tc.wantProblems) == 0, report.OK())
+		})
+	}
+
+	t.Run("moved without new version", func(t *testing.T) {
+		// Like SetDocumentCompanyID of localfsdb and memconn,
+		// the versions keep the CompanyID of the previous company
+		doc := newVerifyTestDoc(docID, companyID)
+		doc.companyID = otherID
+		conn := newVerifyTestConn(
+			map[uu.ID]*verifyTestDoc{docID: doc},
+			map[uu.ID]uu.IDSlice{otherID: {docID}},
+		)
+		report, err := VerifyDocument(ctx, conn, docID)
+		require.NoError(t, err)
+		require.Equal(t, otherID, report.CompanyID)
+		require.True(t, report.OK(), report.String())
+	})
+
+	t.Run("document not found", func(t *testing.T) {
+		conn := newVerifyTestConn(nil, nil)
+		report, err := VerifyDocument(ctx, conn, docID)
+		require.NoError(t, err)
+		require.Len(t, report.Problems, 1)
+		require.Contains(t, report.Problems[0].Problem, "not found")
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		conn := newVerifyTestConn(map[uu.ID]*verifyTestDoc{docID: newVerifyTestDoc(docID, companyID)}, nil)
+		canceledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		conn.DocumentVersionsMock = func(ctx context.Context, docID uu.ID) ([]VersionTime, error) {
+			return nil, ctx.Err()
+		}
+		_, err := VerifyDocument(canceledCtx, conn, docID)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	var (
+		ctx        = t.Context()
+		companyID1 = uu.IDFrom("6f296458-24cd-4146-ac3a-33ca885a993e")
+		companyID2 = uu.IDFrom("2fc110fd-ed66-4a8f-9498-4dcb8386d300")
+		docID1     = uu.IDFrom("c538ac93-2cf0-49a9-8378-22cd48b5ab84")
+		docID2     = uu.IDFrom("ce6f0867-0172-4ffc-a0c0-c5878b921171")
+		docID3     = uu.IDFrom("0dcb4a5e-0e5a-4bb1-97c2-0d5b0fd0b1c3")
+		missingID  = uu.IDFrom("9a3e7a3e-5a48-4c37-a4d7-8ae7b4c2fb52")
+	)
+	conn := newVerifyTestConn(
+		map[uu.ID]*verifyTestDoc{
+			docID1: newVerifyTestDoc(docID1, companyID1),
+			docID2: newVerifyTestDoc(docID2, companyID1),
+			docID3: newVerifyTestDoc(docID3, companyID2),
+		},
+		map[uu.ID]uu.IDSlice{
+			companyID1: {docID1, docID2, docID3, missingID},
+			companyID2: {docID3},
+		},
+	)
+
+	var progress []uu.ID
+	reports, err := Verify(ctx, conn, func(ctx context.Context, docID uu.ID, index, total int) {
+		progress = append(progress, docID)
+	})
+	require.NoError(t, err)
+	require.Len(t, reports, 5)
+	require.Equal(t, uu.IDSlice{docID3, docID1, docID2, docID3, missingID}, uu.IDSlice(progress))
+
+	var failed []uu.ID
+	for _, report := range reports {
+		if !report.OK() {
+			failed = append(failed, report.DocID)
+		}
+	}
+	require.Equal(t, []uu.ID{docID3, missingID}, failed)
+
+	report := reports[slices.IndexFunc(reports, func(r *VerifyReport) bool { return !r.OK() })]
+	require.Equal(t, []VerifyProblem{
+		{Problem: "listed as document of company " + companyID1.String() + " but belongs to company " + companyID2.String()},
+	}, report.Problems)
+}
@@ -0,0 +1,198 @@
+package docdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"path"
+	"time"
+
+	"github.com/ungerik/go-fs"
+	"github.com/ungerik/go-fs/fsimpl"
+
+	"github.com/domonda/go-types/uu"
+)
+
+// versionFileReader is a fs.FileReader for a file of a document version
+// that opens the file with Conn.OpenDocumentVersionFile for every read
+// instead of holding its content in memory.
+//
+// Methods without a context argument use the context
+// the versionFileReader was created with.
+type versionFileReader struct {
+	ctx     context.Context
+	conn    Conn
+	docID   uu.ID
+	version VersionTime
+	file    FileInfo
+}
+
+var _ fs.FileReader = (*versionFileReader)(nil)
+
+// newVersionFileReader returns a versionFileReader for the file filename
+// of versionInfo of the document docID or a wrapped ErrDocumentFileNotFound
+// if versionInfo has no such file.
+func newVersionFileReader(ctx context.Context, conn Conn, docID uu.ID, versionInfo *VersionInfo, filename string) (*versionFileReader, error) {
+	file, ok := versionInfo.Files[filename]
+	if !ok {
+		return nil, NewErrDocumentFileNotFound(docID, filename)
+	}
+	return &versionFileReader{
+		ctx:     ctx,
+		conn:    conn,
+		docID:   docID,
+		version: versionInfo.Version,
+		file:    file,
+	}, nil
+}
+
+func (f *versionFileReader) open(ctx context.Context) (io.ReadCloser, error) {
+	return f.conn.OpenDocumentVersionFile(ctx, f.docID, f.version, f.file.Name)
+}
+
+func (f *versionFileReader) String() string {
+	return fmt.Sprintf("docdb file %s of document %s version %s", f.file.Name, f.docID, f.version)
+}
+
+func (f *versionFileReader) Name() string       { return f.file.Name }
+func (f *versionFileReader) Ext() string        { return path.Ext(f.file.Name) }
+func (f *versionFileReader) LocalPath() string  { return "" }
+func (f *versionFileReader) Size() int64        { return f.file.Size }
+func (f *versionFileReader) Exists() bool       { return true }
+func (f *versionFileReader) CheckExists() error { return nil }
+func (f *versionFileReader) IsDir() bool        { return false }
+func (f *versionFileReader) CheckIsDir() error  { return fs.NewErrIsNotDirectory(f) }
+
+func (f *versionFileReader) ContentHash() (string, error) {
+	return f.ContentHashContext(f.ctx)
+}
+
+func (f *versionFileReader) ContentHashContext(ctx context.Context) (string, error) {
+	reader, err := f.open(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	return fs.DefaultContentHash(ctx, reader)
+}
+
+func (f *versionFileReader) ReadAll() ([]byte, error) {
+	return f.ReadAllContext(f.ctx)
+}
+
+func (f *versionFileReader) ReadAllContext(ctx context.Context) ([]byte, error) {
+	reader, err := f.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func (f *versionFileReader) ReadAllContentHash(ctx context.Context) (data []byte, hash string, err error) {
+	data, err = f.ReadAllContext(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	hash, err = fs.DefaultContentHash(ctx, bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, hash, nil
+}
+
+func (f *versionFileReader) ReadAllString() (string, error) {
+	return f.ReadAllStringContext(f.ctx)
+}
+
+func (f *versionFileReader) ReadAllStringContext(ctx context.Context) (string, error) {
+	data, err := f.ReadAllContext(ctx)
+	return string(data), err
+}
+
+func (f *versionFileReader) WriteTo(writer io.Writer) (n int64, err error) {
+	reader, err := f.open(f.ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	return io.Copy(writer, reader)
+}
+
+func (f *versionFileReader) OpenReader() (fs.ReadCloser, error) {
+	reader, err := f.open(f.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return versionFile{ReadCloser: reader, info: versionFileInfo{f}}, nil
+}
+
+// OpenReadSeeker reads the whole file into memory
+// because the streams of a Conn don't support seeking.
+func (f *versionFileReader) OpenReadSeeker() (fs.ReadSeekCloser, error) {
+	data, err := f.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return fsimpl.NewReadonlyFileBuffer(data, versionFileInfo{f}), nil
+}
+
+func (f *versionFileReader) ReadJSON(ctx context.Context, output any) error {
+	reader, err := f.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	err = json.NewDecoder(reader).Decode(output)
+	if err != nil {
+		return fmt.Errorf("%w because: %w", fs.ErrUnmarshalJSON, err)
+	}
+	return nil
+}
+
+func (f *versionFileReader) ReadXML(ctx context.Context, output any) error {
+	reader, err := f.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	err = xml.NewDecoder(reader).Decode(output)
+	if err != nil {
+		return fmt.Errorf("%w because: %w", fs.ErrUnmarshalXML, err)
+	}
+	return nil
+}
+
+// GobEncode reads the whole file and encodes it like fs.MemFile.
+func (f *versionFileReader) GobEncode() ([]byte, error) {
+	data, err := f.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return fs.NewMemFile(f.file.Name, data).GobEncode()
+}
+
+// versionFile is the io/fs.File returned by versionFileReader.OpenReader.
+type versionFile struct {
+	io.ReadCloser
+	info versionFileInfo
+}
+
+func (f versionFile) Stat() (iofs.FileInfo, error) { return f.info, nil }
+
+// versionFileInfo implements io/fs.FileInfo for a versionFileReader,
+// the version time is used as modification time.
+type versionFileInfo struct {
+	f *versionFileReader
+}
+
+func (i versionFileInfo) Name() string        { return i.f.file.Name }
+func (i versionFileInfo) Size() int64         { return i.f.file.Size }
+func (i versionFileInfo) Mode() iofs.FileMode { return 0o444 }
+func (i versionFileInfo) ModTime() time.Time  { return i.f.version.Time }
+func (i versionFileInfo) IsDir() bool         { return false }
+func (i versionFileInfo) Sys() any            { return nil }
@@ -0,0 +1,67 @@
+package docdb
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/domonda/go-types/uu"
+)
+
+func TestDocumentVersionFileReader(t *testing.T) {
+	ctx := t.Context()
+	docID := uu.IDv7()
+	version := MustVersionTimeFromString("2024-01-01_00-00-00.000")
+	content := `{"a":1}`
+	opened := 0
+	conn := &MockConn{
+		DocumentVersionInfoMock: func(ctx context.Context, docID uu.ID, version VersionTime) (*VersionInfo, error) {
+			return &VersionInfo{
+				DocID:   docID,
+				Version: version,
+				Files: map[string]FileInfo{
+					"doc.json": {Name: "doc.json", Size: int64(len(content)), Hash: ContentHash([]byte(content))},
+				},
+			}, nil
+		},
+		OpenDocumentVersionFileMock: func(ctx context.Context, docID uu.ID, version VersionTime, filename string) (io.ReadCloser, error) {
+			opened++
+			return io.NopCloser(strings.NewReader(content)), nil
+		},
+	}
+	prevConn := GetConn()
+	Configure(conn)
+	t.Cleanup(func() { Configure(prevConn) })
+
+	file, err := DocumentVersionFileReader(ctx, docID, version, "doc.json")
+	require.NoError(t, err)
+	require.Equal(t, 0, opened, "file must not be read before it is used")
+	require.Equal(t, "doc.json", file.Name())
+	require.Equal(t, int64(len(content)), file.Size())
+
+	data, err := file.ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, content, string(data))
+
+	reader, err := file.OpenReader()
+	require.NoError(t, err)
+	info, err := reader.Stat()
+	require.NoError(t, err)
+	require.Equal(t, version.Time, info.ModTime())
+	require.NoError(t, reader.Close())
+
+	var value map[string]int
+	require.NoError(t, file.ReadJSON(ctx, &value))
+	require.Equal(t, map[string]int{"a": 1}, value)
+
+	hash, err := file.ContentHash()
+	require.NoError(t, err)
+	require.Equal(t, ContentHash([]byte(content)), hash)
+	require.Equal(t, 4, opened, "every read must open the file")
+
+	_, err = DocumentVersionFileReader(ctx, docID, version, "missing.txt")
+	require.ErrorIs(t, err, NewErrDocumentFileNotFound(docID, "missing.txt"))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"slices"
 
 	fs "github.com/ungerik/go-fs"
@@ -26,6 +27,9 @@ type VersionInfo struct {
 	CommitUserID uu.ID
 	// CommitReason describes why this version was created.
 	CommitReason string
+	// IdempotencyKey is the key the version was committed with,
+	// see ContextWithIdempotencyKey. Empty if no key was used.
+	IdempotencyKey string `json:",omitempty"`
 
 	// Files maps filename to FileInfo for every file in this version.
 	Files map[string]FileInfo
@@ -74,6 +78,24 @@ func (vi *VersionInfo) String() string {
 	return fmt.Sprintf("VersionInfo{DocID:%s, Version:%s}", vi.DocID, vi.Version)
 }
 
+// Clone returns a deep copy of the VersionInfo
+// or nil for a nil receiver.
+func (vi *VersionInfo) Clone() *VersionInfo {
+	if vi == nil {
+		return nil
+	}
+	clone := *vi
+	if vi.PrevVersion != nil {
+		prevVersion := *vi.PrevVersion
+		clone.PrevVersion = &prevVersion
+	}
+	clone.Files = maps.Clone(vi.Files)
+	clone.AddedFiles = slices.Clone(vi.AddedFiles)
+	clone.RemovedFiles = slices.Clone(vi.RemovedFiles)
+	clone.ModifiedFiles = slices.Clone(vi.ModifiedFiles)
+	return &clone
+}
+
 // WriteJSON writes the VersionInfo as indented JSON to the given file.
 func (vi *VersionInfo) WriteJSON(file fs.File) error {
 	return file.WriteJSON(context.Background(), vi, "  ")
@@ -102,9 +124,9 @@ func (vi *VersionInfo) EqualFiles(other *VersionInfo) bool {
 
 // Equal reports whether vi and other describe the same committed version:
 // identical scalar metadata (company, document, version, previous version,
-// commit user and reason), the same added/removed/modified filename sets
-// (compared order-insensitively, since callers derive them from map
-// iteration), and the same resolved file set (see EqualFiles).
+// commit user, reason and idempotency key), the same added/removed/modified
+// filename sets (compared order-insensitively, since callers derive them
+// from map iteration), and the same resolved file set (see EqualFiles).
 //
 // Keeping the full comparison here means a field added to VersionInfo is
 // compared by every caller, instead of being silently missed by a hand-rolled
@@ -121,7 +143,8 @@ func (vi *VersionInfo) Equal(other *VersionInfo) bool {
 		!vi.Version.Equal(other.Version) ||
 		!equalVersionTimePtr(vi.PrevVersion, other.PrevVersion) ||
 		vi.CommitUserID != other.CommitUserID ||
-		vi.CommitReason != other.CommitReason {
+		vi.CommitReason != other.CommitReason ||
+		vi.IdempotencyKey != other.IdempotencyKey {
 		return false
 	}
 	if !equalStringSets(vi.AddedFiles, other.AddedFiles) ||